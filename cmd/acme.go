@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mholt/acmez/acme"
+)
+
+// acmeEABRequired reports whether the ACME CA with directory URL `caURL`
+// requires external account binding, according to its directory metadata.
+func acmeEABRequired(ctx context.Context, caURL string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, caURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response status %v", res.Status)
+	}
+
+	var dir acme.Directory
+	if err := json.NewDecoder(res.Body).Decode(&dir); err != nil {
+		return false, fmt.Errorf("failed to decode directory: %w", err)
+	}
+
+	return dir.Meta != nil && dir.Meta.ExternalAccountRequired, nil
+}
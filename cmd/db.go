@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/database/badger"
+)
+
+var (
+	dbBackupAPIURL     string
+	dbBackupAdminToken string
+)
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbBackupCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+
+	dbBackupCmd.Flags().StringVar(&dbBackupAPIURL, "api-url", "",
+		`the base URL of the API of a running server to create the backup with, e.g. "http://localhost" (by default, the database is opened directly)`)
+	dbBackupCmd.Flags().StringVar(&dbBackupAdminToken, "admin-token", "",
+		"the admin token of the server, required with --api-url")
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: `Backs up and restores the "badger" database.`,
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "Writes a backup of the database to a file.",
+	Long: `Writes a backup of the database to a file, or to stdout for "-".
+
+The database can't be opened while a server is using it. To back up the
+database of a running server, use --api-url and --admin-token, which streams
+the backup from the "POST /api/db/backup" endpoint of the server.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if args[0] == "-" {
+			return backupDatabase(cmd, cmd.OutOrStdout())
+		}
+
+		// The backup is written to a temporary file first, so an existing
+		// file isn't overwritten by a partial backup.
+		tmpPath := args[0] + ".tmp"
+		f, err := os.Create(tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+
+		err = backupDatabase(cmd, f)
+		if closeErr := f.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write backup: %w", closeErr)
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+
+		return os.Rename(tmpPath, args[0])
+	},
+}
+
+func backupDatabase(cmd *cobra.Command, w io.Writer) error {
+	if dbBackupAPIURL != "" {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(dbBackupAPIURL, "/")+"/api/db/backup", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+dbBackupAdminToken)
+
+		// HTTP/1.1 is used, because the server can only extend the write
+		// timeout for backups on HTTP/1.x connections.
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy:        http.ProxyFromEnvironment,
+				TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+			},
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to request API: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode == http.StatusUnauthorized {
+			return errors.New("failed to create backup: a valid admin token is required (see --admin-token)")
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to create backup: unexpected API response status %v", res.Status)
+		}
+
+		_, err = io.Copy(w, res.Body)
+		if err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+
+		return nil
+	}
+
+	db, logger, err := openDatabaseForCommand("stop it, or use the --api-url flag to create a backup via the server instead")
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error("Failed to close database.", zap.Error(err))
+		}
+	}()
+
+	return db.Backup(w)
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restores a backup of the database from a file.",
+	Long: `Restores a backup created with "edena db backup" from a file, or from stdin
+for "-".
+
+The database must be empty, and can't be used by a running server while
+restoring. To restore into an existing data directory, move its "db" directory
+away first.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var r io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open file: %w", err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		db, logger, err := openDatabaseForCommand("stop it before restoring")
+		if err != nil {
+			return err
+		}
+		defer logger.Sync()
+		defer func() {
+			if err := db.Close(); err != nil {
+				logger.Error("Failed to close database.", zap.Error(err))
+			}
+		}()
+
+		err = db.Restore(r)
+		if errors.Is(err, badger.ErrNotEmpty) {
+			return errors.New("the database is not empty; restoring requires an empty database")
+		}
+
+		return err
+	},
+}
+
+// openDatabaseForCommand opens the badger database in the data directory. The
+// `lockedHint` is added to the error for a database in use.
+func openDatabaseForCommand(lockedHint string) (*badger.Database, *zap.Logger, error) {
+	logger, err := newLogger(debug, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataPath, err := dataDirectory()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure data directory: %w", err)
+	}
+
+	db, err := openBadgerDatabase(logger, dataPath)
+	if errors.Is(err, badger.ErrLocked) {
+		return nil, nil, fmt.Errorf("the database is in use, most likely by a running server; %v", lockedHint)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return db, logger, nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var dnsZoneAPIURL string
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsZoneCmd)
+
+	dnsZoneCmd.Flags().StringVar(&dnsZoneAPIURL, "api-url", "http://localhost", "the base URL of the API of a running server")
+}
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Inspects the DNS zone of a running server.",
+}
+
+var dnsZoneCmd = &cobra.Command{
+	Use:   "zone",
+	Short: "Prints the DNS zone in zonefile format.",
+	Long: `Prints the DNS zone of a running server as an RFC 1035 master file (as used
+by e.g. BIND), including the SOA and NS records and all stored records.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(dnsZoneAPIURL, "/")+"/api/dns/zone", nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to request API: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to export zone: unexpected API response status %v", res.Status)
+		}
+
+		_, err = io.Copy(cmd.OutOrStdout(), res.Body)
+		if err != nil {
+			return fmt.Errorf("failed to write zone: %w", err)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	importAPIURL     string
+	importAdminToken string
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVar(&importAPIURL, "api-url", "http://localhost", "the base URL of the API of a running server")
+	importCmd.Flags().StringVar(&importAdminToken, "admin-token", "", "the admin token of the server (see the --admin-token flag of the server command)")
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Imports HTTP logs into a running server.",
+	Long: `Imports HTTP logs into a running server, from an NDJSON file exported with
+"GET /api/http-logs/export", e.g. of another server. Use "-" to read from
+stdin. IDs are preserved and existing entries are skipped, so an import can
+safely be retried. Importing requires the admin token of the server.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		var r io.Reader = cmd.InOrStdin()
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to open file: %w", err)
+			}
+			defer f.Close()
+			r = f
+		}
+
+		// Imports can be large, so no timeout is used.
+		client := &apiClient{
+			baseURL:    strings.TrimSuffix(importAPIURL, "/"),
+			httpClient: &http.Client{},
+			adminToken: importAdminToken,
+		}
+
+		var result struct {
+			Imported int `json:"imported"`
+			Skipped  int `json:"skipped"`
+		}
+		err := client.post(ctx, "/api/http-logs/import", "application/x-ndjson", r, &result)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Imported %v HTTP log entries, skipped %v existing entries.\n", result.Imported, result.Skipped)
+
+		return nil
+	},
+}
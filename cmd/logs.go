@@ -0,0 +1,362 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsAPIURL   string
+	logsHost     string
+	logsTypes    []string
+	logsSince    time.Duration
+	logsInterval time.Duration
+	logsJSON     bool
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.AddCommand(logsTailCmd)
+
+	logsTailCmd.Flags().StringVar(&logsAPIURL, "api-url", "http://localhost", "the base URL of the API of a running server")
+	logsTailCmd.Flags().StringVar(&logsHost, "host", "", "the hostname of the host to tail interactions for")
+	logsTailCmd.Flags().StringSliceVar(&logsTypes, "type", []string{"http", "dns", "tls"}, `interaction types to print, "http", "dns" and/or "tls"`)
+	logsTailCmd.Flags().DurationVar(&logsSince, "since", 0, "also print interactions received within this duration before starting")
+	logsTailCmd.Flags().DurationVar(&logsInterval, "interval", time.Second, "how often to poll the API for new interactions")
+	logsTailCmd.Flags().BoolVar(&logsJSON, "json", false, "print interactions as newline delimited JSON")
+	if err := logsTailCmd.MarkFlagRequired("host"); err != nil {
+		panic(err)
+	}
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Reads interactions from a running server.",
+}
+
+var logsTailCmd = &cobra.Command{
+	Use:          "tail",
+	Short:        "Prints interactions for a host as they arrive.",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		types := map[string]bool{}
+		for _, t := range logsTypes {
+			switch t {
+			case "http", "dns", "tls":
+				types[t] = true
+			default:
+				return fmt.Errorf("unsupported interaction type %q", t)
+			}
+		}
+
+		client := &apiClient{baseURL: strings.TrimSuffix(logsAPIURL, "/"), httpClient: &http.Client{Timeout: 30 * time.Second}}
+
+		hostID, err := client.findHostID(ctx, logsHost)
+		if err != nil {
+			return err
+		}
+
+		// ULIDs sort by time, so a ULID with zero entropy for the start time
+		// is used as the cursor for new interactions.
+		cursor, err := ulid.New(ulid.Timestamp(time.Now().Add(-logsSince)), zeroReader{})
+		if err != nil {
+			return fmt.Errorf("failed to create cursor: %w", err)
+		}
+
+		ticker := time.NewTicker(logsInterval)
+		defer ticker.Stop()
+
+		for {
+			interactions, err := client.listInteractions(ctx, hostID, types)
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, interaction := range interactions {
+				if interaction.ID.Compare(cursor) <= 0 {
+					continue
+				}
+				if err := printInteraction(cmd.OutOrStdout(), interaction); err != nil {
+					return err
+				}
+				cursor = interaction.ID
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+type interaction struct {
+	Type    string
+	ID      ulid.ULID
+	Summary string
+	Raw     json.RawMessage
+}
+
+func printInteraction(w io.Writer, i interaction) error {
+	if logsJSON {
+		return json.NewEncoder(w).Encode(struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}{i.Type, i.Raw})
+	}
+
+	createdAt := ulid.Time(i.ID.Time()).UTC().Format(time.RFC3339)
+	_, err := fmt.Fprintf(w, "%v  %-4v  %v\n", createdAt, strings.ToUpper(i.Type), i.Summary)
+
+	return err
+}
+
+type apiClient struct {
+	baseURL    string
+	httpClient *http.Client
+	// adminToken is sent as bearer token, if set.
+	adminToken string
+}
+
+func (c *apiClient) get(ctx context.Context, path string, query url.Values, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, data)
+}
+
+func (c *apiClient) post(ctx context.Context, path, contentType string, body io.Reader, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	return c.do(req, data)
+}
+
+// do sends a request to the API and decodes the data of the response into
+// `data`.
+func (c *apiClient) do(req *http.Request, data interface{}) error {
+	if c.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request API: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read API response (status: %v): %w", res.Status, err)
+	}
+
+	return decodeAPIResponse(res.StatusCode, body, data)
+}
+
+// decodeAPIResponse decodes the data of an API response into `data`, or
+// returns its error. Responses are either wrapped in an envelope with `data`
+// and `error` properties, or, if the server runs with `--api-envelope=false`,
+// have the data or error at the top level, in which case errors are told apart
+// by their status code.
+func decodeAPIResponse(statusCode int, body []byte, data interface{}) error {
+	var envelope struct {
+		Data  json.RawMessage `json:"data"`
+		Error *apiError       `json:"error"`
+	}
+
+	if statusCode >= http.StatusBadRequest {
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return fmt.Errorf("failed to decode API response (status: %v): %w", statusCode, err)
+		}
+		apiErr := envelope.Error
+		if apiErr == nil {
+			apiErr = &apiError{}
+			if err := json.Unmarshal(body, apiErr); err != nil {
+				return fmt.Errorf("failed to decode API response (status: %v): %w", statusCode, err)
+			}
+		}
+		return fmt.Errorf("API error: %v", apiErr.Message)
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if isAPIEnvelope(body) {
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return fmt.Errorf("failed to decode API response (status: %v): %w", statusCode, err)
+		}
+		body = envelope.Data
+		if len(body) == 0 {
+			return nil
+		}
+	}
+
+	return json.Unmarshal(body, data)
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// isAPIEnvelope returns whether a successful API response is wrapped in an
+// envelope, i.e. it's an object without properties other than `data`. None of
+// the objects returned by the API look like that.
+func isAPIEnvelope(body []byte) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	for key := range fields {
+		if key != "data" {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *apiClient) findHostID(ctx context.Context, hostname string) (ulid.ULID, error) {
+	var hostList []struct {
+		ID       ulid.ULID `json:"id"`
+		Hostname string    `json:"hostname"`
+	}
+
+	if err := c.get(ctx, "/api/hosts", nil, &hostList); err != nil {
+		return ulid.ULID{}, err
+	}
+
+	for _, host := range hostList {
+		if strings.EqualFold(host.Hostname, hostname) {
+			return host.ID, nil
+		}
+	}
+
+	return ulid.ULID{}, fmt.Errorf("host %q not found", hostname)
+}
+
+// listInteractions returns all interactions of a host, of the given types,
+// ordered by ID.
+func (c *apiClient) listInteractions(ctx context.Context, hostID ulid.ULID, types map[string]bool) ([]interaction, error) {
+	var interactions []interaction
+	query := url.Values{"hostId": []string{hostID.String()}}
+
+	if types["http"] {
+		var entries []json.RawMessage
+		if err := c.get(ctx, "/api/http-logs", query, &entries); err != nil {
+			return nil, err
+		}
+		for _, raw := range entries {
+			var entry struct {
+				ID      ulid.ULID `json:"id"`
+				Request struct {
+					Method     string `json:"method"`
+					URL        string `json:"url"`
+					RemoteAddr string `json:"remoteAddr"`
+				} `json:"request"`
+			}
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("failed to decode HTTP log entry: %w", err)
+			}
+			interactions = append(interactions, interaction{
+				Type:    "http",
+				ID:      entry.ID,
+				Summary: fmt.Sprintf("%v  %v %v", entry.Request.RemoteAddr, entry.Request.Method, entry.Request.URL),
+				Raw:     raw,
+			})
+		}
+	}
+
+	if types["dns"] {
+		var entries []json.RawMessage
+		if err := c.get(ctx, "/api/dns-logs", query, &entries); err != nil {
+			return nil, err
+		}
+		for _, raw := range entries {
+			var entry struct {
+				ID         ulid.ULID `json:"id"`
+				RemoteAddr string    `json:"remoteAddr"`
+				Query      struct {
+					Name string `json:"name"`
+					Type string `json:"type"`
+				} `json:"query"`
+				Response struct {
+					Rcode string `json:"rcode"`
+				} `json:"response"`
+			}
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("failed to decode DNS log entry: %w", err)
+			}
+			interactions = append(interactions, interaction{
+				Type:    "dns",
+				ID:      entry.ID,
+				Summary: fmt.Sprintf("%v  %v %v %v", entry.RemoteAddr, entry.Query.Type, entry.Query.Name, entry.Response.Rcode),
+				Raw:     raw,
+			})
+		}
+	}
+
+	if types["tls"] {
+		var entries []json.RawMessage
+		if err := c.get(ctx, "/api/tls-logs", query, &entries); err != nil {
+			return nil, err
+		}
+		for _, raw := range entries {
+			var entry struct {
+				ID         ulid.ULID `json:"id"`
+				RemoteAddr string    `json:"remoteAddr"`
+				ServerName string    `json:"serverName"`
+			}
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("failed to decode TLS log entry: %w", err)
+			}
+			interactions = append(interactions, interaction{
+				Type:    "tls",
+				ID:      entry.ID,
+				Summary: fmt.Sprintf("%v  %v", entry.RemoteAddr, entry.ServerName),
+				Raw:     raw,
+			})
+		}
+	}
+
+	sort.Slice(interactions, func(i, j int) bool {
+		return interactions[i].ID.Compare(interactions[j].ID) < 0
+	})
+
+	return interactions, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeAPIResponse(t *testing.T) {
+	type host struct {
+		Hostname string `json:"hostname"`
+	}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		expHosts   []host
+		expErr     string
+	}{
+		{
+			name:       "data with envelope",
+			statusCode: http.StatusOK,
+			body:       `{"data":[{"hostname":"abc.example.com"}]}`,
+			expHosts:   []host{{Hostname: "abc.example.com"}},
+		},
+		{
+			name:       "data without envelope",
+			statusCode: http.StatusOK,
+			body:       `[{"hostname":"abc.example.com"}]`,
+			expHosts:   []host{{Hostname: "abc.example.com"}},
+		},
+		{
+			name:       "no data with envelope",
+			statusCode: http.StatusOK,
+			body:       "{}\n",
+		},
+		{
+			name:       "no data without envelope",
+			statusCode: http.StatusOK,
+			body:       "",
+		},
+		{
+			name:       "error with envelope",
+			statusCode: http.StatusNotFound,
+			body:       `{"error":{"message":"Host not found.","code":"host_not_found"}}`,
+			expErr:     "API error: Host not found.",
+		},
+		{
+			name:       "error without envelope",
+			statusCode: http.StatusNotFound,
+			body:       `{"message":"Host not found.","code":"host_not_found"}`,
+			expErr:     "API error: Host not found.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []host
+			err := decodeAPIResponse(tt.statusCode, []byte(tt.body), &got)
+
+			if tt.expErr != "" {
+				if err == nil || err.Error() != tt.expErr {
+					t.Fatalf("expected error %q, got %v", tt.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expHosts) {
+				t.Errorf("expected hosts %v, got %v", tt.expHosts, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/acme"
+	"go.uber.org/zap"
+)
+
+// defaultCertPreflightTimeout is the time certificates are waited for at
+// startup, before they are obtained in the background.
+const defaultCertPreflightTimeout = 2 * time.Minute
+
+// preflightCertificates obtains the certificates for `domains` synchronously,
+// unless they are already stored, so misconfigurations (e.g. a zone that isn't
+// delegated to the server) are reported clearly at startup, instead of
+// surfacing as failing TLS handshakes. The DNS and HTTP servers must be running
+// for challenges to be answered. Failures are logged, not returned: the
+// certificates are managed in the background afterwards, which retries.
+func preflightCertificates(ctx context.Context, cfg *certmagic.Config, domains []string, dnsZone string, timeout time.Duration, logger *zap.Logger) {
+	preflightCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger.Info("Checking certificates ...", zap.Strings("domains", domains))
+
+	for _, domain := range domains {
+		// Obtaining is a no-op for certificates that are already stored.
+		err := cfg.ObtainCertSync(preflightCtx, domain)
+		if err == nil {
+			continue
+		}
+		// The server is shutting down.
+		if ctx.Err() != nil {
+			return
+		}
+
+		fields := []zap.Field{zap.String("domain", domain), zap.Error(err)}
+		if hint := certErrorHint(err, dnsZone); hint != "" {
+			fields = append(fields, zap.String("hint", hint))
+		}
+		logger.Error("Failed to obtain certificate, HTTPS won't work until it's obtained. Retrying in the background.", fields...)
+		return
+	}
+
+	logger.Info("Certificates are ready.", zap.Strings("domains", domains))
+}
+
+// nonInteractiveIssuer is an ACME issuer that never prompts for input on stdin
+// (for an account email address), which certmagic otherwise does when
+// obtaining certificates synchronously.
+type nonInteractiveIssuer struct {
+	*certmagic.ACMEManager
+}
+
+func (iss nonInteractiveIssuer) PreCheck(ctx context.Context, names []string, _ bool) error {
+	return iss.ACMEManager.PreCheck(ctx, names, false)
+}
+
+// certErrorHint returns an actionable explanation of an error obtaining a
+// certificate, or an empty string if the cause is unknown.
+func certErrorHint(err error, dnsZone string) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "Obtaining the certificate timed out. If DNS delegation was changed recently, it may not have propagated yet."
+	}
+
+	var problem acme.Problem
+	if errors.As(err, &problem) {
+		types := []string{problem.Type}
+		for _, sub := range problem.Subproblems {
+			types = append(types, sub.Type)
+		}
+		for _, typ := range types {
+			switch typ {
+			case acme.ProblemTypeRateLimited:
+				return "The ACME CA rate limited certificate requests. Wait before retrying, and use --acme-staging while testing."
+			case acme.ProblemTypeDNS:
+				return fmt.Sprintf("The ACME CA failed to resolve the domain. Verify that the NS records of %q in its parent zone delegate it to this server.", dnsZone)
+			case acme.ProblemTypeConnection:
+				return "The ACME CA couldn't connect to this server. Verify that port 53 (UDP and TCP), and ports 80 and 443, are publicly reachable."
+			case acme.ProblemTypeUnauthorized, acme.ProblemTypeIncorrectResponse:
+				return fmt.Sprintf("The ACME CA received an unexpected challenge response. Verify that %q is delegated to this server only, and not answered by other nameservers.", dnsZone)
+			case acme.ProblemTypeCAA:
+				return "A CAA record of the domain doesn't allow the ACME CA to issue certificates."
+			case acme.ProblemTypeExternalAccountRequired:
+				return "The ACME CA requires external account binding, set --acme-eab-kid and --acme-eab-hmac."
+			}
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "The ACME CA couldn't be reached. Verify outbound network access, and the --acme-ca URL."
+	}
+
+	return ""
+}
@@ -12,8 +12,10 @@ import (
 )
 
 var (
-	cfgFile string
-	debug   bool
+	cfgFile  string
+	dataDir  string
+	debug    bool
+	noBanner bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -24,6 +26,9 @@ var rootCmd = &cobra.Command{
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) {},
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if !showBanner(cmd) {
+			return
+		}
 		fmt.Fprintf(os.Stdout, `███████╗██████╗ ███████╗███╗   ██╗ █████╗
 ██╔════╝██╔══██╗██╔════╝████╗  ██║██╔══██╗
 █████╗  ██║  ██║█████╗  ██╔██╗ ██║███████║
@@ -44,12 +49,35 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "sets minimum log level to debug")
+	rootCmd.PersistentFlags().BoolVar(&noBanner, "no-banner", false, "don't print the banner on startup")
+	rootCmd.PersistentFlags().StringVar(&dataDir, "data-dir", "",
+		"directory for storing data (default is $XDG_DATA_HOME/edena or $HOME/.local/share/edena)")
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/.edena.toml)")
 	if err := rootCmd.MarkPersistentFlagFilename("config", "toml"); err != nil {
 		panic(err)
 	}
 }
 
+// showBanner returns if the banner should be printed, which is only the case
+// for interactive use: it's never printed when stdout isn't a terminal, or
+// when the command outputs JSON.
+func showBanner(cmd *cobra.Command) bool {
+	if noBanner {
+		return false
+	}
+
+	if jsonFlag := cmd.Flags().Lookup("json"); jsonFlag != nil && jsonFlag.Value.String() == "true" {
+		return false
+	}
+
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dstotijn/edena/pkg/database/postgres"
+	"github.com/dstotijn/edena/pkg/hosts"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// setenv sets an environment variable (or unsets it, if `value` is empty)
+// until the test ends.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	prev, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+func TestDataDirectory(t *testing.T) {
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	home := t.TempDir()
+
+	tests := []struct {
+		name    string
+		dataDir string
+		xdgData string
+		exp     string
+	}{
+		{
+			name:    "flag set",
+			dataDir: "/srv/edena",
+			xdgData: "/xdg/data",
+			exp:     "/srv/edena",
+		},
+		{
+			name:    "flag set relative to home",
+			dataDir: "~/edena",
+			exp:     filepath.Join(home, "edena"),
+		},
+		{
+			name:    "XDG set",
+			xdgData: "/xdg/data",
+			exp:     filepath.Join("/xdg/data", "edena"),
+		},
+		{
+			name: "neither set",
+			exp:  filepath.Join(home, ".local", "share", "edena"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, "HOME", home)
+			setenv(t, "XDG_DATA_HOME", tt.xdgData)
+
+			prevDataDir := dataDir
+			dataDir = tt.dataDir
+			defer func() { dataDir = prevDataDir }()
+
+			got, err := dataDirectory()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestWithExtraPorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrs    []string
+		ports    []int
+		exp      []string
+		expError bool
+	}{
+		{
+			name:  "no extra ports",
+			addrs: []string{":80"},
+			exp:   []string{":80"},
+		},
+		{
+			name:  "extra ports",
+			addrs: []string{":80"},
+			ports: []int{8080, 8000},
+			exp:   []string{":80", ":8080", ":8000"},
+		},
+		{
+			name:  "multiple hosts",
+			addrs: []string{"127.0.0.1:80", "[::1]:80"},
+			ports: []int{8080},
+			exp:   []string{"127.0.0.1:80", "[::1]:80", "127.0.0.1:8080", "[::1]:8080"},
+		},
+		{
+			name:  "duplicate ports",
+			addrs: []string{":80"},
+			ports: []int{80, 8080, 8080},
+			exp:   []string{":80", ":8080"},
+		},
+		{
+			name:     "invalid port",
+			addrs:    []string{":80"},
+			ports:    []int{65536},
+			expError: true,
+		},
+		{
+			name:     "invalid address",
+			addrs:    []string{"localhost"},
+			ports:    []int{8080},
+			expError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withExtraPorts(tt.addrs, tt.ports)
+			if tt.expError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestHostnamePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		exp      hosts.HostnamePattern
+		expError bool
+	}{
+		{
+			name: "defaults",
+			exp:  hosts.HostnamePattern{Words: 2, Separator: "-", Hash: true, HashLength: hosts.DefaultHostHashLength},
+		},
+		{
+			name:   "words without hash",
+			config: map[string]interface{}{"hostname-words": 3, "hostname-separator": "--", "hostname-hash": false},
+			exp:    hosts.HostnamePattern{Words: 3, Separator: "--", HashLength: hosts.DefaultHostHashLength},
+		},
+		{
+			name:     "negative words",
+			config:   map[string]interface{}{"hostname-words": -1},
+			expError: true,
+		},
+		{
+			name:     "invalid separator",
+			config:   map[string]interface{}{"hostname-separator": "_"},
+			expError: true,
+		},
+		{
+			name:     "hash too short",
+			config:   map[string]interface{}{"hostname-hash-length": 2},
+			expError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.config {
+				key, prev := key, viper.Get(key)
+				viper.Set(key, value)
+				t.Cleanup(func() { viper.Set(key, prev) })
+			}
+
+			got, err := hostnamePattern("example.com")
+			if tt.expError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.exp {
+				t.Errorf("expected %+v, got %+v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestHTTPLogNotifications(t *testing.T) {
+	notifications := make(chan postgres.Notification, 3)
+	notifications <- postgres.Notification{Type: postgres.NotificationTypeDNS}
+	notifications <- postgres.Notification{Type: postgres.NotificationTypeHTTP}
+	notifications <- postgres.Notification{Type: postgres.NotificationTypeTLS}
+	close(notifications)
+
+	var got int
+	for range httpLogNotifications(context.Background(), notifications, zap.NewNop()) {
+		got++
+	}
+	if got != 1 {
+		t.Errorf("expected 1 HTTP log notification, got %v", got)
+	}
+}
+
+func TestWarnHostnameOutsideZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		dnsZone  string
+		expWarn  bool
+	}{
+		{name: "same", hostname: "edena.example.com", dnsZone: "edena.example.com"},
+		{name: "parent zone", hostname: "edena.example.com", dnsZone: "example.com"},
+		{name: "different case and trailing dot", hostname: "Edena.Example.com.", dnsZone: "example.COM"},
+		{name: "sibling", hostname: "edena.example.com", dnsZone: "other.example.com", expWarn: true},
+		{name: "child zone", hostname: "example.com", dnsZone: "edena.example.com", expWarn: true},
+		{name: "suffix without dot", hostname: "edenaexample.com", dnsZone: "example.com", expWarn: true},
+		{name: "other domain", hostname: "edena.example.com", dnsZone: "example.org", expWarn: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			warnHostnameOutsideZone(zap.New(core), tt.hostname, tt.dnsZone)
+
+			if got := logs.Len() == 1; got != tt.expWarn {
+				t.Errorf("expected warning %v, got %v", tt.expWarn, logs.All())
+			}
+		})
+	}
+}
+
+func TestPlanServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []string
+		exp      servicePlan
+		expError bool
+	}{
+		{
+			name:     "dns only",
+			services: []string{"dns"},
+			exp: servicePlan{
+				dns:         true,
+				certDomains: []string{"example.com", "*.example.com"},
+			},
+		},
+		{
+			name:     "http only",
+			services: []string{"http"},
+			exp: servicePlan{
+				http:        true,
+				certDomains: []string{"example.com"},
+			},
+		},
+		{
+			name:     "https without dns",
+			services: []string{"http", "https"},
+			exp: servicePlan{
+				http:        true,
+				https:       true,
+				certDomains: []string{"example.com"},
+			},
+		},
+		{
+			name:     "all",
+			services: []string{"dns", "http", "https"},
+			exp: servicePlan{
+				dns:         true,
+				http:        true,
+				https:       true,
+				dns01:       true,
+				certDomains: []string{"example.com", "*.example.com"},
+			},
+		},
+		{
+			name:     "case and whitespace",
+			services: []string{" DNS", "HTTPS "},
+			exp: servicePlan{
+				dns:         true,
+				https:       true,
+				dns01:       true,
+				certDomains: []string{"example.com", "*.example.com"},
+			},
+		},
+		{
+			name:     "unsupported service",
+			services: []string{"smtp"},
+			expError: true,
+		},
+		{
+			name:     "no services",
+			services: []string{""},
+			expError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planServices(tt.services, "example.com")
+			if tt.expError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("expected %+v, got %+v", tt.exp, got)
+			}
+		})
+	}
+}
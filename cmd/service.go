@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+const (
+	serviceName   = "edena"
+	launchdLabel  = "com.github.dstotijn.edena"
+	systemDataDir = "/var/lib/edena"
+)
+
+var (
+	serviceUserScope bool
+	serviceForce     bool
+)
+
+func init() {
+	rootCmd.AddCommand(installServiceCmd)
+
+	installServiceCmd.Flags().BoolVar(&serviceUserScope, "user", false,
+		"install a service for the current user, instead of a system service")
+	installServiceCmd.Flags().BoolVar(&serviceForce, "force", false, "overwrite an existing service file")
+}
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service [flags] [-- server flags]",
+	Short: "Writes a systemd (Linux) or launchd (macOS) service file for the server.",
+	Long: `Writes a systemd unit (Linux) or launchd property list (macOS) that runs
+"edena server" with the flags given after "--", e.g.:
+
+  edena install-service -- --hostname example.com --services dns,http
+
+The service isn't enabled or started; the path of the service file and the
+commands for doing so are printed instead.
+
+System services on Linux are granted the CAP_NET_BIND_SERVICE capability for
+listening on privileged ports (e.g. 53, 80 and 443), and store data in
+` + systemDataDir + ` unless --data-dir is given. Services installed with --user
+can't be granted capabilities by systemd.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.ArgsLenAtDash() != 0 && len(args) > 0 {
+			return fmt.Errorf("unexpected arguments %q, server flags must follow \"--\"", args)
+		}
+
+		// The server flags are parsed, so invalid flags are reported now
+		// rather than when the service starts.
+		if err := serverCmd.ParseFlags(args); err != nil {
+			return fmt.Errorf("invalid server flags: %w", err)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to find executable: %w", err)
+		}
+		if exe, err = filepath.EvalSymlinks(exe); err != nil {
+			return fmt.Errorf("failed to find executable: %w", err)
+		}
+
+		svc := serviceParams{
+			Args:      append([]string{exe, "server", "--no-banner"}, args...),
+			UserScope: serviceUserScope,
+		}
+
+		var path, next string
+		var tmpl *template.Template
+
+		switch runtime.GOOS {
+		case "linux":
+			if _, err := exec.LookPath("systemctl"); err != nil {
+				return errors.New("systemd (systemctl) not found, only systemd is supported on Linux")
+			}
+			if !svc.UserScope && !serverCmd.Flags().Changed("data-dir") {
+				svc.Args = append(svc.Args, "--data-dir", systemDataDir)
+			}
+			path, next, err = systemdUnitPath(svc.UserScope)
+			tmpl = systemdUnitTemplate
+		case "darwin":
+			if _, err := exec.LookPath("launchctl"); err != nil {
+				return errors.New("launchd (launchctl) not found")
+			}
+			path, next, err = launchdPlistPath(svc.UserScope)
+			tmpl = launchdPlistTemplate
+		default:
+			return fmt.Errorf("installing a service isn't supported on %v", runtime.GOOS)
+		}
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, svc); err != nil {
+			return fmt.Errorf("failed to render service file: %w", err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create service directory: %w", err)
+		}
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if !serviceForce {
+			flags |= os.O_EXCL
+		}
+		f, err := os.OpenFile(path, flags, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("service file %v already exists, use --force to overwrite it", path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create service file: %w", err)
+		}
+		if _, err := buf.WriteTo(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write service file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write service file: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Wrote service file: %v\n\nTo enable and start the service, run:\n\n  %v\n", path, next)
+
+		if runtime.GOOS == "linux" && svc.UserScope {
+			if ports := privilegedPorts(); len(ports) > 0 {
+				fmt.Fprintf(out, "\nThe server listens on privileged ports (%v), which user services can't be\n"+
+					"granted permission for. Either install a system service, listen on other\n"+
+					"ports, or grant the executable the capability with:\n\n  sudo setcap cap_net_bind_service=+ep %v\n",
+					strings.Join(ports, ", "), exe)
+			}
+		}
+
+		return nil
+	},
+}
+
+type serviceParams struct {
+	Args      []string
+	UserScope bool
+}
+
+// systemdUnitPath returns the path of the systemd unit file, and the command
+// for enabling and starting the service.
+func systemdUnitPath(userScope bool) (path, next string, err error) {
+	if !userScope {
+		return filepath.Join("/etc/systemd/system", serviceName+".service"),
+			"sudo systemctl daemon-reload && sudo systemctl enable --now " + serviceName, nil
+	}
+
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := homedir.Dir()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+
+	return filepath.Join(configDir, "systemd", "user", serviceName+".service"),
+		"systemctl --user daemon-reload && systemctl --user enable --now " + serviceName, nil
+}
+
+// launchdPlistPath returns the path of the launchd property list, and the
+// command for loading and starting the service.
+func launchdPlistPath(userScope bool) (path, next string, err error) {
+	if !userScope {
+		path = filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist")
+		return path, "sudo launchctl load -w " + path, nil
+	}
+
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	path = filepath.Join(homeDir, "Library", "LaunchAgents", launchdLabel+".plist")
+
+	return path, "launchctl load -w " + path, nil
+}
+
+// privilegedPorts returns the ports below 1024 of the parsed server flags, for
+// enabled services.
+func privilegedPorts() []string {
+	enabled := make(map[string]bool)
+	for _, s := range services {
+		enabled[s] = true
+	}
+
+	var addrs []string
+	if enabled[serviceDNS] {
+		addrs = append(addrs, dnsAddrs...)
+	}
+	if enabled[serviceHTTP] {
+		httpAddrs, _ := withExtraPorts(httpAddrs, httpExtraPorts)
+		addrs = append(addrs, httpAddrs...)
+	}
+	if enabled[serviceHTTPS] {
+		addrs = append(addrs, tlsAddrs...)
+	}
+
+	var ports []string
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err == nil && n < 1024 && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	return ports
+}
+
+// systemdQuote quotes an argument of a systemd `ExecStart` command line. The
+// `%` and `$` characters are escaped, so they aren't expanded as specifiers
+// or environment variables.
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+var systemdUnitTemplate = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"quote": systemdQuote,
+}).Parse(`[Unit]
+Description=Edena
+Documentation=https://github.com/dstotijn/edena
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart={{range $i, $arg := .Args}}{{if $i}} {{end}}{{quote $arg}}{{end}}
+Restart=on-failure
+RestartSec=5
+{{- if not .UserScope}}
+DynamicUser=yes
+StateDirectory=edena
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+CapabilityBoundingSet=CAP_NET_BIND_SERVICE
+NoNewPrivileges=yes
+{{- end}}
+
+[Install]
+WantedBy={{if .UserScope}}default.target{{else}}multi-user.target{{end}}
+`))
+
+var launchdPlistTemplate = template.Must(template.New("launchd").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + launchdLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+</dict>
+</plist>
+`))
@@ -3,16 +3,22 @@ module github.com/dstotijn/edena
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.3
 	github.com/caddyserver/certmagic v0.14.1
 	github.com/dgraph-io/badger/v3 v3.2103.1
 	github.com/dustinkirkland/golang-petname v0.0.0-20191129215211-8e5a1ed0cff0
 	github.com/gorilla/mux v1.8.0
 	github.com/hashicorp/go-multierror v1.1.1
+	github.com/jackc/pgx/v4 v4.13.0
+	github.com/klauspost/compress v1.12.3
 	github.com/libdns/libdns v0.2.1
+	github.com/mholt/acmez v0.1.3
 	github.com/miekg/dns v1.1.42
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/oklog/ulid v1.3.1
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/viper v1.8.1
 	go.uber.org/zap v1.17.0
+	golang.org/x/net v0.0.0-20210525063256-abc453219eb5
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 )
@@ -0,0 +1,58 @@
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// ErrNotEmpty is returned by Restore when the database isn't empty.
+var ErrNotEmpty = errors.New("badger: database is not empty")
+
+// maxPendingRestoreWrites is the maximum amount of pending writes while
+// restoring a backup.
+const maxPendingRestoreWrites = 256
+
+// Backup writes a full backup of the database to `w`. Backups are consistent
+// snapshots, so they can be created while the database is in use.
+func (db *Database) Backup(w io.Writer) error {
+	_, err := db.badger.Backup(w, 0)
+	if err != nil {
+		return fmt.Errorf("badger: failed to backup database: %w", err)
+	}
+
+	return nil
+}
+
+// Restore loads a backup created with Backup. The database must be empty,
+// because the keys of the backup would otherwise be merged with existing keys,
+// e.g. leaving interaction counts inconsistent.
+func (db *Database) Restore(r io.Reader) error {
+	empty := true
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Rewind()
+		empty = !it.Valid()
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+	if !empty {
+		return ErrNotEmpty
+	}
+
+	err = db.badger.Load(r, maxPendingRestoreWrites)
+	if err != nil {
+		return fmt.Errorf("badger: failed to restore database: %w", err)
+	}
+
+	return nil
+}
@@ -3,10 +3,12 @@ package badger
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"net/http/httputil"
+	"strings"
+	"sync"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/dstotijn/edena/pkg/hosts"
@@ -14,21 +16,43 @@ import (
 )
 
 const (
-	hostKeyPrefix     byte = 0x00
-	hostHostnameIndex byte = 0x01
+	hostKeyPrefix          byte = 0x00
+	hostHostnameIndex      byte = 0x01
+	hostInteractionCounter byte = 0x02
 
 	httpLogKeyPrefix   byte = 0x10
 	httpLogHostIDIndex byte = 0x11
 
+	dnsLogKeyPrefix   byte = 0x20
+	dnsLogHostIDIndex byte = 0x21
+
+	tlsLogKeyPrefix   byte = 0x30
+	tlsLogHostIDIndex byte = 0x31
+
+	storageKeyPrefix byte = 0x40
+	storageLockIndex byte = 0x01
+
 	indexKeyMask byte = 0x0F // Secondary index keys use the last 4 bits
 )
 
+// ErrLocked is returned by OpenDatabase when the database is in use by another
+// process, e.g. a running server.
+var ErrLocked = errors.New("badger: database is locked by another process")
+
 type Database struct {
 	badger *badger.DB
+	// counterMu serializes transactions that increment counters, which would
+	// otherwise conflict when interactions for a host are stored concurrently.
+	counterMu sync.Mutex
 }
 
 func OpenDatabase(opts badger.Options) (*Database, error) {
 	db, err := badger.Open(opts)
+	// Badger doesn't wrap the underlying error, so it can only be detected by
+	// its message.
+	if err != nil && strings.Contains(err.Error(), "Cannot acquire directory lock") {
+		return nil, ErrLocked
+	}
 	if err != nil {
 		return nil, fmt.Errorf("badger: failed to open database: %w", err)
 	}
@@ -81,8 +105,97 @@ func (db *Database) StoreHosts(ctx context.Context, hosts ...hosts.Host) error {
 	return nil
 }
 
+// UpdateHost overwrites an existing host. The hostname can't be changed.
+func (db *Database) UpdateHost(ctx context.Context, host hosts.Host) error {
+	// The interaction count is maintained separately.
+	host.InteractionCount = 0
+
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(host)
+	if err != nil {
+		return fmt.Errorf("badger: failed to encode host: %w", err)
+	}
+
+	key := entryKey(hostKeyPrefix, 0, host.ID[:])
+
+	err = db.badger.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(key); err != nil {
+			return err
+		}
+		return txn.Set(key, buf.Bytes())
+	})
+	if err == badger.ErrKeyNotFound {
+		return hosts.ErrHostNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteHost deletes a host, including its interactions and their indexes.
+func (db *Database) DeleteHost(ctx context.Context, hostID ulid.ULID) error {
+	host, err := db.FindHostByID(ctx, hostID)
+	if err != nil {
+		return err
+	}
+
+	// The host itself is deleted first, so no interactions are stored for it
+	// while its interactions are deleted.
+	keys := [][]byte{
+		entryKey(hostKeyPrefix, 0, hostID[:]),
+		entryKey(hostKeyPrefix, hostHostnameIndex, append([]byte(host.Hostname+"#"), hostID[:]...)),
+		entryKey(hostKeyPrefix, hostInteractionCounter, hostID[:]),
+	}
+
+	err = db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		indexes := []struct{ prefix, index byte }{
+			{httpLogKeyPrefix, httpLogHostIDIndex},
+			{dnsLogKeyPrefix, dnsLogHostIDIndex},
+			{tlsLogKeyPrefix, tlsLogHostIDIndex},
+		}
+		for _, idx := range indexes {
+			prefix := entryKey(idx.prefix, idx.index, hostID[:])
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				indexKey := it.Item().KeyCopy(nil)
+				// The log entry ID starts *after* the first index byte and
+				// the 16 byte host ID.
+				keys = append(keys, indexKey, entryKey(idx.prefix, 0, indexKey[17:]))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	// A write batch is used, because a host can have more interactions than
+	// fit in a single transaction.
+	wb := db.badger.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return fmt.Errorf("badger: failed to delete host: %w", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("badger: failed to delete host: %w", err)
+	}
+
+	return nil
+}
+
 func (db *Database) FindHostByID(ctx context.Context, hostID ulid.ULID) (hosts.Host, error) {
 	var rawHost []byte
+	var interactionCount int
 
 	err := db.badger.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(entryKey(hostKeyPrefix, 0, hostID[:]))
@@ -95,6 +208,11 @@ func (db *Database) FindHostByID(ctx context.Context, hostID ulid.ULID) (hosts.H
 			return err
 		}
 
+		interactionCount, err = hostInteractionCount(txn, hostID[:])
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err == badger.ErrKeyNotFound {
@@ -109,6 +227,7 @@ func (db *Database) FindHostByID(ctx context.Context, hostID ulid.ULID) (hosts.H
 	if err != nil {
 		return hosts.Host{}, fmt.Errorf("badger: failed to decode host: %w", err)
 	}
+	host.InteractionCount = interactionCount
 
 	return host, nil
 }
@@ -164,30 +283,108 @@ func (db *Database) FindHostByHostname(ctx context.Context, hostname string) (ho
 	return host, nil
 }
 
-type httpLogEntry struct {
-	ID          ulid.ULID
-	HostID      ulid.ULID
-	RawRequest  []byte
-	RawResponse []byte
-}
+// ListHosts returns all hosts, including their interaction count.
+func (db *Database) ListHosts(ctx context.Context) ([]hosts.Host, error) {
+	var hostList []hosts.Host
 
-func (db *Database) StoreHTTPLogEntry(ctx context.Context, entry hosts.HTTPLogEntry) error {
-	rawReq, err := httputil.DumpRequest(entry.Request, true)
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var rawHost []byte
+		prefix := []byte{hostKeyPrefix}
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var err error
+			rawHost, err = it.Item().ValueCopy(rawHost)
+			if err != nil {
+				return err
+			}
+
+			host := hosts.Host{}
+			err = gob.NewDecoder(bytes.NewReader(rawHost)).Decode(&host)
+			if err != nil {
+				return fmt.Errorf("failed to decode host: %w", err)
+			}
+
+			host.InteractionCount, err = hostInteractionCount(txn, host.ID[:])
+			if err != nil {
+				return err
+			}
+
+			hostList = append(hostList, host)
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("badger: failed to dump HTTP request: %w", err)
+		return nil, fmt.Errorf("badger: failed to commit transaction: %w", err)
 	}
 
-	rawRes, err := httputil.DumpResponse(entry.Response, true)
+	return hostList, nil
+}
+
+func (db *Database) CountHosts(ctx context.Context) (int, error) {
+	var count int
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		prefix := []byte{hostKeyPrefix}
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			count++
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("badger: failed to dump HTTP request: %w", err)
+		return 0, fmt.Errorf("badger: failed to commit transaction: %w", err)
 	}
 
+	return count, nil
+}
+
+type httpLogEntry struct {
+	ID            ulid.ULID
+	HostID        ulid.ULID
+	RawRequest    []byte
+	RawResponse   []byte
+	RemoteAddr    string
+	ACMEChallenge bool
+	RepeatCount   int
+	// ClientCertificates holds DER encoded certificates, leaf first.
+	ClientCertificates [][]byte
+	BodyDropped        bool
+	ServerName         string
+	RawWire            []byte
+	// ResponseBodyTruncated is set when RawResponse holds only the first part
+	// of the response body.
+	ResponseBodyTruncated bool
+	Label                 string
+}
+
+// dropBodiesBatchSize is the amount of HTTP log entries updated per
+// transaction when dropping bodies, to stay within transaction size limits.
+const dropBodiesBatchSize = 100
+
+func (db *Database) StoreHTTPLogEntry(ctx context.Context, entry hosts.HTTPLogEntry) error {
 	buf := bytes.Buffer{}
-	err = gob.NewEncoder(&buf).Encode(httpLogEntry{
-		ID:          entry.ID,
-		HostID:      entry.HostID,
-		RawRequest:  rawReq,
-		RawResponse: rawRes,
+	err := gob.NewEncoder(&buf).Encode(httpLogEntry{
+		ID:                    entry.ID,
+		HostID:                entry.HostID,
+		RawRequest:            entry.RawRequest,
+		RawResponse:           entry.RawResponse,
+		RemoteAddr:            entry.RemoteAddr,
+		ACMEChallenge:         entry.ACMEChallenge,
+		RepeatCount:           entry.RepeatCount,
+		ClientCertificates:    entry.ClientCertificates,
+		BodyDropped:           entry.BodyDropped,
+		ServerName:            entry.ServerName,
+		RawWire:               entry.RawWire,
+		ResponseBodyTruncated: entry.ResponseBodyTruncated,
+		Label:                 entry.Label,
 	})
 	if err != nil {
 		return fmt.Errorf("badger: failed to encode log entry: %w", err)
@@ -205,25 +402,231 @@ func (db *Database) StoreHTTPLogEntry(ctx context.Context, entry hosts.HTTPLogEn
 		},
 	}
 
-	err = db.badger.Update(func(txn *badger.Txn) error {
+	err = db.updateCounters(func(txn *badger.Txn) error {
 		for i := range entries {
 			err := txn.SetEntry(entries[i])
 			if err != nil {
 				return err
 			}
 		}
+		// Repeats (e.g. of imported entries) count as interactions too.
+		return addHostInteractionCount(txn, entry.HostID[:], 1+entry.RepeatCount)
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) IncrementHTTPLogEntryRepeatCount(ctx context.Context, id ulid.ULID) error {
+	err := db.updateCounters(func(txn *badger.Txn) error {
+		key := entryKey(httpLogKeyPrefix, 0, id[:])
+
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		rawHTTPLogEntry, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		entry := httpLogEntry{}
+		err = gob.NewDecoder(bytes.NewReader(rawHTTPLogEntry)).Decode(&entry)
+		if err != nil {
+			return err
+		}
+
+		entry.RepeatCount++
+
+		buf := bytes.Buffer{}
+		err = gob.NewEncoder(&buf).Encode(entry)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(key, buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		return incrementHostInteractionCount(txn, entry.HostID[:])
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DropHTTPLogEntryBodies drops the request and response bodies of the HTTP log
+// entries of a host that are beyond the body retention limits, starting from
+// the most recent entry. Entries older than an entry with dropped bodies are
+// expected to have dropped bodies too, so they aren't visited.
+func (db *Database) DropHTTPLogEntryBodies(ctx context.Context, hostID ulid.ULID, retention hosts.BodyRetention) error {
+	var keys [][]byte
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var count int
+		var size int64
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := entryKey(httpLogKeyPrefix, httpLogHostIDIndex, hostID[:])
+		// Seek to the last possible key with the prefix, for iterating in
+		// reverse.
+		seekKey := append(append([]byte{}, prefix...), bytes.Repeat([]byte{0xFF}, len(ulid.ULID{}))...)
+
+		for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+			count++
+			// Without a size limit, entries within the count limit don't need
+			// to be read.
+			if retention.Bytes == 0 && !retention.Exceeded(count, 0) {
+				continue
+			}
+
+			// The HTTP log entry ID starts *after* the first index byte and
+			// the 16 byte host ID.
+			key := entryKey(httpLogKeyPrefix, 0, it.Item().Key()[17:])
+
+			item, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+			entry := httpLogEntry{}
+			err = item.Value(func(val []byte) error {
+				return gob.NewDecoder(bytes.NewReader(val)).Decode(&entry)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to decode HTTP log entry: %w", err)
+			}
+			if entry.BodyDropped {
+				break
+			}
+
+			size += hosts.HTTPLogEntryBodySize(entry.RawRequest, entry.RawResponse)
+			if retention.Exceeded(count, size) {
+				keys = append(keys, key)
+			}
+		}
+
 		return nil
 	})
 	if err != nil {
 		return fmt.Errorf("badger: failed to commit transaction: %w", err)
 	}
 
+	// Bodies are dropped oldest first, so when a transaction fails, no entry
+	// with a body is left behind an entry without one.
+	for end := len(keys); end > 0; end -= dropBodiesBatchSize {
+		start := end - dropBodiesBatchSize
+		if start < 0 {
+			start = 0
+		}
+		batch := keys[start:end]
+
+		// Log entries are also updated when incrementing repeat counts.
+		err := db.updateCounters(func(txn *badger.Txn) error {
+			for i := len(batch) - 1; i >= 0; i-- {
+				if err := dropHTTPLogEntryBody(txn, batch[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("badger: failed to commit transaction: %w", err)
+		}
+	}
+
 	return nil
 }
 
+func dropHTTPLogEntryBody(txn *badger.Txn, key []byte) error {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := httpLogEntry{}
+	err = item.Value(func(val []byte) error {
+		return gob.NewDecoder(bytes.NewReader(val)).Decode(&entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode HTTP log entry: %w", err)
+	}
+	if entry.BodyDropped {
+		return nil
+	}
+
+	entry.RawRequest = hosts.StripHTTPBody(entry.RawRequest)
+	entry.RawResponse = hosts.StripHTTPBody(entry.RawResponse)
+	entry.RawWire = hosts.StripHTTPBody(entry.RawWire)
+	entry.BodyDropped = true
+
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return fmt.Errorf("failed to encode HTTP log entry: %w", err)
+	}
+
+	return txn.Set(key, buf.Bytes())
+}
+
 func (db *Database) ListHTTPLogEntries(ctx context.Context, params hosts.ListHTTPLogEntriesParams) ([]hosts.HTTPLogEntry, error) {
 	var httpLogEntries []hosts.HTTPLogEntry
 
+	err := db.WalkHTTPLogEntries(ctx, params, func(httpLogEntry hosts.HTTPLogEntry) error {
+		httpLogEntries = append(httpLogEntries, httpLogEntry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return httpLogEntries, nil
+}
+
+func (db *Database) FindHTTPLogEntryByID(ctx context.Context, id ulid.ULID) (hosts.HTTPLogEntry, error) {
+	var rawHTTPLogEntry []byte
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(entryKey(httpLogKeyPrefix, 0, id[:]))
+		if err != nil {
+			return err
+		}
+
+		rawHTTPLogEntry, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return hosts.HTTPLogEntry{}, hosts.ErrHTTPLogEntryNotFound
+	}
+	if err != nil {
+		return hosts.HTTPLogEntry{}, fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	httpLogEntry := hosts.HTTPLogEntry{}
+	err = gob.NewDecoder(bytes.NewReader(rawHTTPLogEntry)).Decode(&httpLogEntry)
+	if err != nil {
+		return hosts.HTTPLogEntry{}, fmt.Errorf("badger: failed to decode HTTP log entry: %w", err)
+	}
+
+	return httpLogEntry, nil
+}
+
+// WalkHTTPLogEntries calls `fn` for each HTTP log entry matching `params`,
+// without buffering the entries in memory. Walking stops on the first error
+// returned by `fn`.
+func (db *Database) WalkHTTPLogEntries(ctx context.Context, params hosts.ListHTTPLogEntriesParams, fn func(hosts.HTTPLogEntry) error) error {
 	err := db.badger.View(func(txn *badger.Txn) error {
 		var rawHTTPLogEntry []byte
 		opts := badger.DefaultIteratorOptions
@@ -259,18 +662,70 @@ func (db *Database) ListHTTPLogEntries(ctx context.Context, params hosts.ListHTT
 				if err != nil {
 					return err
 				}
+				if !params.Match(httpLogEntry) {
+					continue
+				}
 
-				httpLogEntries = append(httpLogEntries, httpLogEntry)
+				err = fn(httpLogEntry)
+				if err != nil {
+					return err
+				}
 			}
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("badger: failed to commit transaction: %w", err)
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
 	}
 
-	return httpLogEntries, nil
+	return nil
+}
+
+// updateCounters runs `fn` in a read-write transaction that increments one or
+// more counters.
+func (db *Database) updateCounters(fn func(txn *badger.Txn) error) error {
+	db.counterMu.Lock()
+	defer db.counterMu.Unlock()
+
+	return db.badger.Update(fn)
+}
+
+func hostInteractionCount(txn *badger.Txn, hostID []byte) (int, error) {
+	item, err := txn.Get(entryKey(hostKeyPrefix, hostInteractionCounter, hostID))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var count uint64
+	err = item.Value(func(val []byte) error {
+		count = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+func incrementHostInteractionCount(txn *badger.Txn, hostID []byte) error {
+	return addHostInteractionCount(txn, hostID, 1)
+}
+
+func addHostInteractionCount(txn *badger.Txn, hostID []byte, n int) error {
+	count, err := hostInteractionCount(txn, hostID)
+	if err != nil {
+		return err
+	}
+
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, uint64(count+n))
+
+	return txn.Set(entryKey(hostKeyPrefix, hostInteractionCounter, hostID), val)
 }
 
 func entryKey(prefix, indexKey byte, indexValue []byte) []byte {
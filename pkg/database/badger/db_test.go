@@ -0,0 +1,164 @@
+package badger
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/miekg/dns"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := OpenDatabase(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// newTestHost stores a host, and fails the test on error.
+func newTestHost(t *testing.T, db *Database, hostname string) hosts.Host {
+	t.Helper()
+
+	host := hosts.Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: hostname}
+	if err := db.StoreHosts(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+
+	return host
+}
+
+func TestInteractionCount(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	host := newTestHost(t, db, "abc.example.com")
+	other := newTestHost(t, db, "def.example.com")
+
+	expectCount := func(t *testing.T, exp int) {
+		t.Helper()
+
+		found, err := db.FindHostByID(ctx, host.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if found.InteractionCount != exp {
+			t.Errorf("expected interaction count %v, got %v", exp, found.InteractionCount)
+		}
+
+		list, err := db.ListHosts(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts := make(map[ulid.ULID]int)
+		for _, h := range list {
+			counts[h.ID] = h.InteractionCount
+		}
+		if counts[host.ID] != exp {
+			t.Errorf("expected listed interaction count %v, got %v", exp, counts[host.ID])
+		}
+		if counts[other.ID] != 0 {
+			t.Errorf("expected interaction count of other host to be 0, got %v", counts[other.ID])
+		}
+	}
+
+	expectCount(t, 0)
+
+	httpEntryID := ulid.MustNew(ulid.Now(), rand.Reader)
+	err := db.StoreHTTPLogEntry(ctx, hosts.HTTPLogEntry{ID: httpEntryID, HostID: host.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, 1)
+
+	if err := db.IncrementHTTPLogEntryRepeatCount(ctx, httpEntryID); err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, 2)
+
+	query := &dns.Msg{}
+	query.SetQuestion("abc.example.com.", dns.TypeA)
+	err = db.StoreDNSLogEntry(ctx, hosts.DNSLogEntry{
+		ID:     ulid.MustNew(ulid.Now(), rand.Reader),
+		HostID: host.ID,
+		Query:  query,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, 3)
+
+	err = db.StoreTLSLogEntry(ctx, hosts.TLSLogEntry{
+		ID:     ulid.MustNew(ulid.Now(), rand.Reader),
+		HostID: host.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCount(t, 4)
+}
+
+func TestInteractionCountConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	host := newTestHost(t, db, "abc.example.com")
+
+	const n = 20
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- db.StoreHTTPLogEntry(ctx, hosts.HTTPLogEntry{
+				ID:     ulid.MustNew(ulid.Now(), rand.Reader),
+				HostID: host.ID,
+			})
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	found, err := db.FindHostByID(ctx, host.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found.InteractionCount != n {
+		t.Errorf("expected interaction count %v, got %v", n, found.InteractionCount)
+	}
+}
+
+func TestStoreHTTPLogEntryRepeatCount(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+	host := newTestHost(t, db, "abc.example.com")
+
+	entryID := ulid.MustNew(ulid.Now(), rand.Reader)
+	err := db.StoreHTTPLogEntry(ctx, hosts.HTTPLogEntry{ID: entryID, HostID: host.ID, RepeatCount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := db.FindHTTPLogEntryByID(ctx, entryID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.RepeatCount != 3 {
+		t.Errorf("expected repeat count 3, got %v", entry.RepeatCount)
+	}
+
+	found, err := db.FindHostByID(ctx, host.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found.InteractionCount != 4 {
+		t.Errorf("expected interaction count 4, got %v", found.InteractionCount)
+	}
+}
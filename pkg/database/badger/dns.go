@@ -0,0 +1,127 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+type dnsLogEntry struct {
+	ID           ulid.ULID
+	HostID       ulid.ULID
+	RemoteAddr   string
+	ClientSubnet string
+	RawQuery     []byte
+	RawResponse  []byte
+}
+
+func (db *Database) StoreDNSLogEntry(ctx context.Context, entry hosts.DNSLogEntry) error {
+	rawQuery, err := entry.Query.Pack()
+	if err != nil {
+		return fmt.Errorf("badger: failed to pack DNS query: %w", err)
+	}
+
+	var rawRes []byte
+	if entry.Response != nil {
+		rawRes, err = entry.Response.Pack()
+		if err != nil {
+			return fmt.Errorf("badger: failed to pack DNS response: %w", err)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	err = gob.NewEncoder(&buf).Encode(dnsLogEntry{
+		ID:           entry.ID,
+		HostID:       entry.HostID,
+		RemoteAddr:   entry.RemoteAddr,
+		ClientSubnet: entry.ClientSubnet,
+		RawQuery:     rawQuery,
+		RawResponse:  rawRes,
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to encode log entry: %w", err)
+	}
+
+	entries := []*badger.Entry{
+		// DNS log itself
+		{
+			Key:   entryKey(dnsLogKeyPrefix, 0, entry.ID[:]),
+			Value: buf.Bytes(),
+		},
+		// Index by host ID
+		{
+			Key: entryKey(dnsLogKeyPrefix, dnsLogHostIDIndex, append(entry.HostID[:], entry.ID[:]...)),
+		},
+	}
+
+	err = db.updateCounters(func(txn *badger.Txn) error {
+		for i := range entries {
+			err := txn.SetEntry(entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return incrementHostInteractionCount(txn, entry.HostID[:])
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) ListDNSLogEntries(ctx context.Context, params hosts.ListDNSLogEntriesParams) ([]hosts.DNSLogEntry, error) {
+	var dnsLogEntries []hosts.DNSLogEntry
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var rawDNSLogEntry []byte
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for _, hostID := range params.HostIDs {
+			var hostIndexKey []byte
+			prefix := entryKey(dnsLogKeyPrefix, dnsLogHostIDIndex, hostID[:])
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				hostIndexKey = it.Item().KeyCopy(hostIndexKey)
+
+				// The DNS log entry ID starts *after* the first index byte
+				// and the 16 byte host ID.
+				dnsLogEntryID := hostIndexKey[17:]
+
+				item, err := txn.Get(entryKey(dnsLogKeyPrefix, 0, dnsLogEntryID))
+				if err != nil {
+					return err
+				}
+
+				rawDNSLogEntry, err = item.ValueCopy(rawDNSLogEntry)
+				if err != nil {
+					return err
+				}
+
+				dnsLogEntry := hosts.DNSLogEntry{}
+				err = gob.NewDecoder(bytes.NewReader(rawDNSLogEntry)).Decode(&dnsLogEntry)
+				if err != nil {
+					return err
+				}
+
+				dnsLogEntries = append(dnsLogEntries, dnsLogEntry)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return dnsLogEntries, nil
+}
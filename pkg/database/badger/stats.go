@@ -0,0 +1,105 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func (db *Database) Stats(ctx context.Context) (hosts.Stats, error) {
+	var stats hosts.Stats
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var err error
+		if stats.Hosts, _, _, err = countEntries(txn, hostKeyPrefix); err != nil {
+			return err
+		}
+
+		logs := []struct {
+			prefix byte
+			count  *int
+		}{
+			{httpLogKeyPrefix, &stats.HTTPLogEntries},
+			{dnsLogKeyPrefix, &stats.DNSLogEntries},
+			{tlsLogKeyPrefix, &stats.TLSLogEntries},
+		}
+		for _, l := range logs {
+			count, oldest, newest, err := countEntries(txn, l.prefix)
+			if err != nil {
+				return err
+			}
+			*l.count = count
+			if count == 0 {
+				continue
+			}
+			if stats.OldestInteraction.IsZero() || oldest.Before(stats.OldestInteraction) {
+				stats.OldestInteraction = oldest
+			}
+			if newest.After(stats.NewestInteraction) {
+				stats.NewestInteraction = newest
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return hosts.Stats{}, fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	lsm, vlog := db.badger.Size()
+	stats.Size = lsm + vlog
+
+	return stats, nil
+}
+
+// countEntries counts the entries with a key prefix, iterating over keys only.
+// Keys consist of the prefix and a ULID, so the first and last keys hold the
+// times of the oldest and newest entries, which are returned as well.
+func countEntries(txn *badger.Txn, prefix byte) (count int, oldest, newest time.Time, err error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var first, last []byte
+	for it.Seek([]byte{prefix}); it.ValidForPrefix([]byte{prefix}); it.Next() {
+		if count == 0 {
+			first = it.Item().KeyCopy(nil)
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, time.Time{}, time.Time{}, nil
+	}
+
+	// The last key is found with a reverse iterator, rather than copying
+	// every key while counting.
+	opts.Reverse = true
+	rit := txn.NewIterator(opts)
+	defer rit.Close()
+
+	seekKey := append([]byte{prefix}, bytes.Repeat([]byte{0xFF}, len(ulid.ULID{}))...)
+	rit.Seek(seekKey)
+	if rit.ValidForPrefix([]byte{prefix}) {
+		last = rit.Item().KeyCopy(nil)
+	}
+
+	return count, keyTime(first), keyTime(last), nil
+}
+
+// keyTime returns the time of the ULID in a key, which follows the prefix.
+func keyTime(key []byte) time.Time {
+	var id ulid.ULID
+	if len(key) != 1+len(id) {
+		return time.Time{}
+	}
+	copy(id[:], key[1:])
+
+	return ulid.Time(id.Time()).UTC()
+}
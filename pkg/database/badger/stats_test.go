@@ -0,0 +1,88 @@
+package badger
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	stats, err := db.Stats(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Hosts != 0 || stats.HTTPLogEntries != 0 || stats.DNSLogEntries != 0 || stats.TLSLogEntries != 0 {
+		t.Errorf("expected no entries in empty database, got %+v", stats)
+	}
+	if !stats.OldestInteraction.IsZero() || !stats.NewestInteraction.IsZero() {
+		t.Errorf("expected no interaction times in empty database, got %+v", stats)
+	}
+
+	hostA := newTestHost(t, db, "abc.example.com")
+	hostB := newTestHost(t, db, "def.example.com")
+	newTestHost(t, db, "ghi.example.com")
+
+	oldest := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	newest := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	newID := func(t time.Time) ulid.ULID {
+		return ulid.MustNew(ulid.Timestamp(t), rand.Reader)
+	}
+
+	httpTimes := []time.Time{oldest.Add(time.Hour), oldest, newest.Add(-time.Hour)}
+	for i, ts := range httpTimes {
+		host := hostA
+		if i%2 == 1 {
+			host = hostB
+		}
+		if err := db.StoreHTTPLogEntry(ctx, hosts.HTTPLogEntry{ID: newID(ts), HostID: host.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := &dns.Msg{}
+	query.SetQuestion("abc.example.com.", dns.TypeA)
+	for _, ts := range []time.Time{oldest.Add(2 * time.Hour), newest} {
+		err := db.StoreDNSLogEntry(ctx, hosts.DNSLogEntry{ID: newID(ts), HostID: hostA.ID, Query: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.StoreTLSLogEntry(ctx, hosts.TLSLogEntry{ID: newID(oldest.Add(3 * time.Hour)), HostID: hostB.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = db.Stats(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Secondary index keys and interaction counters aren't counted.
+	if stats.Hosts != 3 {
+		t.Errorf("expected 3 hosts, got %v", stats.Hosts)
+	}
+	if stats.HTTPLogEntries != 3 {
+		t.Errorf("expected 3 HTTP log entries, got %v", stats.HTTPLogEntries)
+	}
+	if stats.DNSLogEntries != 2 {
+		t.Errorf("expected 2 DNS log entries, got %v", stats.DNSLogEntries)
+	}
+	if stats.TLSLogEntries != 1 {
+		t.Errorf("expected 1 TLS log entry, got %v", stats.TLSLogEntries)
+	}
+	if !stats.OldestInteraction.Equal(oldest) {
+		t.Errorf("expected oldest interaction %v, got %v", oldest, stats.OldestInteraction)
+	}
+	if !stats.NewestInteraction.Equal(newest) {
+		t.Errorf("expected newest interaction %v, got %v", newest, stats.NewestInteraction)
+	}
+}
@@ -0,0 +1,331 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// Interface guard.
+var _ certmagic.Storage = (*Storage)(nil)
+
+// errLockHeld is used for aborting a transaction when a lock is held.
+var errLockHeld = errors.New("badger: lock is held")
+
+const (
+	// storageLockTTL is the time after which a lock expires, unless it's
+	// refreshed. It prevents deadlocks after a crash.
+	storageLockTTL = 15 * time.Second
+	// storageLockRefreshInterval is the interval for refreshing held locks.
+	storageLockRefreshInterval = 5 * time.Second
+	// storageLockPollInterval is the interval for checking if a lock held by
+	// another caller was released.
+	storageLockPollInterval = 50 * time.Millisecond
+)
+
+// Storage implements certmagic.Storage on top of the database, for storing
+// certificates, ACME accounts and DNS zonefiles along with all other data.
+type Storage struct {
+	db *Database
+
+	mu    sync.Mutex
+	locks map[string]storageLock
+}
+
+type storageLock struct {
+	token []byte
+	done  chan struct{}
+}
+
+// storageValue is the encoded value of a storage key.
+type storageValue struct {
+	Value    []byte
+	Modified time.Time
+}
+
+// NewStorage returns a certmagic.Storage backed by `db`.
+func NewStorage(db *Database) *Storage {
+	return &Storage{
+		db:    db,
+		locks: make(map[string]storageLock),
+	}
+}
+
+// Store puts value at key.
+func (s *Storage) Store(key string, value []byte) error {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(storageValue{
+		Value:    value,
+		Modified: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to encode storage value: %w", err)
+	}
+
+	err = s.db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(storageKey(key), buf.Bytes())
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Load retrieves the value at key. If the key doesn't exist, an error of type
+// certmagic.ErrNotExist is returned.
+func (s *Storage) Load(key string) ([]byte, error) {
+	val, err := s.load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return val.Value, nil
+}
+
+func (s *Storage) load(key string) (storageValue, error) {
+	var val storageValue
+
+	err := s.db.badger.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(storageKey(key))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(raw []byte) error {
+			return gob.NewDecoder(bytes.NewReader(raw)).Decode(&val)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return storageValue{}, certmagic.ErrNotExist(fmt.Errorf("badger: storage key %q not found", key))
+	}
+	if err != nil {
+		return storageValue{}, fmt.Errorf("badger: failed to load storage key %q: %w", key, err)
+	}
+
+	return val, nil
+}
+
+// Delete deletes key. Deleting a key that doesn't exist isn't an error.
+func (s *Storage) Delete(key string) error {
+	err := s.db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Delete(storageKey(key))
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Exists returns true if the key exists.
+func (s *Storage) Exists(key string) bool {
+	_, err := s.load(key)
+	return err == nil
+}
+
+// List returns all keys that start with `prefix`, followed by a `/`. Unless
+// `recursive` is true, keys are cut off after the first path segment, similar
+// to listing a directory.
+func (s *Storage) List(prefix string, recursive bool) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+
+	dir := strings.TrimSuffix(prefix, "/")
+	if dir != "" {
+		dir += "/"
+	}
+
+	err := s.db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		keyPrefix := storageKey(dir)
+		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			key := string(it.Item().Key()[1:])
+			if !recursive {
+				if i := strings.Index(key[len(dir):], "/"); i != -1 {
+					key = key[:len(dir)+i]
+				}
+			}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Stat returns information about key. Keys that only prefix other keys (like
+// directories) aren't terminal.
+func (s *Storage) Stat(key string) (certmagic.KeyInfo, error) {
+	val, err := s.load(key)
+	var errNotExist certmagic.ErrNotExist
+	if err != nil && !errors.As(err, &errNotExist) {
+		return certmagic.KeyInfo{}, err
+	}
+	if err == nil {
+		return certmagic.KeyInfo{
+			Key:        key,
+			Modified:   val.Modified,
+			Size:       int64(len(val.Value)),
+			IsTerminal: true,
+		}, nil
+	}
+
+	keys, err := s.List(key, false)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	if len(keys) == 0 {
+		return certmagic.KeyInfo{}, certmagic.ErrNotExist(fmt.Errorf("badger: storage key %q not found", key))
+	}
+
+	return certmagic.KeyInfo{Key: key}, nil
+}
+
+// Lock acquires the lock for key, blocking until the lock can be obtained or
+// `ctx` is done. Locks are stored in the database with an expiry, which is
+// extended until Unlock is called, so a lock left behind after a crash
+// eventually expires.
+func (s *Storage) Lock(ctx context.Context, key string) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("badger: failed to generate lock token: %w", err)
+	}
+
+	for {
+		ok, err := s.tryLock(key, token)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(storageLockPollInterval):
+		}
+	}
+
+	lock := storageLock{token: token, done: make(chan struct{})}
+	s.mu.Lock()
+	s.locks[key] = lock
+	s.mu.Unlock()
+
+	go s.refreshLock(key, lock)
+
+	return nil
+}
+
+// tryLock stores a lock for key, unless a lock exists.
+func (s *Storage) tryLock(key string, token []byte) (bool, error) {
+	err := s.db.badger.Update(func(txn *badger.Txn) error {
+		_, err := txn.Get(storageLockKey(key))
+		if err == nil {
+			return errLockHeld
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry(storageLockKey(key), token).WithTTL(storageLockTTL))
+	})
+	if err == errLockHeld || err == badger.ErrConflict {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("badger: failed to obtain lock %q: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// refreshLock extends the expiry of a lock, until it's released.
+func (s *Storage) refreshLock(key string, lock storageLock) {
+	ticker := time.NewTicker(storageLockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.done:
+			return
+		case <-ticker.C:
+			// Errors are ignored, the next refresh is tried before the lock
+			// expires.
+			_ = s.db.badger.Update(func(txn *badger.Txn) error {
+				if !s.ownsLock(txn, key, lock.token) {
+					return nil
+				}
+				return txn.SetEntry(badger.NewEntry(storageLockKey(key), lock.token).WithTTL(storageLockTTL))
+			})
+		}
+	}
+}
+
+// Unlock releases the lock for key.
+func (s *Storage) Unlock(key string) error {
+	s.mu.Lock()
+	lock, ok := s.locks[key]
+	delete(s.locks, key)
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("badger: lock %q isn't held", key)
+	}
+	close(lock.done)
+
+	err := s.db.badger.Update(func(txn *badger.Txn) error {
+		if !s.ownsLock(txn, key, lock.token) {
+			return nil
+		}
+		return txn.Delete(storageLockKey(key))
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to release lock %q: %w", key, err)
+	}
+
+	return nil
+}
+
+// ownsLock returns whether the lock for key exists and has `token`, i.e. it
+// didn't expire and wasn't obtained by another caller since.
+func (s *Storage) ownsLock(txn *badger.Txn, key string, token []byte) bool {
+	item, err := txn.Get(storageLockKey(key))
+	if err != nil {
+		return false
+	}
+	owned := false
+	_ = item.Value(func(val []byte) error {
+		owned = bytes.Equal(val, token)
+		return nil
+	})
+
+	return owned
+}
+
+func storageKey(key string) []byte {
+	return entryKey(storageKeyPrefix, 0, []byte(key))
+}
+
+func storageLockKey(key string) []byte {
+	return entryKey(storageKeyPrefix, storageLockIndex, []byte(key))
+}
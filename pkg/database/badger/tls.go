@@ -0,0 +1,97 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v3"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func (db *Database) StoreTLSLogEntry(ctx context.Context, entry hosts.TLSLogEntry) error {
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(entry)
+	if err != nil {
+		return fmt.Errorf("badger: failed to encode log entry: %w", err)
+	}
+
+	entries := []*badger.Entry{
+		// TLS log itself
+		{
+			Key:   entryKey(tlsLogKeyPrefix, 0, entry.ID[:]),
+			Value: buf.Bytes(),
+		},
+		// Index by host ID
+		{
+			Key: entryKey(tlsLogKeyPrefix, tlsLogHostIDIndex, append(entry.HostID[:], entry.ID[:]...)),
+		},
+	}
+
+	err = db.updateCounters(func(txn *badger.Txn) error {
+		for i := range entries {
+			err := txn.SetEntry(entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return incrementHostInteractionCount(txn, entry.HostID[:])
+	})
+	if err != nil {
+		return fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) ListTLSLogEntries(ctx context.Context, params hosts.ListTLSLogEntriesParams) ([]hosts.TLSLogEntry, error) {
+	var tlsLogEntries []hosts.TLSLogEntry
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var rawTLSLogEntry []byte
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for _, hostID := range params.HostIDs {
+			var hostIndexKey []byte
+			prefix := entryKey(tlsLogKeyPrefix, tlsLogHostIDIndex, hostID[:])
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				hostIndexKey = it.Item().KeyCopy(hostIndexKey)
+
+				// The TLS log entry ID starts *after* the first index byte
+				// and the 16 byte host ID.
+				tlsLogEntryID := hostIndexKey[17:]
+
+				item, err := txn.Get(entryKey(tlsLogKeyPrefix, 0, tlsLogEntryID))
+				if err != nil {
+					return err
+				}
+
+				rawTLSLogEntry, err = item.ValueCopy(rawTLSLogEntry)
+				if err != nil {
+					return err
+				}
+
+				tlsLogEntry := hosts.TLSLogEntry{}
+				err = gob.NewDecoder(bytes.NewReader(rawTLSLogEntry)).Decode(&tlsLogEntry)
+				if err != nil {
+					return err
+				}
+
+				tlsLogEntries = append(tlsLogEntries, tlsLogEntry)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("badger: failed to commit transaction: %w", err)
+	}
+
+	return tlsLogEntries, nil
+}
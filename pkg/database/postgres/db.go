@@ -0,0 +1,678 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// Interface guard.
+var _ hosts.Database = (*Database)(nil)
+
+// NotificationChannel is the Postgres channel on which a notification is sent
+// for every stored interaction, so that multiple Edena instances sharing the
+// same database can stream interactions received by any of them.
+const NotificationChannel = "edena_interactions"
+
+// Notification types, by kind of stored interaction.
+const (
+	NotificationTypeHTTP = "http"
+	NotificationTypeDNS  = "dns"
+	NotificationTypeTLS  = "tls"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS hosts (
+	id       bytea PRIMARY KEY,
+	hostname text NOT NULL UNIQUE
+);
+
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS interaction_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS response_delay_ms bigint NOT NULL DEFAULT 0;
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS label text NOT NULL DEFAULT '';
+ALTER TABLE hosts ADD COLUMN IF NOT EXISTS acme_dns boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS http_logs (
+	id             bytea PRIMARY KEY,
+	host_id        bytea NOT NULL REFERENCES hosts (id) ON DELETE CASCADE,
+	raw_request    bytea NOT NULL,
+	raw_response   bytea NOT NULL,
+	acme_challenge boolean NOT NULL DEFAULT false,
+	repeat_count   integer NOT NULL DEFAULT 0
+);
+
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS remote_addr text NOT NULL DEFAULT '';
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS client_certificates bytea[] NOT NULL DEFAULT '{}';
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS body_dropped boolean NOT NULL DEFAULT false;
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS server_name text NOT NULL DEFAULT '';
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS raw_wire bytea;
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS response_body_truncated boolean NOT NULL DEFAULT false;
+ALTER TABLE http_logs ADD COLUMN IF NOT EXISTS label text NOT NULL DEFAULT '';
+
+-- edena_http_headers returns the header section of a raw HTTP/1.x message.
+CREATE OR REPLACE FUNCTION edena_http_headers(raw bytea) RETURNS bytea AS $$
+	SELECT CASE
+		WHEN position('\x0d0a0d0a'::bytea IN raw) > 0 THEN substring(raw FROM 1 FOR position('\x0d0a0d0a'::bytea IN raw) + 3)
+		ELSE raw
+	END
+$$ LANGUAGE sql IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS http_logs_host_id_idx ON http_logs (host_id, id);
+
+CREATE TABLE IF NOT EXISTS dns_logs (
+	id            bytea PRIMARY KEY,
+	host_id       bytea NOT NULL REFERENCES hosts (id) ON DELETE CASCADE,
+	remote_addr   text NOT NULL,
+	client_subnet text NOT NULL,
+	raw_query     bytea NOT NULL,
+	raw_response  bytea
+);
+
+CREATE INDEX IF NOT EXISTS dns_logs_host_id_idx ON dns_logs (host_id, id);
+
+CREATE TABLE IF NOT EXISTS tls_logs (
+	id               bytea PRIMARY KEY,
+	host_id          bytea NOT NULL REFERENCES hosts (id) ON DELETE CASCADE,
+	remote_addr      text NOT NULL,
+	server_name      text NOT NULL,
+	supported_protos text[] NOT NULL DEFAULT '{}'
+);
+
+ALTER TABLE tls_logs ADD COLUMN IF NOT EXISTS ja3 text NOT NULL DEFAULT '';
+ALTER TABLE tls_logs ADD COLUMN IF NOT EXISTS ja4 text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tls_logs_host_id_idx ON tls_logs (host_id, id);
+`
+
+// Notification is the payload of a notification sent on NotificationChannel.
+type Notification struct {
+	Type   string    `json:"type"`
+	ID     ulid.ULID `json:"id"`
+	HostID ulid.ULID `json:"hostId"`
+}
+
+type Database struct {
+	pool *pgxpool.Pool
+}
+
+// OpenDatabase connects to a Postgres database using a connection string (DSN)
+// and creates the database schema if it doesn't exist yet.
+func OpenDatabase(ctx context.Context, dsn string) (*Database, error) {
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to connect to database: %w", err)
+	}
+
+	_, err = pool.Exec(ctx, schema)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("postgres: failed to create schema: %w", err)
+	}
+
+	return &Database{pool: pool}, nil
+}
+
+func (db *Database) Close() error {
+	db.pool.Close()
+	return nil
+}
+
+func (db *Database) StoreHosts(ctx context.Context, hosts ...hosts.Host) error {
+	if len(hosts) == 0 {
+		return errors.New("postgres: hosts cannot be 0 length")
+	}
+
+	err := db.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		for _, host := range hosts {
+			_, err := tx.Exec(ctx,
+				`INSERT INTO hosts (id, hostname, response_delay_ms, expires_at, label, acme_dns) VALUES ($1, $2, $3, $4, $5, $6)`,
+				host.ID, host.Hostname, host.ResponseDelay.Milliseconds(), nullTime(host.ExpiresAt), host.Label, host.ACMEDNS,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) FindHostByID(ctx context.Context, hostID ulid.ULID) (hosts.Host, error) {
+	host := hosts.Host{}
+
+	var responseDelayMs int64
+	var expiresAt *time.Time
+
+	err := db.pool.QueryRow(ctx,
+		`SELECT id, hostname, interaction_count, response_delay_ms, expires_at, label, acme_dns FROM hosts WHERE id = $1`,
+		hostID,
+	).Scan(&host.ID, &host.Hostname, &host.InteractionCount, &responseDelayMs, &expiresAt, &host.Label, &host.ACMEDNS)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hosts.Host{}, hosts.ErrHostNotFound
+	}
+	if err != nil {
+		return hosts.Host{}, fmt.Errorf("postgres: failed to query host: %w", err)
+	}
+	host.ResponseDelay = time.Duration(responseDelayMs) * time.Millisecond
+	if expiresAt != nil {
+		host.ExpiresAt = expiresAt.UTC()
+	}
+
+	return host, nil
+}
+
+func (db *Database) FindHostByHostname(ctx context.Context, hostname string) (hosts.Host, error) {
+	host := hosts.Host{}
+	var responseDelayMs int64
+	var expiresAt *time.Time
+
+	err := db.pool.QueryRow(ctx,
+		`SELECT id, hostname, response_delay_ms, expires_at, label, acme_dns FROM hosts WHERE hostname = $1`,
+		hostname,
+	).Scan(&host.ID, &host.Hostname, &responseDelayMs, &expiresAt, &host.Label, &host.ACMEDNS)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hosts.Host{}, hosts.ErrHostNotFound
+	}
+	if err != nil {
+		return hosts.Host{}, fmt.Errorf("postgres: failed to query host: %w", err)
+	}
+	host.ResponseDelay = time.Duration(responseDelayMs) * time.Millisecond
+	if expiresAt != nil {
+		host.ExpiresAt = expiresAt.UTC()
+	}
+
+	return host, nil
+}
+
+// UpdateHost overwrites the settings of an existing host. The hostname can't
+// be changed.
+func (db *Database) UpdateHost(ctx context.Context, host hosts.Host) error {
+	tag, err := db.pool.Exec(ctx,
+		`UPDATE hosts SET response_delay_ms = $2, label = $3 WHERE id = $1`,
+		host.ID, host.ResponseDelay.Milliseconds(), host.Label,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: failed to update host: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return hosts.ErrHostNotFound
+	}
+
+	return nil
+}
+
+// ListHosts returns all hosts, including their interaction count.
+func (db *Database) ListHosts(ctx context.Context) ([]hosts.Host, error) {
+	var hostList []hosts.Host
+
+	rows, err := db.pool.Query(ctx, `SELECT id, hostname, interaction_count, response_delay_ms, expires_at, label, acme_dns FROM hosts ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to query hosts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		host := hosts.Host{}
+		var responseDelayMs int64
+		var expiresAt *time.Time
+		err := rows.Scan(&host.ID, &host.Hostname, &host.InteractionCount, &responseDelayMs, &expiresAt, &host.Label, &host.ACMEDNS)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: failed to scan host: %w", err)
+		}
+		host.ResponseDelay = time.Duration(responseDelayMs) * time.Millisecond
+		if expiresAt != nil {
+			host.ExpiresAt = expiresAt.UTC()
+		}
+		hostList = append(hostList, host)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: failed to query hosts: %w", err)
+	}
+
+	return hostList, nil
+}
+
+// DeleteHost deletes a host. Its interactions are deleted by cascade.
+func (db *Database) DeleteHost(ctx context.Context, hostID ulid.ULID) error {
+	tag, err := db.pool.Exec(ctx, `DELETE FROM hosts WHERE id = $1`, hostID)
+	if err != nil {
+		return fmt.Errorf("postgres: failed to delete host: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return hosts.ErrHostNotFound
+	}
+
+	return nil
+}
+
+// nullTime returns nil for the zero time, which is stored as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (db *Database) CountHosts(ctx context.Context) (int, error) {
+	var count int
+
+	err := db.pool.QueryRow(ctx, `SELECT count(*) FROM hosts`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: failed to count hosts: %w", err)
+	}
+
+	return count, nil
+}
+
+const statsQuery = `
+WITH logs AS (
+	SELECT id FROM http_logs
+	UNION ALL SELECT id FROM dns_logs
+	UNION ALL SELECT id FROM tls_logs
+)
+SELECT
+	(SELECT count(*) FROM hosts),
+	(SELECT count(*) FROM http_logs),
+	(SELECT count(*) FROM dns_logs),
+	(SELECT count(*) FROM tls_logs),
+	(SELECT id FROM logs ORDER BY id ASC LIMIT 1),
+	(SELECT id FROM logs ORDER BY id DESC LIMIT 1),
+	pg_database_size(current_database())`
+
+func (db *Database) Stats(ctx context.Context) (hosts.Stats, error) {
+	var (
+		stats          hosts.Stats
+		oldest, newest []byte
+	)
+
+	err := db.pool.QueryRow(ctx, statsQuery).Scan(
+		&stats.Hosts,
+		&stats.HTTPLogEntries,
+		&stats.DNSLogEntries,
+		&stats.TLSLogEntries,
+		&oldest,
+		&newest,
+		&stats.Size,
+	)
+	if err != nil {
+		return hosts.Stats{}, fmt.Errorf("postgres: failed to query stats: %w", err)
+	}
+
+	// IDs are ULIDs, so their byte order matches the order of their times.
+	stats.OldestInteraction = idTime(oldest)
+	stats.NewestInteraction = idTime(newest)
+
+	return stats, nil
+}
+
+func idTime(b []byte) time.Time {
+	var id ulid.ULID
+	if len(b) != len(id) {
+		return time.Time{}
+	}
+	copy(id[:], b)
+
+	return ulid.Time(id.Time()).UTC()
+}
+
+func (db *Database) StoreHTTPLogEntry(ctx context.Context, entry hosts.HTTPLogEntry) error {
+	// A nil slice would be encoded as NULL.
+	clientCerts := entry.ClientCertificates
+	if clientCerts == nil {
+		clientCerts = [][]byte{}
+	}
+
+	err := db.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO http_logs (id, host_id, raw_request, raw_response, remote_addr, acme_challenge, client_certificates, body_dropped, server_name, raw_wire,
+				response_body_truncated, label, repeat_count)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			entry.ID, entry.HostID, entry.RawRequest, entry.RawResponse, entry.RemoteAddr, entry.ACMEChallenge, clientCerts, entry.BodyDropped,
+			entry.ServerName, entry.RawWire, entry.ResponseBodyTruncated, entry.Label, entry.RepeatCount,
+		)
+		if err != nil {
+			return err
+		}
+		// Repeats (e.g. of imported entries) count as interactions too.
+		if err := addInteractionCount(ctx, tx, entry.HostID, 1+entry.RepeatCount); err != nil {
+			return err
+		}
+		return notify(ctx, tx, Notification{Type: NotificationTypeHTTP, ID: entry.ID, HostID: entry.HostID})
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) IncrementHTTPLogEntryRepeatCount(ctx context.Context, id ulid.ULID) error {
+	err := db.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var hostID ulid.ULID
+		err := tx.QueryRow(ctx,
+			`UPDATE http_logs SET repeat_count = repeat_count + 1 WHERE id = $1 RETURNING host_id`,
+			id,
+		).Scan(&hostID)
+		if err != nil {
+			return err
+		}
+		return incrementInteractionCount(ctx, tx, hostID)
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: failed to update HTTP log entry: %w", err)
+	}
+
+	return nil
+}
+
+// DropHTTPLogEntryBodies drops the request and response bodies of the HTTP log
+// entries of a host that are beyond the body retention limits, starting from
+// the most recent entry.
+func (db *Database) DropHTTPLogEntryBodies(ctx context.Context, hostID ulid.ULID, retention hosts.BodyRetention) error {
+	_, err := db.pool.Exec(ctx,
+		`UPDATE http_logs
+		SET raw_request = edena_http_headers(raw_request),
+			raw_response = edena_http_headers(raw_response),
+			raw_wire = edena_http_headers(raw_wire),
+			body_dropped = true
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, body_dropped,
+					row_number() OVER w AS count,
+					sum(octet_length(raw_request) - octet_length(edena_http_headers(raw_request)) +
+						octet_length(raw_response) - octet_length(edena_http_headers(raw_response))) OVER w AS size
+				FROM http_logs
+				WHERE host_id = $1
+				WINDOW w AS (ORDER BY id DESC)
+			) e
+			WHERE NOT body_dropped AND (($2::bigint > 0 AND count > $2::bigint) OR ($3::bigint > 0 AND size > $3::bigint))
+		)`,
+		hostID, int64(retention.Entries), retention.Bytes,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: failed to drop bodies of HTTP log entries: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) ListHTTPLogEntries(ctx context.Context, params hosts.ListHTTPLogEntriesParams) ([]hosts.HTTPLogEntry, error) {
+	var httpLogEntries []hosts.HTTPLogEntry
+
+	err := db.WalkHTTPLogEntries(ctx, params, func(httpLogEntry hosts.HTTPLogEntry) error {
+		httpLogEntries = append(httpLogEntries, httpLogEntry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return httpLogEntries, nil
+}
+
+func (db *Database) FindHTTPLogEntryByID(ctx context.Context, id ulid.ULID) (hosts.HTTPLogEntry, error) {
+	entry := hosts.HTTPLogEntry{}
+
+	err := db.pool.QueryRow(ctx,
+		`SELECT id, host_id, raw_request, raw_response, remote_addr, acme_challenge, repeat_count, client_certificates, body_dropped, server_name, raw_wire,
+			response_body_truncated, label
+		FROM http_logs
+		WHERE id = $1`,
+		id,
+	).Scan(&entry.ID, &entry.HostID, &entry.RawRequest, &entry.RawResponse, &entry.RemoteAddr, &entry.ACMEChallenge, &entry.RepeatCount, &entry.ClientCertificates, &entry.BodyDropped, &entry.ServerName, &entry.RawWire, &entry.ResponseBodyTruncated, &entry.Label)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hosts.HTTPLogEntry{}, hosts.ErrHTTPLogEntryNotFound
+	}
+	if err != nil {
+		return hosts.HTTPLogEntry{}, fmt.Errorf("postgres: failed to query HTTP log entry: %w", err)
+	}
+
+	return entry, nil
+}
+
+// WalkHTTPLogEntries calls `fn` for each HTTP log entry matching `params`,
+// without buffering the entries in memory. Walking stops on the first error
+// returned by `fn`.
+func (db *Database) WalkHTTPLogEntries(ctx context.Context, params hosts.ListHTTPLogEntriesParams, fn func(hosts.HTTPLogEntry) error) error {
+	rows, err := db.pool.Query(ctx,
+		`SELECT id, host_id, raw_request, raw_response, remote_addr, acme_challenge, repeat_count, client_certificates, body_dropped, server_name, raw_wire,
+			response_body_truncated, label
+		FROM http_logs
+		WHERE host_id = ANY($1)
+		ORDER BY host_id, id`,
+		ulidsToBytes(params.HostIDs),
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: failed to query HTTP log entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := hosts.HTTPLogEntry{}
+		err := rows.Scan(&entry.ID, &entry.HostID, &entry.RawRequest, &entry.RawResponse, &entry.RemoteAddr, &entry.ACMEChallenge, &entry.RepeatCount, &entry.ClientCertificates, &entry.BodyDropped, &entry.ServerName, &entry.RawWire, &entry.ResponseBodyTruncated, &entry.Label)
+		if err != nil {
+			return fmt.Errorf("postgres: failed to scan HTTP log entry: %w", err)
+		}
+		if !params.Match(entry) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("postgres: failed to query HTTP log entries: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) StoreDNSLogEntry(ctx context.Context, entry hosts.DNSLogEntry) error {
+	rawQuery, err := entry.Query.Pack()
+	if err != nil {
+		return fmt.Errorf("postgres: failed to pack DNS query: %w", err)
+	}
+
+	var rawRes []byte
+	if entry.Response != nil {
+		rawRes, err = entry.Response.Pack()
+		if err != nil {
+			return fmt.Errorf("postgres: failed to pack DNS response: %w", err)
+		}
+	}
+
+	err = db.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO dns_logs (id, host_id, remote_addr, client_subnet, raw_query, raw_response)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			entry.ID, entry.HostID, entry.RemoteAddr, entry.ClientSubnet, rawQuery, rawRes,
+		)
+		if err != nil {
+			return err
+		}
+		if err := incrementInteractionCount(ctx, tx, entry.HostID); err != nil {
+			return err
+		}
+		return notify(ctx, tx, Notification{Type: NotificationTypeDNS, ID: entry.ID, HostID: entry.HostID})
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) ListDNSLogEntries(ctx context.Context, params hosts.ListDNSLogEntriesParams) ([]hosts.DNSLogEntry, error) {
+	var dnsLogEntries []hosts.DNSLogEntry
+
+	rows, err := db.pool.Query(ctx,
+		`SELECT id, host_id, remote_addr, client_subnet, raw_query, raw_response
+		FROM dns_logs
+		WHERE host_id = ANY($1)
+		ORDER BY host_id, id`,
+		ulidsToBytes(params.HostIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to query DNS log entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := hosts.DNSLogEntry{}
+		err := rows.Scan(&entry.ID, &entry.HostID, &entry.RemoteAddr, &entry.ClientSubnet, &entry.RawQuery, &entry.RawResponse)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: failed to scan DNS log entry: %w", err)
+		}
+		dnsLogEntries = append(dnsLogEntries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: failed to query DNS log entries: %w", err)
+	}
+
+	return dnsLogEntries, nil
+}
+
+func (db *Database) StoreTLSLogEntry(ctx context.Context, entry hosts.TLSLogEntry) error {
+	supportedProtos := entry.SupportedProtos
+	if supportedProtos == nil {
+		supportedProtos = []string{}
+	}
+
+	err := db.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO tls_logs (id, host_id, remote_addr, server_name, supported_protos, ja3, ja4)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			entry.ID, entry.HostID, entry.RemoteAddr, entry.ServerName, supportedProtos, entry.JA3, entry.JA4,
+		)
+		if err != nil {
+			return err
+		}
+		if err := incrementInteractionCount(ctx, tx, entry.HostID); err != nil {
+			return err
+		}
+		return notify(ctx, tx, Notification{Type: NotificationTypeTLS, ID: entry.ID, HostID: entry.HostID})
+	})
+	if err != nil {
+		return fmt.Errorf("postgres: failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (db *Database) ListTLSLogEntries(ctx context.Context, params hosts.ListTLSLogEntriesParams) ([]hosts.TLSLogEntry, error) {
+	var tlsLogEntries []hosts.TLSLogEntry
+
+	rows, err := db.pool.Query(ctx,
+		`SELECT id, host_id, remote_addr, server_name, supported_protos, ja3, ja4
+		FROM tls_logs
+		WHERE host_id = ANY($1)
+		ORDER BY host_id, id`,
+		ulidsToBytes(params.HostIDs),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to query TLS log entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := hosts.TLSLogEntry{}
+		err := rows.Scan(&entry.ID, &entry.HostID, &entry.RemoteAddr, &entry.ServerName, &entry.SupportedProtos, &entry.JA3, &entry.JA4)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: failed to scan TLS log entry: %w", err)
+		}
+		tlsLogEntries = append(tlsLogEntries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: failed to query TLS log entries: %w", err)
+	}
+
+	return tlsLogEntries, nil
+}
+
+// Listen subscribes to notifications of interactions stored by any instance
+// using the database, until the context is cancelled.
+func (db *Database) Listen(ctx context.Context) (<-chan Notification, error) {
+	conn, err := db.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to acquire connection: %w", err)
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+NotificationChannel)
+	if err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("postgres: failed to listen for notifications: %w", err)
+	}
+
+	ch := make(chan Notification)
+
+	go func() {
+		defer close(ch)
+		defer conn.Release()
+
+		for {
+			pgNotification, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return
+			}
+
+			var notification Notification
+			if err := json.Unmarshal([]byte(pgNotification.Payload), &notification); err != nil {
+				continue
+			}
+
+			select {
+			case ch <- notification:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func incrementInteractionCount(ctx context.Context, tx pgx.Tx, hostID ulid.ULID) error {
+	return addInteractionCount(ctx, tx, hostID, 1)
+}
+
+func addInteractionCount(ctx context.Context, tx pgx.Tx, hostID ulid.ULID, n int) error {
+	_, err := tx.Exec(ctx,
+		`UPDATE hosts SET interaction_count = interaction_count + $2 WHERE id = $1`,
+		hostID, n,
+	)
+	return err
+}
+
+func notify(ctx context.Context, tx pgx.Tx, notification Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, NotificationChannel, string(payload))
+
+	return err
+}
+
+func ulidsToBytes(ids []ulid.ULID) [][]byte {
+	b := make([][]byte, len(ids))
+	for i := range ids {
+		id := ids[i]
+		b[i] = id[:]
+	}
+
+	return b
+}
@@ -0,0 +1,137 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// axfrEnvelopeSize is the max amount of records sent per message during a
+// zone transfer.
+const axfrEnvelopeSize = 100
+
+// serveZoneTransfer answers an AXFR query with all stored records in the
+// zone, enclosed by the zone's SOA record. Transfers are only allowed over TCP
+// for clients in one of the networks configured via WithZoneTransferAllow.
+func (srv *Server) serveZoneTransfer(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) {
+	zone := dns.Fqdn(r.Question[0].Name)
+	remoteAddr := w.RemoteAddr()
+
+	refuse := func(reason string) {
+		srv.logger.Info("Refused zone transfer.",
+			zap.String("zone", zone),
+			zap.Stringer("remoteAddr", remoteAddr),
+			zap.String("reason", reason),
+		)
+		reply := &dns.Msg{}
+		reply.SetRcode(r, dns.RcodeRefused)
+		if err := w.WriteMsg(reply); err != nil {
+			srv.logger.Error("Failed to write DNS reply.", zap.Error(err))
+		}
+	}
+
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		refuse("transport is not TCP")
+		return
+	}
+	if !srv.zoneTransferAllowed(tcpAddr.IP) {
+		refuse("client not allowed")
+		return
+	}
+	if !dns.IsSubDomain(dns.Fqdn(srv.soaHostname), zone) {
+		refuse("zone not served")
+		return
+	}
+
+	rrs, err := srv.zoneRecords(ctx, zone)
+	if err != nil {
+		srv.logger.Error("Failed to get records for zone transfer.",
+			zap.String("zone", zone),
+			zap.Error(err),
+		)
+		reply := &dns.Msg{}
+		reply.SetRcode(r, dns.RcodeServerFailure)
+		if err := w.WriteMsg(reply); err != nil {
+			srv.logger.Error("Failed to write DNS reply.", zap.Error(err))
+		}
+		return
+	}
+
+	soa := srv.soaRecord(zone)
+	rrs = append([]dns.RR{soa}, rrs...)
+	rrs = append(rrs, soa)
+
+	ch := make(chan *dns.Envelope, len(rrs)/axfrEnvelopeSize+1)
+	for len(rrs) > 0 {
+		n := axfrEnvelopeSize
+		if len(rrs) < n {
+			n = len(rrs)
+		}
+		ch <- &dns.Envelope{RR: rrs[:n]}
+		rrs = rrs[n:]
+	}
+	close(ch)
+
+	tr := &dns.Transfer{}
+	if err := tr.Out(w, r, ch); err != nil {
+		srv.logger.Error("Failed to write zone transfer.", zap.String("zone", zone), zap.Error(err))
+		return
+	}
+
+	srv.logger.Info("Completed zone transfer.",
+		zap.String("zone", zone),
+		zap.Stringer("remoteAddr", remoteAddr),
+	)
+}
+
+func (srv *Server) zoneTransferAllowed(ip net.IP) bool {
+	for _, ipNet := range srv.zoneTransferAllow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneRecords returns the NS record of `zone` and the records of all stored
+// names in (or below) `zone`, excluding the SOA record.
+func (srv *Server) zoneRecords(ctx context.Context, zone string) ([]dns.RR, error) {
+	rrs := []dns.RR{srv.nsRecord(zone)}
+
+	keys, err := srv.storage.List("dns", false)
+	if errors.Is(err, os.ErrNotExist) {
+		return rrs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dns: failed to list zonefiles: %w", err)
+	}
+
+	for _, key := range keys {
+		name := dns.Fqdn(strings.TrimPrefix(key, "dns/"))
+		if !dns.IsSubDomain(zone, name) {
+			continue
+		}
+
+		recs, err := srv.GetRecords(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recs {
+			rr, err := MessageFromRecord(name, rec)
+			if err != nil {
+				srv.logger.Error("Failed to parse message from record.", zap.Error(err))
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs, nil
+}
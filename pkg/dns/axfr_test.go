@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// serverAddrs runs the server, and returns the address of its UDP and TCP
+// listener.
+func serverAddrs(t *testing.T, srv *Server) (udpAddr, tcpAddr string) {
+	t.Helper()
+
+	for _, l := range runTestServer(t, srv) {
+		if l.server.Listener != nil {
+			tcpAddr = listenerAddr(l).String()
+		} else {
+			udpAddr = listenerAddr(l).String()
+		}
+	}
+
+	return udpAddr, tcpAddr
+}
+
+func mustParseCIDR(t *testing.T, s string) net.IPNet {
+	t.Helper()
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *ipNet
+}
+
+func TestZoneTransfer(t *testing.T) {
+	srv := newTestServer(t,
+		WithAddress("127.0.0.1:0"),
+		WithZoneTransferAllow([]net.IPNet{mustParseCIDR(t, "127.0.0.0/8")}),
+	)
+	_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.1"},
+		{Type: "TXT", Value: "foo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.AppendRecords(context.Background(), "example.org.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tcpAddr := serverAddrs(t, srv)
+
+	m := &dns.Msg{}
+	m.SetAxfr("example.com.")
+	tr := &dns.Transfer{DialTimeout: 5 * time.Second, ReadTimeout: 5 * time.Second}
+	envs, err := tr.In(m, tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rrs []dns.RR
+	for env := range envs {
+		if env.Error != nil {
+			t.Fatalf("unexpected error: %v", env.Error)
+		}
+		rrs = append(rrs, env.RR...)
+	}
+
+	if len(rrs) != 5 {
+		t.Fatalf("expected 5 records, got %v", rrs)
+	}
+	if _, ok := rrs[0].(*dns.SOA); !ok {
+		t.Errorf("expected first record to be SOA, got %v", rrs[0])
+	}
+	if _, ok := rrs[len(rrs)-1].(*dns.SOA); !ok {
+		t.Errorf("expected last record to be SOA, got %v", rrs[len(rrs)-1])
+	}
+	types := make(map[uint16]string)
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		types[rr.Header().Rrtype] = rr.Header().Name
+	}
+	for _, qtype := range []uint16{dns.TypeNS, dns.TypeA, dns.TypeTXT} {
+		if _, ok := types[qtype]; !ok {
+			t.Errorf("expected %v record, got %v", dns.TypeToString[qtype], rrs)
+		}
+	}
+	if name := types[dns.TypeA]; name != "abc.example.com." {
+		t.Errorf("expected A record of abc.example.com., got %v", name)
+	}
+}
+
+func TestZoneTransferRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow string
+		net   string
+		zone  string
+	}{
+		{
+			name:  "UDP",
+			allow: "127.0.0.0/8",
+			net:   "udp",
+			zone:  "example.com.",
+		},
+		{
+			name:  "client not allowed",
+			allow: "192.0.2.0/24",
+			net:   "tcp",
+			zone:  "example.com.",
+		},
+		{
+			name:  "zone not served",
+			allow: "127.0.0.0/8",
+			net:   "tcp",
+			zone:  "example.org.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t,
+				WithAddress("127.0.0.1:0"),
+				WithZoneTransferAllow([]net.IPNet{mustParseCIDR(t, tt.allow)}),
+			)
+			udpAddr, tcpAddr := serverAddrs(t, srv)
+			addr := tcpAddr
+			if tt.net == "udp" {
+				addr = udpAddr
+			}
+
+			m := &dns.Msg{}
+			m.SetAxfr(tt.zone)
+			c := &dns.Client{Net: tt.net, Timeout: 5 * time.Second}
+			reply, _, err := c.Exchange(m, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.Rcode != dns.RcodeRefused {
+				t.Errorf("expected REFUSED, got %v", dns.RcodeToString[reply.Rcode])
+			}
+			if len(reply.Answer) != 0 {
+				t.Errorf("expected no answers, got %v", reply.Answer)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// recordCacheMaxEntries is the max amount of zones kept in the record cache.
+// It bounds memory use under floods of queries for random names, which are
+// cached too (without records).
+const recordCacheMaxEntries = 10000
+
+// recordCache caches the records of zones in memory, keyed by storage key. It
+// is populated and invalidated while holding the storage lock of the zone, so
+// it's coherent with writes made via the server. Writes made by others (e.g.
+// another process sharing the storage) are seen after the TTL expires.
+type recordCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]recordCacheEntry
+}
+
+type recordCacheEntry struct {
+	recs      []libdns.Record
+	expiresAt time.Time
+}
+
+func newRecordCache(ttl time.Duration) *recordCache {
+	return &recordCache{
+		ttl:     ttl,
+		entries: make(map[string]recordCacheEntry),
+	}
+}
+
+// get returns a copy of the cached records of a zone, and whether they were
+// cached.
+func (c *recordCache) get(key string) ([]libdns.Record, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return append([]libdns.Record(nil), entry.recs...), true
+}
+
+func (c *recordCache) set(key string, recs []libdns.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if len(c.entries) >= recordCacheMaxEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// Rather than evicting entries that are still valid, the records
+		// aren't cached.
+		if len(c.entries) >= recordCacheMaxEntries {
+			return
+		}
+	}
+
+	c.entries[key] = recordCacheEntry{
+		recs:      append([]libdns.Record(nil), recs...),
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *recordCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
@@ -0,0 +1,235 @@
+package dns
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// CookieSecretLen is the length in bytes of secrets for DNS server cookies.
+const CookieSecretLen = 16
+
+const (
+	clientCookieLen = 8
+	// serverCookieLen is the length of server cookies generated by the
+	// server. Server cookies of other servers may be 8 to 32 bytes.
+	serverCookieLen = 16
+	// serverCookieVersion is the version of the server cookie format of RFC
+	// 9018.
+	serverCookieVersion = 1
+	// serverCookieMaxAge is the age after which server cookies are no longer
+	// valid, and serverCookieMaxSkew the time they may be in the future,
+	// e.g. when generated by another server with a clock that's ahead.
+	serverCookieMaxAge  = time.Hour
+	serverCookieMaxSkew = 5 * time.Minute
+)
+
+// WithCookieSecret enables DNS cookies (RFC 7873). Server cookies are computed
+// from the secret as described in RFC 9018, so servers sharing the secret
+// (e.g. behind anycast) accept each other's cookies.
+func WithCookieSecret(secret [CookieSecretLen]byte) ServerOption {
+	return func(srv *Server) {
+		srv.cookieSecret = &secret
+	}
+}
+
+// WithStrictCookies requires UDP queries to prove they aren't spoofed by
+// off-path attackers. Queries with a client cookie but without a valid server
+// cookie get a BADCOOKIE error with a new server cookie, for the client to
+// retry with (RFC 7873, section 5.2.3). Queries without a cookie option at all
+// (e.g. from resolvers that don't support cookies) get an empty, truncated
+// reply instead, so they retry over TCP, which can't be spoofed. Neither are
+// stored as DNS log entries. Requires WithCookieSecret.
+func WithStrictCookies() ServerOption {
+	return func(srv *Server) {
+		srv.strictCookies = true
+	}
+}
+
+// handleCookie adds a server cookie to replies to queries with a DNS cookie
+// option, and validates the server cookie of the query. It returns false if
+// the query shouldn't be answered, because its cookie is malformed (the reply
+// is then a FORMERR), or because it's a UDP query in strict mode without a
+// valid server cookie (the reply is then a BADCOOKIE) or without any cookie
+// (the reply is then truncated).
+func (srv *Server) handleCookie(w dns.ResponseWriter, r, reply *dns.Msg) bool {
+	if srv.cookieSecret == nil {
+		return true
+	}
+
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	clientIP := addrIP(w.RemoteAddr())
+
+	var cookie *dns.EDNS0_COOKIE
+	if opt := r.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if c, ok := o.(*dns.EDNS0_COOKIE); ok {
+				cookie = c
+				break
+			}
+		}
+	}
+
+	if cookie == nil {
+		if srv.strictCookies && udp {
+			reply.Truncated = true
+			return false
+		}
+		return true
+	}
+
+	// A cookie is a client cookie, optionally followed by a server cookie of
+	// 8 to 32 bytes (RFC 7873, section 4).
+	raw, err := hex.DecodeString(cookie.Cookie)
+	if err != nil || len(raw) < clientCookieLen || (len(raw) > clientCookieLen && len(raw) < 16) || len(raw) > 40 {
+		reply.Rcode = dns.RcodeFormatError
+		replyOPT(reply)
+		return false
+	}
+
+	now := time.Now()
+	clientCookie := raw[:clientCookieLen]
+	valid := srv.validServerCookie(clientCookie, raw[clientCookieLen:], clientIP, now)
+
+	// A new server cookie is returned for valid cookies too, so clients get
+	// a cookie with a recent timestamp. It's built in a new slice, so it
+	// doesn't share memory with the cookie of the query.
+	newCookie := make([]byte, 0, clientCookieLen+serverCookieLen)
+	newCookie = append(newCookie, clientCookie...)
+	newCookie = append(newCookie, srv.serverCookie(clientCookie, clientIP, now)...)
+	opt := replyOPT(reply)
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(newCookie),
+	})
+
+	if !valid && srv.strictCookies && udp {
+		reply.Rcode = dns.RcodeBadCookie
+		return false
+	}
+
+	return true
+}
+
+// replyOPT returns the OPT record of a reply, which is added if missing.
+func replyOPT(reply *dns.Msg) *dns.OPT {
+	if opt := reply.IsEdns0(); opt != nil {
+		return opt
+	}
+	reply.SetEdns0(maxUDPSize, false)
+	return reply.IsEdns0()
+}
+
+// serverCookie returns a server cookie in the format of RFC 9018: a version,
+// three reserved bytes, a timestamp and a SipHash-2-4 hash.
+func (srv *Server) serverCookie(clientCookie []byte, clientIP net.IP, now time.Time) []byte {
+	cookie := make([]byte, serverCookieLen)
+	cookie[0] = serverCookieVersion
+	binary.BigEndian.PutUint32(cookie[4:8], uint32(now.Unix()))
+	binary.LittleEndian.PutUint64(cookie[8:], srv.cookieHash(clientCookie, cookie[:8], clientIP))
+
+	return cookie
+}
+
+func (srv *Server) validServerCookie(clientCookie, serverCookie []byte, clientIP net.IP, now time.Time) bool {
+	if len(serverCookie) != serverCookieLen || serverCookie[0] != serverCookieVersion {
+		return false
+	}
+
+	// Timestamps are compared using serial number arithmetic (RFC 1982), as
+	// they wrap around in 2106.
+	ts := binary.BigEndian.Uint32(serverCookie[4:8])
+	age := time.Duration(int32(uint32(now.Unix())-ts)) * time.Second
+	if age > serverCookieMaxAge || age < -serverCookieMaxSkew {
+		return false
+	}
+
+	var hash [8]byte
+	binary.LittleEndian.PutUint64(hash[:], srv.cookieHash(clientCookie, serverCookie[:8], clientIP))
+
+	return subtle.ConstantTimeCompare(hash[:], serverCookie[8:]) == 1
+}
+
+// cookieHash computes the hash of a server cookie over the client cookie, the
+// version, reserved bytes and timestamp of the server cookie (`header`), and
+// the client IP address.
+func (srv *Server) cookieHash(clientCookie, header []byte, clientIP net.IP) uint64 {
+	if ip4 := clientIP.To4(); ip4 != nil {
+		clientIP = ip4
+	}
+
+	msg := make([]byte, 0, len(clientCookie)+len(header)+len(clientIP))
+	msg = append(msg, clientCookie...)
+	msg = append(msg, header...)
+	msg = append(msg, clientIP...)
+
+	return sipHash24(srv.cookieSecret, msg)
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// sipHash24 computes the SipHash-2-4 hash of `msg`.
+func sipHash24(key *[CookieSecretLen]byte, msg []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	b := uint64(len(msg)) << 56
+	for ; len(msg) >= 8; msg = msg[8:] {
+		m := binary.LittleEndian.Uint64(msg)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, c := range msg {
+		b |= uint64(c) << (8 * i)
+	}
+
+	v3 ^= b
+	round()
+	round()
+	v0 ^= b
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,265 @@
+package dns
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// sipHashVectors are the SipHash-2-4 outputs of appendix A of the SipHash
+// paper, for key 00 01 .. 0f and messages 00 01 .. of length 0 to 63.
+var sipHashVectors = []uint64{
+	0x726fdb47dd0e0e31, 0x74f839c593dc67fd, 0x0d6c8009d9a94f5a, 0x85676696d7fb7e2d,
+	0xcf2794e0277187b7, 0x18765564cd99a68d, 0xcbc9466e58fee3ce, 0xab0200f58b01d137,
+	0x93f5f5799a932462, 0x9e0082df0ba9e4b0, 0x7a5dbbc594ddb9f3, 0xf4b32f46226bada7,
+	0x751e8fbc860ee5fb, 0x14ea5627c0843d90, 0xf723ca908e7af2ee, 0xa129ca6149be45e5,
+	0x3f2acc7f57c29bdb, 0x699ae9f52cbe4794, 0x4bc1b3f0968dd39c, 0xbb6dc91da77961bd,
+	0xbed65cf21aa2ee98, 0xd0f2cbb02e3b67c7, 0x93536795e3a33e88, 0xa80c038ccd5ccec8,
+	0xb8ad50c6f649af94, 0xbce192de8a85b8ea, 0x17d835b85bbb15f3, 0x2f2e6163076bcfad,
+	0xde4daaaca71dc9a5, 0xa6a2506687956571, 0xad87a3535c49ef28, 0x32d892fad841c342,
+	0x7127512f72f27cce, 0xa7f32346f95978e3, 0x12e0b01abb051238, 0x15e034d40fa197ae,
+	0x314dffbe0815a3b4, 0x027990f029623981, 0xcadcd4e59ef40c4d, 0x9abfd8766a33735c,
+	0x0e3ea96b5304a7d0, 0xad0c42d6fc585992, 0x187306c89bc215a9, 0xd4a60abcf3792b95,
+	0xf935451de4f21df2, 0xa9538f0419755787, 0xdb9acddff56ca510, 0xd06c98cd5c0975eb,
+	0xe612a3cb9ecba951, 0xc766e62cfcadaf96, 0xee64435a9752fe72, 0xa192d576b245165a,
+	0x0a8787bf8ecb74b2, 0x81b3e73d20b49b6f, 0x7fa8220ba3b2ecea, 0x245731c13ca42499,
+	0xb78dbfaf3a8d83bd, 0xea1ad565322a1a0b, 0x60e61c23a3795013, 0x6606d7e446282b93,
+	0x6ca4ecb15c5f91e1, 0x9f626da15c9625f3, 0xe51b38608ef25f57, 0x958a324ceb064572,
+}
+
+func TestSipHash24(t *testing.T) {
+	var key [CookieSecretLen]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	msg := make([]byte, len(sipHashVectors))
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	for i, exp := range sipHashVectors {
+		if got := sipHash24(&key, msg[:i]); got != exp {
+			t.Errorf("message of length %v: expected %#016x, got %#016x", i, exp, got)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestServerCookie(t *testing.T) {
+	// Test vector of RFC 9018, appendix A.2.1.
+	var secret [CookieSecretLen]byte
+	copy(secret[:], mustDecodeHex(t, "e5e973e5a6b2a43f48e7dc849e37bfcf"))
+	srv := newTestServer(t, WithCookieSecret(secret))
+
+	clientCookie := mustDecodeHex(t, "2464c4abcf10c957")
+	clientIP := net.ParseIP("198.51.100.100")
+	now := time.Unix(1559731985, 0)
+
+	exp := "010000005cf79f111f8130c3eee29480"
+	if got := hex.EncodeToString(srv.serverCookie(clientCookie, clientIP, now)); got != exp {
+		t.Errorf("expected server cookie %v, got %v", exp, got)
+	}
+}
+
+func TestValidServerCookie(t *testing.T) {
+	var secret [CookieSecretLen]byte
+	copy(secret[:], "0123456789abcdef")
+	srv := newTestServer(t, WithCookieSecret(secret))
+
+	clientCookie := mustDecodeHex(t, "2464c4abcf10c957")
+	clientIP := net.ParseIP("198.51.100.100")
+	now := time.Unix(1559731985, 0)
+	serverCookie := srv.serverCookie(clientCookie, clientIP, now)
+
+	var otherSecret [CookieSecretLen]byte
+	copy(otherSecret[:], "fedcba9876543210")
+	otherSrv := newTestServer(t, WithCookieSecret(otherSecret))
+
+	tests := []struct {
+		name         string
+		srv          *Server
+		clientCookie []byte
+		clientIP     net.IP
+		now          time.Time
+		exp          bool
+	}{
+		{
+			name:         "round trip",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now,
+			exp:          true,
+		},
+		{
+			name:         "within max age",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now.Add(serverCookieMaxAge),
+			exp:          true,
+		},
+		{
+			name:         "expired",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now.Add(serverCookieMaxAge + time.Second),
+			exp:          false,
+		},
+		{
+			name:         "within max skew",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now.Add(-serverCookieMaxSkew),
+			exp:          true,
+		},
+		{
+			name:         "too far in the future",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now.Add(-serverCookieMaxSkew - time.Second),
+			exp:          false,
+		},
+		{
+			name:         "wrong secret",
+			srv:          otherSrv,
+			clientCookie: clientCookie,
+			clientIP:     clientIP,
+			now:          now,
+			exp:          false,
+		},
+		{
+			name:         "other client cookie",
+			srv:          srv,
+			clientCookie: mustDecodeHex(t, "0000000000000000"),
+			clientIP:     clientIP,
+			now:          now,
+			exp:          false,
+		},
+		{
+			name:         "other client IP",
+			srv:          srv,
+			clientCookie: clientCookie,
+			clientIP:     net.ParseIP("198.51.100.101"),
+			now:          now,
+			exp:          false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.srv.validServerCookie(tt.clientCookie, serverCookie, tt.clientIP, tt.now)
+			if got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+// cookieQuery sends a query with a cookie option (if `cookie` isn't empty) to
+// the handler of the server, and returns the single written reply.
+func cookieQuery(t *testing.T, srv *Server, cookie string) *dns.Msg {
+	t.Helper()
+
+	r := &dns.Msg{}
+	r.SetQuestion("abc.example.com.", dns.TypeSOA)
+	if cookie != "" {
+		r.SetEdns0(maxUDPSize, false)
+		opt := r.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	}
+
+	w := newTestResponseWriter()
+	srv.ServeDNS(w, r)
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected 1 reply, got %v", len(w.msgs))
+	}
+
+	return w.msgs[0]
+}
+
+// replyCookie returns the cookie of the OPT record of a reply.
+func replyCookie(reply *dns.Msg) string {
+	if opt := reply.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if c, ok := o.(*dns.EDNS0_COOKIE); ok {
+				return c.Cookie
+			}
+		}
+	}
+	return ""
+}
+
+func TestHandleCookieStrict(t *testing.T) {
+	var secret [CookieSecretLen]byte
+	copy(secret[:], "0123456789abcdef")
+	srv := newTestServer(t, WithCookieSecret(secret), WithStrictCookies())
+	clientCookie := "2464c4abcf10c957"
+
+	t.Run("no cookie", func(t *testing.T) {
+		reply := cookieQuery(t, srv, "")
+		if !reply.Truncated {
+			t.Error("expected truncated reply")
+		}
+		if len(reply.Answer) != 0 {
+			t.Errorf("expected no answers, got %v", reply.Answer)
+		}
+	})
+
+	t.Run("malformed cookie", func(t *testing.T) {
+		reply := cookieQuery(t, srv, "2464c4ab")
+		if reply.Rcode != dns.RcodeFormatError {
+			t.Errorf("expected FORMERR, got %v", dns.RcodeToString[reply.Rcode])
+		}
+	})
+
+	t.Run("client cookie only", func(t *testing.T) {
+		reply := cookieQuery(t, srv, clientCookie)
+		if reply.Rcode != dns.RcodeBadCookie {
+			t.Errorf("expected BADCOOKIE, got %v", dns.RcodeToString[reply.Rcode])
+		}
+		if reply.Truncated {
+			t.Error("expected reply not to be truncated")
+		}
+		if len(reply.Answer) != 0 {
+			t.Errorf("expected no answers, got %v", reply.Answer)
+		}
+		if got := replyCookie(reply); len(got) != 2*(clientCookieLen+serverCookieLen) || got[:2*clientCookieLen] != clientCookie {
+			t.Errorf("expected new server cookie for client cookie %v, got %v", clientCookie, got)
+		}
+	})
+
+	t.Run("invalid server cookie", func(t *testing.T) {
+		reply := cookieQuery(t, srv, clientCookie+"01000000000000000000000000000000")
+		if reply.Rcode != dns.RcodeBadCookie {
+			t.Errorf("expected BADCOOKIE, got %v", dns.RcodeToString[reply.Rcode])
+		}
+	})
+
+	t.Run("valid server cookie", func(t *testing.T) {
+		cookie := replyCookie(cookieQuery(t, srv, clientCookie))
+
+		reply := cookieQuery(t, srv, cookie)
+		if reply.Rcode != dns.RcodeSuccess {
+			t.Errorf("expected NOERROR, got %v", dns.RcodeToString[reply.Rcode])
+		}
+		if len(reply.Answer) != 1 {
+			t.Errorf("expected 1 answer, got %v", reply.Answer)
+		}
+	})
+}
@@ -2,64 +2,98 @@ package dns
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/libdns/libdns"
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
 )
 
 func (srv *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
-	ctx := context.Background() // TODO: Introduce context on `srv`?
+	ctx := srv.ctx
 
 	if len(r.Question) == 0 {
 		return
 	}
 	name := r.Question[0].Name
 
+	if srv.queryLog != nil {
+		rec := &replyRecorder{ResponseWriter: w}
+		w = rec
+		defer srv.writeQueryLog(r, rec, time.Now())
+	}
+
+	// A panic (e.g. caused by a malformed record) fails the query, instead of
+	// crashing the server. Replies are only written once the query is
+	// answered, so a partial reply is never written.
+	tracker := &writeTracker{ResponseWriter: w}
+	w = tracker
+	defer srv.recoverServeDNS(tracker, r)
+
+	if r.Question[0].Qtype == dns.TypeAXFR {
+		srv.serveZoneTransfer(ctx, w, r)
+		return
+	}
+
 	reply := &dns.Msg{}
 	_ = reply.SetReply(r)
-	defer func() {
-		err := w.WriteMsg(reply)
-		if err != nil {
-			srv.logger.Error("Failed to write DNS reply.", zap.Error(err))
-		}
-	}()
+	inZone := dns.IsSubDomain(dns.Fqdn(srv.soaHostname), dns.Fqdn(hosts.NormalizeDomainName(name)))
 
-	if !dns.IsSubDomain(dns.Fqdn(srv.soaHostname), dns.Fqdn(name)) {
+	// Queries that are rejected in strict cookie mode aren't logged, as they
+	// may be spoofed.
+	unverified := false
+	if !srv.handleCookie(w, r, reply) {
+		unverified = reply.Truncated || reply.Rcode == dns.RcodeBadCookie
+	} else if inZone {
+		answerCtx, cancel := context.WithTimeout(ctx, srv.queryTimeout)
+		srv.answer(answerCtx, r, reply)
+		cancel()
+	}
+
+	truncateReply(w, r, reply)
+	if err := w.WriteMsg(reply); err != nil {
+		srv.logger.Error("Failed to write DNS reply.", zap.Error(err))
+	}
+	if inZone && !unverified {
+		srv.storeDNSLogEntry(ctx, w, r, reply)
+	}
+}
+
+// answer sets the answer to a query for a name in the zone on `reply`.
+func (srv *Server) answer(ctx context.Context, r, reply *dns.Msg) {
+	name := r.Question[0].Name
+
+	// Names in (or below) a delegated sub-zone are answered with a referral
+	// to the sub-zone's nameservers.
+	zone, nsRecs, err := srv.findDelegation(ctx, name)
+	if err != nil {
+		srv.logger.Error("Failed to find delegation for name.",
+			zap.String("name", name),
+			zap.Error(err),
+		)
+		return
+	}
+	if len(nsRecs) > 0 {
+		srv.setReferral(ctx, reply, zone, nsRecs)
 		return
 	}
 
 	reply.Authoritative = true
+	qtype := r.Question[0].Qtype
 
-	switch r.Question[0].Qtype {
+	switch qtype {
 	case dns.TypeSOA:
-		soa := &dns.SOA{
-			Ns: dns.Fqdn(libdns.AbsoluteName("ns1", srv.soaHostname)),
-			Hdr: dns.RR_Header{
-				Name:   name,
-				Rrtype: dns.TypeSOA,
-				Class:  dns.ClassINET,
-				Ttl:    0,
-			},
-			Mbox:    libdns.AbsoluteName("hostmaster", srv.soaHostname),
-			Serial:  1,
-			Refresh: 86400,
-			Retry:   7200,
-			Expire:  3600000,
-			Minttl:  3600,
-		}
-		reply.Answer = append(reply.Answer, soa)
+		reply.Answer = append(reply.Answer, srv.soaRecord(name))
 	case dns.TypeNS:
-		ns := &dns.NS{
-			Hdr: dns.RR_Header{
-				Name:   name,
-				Rrtype: dns.TypeNS,
-				Class:  dns.ClassINET,
-				Ttl:    3600,
-			},
-			Ns: dns.Fqdn(libdns.AbsoluteName("ns1", srv.soaHostname)),
-		}
-		reply.Answer = append(reply.Answer, ns)
+		reply.Answer = append(reply.Answer, srv.nsRecord(name))
+		reply.Extra = append(reply.Extra, srv.selfRecords(srv.nsHostname(), dns.TypeA)...)
+		reply.Extra = append(reply.Extra, srv.selfRecords(srv.nsHostname(), dns.TypeAAAA)...)
 	default:
 		recs, err := srv.GetRecords(ctx, name)
 		if err != nil {
@@ -69,15 +103,365 @@ func (srv *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			)
 			return
 		}
+		// All records of the queried type are answered, e.g. multiple TXT
+		// records for ACME DNS-01 challenges. A malformed record doesn't
+		// prevent answering the others.
 		for _, rec := range recs {
-			if _, ok := dns.StringToType[rec.Type]; ok {
+			if rrType, ok := dns.StringToType[rec.Type]; ok && rrType == qtype {
+				rr, err := MessageFromRecord(name, rec)
+				if err != nil {
+					srv.logger.Error("Failed to parse message from record.", zap.Error(err))
+					continue
+				}
+				reply.Answer = append(reply.Answer, rr)
+			}
+		}
+		// Without records of the queried type, an alias is answered instead.
+		// Its target isn't resolved, which is left to the resolver.
+		if len(reply.Answer) == 0 {
+			for _, rec := range recs {
+				if rec.Type != "CNAME" {
+					continue
+				}
 				rr, err := MessageFromRecord(name, rec)
 				if err != nil {
 					srv.logger.Error("Failed to parse message from record.", zap.Error(err))
-					return
+					continue
 				}
 				reply.Answer = append(reply.Answer, rr)
+				break
+			}
+		}
+		if len(reply.Answer) == 0 {
+			reply.Answer = append(reply.Answer, srv.selfRecords(name, qtype)...)
+		}
+		if len(reply.Answer) == 0 && srv.catchAll {
+			if rr := srv.catchAllRecord(name, qtype); rr != nil {
+				reply.Answer = append(reply.Answer, rr)
+			}
+		}
+		// A NODATA answer (e.g. for HTTPS queries browsers send before
+		// connecting) includes the SOA record, so resolvers can cache it
+		// for the SOA's minimum TTL (RFC 2308).
+		if len(reply.Answer) == 0 {
+			soa := srv.soaRecord(dns.Fqdn(srv.soaHostname))
+			soa.Hdr.Ttl = soa.Minttl
+			reply.Ns = append(reply.Ns, soa)
+		}
+	}
+}
+
+// recoverServeDNS recovers from a panic in ServeDNS, and answers the query
+// with SERVFAIL, unless a reply was already written.
+func (srv *Server) recoverServeDNS(w *writeTracker, r *dns.Msg) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	srv.logServeDNSPanic(r, v)
+
+	if w.written {
+		return
+	}
+	reply := &dns.Msg{}
+	reply.SetRcode(r, dns.RcodeServerFailure)
+	if err := w.WriteMsg(reply); err != nil {
+		srv.logger.Error("Failed to write DNS reply.", zap.Error(err))
+	}
+}
+
+// writeTracker is a dns.ResponseWriter that tracks whether a reply was
+// written.
+type writeTracker struct {
+	dns.ResponseWriter
+	written bool
+}
+
+func (wt *writeTracker) WriteMsg(m *dns.Msg) error {
+	wt.written = true
+	return wt.ResponseWriter.WriteMsg(m)
+}
+
+func (srv *Server) logServeDNSPanic(r *dns.Msg, v interface{}) {
+	srv.logger.Error("Recovered from panic while answering DNS query.",
+		zap.String("name", r.Question[0].Name),
+		zap.Any("panic", v),
+		zap.Stack("stack"),
+	)
+}
+
+// maxUDPSize is the maximum payload size of UDP replies, as advertised in
+// EDNS(0) OPT records. It avoids IP fragmentation (see DNS Flag Day 2020).
+const maxUDPSize = 1232
+
+// truncateReply truncates a reply to a UDP query to the payload size the client
+// supports, e.g. for names with many TXT records. Truncated replies have the
+// TC bit set, so clients retry over TCP. The OPT record of EDNS(0) queries is
+// echoed (RFC 6891, section 7).
+func truncateReply(w dns.ResponseWriter, r, reply *dns.Msg) {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); !ok {
+		return
+	}
+
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		if reply.IsEdns0() == nil {
+			reply.SetEdns0(maxUDPSize, false)
+		}
+		size = int(opt.UDPSize())
+		if size > maxUDPSize {
+			size = maxUDPSize
+		}
+	}
+
+	reply.Truncate(size)
+}
+
+// catchAllRecord returns a synthesized answer for `name` and `qtype`, or nil
+// if the query type isn't supported. See WithCatchAll.
+func (srv *Server) catchAllRecord(name string, qtype uint16) dns.RR {
+	hdr := dns.RR_Header{
+		Name:   name,
+		Rrtype: qtype,
+		Class:  dns.ClassINET,
+		Ttl:    0,
+	}
+
+	switch {
+	case qtype == dns.TypeA && srv.catchAllIPv4 != nil:
+		return &dns.A{Hdr: hdr, A: srv.catchAllIPv4}
+	case qtype == dns.TypeAAAA && srv.catchAllIPv6 != nil:
+		return &dns.AAAA{Hdr: hdr, AAAA: srv.catchAllIPv6}
+	case qtype == dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: []string{""}}
+	case qtype == dns.TypeMX:
+		return &dns.MX{Hdr: hdr, Preference: 10, Mx: dns.Fqdn(name)}
+	}
+
+	return nil
+}
+
+// selfRecords returns A or AAAA records with the server's own IP addresses
+// (see WithSelfIP) if `name` is the zone apex or its nameserver.
+func (srv *Server) selfRecords(name string, qtype uint16) []dns.RR {
+	if !strings.EqualFold(name, dns.Fqdn(srv.soaHostname)) && !strings.EqualFold(name, srv.nsHostname()) {
+		return nil
+	}
+
+	hdr := dns.RR_Header{
+		Name:   name,
+		Rrtype: qtype,
+		Class:  dns.ClassINET,
+		Ttl:    3600,
+	}
+
+	var rrs []dns.RR
+	for _, ip := range srv.selfIPs {
+		ipv4 := ip.To4()
+		switch {
+		case qtype == dns.TypeA && ipv4 != nil:
+			rrs = append(rrs, &dns.A{Hdr: hdr, A: ipv4})
+		case qtype == dns.TypeAAAA && ipv4 == nil && ip.To16() != nil:
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return rrs
+}
+
+// nsHostname returns the hostname of the nameserver of the zone.
+func (srv *Server) nsHostname() string {
+	return dns.Fqdn(libdns.AbsoluteName("ns1", srv.soaHostname))
+}
+
+// Nameserver returns the hostname of the nameserver of the zone, as used in NS
+// records. The parent zone should delegate the zone to it.
+func (srv *Server) Nameserver() string {
+	return srv.nsHostname()
+}
+
+func (srv *Server) soaRecord(name string) *dns.SOA {
+	soa := &dns.SOA{
+		Ns: srv.nsHostname(),
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    0,
+		},
+		Mbox:    libdns.AbsoluteName("hostmaster", srv.soaHostname),
+		Serial:  1,
+		Refresh: DefaultSOAParams.Refresh,
+		Retry:   DefaultSOAParams.Retry,
+		Expire:  DefaultSOAParams.Expire,
+		Minttl:  DefaultSOAParams.Minttl,
+	}
+
+	if srv.soa.Ns != "" {
+		soa.Ns = dns.Fqdn(srv.soa.Ns)
+	}
+	if srv.soa.Mbox != "" {
+		// Allow an email address, e.g. `hostmaster@example.com`.
+		soa.Mbox = dns.Fqdn(strings.Replace(srv.soa.Mbox, "@", ".", 1))
+	}
+	if srv.soa.Refresh != 0 {
+		soa.Refresh = srv.soa.Refresh
+	}
+	if srv.soa.Retry != 0 {
+		soa.Retry = srv.soa.Retry
+	}
+	if srv.soa.Expire != 0 {
+		soa.Expire = srv.soa.Expire
+	}
+	if srv.soa.Minttl != 0 {
+		soa.Minttl = srv.soa.Minttl
+	}
+
+	return soa
+}
+
+func (srv *Server) nsRecord(name string) *dns.NS {
+	return &dns.NS{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNS,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Ns: srv.nsHostname(),
+	}
+}
+
+// delegationCacheTTL is how long the NS records of zones are cached for
+// finding delegations. Delegations registered by others sharing the storage
+// are seen after it expires.
+const delegationCacheTTL = time.Minute
+
+// findDelegation returns the NS records of the closest delegated sub-zone
+// that `name` is in, if any. Delegations are registered by storing NS records
+// for the sub-zone, e.g. via AppendRecords. The NS records of each zone are
+// looked up in the delegation cache, and only loaded when they aren't cached.
+func (srv *Server) findDelegation(ctx context.Context, name string) (string, []libdns.Record, error) {
+	apex := dns.Fqdn(srv.soaHostname)
+	zone := dns.Fqdn(name)
+
+	for dns.IsSubDomain(apex, zone) && !strings.EqualFold(zone, apex) {
+		nsRecs, ok := srv.delegationCache.get(storageKey(zone))
+		if !ok {
+			recs, err := srv.GetRecords(ctx, zone)
+			if err != nil {
+				return "", nil, err
+			}
+			nsRecs = nsRecords(recs)
+		}
+		if len(nsRecs) > 0 {
+			return zone, nsRecs, nil
+		}
+
+		i, end := dns.NextLabel(zone, 0)
+		if end {
+			break
+		}
+		zone = zone[i:]
+	}
+
+	return "", nil, nil
+}
+
+// nsRecords returns the NS records of `recs`.
+func nsRecords(recs []libdns.Record) []libdns.Record {
+	var nsRecs []libdns.Record
+	for _, rec := range recs {
+		if rec.Type == "NS" {
+			nsRecs = append(nsRecs, rec)
+		}
+	}
+	return nsRecs
+}
+
+// setReferral configures a reply as a referral to the nameservers of a
+// delegated sub-zone, including glue records for nameservers that are within
+// the zone served by `srv`.
+func (srv *Server) setReferral(ctx context.Context, reply *dns.Msg, zone string, nsRecs []libdns.Record) {
+	apex := dns.Fqdn(srv.soaHostname)
+	reply.Authoritative = false
+
+	for _, nsRec := range nsRecs {
+		rr, err := MessageFromRecord(zone, nsRec)
+		if err != nil {
+			srv.logger.Error("Failed to parse message from record.", zap.Error(err))
+			continue
+		}
+		reply.Ns = append(reply.Ns, rr)
+
+		target := rr.(*dns.NS).Ns
+		if !dns.IsSubDomain(apex, target) {
+			continue
+		}
+
+		glueRecs, err := srv.GetRecords(ctx, target)
+		if err != nil {
+			srv.logger.Error("Failed to get glue records for nameserver.",
+				zap.String("nameserver", target),
+				zap.Error(err),
+			)
+			continue
+		}
+		for _, glueRec := range glueRecs {
+			if glueRec.Type != "A" && glueRec.Type != "AAAA" {
+				continue
+			}
+			glue, err := MessageFromRecord(target, glueRec)
+			if err != nil {
+				srv.logger.Error("Failed to parse message from record.", zap.Error(err))
+				continue
 			}
+			reply.Extra = append(reply.Extra, glue)
 		}
 	}
 }
+
+func (srv *Server) storeDNSLogEntry(ctx context.Context, w dns.ResponseWriter, r, reply *dns.Msg) {
+	if srv.hostsService == nil {
+		return
+	}
+	if len(srv.loggedQTypes) > 0 && !srv.loggedQTypes[r.Question[0].Qtype] {
+		return
+	}
+
+	var remoteAddr string
+	if addr := w.RemoteAddr(); addr != nil {
+		remoteAddr = addr.String()
+	}
+
+	err := srv.hostsService.StoreDNSLogEntry(ctx, hosts.StoreDNSLogEntryParams{
+		Query:        r,
+		Response:     reply,
+		RemoteAddr:   remoteAddr,
+		ClientSubnet: clientSubnet(r),
+	})
+	if errors.Is(err, hosts.ErrHostNotFound) {
+		srv.logger.Debug("Host not found, ignoring DNS query.", zap.Error(err))
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to store DNS log entry.", zap.Error(err))
+	}
+}
+
+// clientSubnet returns the EDNS Client Subnet (RFC 7871) of a DNS query in CIDR
+// notation, or an empty string if the query has none.
+func clientSubnet(r *dns.Msg) string {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok && subnet.Address != nil {
+			return fmt.Sprintf("%v/%v", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+
+	return ""
+}
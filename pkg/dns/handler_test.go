@@ -0,0 +1,958 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// testResponseWriter records written replies.
+type testResponseWriter struct {
+	dns.ResponseWriter
+	remoteAddr net.Addr
+	msgs       []*dns.Msg
+}
+
+func newTestResponseWriter() *testResponseWriter {
+	return &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234},
+	}
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return w.remoteAddr
+}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msgs = append(w.msgs, m)
+	return nil
+}
+
+// query sends a query to the handler of the server, and returns the written
+// replies.
+func query(srv *Server, name string, qtype uint16) []*dns.Msg {
+	w := newTestResponseWriter()
+	r := &dns.Msg{}
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	srv.ServeDNS(w, r)
+
+	return w.msgs
+}
+
+// panicStorage panics when loading keys with `panicPrefix`.
+type panicStorage struct {
+	certmagic.Storage
+	panicPrefix string
+}
+
+func (s panicStorage) Load(key string) ([]byte, error) {
+	if strings.HasPrefix(key, s.panicPrefix) {
+		panic("malformed record")
+	}
+	return s.Storage.Load(key)
+}
+
+// panicHostsService panics when storing DNS log entries.
+type panicHostsService struct {
+	hosts.Service
+}
+
+func (panicHostsService) StoreDNSLogEntry(context.Context, hosts.StoreDNSLogEntryParams) error {
+	panic("failed to store")
+}
+
+func TestServeDNSRecoversFromPanic(t *testing.T) {
+	t.Run("while answering", func(t *testing.T) {
+		srv := newTestServer(t)
+		srv.storage = panicStorage{Storage: srv.storage, panicPrefix: storageKey("abc.example.com.")}
+
+		msgs := query(srv, "abc.example.com", dns.TypeTXT)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		if msgs[0].Rcode != dns.RcodeServerFailure {
+			t.Errorf("expected SERVFAIL, got %v", dns.RcodeToString[msgs[0].Rcode])
+		}
+		if len(msgs[0].Answer) != 0 {
+			t.Errorf("expected no answers, got %v", msgs[0].Answer)
+		}
+	})
+
+	t.Run("after writing the reply", func(t *testing.T) {
+		srv := newTestServer(t, WithHostsService(panicHostsService{}))
+
+		msgs := query(srv, "abc.example.com", dns.TypeSOA)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		if msgs[0].Rcode != dns.RcodeSuccess {
+			t.Errorf("expected NOERROR, got %v", dns.RcodeToString[msgs[0].Rcode])
+		}
+	})
+}
+
+// countingStorage counts loads per key.
+type countingStorage struct {
+	certmagic.Storage
+	mu    sync.Mutex
+	loads map[string]int
+}
+
+func (s *countingStorage) Load(key string) ([]byte, error) {
+	s.mu.Lock()
+	s.loads[key]++
+	s.mu.Unlock()
+	return s.Storage.Load(key)
+}
+
+func (s *countingStorage) loadCount(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads[key]
+}
+
+func TestServeDNSDelegatedSubZone(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	_, err := srv.AppendRecords(ctx, "sub.example.com.", []libdns.Record{
+		{Type: "NS", Value: "ns1.sub.example.com."},
+		{Type: "NS", Value: "ns.example.org."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.AppendRecords(ctx, "ns1.sub.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.53"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := &countingStorage{Storage: srv.storage, loads: make(map[string]int)}
+	srv.storage = storage
+
+	for i := 0; i < 2; i++ {
+		msgs := query(srv, "www.sub.example.com", dns.TypeA)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		reply := msgs[0]
+
+		if reply.Authoritative {
+			t.Error("expected referral not to be authoritative")
+		}
+		if len(reply.Answer) != 0 {
+			t.Errorf("expected no answers, got %v", reply.Answer)
+		}
+
+		var nameservers []string
+		for _, rr := range reply.Ns {
+			ns, ok := rr.(*dns.NS)
+			if !ok {
+				t.Fatalf("expected NS record in authority section, got %v", rr)
+			}
+			if ns.Hdr.Name != "sub.example.com." {
+				t.Errorf("expected NS record for sub.example.com., got %v", ns.Hdr.Name)
+			}
+			nameservers = append(nameservers, ns.Ns)
+		}
+		if exp := []string{"ns1.sub.example.com.", "ns.example.org."}; !reflect.DeepEqual(nameservers, exp) {
+			t.Errorf("expected nameservers %v, got %v", exp, nameservers)
+		}
+
+		if len(reply.Extra) != 1 {
+			t.Fatalf("expected 1 glue record, got %v", reply.Extra)
+		}
+		glue, ok := reply.Extra[0].(*dns.A)
+		if !ok || glue.Hdr.Name != "ns1.sub.example.com." || !glue.A.Equal(net.IPv4(192, 0, 2, 53)) {
+			t.Errorf("expected glue record for ns1.sub.example.com., got %v", reply.Extra[0])
+		}
+	}
+
+	// The zones of the queried name and its parent are loaded once, after
+	// which their NS records are cached.
+	for _, zone := range []string{"www.sub.example.com.", "sub.example.com."} {
+		if n := storage.loadCount(storageKey(zone)); n != 1 {
+			t.Errorf("expected zone %v to be loaded once, got %v loads", zone, n)
+		}
+	}
+}
+
+func TestServeDNSDelegationCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	if msgs := query(srv, "www.sub.example.com", dns.TypeA); len(msgs[0].Ns) != 1 || msgs[0].Ns[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("expected NODATA answer before delegation, got %v", msgs[0])
+	}
+
+	_, err := srv.AppendRecords(ctx, "sub.example.com.", []libdns.Record{{Type: "NS", Value: "ns.example.org."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := query(srv, "www.sub.example.com", dns.TypeA)
+	if len(msgs[0].Ns) != 1 || msgs[0].Ns[0].Header().Rrtype != dns.TypeNS {
+		t.Errorf("expected referral after delegation, got %v", msgs[0])
+	}
+}
+
+func TestServeDNSLockedZone(t *testing.T) {
+	srv := newTestServer(t)
+	srv.queryTimeout = 100 * time.Millisecond
+
+	// A write in progress holds the lock of the zone.
+	key := lockKey("abc.example.com.")
+	if err := srv.storage.Lock(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.storage.Unlock(key)
+
+	start := time.Now()
+	msgs := query(srv, "abc.example.com", dns.TypeTXT)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 reply, got %v", len(msgs))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected query to be answered within the query timeout, took %v", elapsed)
+	}
+}
+
+func TestServeDNSNoData(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ServerOption
+		expMinttl uint32
+	}{
+		{
+			name:      "default SOA",
+			expMinttl: DefaultSOAParams.Minttl,
+		},
+		{
+			name:      "custom minimum TTL",
+			opts:      []ServerOption{WithSOA(SOAParams{Minttl: 60})},
+			expMinttl: 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, tt.opts...)
+
+			msgs := query(srv, "abc.example.com", dns.TypeHTTPS)
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 reply, got %v", len(msgs))
+			}
+			reply := msgs[0]
+
+			// The server is authoritative for all names in the zone, so
+			// names without records are answered with NODATA, not NXDOMAIN.
+			if reply.Rcode != dns.RcodeSuccess {
+				t.Errorf("expected NOERROR, got %v", dns.RcodeToString[reply.Rcode])
+			}
+			if len(reply.Answer) != 0 {
+				t.Errorf("expected no answers, got %v", reply.Answer)
+			}
+			if len(reply.Ns) != 1 {
+				t.Fatalf("expected 1 authority record, got %v", reply.Ns)
+			}
+			soa, ok := reply.Ns[0].(*dns.SOA)
+			if !ok {
+				t.Fatalf("expected SOA record, got %v", reply.Ns[0])
+			}
+			if soa.Hdr.Name != "example.com." {
+				t.Errorf("expected SOA record of the zone apex, got %v", soa.Hdr.Name)
+			}
+			if soa.Minttl != tt.expMinttl {
+				t.Errorf("expected minimum TTL %v, got %v", tt.expMinttl, soa.Minttl)
+			}
+			if soa.Hdr.Ttl != soa.Minttl {
+				t.Errorf("expected TTL %v (minimum TTL), got %v", soa.Minttl, soa.Hdr.Ttl)
+			}
+		})
+	}
+}
+
+func TestServeDNSHTTPS(t *testing.T) {
+	srv := newTestServer(t)
+	_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+		{Type: "HTTPS", Value: ". alpn=h2,h3", Priority: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := query(srv, "abc.example.com", dns.TypeHTTPS)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 reply, got %v", len(msgs))
+	}
+	if len(msgs[0].Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %v", msgs[0].Answer)
+	}
+	if _, ok := msgs[0].Answer[0].(*dns.HTTPS); !ok {
+		t.Errorf("expected HTTPS record, got %v", msgs[0].Answer[0])
+	}
+	if len(msgs[0].Ns) != 0 {
+		t.Errorf("expected no authority records, got %v", msgs[0].Ns)
+	}
+}
+
+// blockingHostsService blocks storing DNS log entries until the context is
+// done, and signals `storing` when it starts to.
+type blockingHostsService struct {
+	hosts.Service
+	storing chan struct{}
+}
+
+func (svc blockingHostsService) StoreDNSLogEntry(ctx context.Context, _ hosts.StoreDNSLogEntryParams) error {
+	svc.storing <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestShutdownCancelsInFlightQueries(t *testing.T) {
+	// serveDNS handles a query in the background, and returns a channel that
+	// is closed once it's handled.
+	serveDNS := func(srv *Server) <-chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			query(srv, "abc.example.com", dns.TypeTXT)
+		}()
+		return done
+	}
+
+	// awaitShutdown shuts down the server, and fails the test if the query
+	// isn't handled soon after.
+	awaitShutdown := func(t *testing.T, srv *Server, done <-chan struct{}) {
+		t.Helper()
+
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatalf("failed to shutdown: %v", err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected in-flight query to be cancelled on shutdown")
+		}
+	}
+
+	t.Run("while answering", func(t *testing.T) {
+		srv := newTestServer(t)
+		srv.queryTimeout = time.Minute
+
+		// The zone is locked, so answering blocks until the lock is acquired
+		// or the context is done.
+		key := lockKey("abc.example.com.")
+		if err := srv.storage.Lock(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+		defer srv.storage.Unlock(key)
+
+		done := serveDNS(srv)
+		select {
+		case <-done:
+			t.Fatal("expected query to block on the locked zone")
+		case <-time.After(100 * time.Millisecond):
+		}
+		awaitShutdown(t, srv, done)
+	})
+
+	t.Run("while storing the log entry", func(t *testing.T) {
+		svc := blockingHostsService{storing: make(chan struct{}, 1)}
+		srv := newTestServer(t, WithHostsService(svc))
+
+		done := serveDNS(srv)
+		select {
+		case <-svc.storing:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected DNS log entry to be stored")
+		}
+		awaitShutdown(t, srv, done)
+	})
+}
+
+func TestServeDNSSOA(t *testing.T) {
+	tests := []struct {
+		name string
+		soa  SOAParams
+		exp  dns.SOA
+	}{
+		{
+			name: "defaults",
+			exp: dns.SOA{
+				Ns:      "ns1.example.com.",
+				Mbox:    "hostmaster.example.com.",
+				Refresh: 86400,
+				Retry:   7200,
+				Expire:  3600000,
+				Minttl:  3600,
+			},
+		},
+		{
+			name: "configured",
+			soa: SOAParams{
+				Ns:      "ns.example.org",
+				Mbox:    "admin.example.org",
+				Refresh: 3600,
+				Retry:   600,
+				Expire:  86400,
+				Minttl:  60,
+			},
+			exp: dns.SOA{
+				Ns:      "ns.example.org.",
+				Mbox:    "admin.example.org.",
+				Refresh: 3600,
+				Retry:   600,
+				Expire:  86400,
+				Minttl:  60,
+			},
+		},
+		{
+			name: "email mailbox and partial timers",
+			soa: SOAParams{
+				Mbox:   "admin@example.org",
+				Minttl: 60,
+			},
+			exp: dns.SOA{
+				Ns:      "ns1.example.com.",
+				Mbox:    "admin.example.org.",
+				Refresh: 86400,
+				Retry:   7200,
+				Expire:  3600000,
+				Minttl:  60,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, WithSOA(tt.soa))
+
+			msgs := query(srv, "example.com", dns.TypeSOA)
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 reply, got %v", len(msgs))
+			}
+			if len(msgs[0].Answer) != 1 {
+				t.Fatalf("expected 1 answer, got %v", msgs[0].Answer)
+			}
+			soa, ok := msgs[0].Answer[0].(*dns.SOA)
+			if !ok {
+				t.Fatalf("expected SOA record, got %v", msgs[0].Answer[0])
+			}
+
+			got := *soa
+			got.Hdr = dns.RR_Header{}
+			got.Serial = 0
+			if got != tt.exp {
+				t.Errorf("expected SOA %v, got %v", &tt.exp, &got)
+			}
+		})
+	}
+}
+
+func TestServeDNSSelfIP(t *testing.T) {
+	ipv4 := net.ParseIP("192.0.2.1")
+	ipv6 := net.ParseIP("2001:db8::1")
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		exp   []string
+	}{
+		{
+			name:  "apex A",
+			qname: "example.com",
+			qtype: dns.TypeA,
+			exp:   []string{"example.com.\t3600\tIN\tA\t192.0.2.1"},
+		},
+		{
+			name:  "apex AAAA",
+			qname: "example.com",
+			qtype: dns.TypeAAAA,
+			exp:   []string{"example.com.\t3600\tIN\tAAAA\t2001:db8::1"},
+		},
+		{
+			name:  "nameserver A",
+			qname: "ns1.example.com",
+			qtype: dns.TypeA,
+			exp:   []string{"ns1.example.com.\t3600\tIN\tA\t192.0.2.1"},
+		},
+		{
+			name:  "nameserver AAAA",
+			qname: "ns1.example.com",
+			qtype: dns.TypeAAAA,
+			exp:   []string{"ns1.example.com.\t3600\tIN\tAAAA\t2001:db8::1"},
+		},
+		{
+			name:  "other name",
+			qname: "abc.example.com",
+			qtype: dns.TypeA,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, WithSelfIP(ipv4, ipv6))
+
+			msgs := query(srv, tt.qname, tt.qtype)
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 reply, got %v", len(msgs))
+			}
+			var got []string
+			for _, rr := range msgs[0].Answer {
+				got = append(got, rr.String())
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("expected answers %q, got %q", tt.exp, got)
+			}
+		})
+	}
+
+	t.Run("glue for NS answers", func(t *testing.T) {
+		srv := newTestServer(t, WithSelfIP(ipv4, ipv6))
+
+		msgs := query(srv, "example.com", dns.TypeNS)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		var got []string
+		for _, rr := range msgs[0].Extra {
+			got = append(got, rr.String())
+		}
+		exp := []string{
+			"ns1.example.com.\t3600\tIN\tA\t192.0.2.1",
+			"ns1.example.com.\t3600\tIN\tAAAA\t2001:db8::1",
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("expected glue records %q, got %q", exp, got)
+		}
+	})
+
+	t.Run("stored records take precedence", func(t *testing.T) {
+		srv := newTestServer(t, WithSelfIP(ipv4, ipv6))
+		_, err := srv.AppendRecords(context.Background(), "example.com.", []libdns.Record{
+			{Type: "A", Value: "198.51.100.1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msgs := query(srv, "example.com", dns.TypeA)
+		if len(msgs[0].Answer) != 1 {
+			t.Fatalf("expected 1 answer, got %v", msgs[0].Answer)
+		}
+		if a, ok := msgs[0].Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("198.51.100.1")) {
+			t.Errorf("expected stored A record, got %v", msgs[0].Answer[0])
+		}
+	})
+}
+
+func TestServeDNSUnicodeZone(t *testing.T) {
+	srv := newTestServer(t, WithSOAHostname("bücher.test"))
+
+	for _, name := range []string{`b\195\188cher.test.`, `B\195\156CHER.test.`, "xn--bcher-kva.test."} {
+		msgs := query(srv, name, dns.TypeSOA)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		if len(msgs[0].Answer) != 1 {
+			t.Errorf("expected name %q to be in zone, got answers %v", name, msgs[0].Answer)
+			continue
+		}
+		if soa, ok := msgs[0].Answer[0].(*dns.SOA); !ok || soa.Ns != "ns1.xn--bcher-kva.test." {
+			t.Errorf("expected SOA record of zone, got %v", msgs[0].Answer[0])
+		}
+	}
+}
+
+func TestServeDNSMultipleTXT(t *testing.T) {
+	srv := newTestServer(t)
+	values := []string{"token-1", "token-2", "token-3"}
+	for _, value := range values {
+		_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+			{Type: "TXT", Value: value},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := query(srv, "abc.example.com", dns.TypeTXT)
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 reply, got %v", len(msgs))
+	}
+	reply := msgs[0]
+
+	var got []string
+	for _, rr := range reply.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			t.Fatalf("expected TXT record, got %v", rr)
+		}
+		got = append(got, strings.Join(txt.Txt, ""))
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("expected TXT values %v, got %v", values, got)
+	}
+
+	packed, err := reply.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack reply: %v", err)
+	}
+	unpacked := &dns.Msg{}
+	if err := unpacked.Unpack(packed); err != nil {
+		t.Fatalf("failed to unpack reply: %v", err)
+	}
+	if len(unpacked.Answer) != len(values) {
+		t.Errorf("expected %v answers after packing, got %v", len(values), len(unpacked.Answer))
+	}
+}
+
+func TestServeDNSTruncatesUDPReplies(t *testing.T) {
+	srv := newTestServer(t)
+	const n = 20
+	for i := 0; i < n; i++ {
+		_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+			{Type: "TXT", Value: fmt.Sprintf("%02d%v", i, strings.Repeat("a", 200))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   net.Addr
+		udpSize      uint16
+		expTruncated bool
+		expMaxSize   int
+	}{
+		{
+			name:         "UDP",
+			remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234},
+			expTruncated: true,
+			expMaxSize:   dns.MinMsgSize,
+		},
+		{
+			name:         "UDP with EDNS(0)",
+			remoteAddr:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234},
+			udpSize:      4096,
+			expTruncated: true,
+			expMaxSize:   maxUDPSize,
+		},
+		{
+			name:       "TCP",
+			remoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1234},
+			expMaxSize: dns.MaxMsgSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestResponseWriter()
+			w.remoteAddr = tt.remoteAddr
+			r := &dns.Msg{}
+			r.SetQuestion("abc.example.com.", dns.TypeTXT)
+			if tt.udpSize > 0 {
+				r.SetEdns0(tt.udpSize, false)
+			}
+			srv.ServeDNS(w, r)
+
+			if len(w.msgs) != 1 {
+				t.Fatalf("expected 1 reply, got %v", len(w.msgs))
+			}
+			reply := w.msgs[0]
+			if reply.Truncated != tt.expTruncated {
+				t.Errorf("expected truncated %v, got %v", tt.expTruncated, reply.Truncated)
+			}
+			if !tt.expTruncated && len(reply.Answer) != n {
+				t.Errorf("expected %v answers, got %v", n, len(reply.Answer))
+			}
+			if size := reply.Len(); size > tt.expMaxSize {
+				t.Errorf("expected reply of max %v bytes, got %v", tt.expMaxSize, size)
+			}
+		})
+	}
+}
+
+// recordingHostsService records the query types of stored DNS log entries.
+type recordingHostsService struct {
+	hosts.Service
+	mu     sync.Mutex
+	qtypes []uint16
+}
+
+func (svc *recordingHostsService) StoreDNSLogEntry(_ context.Context, params hosts.StoreDNSLogEntryParams) error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.qtypes = append(svc.qtypes, params.Query.Question[0].Qtype)
+	return nil
+}
+
+func TestServeDNSLoggedQTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ServerOption
+		expLogged []uint16
+	}{
+		{
+			name:      "all query types",
+			expLogged: []uint16{dns.TypeA, dns.TypeTXT, dns.TypeCNAME},
+		},
+		{
+			name:      "filtered query types",
+			opts:      []ServerOption{WithLoggedQTypes([]uint16{dns.TypeTXT, dns.TypeCNAME})},
+			expLogged: []uint16{dns.TypeTXT, dns.TypeCNAME},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &recordingHostsService{}
+			srv := newTestServer(t, append([]ServerOption{WithHostsService(svc)}, tt.opts...)...)
+			_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+				{Type: "A", Value: "192.0.2.1"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeTXT, dns.TypeCNAME} {
+				msgs := query(srv, "abc.example.com", qtype)
+				if len(msgs) != 1 || msgs[0].Rcode != dns.RcodeSuccess {
+					t.Fatalf("expected %v query to be answered, got %v", dns.TypeToString[qtype], msgs)
+				}
+				// Queries that aren't logged are still answered.
+				if qtype == dns.TypeA && len(msgs[0].Answer) != 1 {
+					t.Errorf("expected A record answer, got %v", msgs[0].Answer)
+				}
+			}
+
+			if !reflect.DeepEqual(svc.qtypes, tt.expLogged) {
+				t.Errorf("expected logged query types %v, got %v", tt.expLogged, svc.qtypes)
+			}
+		})
+	}
+}
+
+func (s *countingStorage) totalLoads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, count := range s.loads {
+		n += count
+	}
+	return n
+}
+
+func BenchmarkServeDNSRecordCache(b *testing.B) {
+	tests := []struct {
+		name string
+		opts []ServerOption
+	}{
+		{name: "without cache"},
+		{name: "with cache", opts: []ServerOption{WithRecordCache(time.Minute)}},
+	}
+
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			srv := newTestServer(b, tt.opts...)
+			_, err := srv.AppendRecords(context.Background(), "abc.example.com.", []libdns.Record{
+				{Type: "A", Value: "192.0.2.1"},
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			storage := &countingStorage{Storage: srv.storage, loads: make(map[string]int)}
+			srv.storage = storage
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				query(srv, "abc.example.com", dns.TypeA)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(storage.totalLoads())/float64(b.N), "loads/op")
+		})
+	}
+}
+
+func TestServeDNSRecordCache(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t, WithRecordCache(time.Minute))
+	_, err := srv.AppendRecords(ctx, "abc.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := &countingStorage{Storage: srv.storage, loads: make(map[string]int)}
+	srv.storage = storage
+
+	expectA := func(t *testing.T, exp []string) {
+		t.Helper()
+
+		msgs := query(srv, "abc.example.com", dns.TypeA)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		var got []string
+		for _, rr := range msgs[0].Answer {
+			if a, ok := rr.(*dns.A); ok {
+				got = append(got, a.A.String())
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("expected A records %v, got %v", exp, got)
+		}
+	}
+
+	expectA(t, []string{"192.0.2.1"})
+	loads := storage.totalLoads()
+	for i := 0; i < 10; i++ {
+		expectA(t, []string{"192.0.2.1"})
+	}
+	if n := storage.totalLoads(); n != loads {
+		t.Errorf("expected no storage loads for repeated queries, got %v", n-loads)
+	}
+
+	// Writes via the server invalidate the cache.
+	_, err = srv.AppendRecords(ctx, "abc.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectA(t, []string{"192.0.2.1", "192.0.2.2"})
+
+	_, err = srv.DeleteRecords(ctx, "abc.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectA(t, []string{"192.0.2.2"})
+}
+
+func TestServeDNSParentZone(t *testing.T) {
+	// The zone is a parent of the hostname, e.g. with `--dns-zone`.
+	srv := newTestServer(t, WithSOAHostname("example.com"))
+	ctx := context.Background()
+
+	_, err := srv.AppendRecords(ctx, "abc.edena.example.com.", []libdns.Record{
+		{Type: "A", Value: "192.0.2.10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = srv.AppendRecords(ctx, "_acme-challenge.edena.example.com.", []libdns.Record{
+		{Type: "TXT", Value: "token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		qname       string
+		qtype       uint16
+		expAuth     bool
+		expRR       string
+		expNoAnswer bool
+	}{
+		{
+			name:    "SOA of zone",
+			qname:   "example.com",
+			qtype:   dns.TypeSOA,
+			expAuth: true,
+			expRR:   "ns1.example.com.",
+		},
+		{
+			name:    "NS of zone",
+			qname:   "example.com",
+			qtype:   dns.TypeNS,
+			expAuth: true,
+			expRR:   "ns1.example.com.",
+		},
+		{
+			name:    "host record",
+			qname:   "abc.edena.example.com",
+			qtype:   dns.TypeA,
+			expAuth: true,
+			expRR:   "192.0.2.10",
+		},
+		{
+			name:    "ACME DNS-01 challenge of hostname",
+			qname:   "_acme-challenge.edena.example.com",
+			qtype:   dns.TypeTXT,
+			expAuth: true,
+			expRR:   "token",
+		},
+		{
+			name:        "outside of zone",
+			qname:       "abc.example.org",
+			qtype:       dns.TypeA,
+			expNoAnswer: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := query(srv, tt.qname, tt.qtype)
+			if len(msgs) != 1 {
+				t.Fatalf("expected 1 reply, got %v", len(msgs))
+			}
+			reply := msgs[0]
+			if reply.Authoritative != tt.expAuth {
+				t.Errorf("expected authoritative %v, got %v", tt.expAuth, reply.Authoritative)
+			}
+			if tt.expNoAnswer {
+				if len(reply.Answer) != 0 {
+					t.Errorf("expected no answers, got %v", reply.Answer)
+				}
+				return
+			}
+			if len(reply.Answer) != 1 {
+				t.Fatalf("expected 1 answer, got %v", reply.Answer)
+			}
+
+			var got string
+			switch rr := reply.Answer[0].(type) {
+			case *dns.SOA:
+				got = rr.Ns
+			case *dns.NS:
+				got = rr.Ns
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.TXT:
+				got = strings.Join(rr.Txt, "")
+			default:
+				t.Fatalf("unexpected answer %v", rr)
+			}
+			if got != tt.expRR {
+				t.Errorf("expected %q, got %q", tt.expRR, got)
+			}
+			if name := reply.Answer[0].Header().Name; name != dns.Fqdn(tt.qname) {
+				t.Errorf("expected answer for %q, got %q", dns.Fqdn(tt.qname), name)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// listener is a dns.Server, running on a listener (TCP) or packet conn (UDP)
+// owned by Server, so it can be force closed when a graceful shutdown times
+// out.
+type listener struct {
+	server *dns.Server
+	// name describes the listener in logs, e.g. `UDP 127.0.0.1:53`.
+	name string
+	// conns tracks accepted TCP connections, and is nil for UDP.
+	conns *trackingListener
+	// started is closed when the server has started, and stopped when
+	// it has returned.
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func newListener(server *dns.Server) *listener {
+	l := &listener{
+		server:  server,
+		started: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	server.NotifyStartedFunc = func() { close(l.started) }
+
+	if server.PacketConn != nil {
+		l.name = "UDP " + server.PacketConn.LocalAddr().String()
+	}
+	if server.Listener != nil {
+		l.name = "TCP " + server.Listener.Addr().String()
+		l.conns = &trackingListener{
+			Listener: server.Listener,
+			conns:    make(map[net.Conn]struct{}),
+		}
+		server.Listener = l.conns
+	}
+
+	return l
+}
+
+// listen creates a UDP and TCP listener for each address, with `handler` as
+// DNS handler. Sockets have SO_REUSEPORT set where supported, like dns.Server
+// does with ReusePort. If listening on any of the addresses fails, the
+// listeners created so far are closed.
+func listen(addrs []string, handler dns.Handler) ([]*listener, error) {
+	ctx := context.Background()
+	lc := net.ListenConfig{Control: reusePort}
+
+	var listeners []*listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.forceClose()
+		}
+	}
+
+	for _, addr := range addrs {
+		pc, err := lc.ListenPacket(ctx, "udp", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, newListener(&dns.Server{PacketConn: pc, Handler: handler}))
+
+		ln, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, newListener(&dns.Server{Listener: ln, Handler: handler}))
+	}
+
+	return listeners, nil
+}
+
+func (l *listener) serve() error {
+	defer close(l.stopped)
+	return l.server.ActivateAndServe()
+}
+
+// shutdown gracefully shuts down the server. If `ctx` is done first, the
+// listener and all connections are closed, and ctx.Err() is returned.
+func (l *listener) shutdown(ctx context.Context) error {
+	select {
+	case <-l.started:
+	case <-l.stopped:
+		return nil
+	case <-ctx.Done():
+		l.forceClose()
+		return ctx.Err()
+	}
+
+	err := l.server.ShutdownContext(ctx)
+	if ctx.Err() != nil {
+		l.forceClose()
+	}
+
+	return err
+}
+
+func (l *listener) forceClose() {
+	if l.server.PacketConn != nil {
+		l.server.PacketConn.Close()
+	}
+	if l.conns != nil {
+		l.conns.closeAll()
+	}
+}
+
+// trackingListener is a net.Listener that keeps track of accepted connections
+// that are still open.
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (tl *trackingListener) Accept() (net.Conn, error) {
+	conn, err := tl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &trackedConn{Conn: conn, listener: tl}
+	tl.mu.Lock()
+	tl.conns[tc] = struct{}{}
+	tl.mu.Unlock()
+
+	return tc, nil
+}
+
+func (tl *trackingListener) closeAll() {
+	tl.Listener.Close()
+
+	tl.mu.Lock()
+	conns := make([]net.Conn, 0, len(tl.conns))
+	for conn := range tl.conns {
+		conns = append(conns, conn)
+	}
+	tl.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	listener *trackingListener
+}
+
+func (tc *trackedConn) Close() error {
+	tc.listener.mu.Lock()
+	delete(tc.listener.conns, tc)
+	tc.listener.mu.Unlock()
+
+	return tc.Conn.Close()
+}
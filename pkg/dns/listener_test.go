@@ -0,0 +1,279 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/miekg/dns"
+)
+
+// runTestServer runs the server until the test ends, and returns its
+// listeners once they've all started.
+func runTestServer(t *testing.T, srv *Server) []*listener {
+	t.Helper()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(context.Background()) }()
+	t.Cleanup(func() {
+		srv.Shutdown(context.Background())
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected error running server: %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		srv.mu.Lock()
+		listeners := srv.listeners
+		srv.mu.Unlock()
+		if listeners != nil {
+			for _, l := range listeners {
+				<-l.started
+			}
+			return listeners
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for server to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func listenerAddr(l *listener) net.Addr {
+	if l.server.PacketConn != nil {
+		return l.server.PacketConn.LocalAddr()
+	}
+	return l.server.Listener.Addr()
+}
+
+func TestRunBindsAddress(t *testing.T) {
+	if ln, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skip("IPv6 loopback isn't available")
+	} else {
+		ln.Close()
+	}
+
+	srv := newTestServer(t, WithAddress("127.0.0.1:0", "[::1]:0"))
+	listeners := runTestServer(t, srv)
+
+	if len(listeners) != 4 {
+		t.Fatalf("expected 4 listeners, got %v", len(listeners))
+	}
+
+	expIPs := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 1), net.IPv6loopback, net.IPv6loopback}
+	for i, l := range listeners {
+		addr := listenerAddr(l)
+		if ip := addrIP(addr); !ip.Equal(expIPs[i]) {
+			t.Errorf("expected listener %v to be bound to %v, got %v", i, expIPs[i], addr)
+		}
+
+		network := "udp"
+		if l.server.Listener != nil {
+			network = "tcp"
+		}
+		c := &dns.Client{Net: network, Timeout: 5 * time.Second}
+		r := &dns.Msg{}
+		r.SetQuestion("example.com.", dns.TypeSOA)
+		reply, _, err := c.Exchange(r, addr.String())
+		if err != nil {
+			t.Errorf("failed to query %v: %v", l.name, err)
+			continue
+		}
+		if len(reply.Answer) != 1 {
+			t.Errorf("expected 1 answer from %v, got %v", l.name, reply.Answer)
+		}
+	}
+}
+
+func TestRunFailsOnAddressInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srv := newTestServer(t, WithAddress(ln.Addr().String()))
+	if err := srv.Run(context.Background()); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listeners != nil {
+		t.Errorf("expected no listeners, got %v", len(srv.listeners))
+	}
+}
+
+func TestRunPropagatesBindError(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen func() (io.Closer, string, error)
+	}{
+		{
+			name: "UDP",
+			listen: func() (io.Closer, string, error) {
+				pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+				if err != nil {
+					return nil, "", err
+				}
+				return pc, pc.LocalAddr().String(), nil
+			},
+		},
+		{
+			name: "TCP",
+			listen: func() (io.Closer, string, error) {
+				ln, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					return nil, "", err
+				}
+				return ln, ln.Addr().String(), nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, addr, err := tt.listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			srv := newTestServer(t, WithAddress(addr))
+			err = srv.Run(context.Background())
+			// The cause is wrapped, so callers can detect e.g. permission
+			// errors for privileged ports.
+			if !errors.Is(err, syscall.EADDRINUSE) {
+				t.Errorf("expected error wrapping %v, got %v", syscall.EADDRINUSE, err)
+			}
+		})
+	}
+}
+
+func TestRunShutdownConcurrently(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		srv := newTestServer(t, WithAddress("127.0.0.1:0"))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := srv.Run(context.Background()); err != nil {
+				t.Errorf("unexpected error running server: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Run and Shutdown to return")
+		}
+	}
+}
+
+func TestRunAfterShutdown(t *testing.T) {
+	srv := newTestServer(t, WithAddress("127.0.0.1:0"))
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := srv.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listeners != nil {
+		t.Errorf("expected no listeners, got %v", len(srv.listeners))
+	}
+}
+
+// blockingStorage blocks loading keys with `prefix` until `release` is
+// closed, and signals `loading` when it starts to.
+type blockingStorage struct {
+	certmagic.Storage
+	prefix  string
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStorage) Load(key string) ([]byte, error) {
+	if strings.HasPrefix(key, s.prefix) {
+		select {
+		case s.loading <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	return s.Storage.Load(key)
+}
+
+func TestShutdownClosesInFlightConnections(t *testing.T) {
+	srv := newTestServer(t, WithAddress("127.0.0.1:0"))
+	storage := &blockingStorage{
+		Storage: srv.storage,
+		prefix:  storageKey("abc.example.com."),
+		loading: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	srv.storage = storage
+	defer close(storage.release)
+
+	var tcpAddr net.Addr
+	for _, l := range runTestServer(t, srv) {
+		if l.server.Listener != nil {
+			tcpAddr = listenerAddr(l)
+		}
+	}
+
+	conn, err := dns.Dial("tcp", tcpAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := &dns.Msg{}
+	r.SetQuestion("abc.example.com.", dns.TypeTXT)
+	if err := conn.WriteMsg(r); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-storage.loading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for query to be handled")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The connection is closed by the server, so reading fails instead of
+	// waiting for the reply to the blocked query.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.ReadMsg(); err == nil {
+		t.Fatal("expected error reading from closed connection, got nil")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatalf("expected connection to be closed, got: %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package dns
+
+import "syscall"
+
+// reusePort is nil, because SO_REUSEPORT isn't supported on this platform.
+var reusePort func(network, address string, c syscall.RawConn) error
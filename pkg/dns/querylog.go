@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// queryLog writes DNS queries and their responses as JSON lines.
+type queryLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type queryLogEntry struct {
+	Time      time.Time `json:"time"`
+	QName     string    `json:"qname"`
+	QType     string    `json:"qtype"`
+	RCode     string    `json:"rcode,omitempty"`
+	ClientIP  string    `json:"clientIp"`
+	Protocol  string    `json:"protocol"`
+	LatencyMS float64   `json:"latencyMs"`
+}
+
+func newQueryLog(w io.Writer) *queryLog {
+	return &queryLog{enc: json.NewEncoder(w)}
+}
+
+func (ql *queryLog) write(entry queryLogEntry) error {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+
+	return ql.enc.Encode(entry)
+}
+
+// replyRecorder is a dns.ResponseWriter that keeps the last written reply, so
+// its response code can be logged.
+type replyRecorder struct {
+	dns.ResponseWriter
+	reply *dns.Msg
+}
+
+func (rec *replyRecorder) WriteMsg(m *dns.Msg) error {
+	rec.reply = m
+	return rec.ResponseWriter.WriteMsg(m)
+}
+
+func (srv *Server) writeQueryLog(r *dns.Msg, rec *replyRecorder, start time.Time) {
+	q := r.Question[0]
+	entry := queryLogEntry{
+		Time:      start.UTC(),
+		QName:     q.Name,
+		QType:     dns.Type(q.Qtype).String(),
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if rec.reply != nil {
+		entry.RCode = dns.RcodeToString[rec.reply.Rcode]
+	}
+	if addr := rec.RemoteAddr(); addr != nil {
+		entry.Protocol = addr.Network()
+		entry.ClientIP = addr.String()
+		if host, _, err := net.SplitHostPort(entry.ClientIP); err == nil {
+			entry.ClientIP = host
+		}
+	}
+
+	if err := srv.queryLog.write(entry); err != nil {
+		srv.logger.Error("Failed to write DNS query log entry.", zap.Error(err))
+	}
+}
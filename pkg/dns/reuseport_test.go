@@ -0,0 +1,43 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestReusePort(t *testing.T) {
+	ctx := context.Background()
+	lc := net.ListenConfig{Control: reusePort}
+
+	t.Run("TCP", func(t *testing.T) {
+		first, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+
+		second, err := lc.Listen(ctx, "tcp", first.Addr().String())
+		if err != nil {
+			t.Fatalf("expected listening on the same address to succeed, got: %v", err)
+		}
+		second.Close()
+	})
+
+	t.Run("UDP", func(t *testing.T) {
+		first, err := lc.ListenPacket(ctx, "udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+
+		second, err := lc.ListenPacket(ctx, "udp", first.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("expected listening on the same address to succeed, got: %v", err)
+		}
+		second.Close()
+	})
+}
@@ -1,20 +1,27 @@
 package dns
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math/rand"
+	"net"
+	"os"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/hashicorp/go-multierror"
 	"github.com/libdns/libdns"
 	"github.com/miekg/dns"
 	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
 )
 
 // Interface guards.
@@ -31,21 +38,53 @@ var (
 // Server is used for capturing DNS requests, and storing/serving TXT records
 // for the ACME DNS-01 challenge. It implements certmagic.ACMEDNSProvider.
 type Server struct {
-	storage     certmagic.Storage
-	addr        string
-	soaHostname string
-	tcpServer   *dns.Server
-	udpServer   *dns.Server
-	logger      *zap.Logger
+	storage      certmagic.Storage
+	hostsService hosts.Service
+	addrs        []string
+	soaHostname  string
+	soa          SOAParams
+	// zoneTransferAllow holds the networks that may request zone transfers.
+	zoneTransferAllow []net.IPNet
+	queryLog          *queryLog
+	catchAll          bool
+	catchAllIPv4      net.IP
+	catchAllIPv6      net.IP
+	selfIPs           []net.IP
+	// loggedQTypes holds the query types that DNS log entries are stored
+	// for. Entries are stored for all query types if empty.
+	loggedQTypes map[uint16]bool
+	// recordCache caches records read from storage. Disabled if nil.
+	recordCache *recordCache
+	// delegationCache caches the NS records of zones, so finding the
+	// delegation of a name doesn't load each of its parent zones from
+	// storage on every query.
+	delegationCache *recordCache
+	// cookieSecret is used for DNS server cookies. Disabled if nil.
+	cookieSecret  *[CookieSecretLen]byte
+	strictCookies bool
+	// mu guards the listeners, which are set by Run and read by Shutdown.
+	mu           sync.Mutex
+	listeners    []*listener
+	shuttingDown bool
+	// queryTimeout bounds the time spent on storage operations for answering
+	// a query, e.g. waiting for the lock of a zone that's being written.
+	queryTimeout time.Duration
+	// ctx is used for handling queries, and is cancelled on shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger *zap.Logger
 }
 
 type ServerOption func(*Server)
 
 func NewServer(opts ...ServerOption) *Server {
 	srv := &Server{
-		addr:   ":53",
-		logger: zap.NewNop(),
+		addrs:           []string{":53"},
+		delegationCache: newRecordCache(delegationCacheTTL),
+		queryTimeout:    defaultQueryTimeout,
+		logger:          zap.NewNop(),
 	}
+	srv.ctx, srv.cancel = context.WithCancel(context.Background())
 
 	for _, opt := range opts {
 		opt(srv)
@@ -60,18 +99,143 @@ func WithStorage(s certmagic.Storage) ServerOption {
 	}
 }
 
-func WithAddress(addr string) ServerOption {
+// WithHostsService sets the hosts.Service used for storing DNS queries
+// received for hosts.
+func WithHostsService(svc hosts.Service) ServerOption {
+	return func(srv *Server) {
+		srv.hostsService = svc
+	}
+}
+
+// WithAddress overrides the default address (`:53`) for the UDP and TCP
+// servers to listen on. When multiple addresses are given, e.g. an IPv4 and
+// IPv6 address, the servers listen on all of them.
+func WithAddress(addrs ...string) ServerOption {
 	return func(srv *Server) {
-		srv.addr = addr
+		srv.addrs = addrs
 	}
 }
 
+// WithSOAHostname sets the zone the server is authoritative for. Queries for
+// names outside of the zone get an empty reply. The zone may differ from the hostname
+// used for the API, e.g. when it's a parent domain.
 func WithSOAHostname(soaHostname string) ServerOption {
 	return func(srv *Server) {
-		srv.soaHostname = dns.Fqdn(soaHostname)
+		srv.soaHostname = dns.Fqdn(hosts.NormalizeHostname(soaHostname))
+	}
+}
+
+// SOAParams configures the values of SOA records. Zero values are replaced by
+// the values of DefaultSOAParams, and the name server and mailbox default to
+// `ns1` and `hostmaster` below the SOA hostname.
+type SOAParams struct {
+	// Ns is the primary name server.
+	Ns string
+	// Mbox is the mailbox of the person responsible for the zone, either as
+	// domain name (`hostmaster.example.com`) or email address.
+	Mbox string
+	// Timers, in seconds.
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	// Minttl is the TTL used by resolvers for caching negative answers
+	// (RFC 2308). Lower it for short lived records, e.g. ACME challenges.
+	Minttl uint32
+}
+
+// DefaultSOAParams holds the default timers of SOA records.
+var DefaultSOAParams = SOAParams{
+	Refresh: 86400,
+	Retry:   7200,
+	Expire:  3600000,
+	Minttl:  3600,
+}
+
+// WithSOA overrides the values of SOA records.
+func WithSOA(params SOAParams) ServerOption {
+	return func(srv *Server) {
+		srv.soa = params
+	}
+}
+
+// WithZoneTransferAllow allows zone transfers (AXFR) over TCP for clients with
+// an IP address in one of the given networks. By default, zone transfers are
+// refused.
+func WithZoneTransferAllow(nets []net.IPNet) ServerOption {
+	return func(srv *Server) {
+		srv.zoneTransferAllow = nets
+	}
+}
+
+// WithCatchAll enables answering queries for in-zone names that have no
+// records of the queried type with a synthesized answer, so interactions are
+// captured for any query type: A and AAAA queries are answered with the given
+// IP addresses (if not nil), TXT queries with an empty string and MX queries
+// with the queried name as mail exchanger. Synthesized answers have a TTL of
+// zero, so resolvers don't cache them. Stored records take precedence.
+func WithCatchAll(ipv4, ipv6 net.IP) ServerOption {
+	return func(srv *Server) {
+		srv.catchAll = true
+		srv.catchAllIPv4 = ipv4.To4()
+		srv.catchAllIPv6 = ipv6
 	}
 }
 
+// WithSelfIP sets the IP addresses of the server itself, which are answered
+// for A and AAAA queries for the zone apex and its nameserver (`ns1` below the
+// apex), and as glue for NS answers. Stored records take precedence.
+func WithSelfIP(ips ...net.IP) ServerOption {
+	return func(srv *Server) {
+		srv.selfIPs = ips
+	}
+}
+
+// WithQueryLog writes every DNS query the server receives to `w` as a JSON
+// line, with the query name and type, response code, client IP and latency.
+// Unlike DNS log entries stored for hosts, queries for any name are written.
+// Writes are serialized, so `w` doesn't need to be safe for concurrent use.
+func WithQueryLog(w io.Writer) ServerOption {
+	return func(srv *Server) {
+		srv.queryLog = newQueryLog(w)
+	}
+}
+
+// WithLoggedQTypes limits the DNS log entries stored for hosts to queries of
+// the given types, e.g. to ignore noisy A queries of caching resolvers. Queries
+// of other types are still answered. By default, entries are stored for all
+// query types.
+func WithLoggedQTypes(qtypes []uint16) ServerOption {
+	return func(srv *Server) {
+		srv.loggedQTypes = make(map[uint16]bool, len(qtypes))
+		for _, qtype := range qtypes {
+			srv.loggedQTypes[qtype] = true
+		}
+	}
+}
+
+// WithRecordCache caches the records of zones in memory for `ttl`, so repeated
+// queries don't read from storage. The cache is invalidated when records are
+// changed via the server. Records changed directly in storage, e.g. by another
+// server sharing it, can be served stale for up to `ttl`.
+func WithRecordCache(ttl time.Duration) ServerOption {
+	return func(srv *Server) {
+		srv.recordCache = newRecordCache(ttl)
+	}
+}
+
+// ParseQTypes parses query type mnemonics, e.g. `TXT`, case-insensitively.
+func ParseQTypes(names []string) ([]uint16, error) {
+	qtypes := make([]uint16, 0, len(names))
+	for _, name := range names {
+		qtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("dns: unknown query type %q", name)
+		}
+		qtypes = append(qtypes, qtype)
+	}
+	return qtypes, nil
+}
+
 // WithLogger provides a logger, which is used for HTTP related logs.
 func WithLogger(logger *zap.Logger) ServerOption {
 	return func(srv *Server) {
@@ -79,46 +243,47 @@ func WithLogger(logger *zap.Logger) ServerOption {
 	}
 }
 
-// Run starts the DNS server.
+// Run starts the DNS server, on both UDP and TCP. If only one of them fails,
+// the server keeps running on the other one. Run returns when both have
+// stopped, with the errors of all failed servers.
 func (srv *Server) Run(ctx context.Context) error {
 	var result *multierror.Error
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	wg.Add(2)
-
-	srv.logger.Info(fmt.Sprintf("DNS server listening on %v ...", srv.addr))
-
-	go func() {
-		defer wg.Done()
-
-		dnsServer := &dns.Server{
-			Addr:      srv.addr,
-			Net:       "udp",
-			Handler:   srv,
-			ReusePort: true,
-		}
-		srv.udpServer = dnsServer
 
-		err := dnsServer.ListenAndServe()
-		if err != nil && err != context.Canceled {
-			srv.logger.Error("DNS server (UDP) failed.", zap.Error(err))
-		}
-	}()
-	go func() {
-		defer wg.Done()
-
-		dnsServer := &dns.Server{
-			Addr:      srv.addr,
-			Net:       "tcp",
-			Handler:   srv,
-			ReusePort: true,
-		}
-		srv.tcpServer = dnsServer
+	srv.mu.Lock()
+	if srv.shuttingDown {
+		srv.mu.Unlock()
+		return nil
+	}
 
-		err := dnsServer.ListenAndServe()
-		if err != nil && err != context.Canceled {
-			srv.logger.Error("DNS server (TCP) failed.", zap.Error(err))
-		}
-	}()
+	// The listeners are created here instead of by dns.Server, so they can
+	// be force closed on shutdown.
+	listeners, err := listen(srv.addrs, srv)
+	if err != nil {
+		srv.mu.Unlock()
+		srv.logger.Error("DNS server failed.", zap.Error(err))
+		return fmt.Errorf("dns: failed to run servers: %w", err)
+	}
+	srv.listeners = listeners
+	srv.mu.Unlock()
+
+	for _, l := range listeners {
+		srv.logger.Info(fmt.Sprintf("DNS server listening on %v ...", l.name))
+
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+
+			err := l.serve()
+			if err != nil {
+				srv.logger.Error(fmt.Sprintf("DNS server (%v) failed.", l.name), zap.Error(err))
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}(l)
+	}
 
 	wg.Wait()
 
@@ -129,35 +294,48 @@ func (srv *Server) Run(ctx context.Context) error {
 	return nil
 }
 
+// Apex returns the fully qualified name of the zone the server is
+// authoritative for.
+func (srv *Server) Apex() string {
+	return dns.Fqdn(srv.soaHostname)
+}
+
+// Shutdown gracefully shuts down the UDP and TCP servers. If `ctx` is done
+// before in-flight queries are handled, their connections are closed. When
+// Shutdown returns, storage operations of queries that are still being
+// handled are cancelled.
 func (srv *Server) Shutdown(ctx context.Context) error {
+	defer srv.cancel()
+
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	listeners := srv.listeners
+	srv.mu.Unlock()
+
 	// We don't use the `errgroup` package, because we want to await *all*
 	// errors before returning.
 	var result *multierror.Error
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	wg.Add(2)
 
-	go func() {
-		defer wg.Done()
-		if srv.tcpServer == nil {
-			return
-		}
-		err := srv.tcpServer.ShutdownContext(ctx)
-		if err != nil && err != context.DeadlineExceeded {
-			srv.logger.Error("Failed to shutdown DNS server (TCP).", zap.Error(err))
-			result = multierror.Append(result, err)
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		if srv.udpServer == nil {
-			return
-		}
-		err := srv.udpServer.ShutdownContext(ctx)
-		if err != nil && err != context.DeadlineExceeded {
-			srv.logger.Error("Failed to shutdown DNS server (UDP).", zap.Error(err))
-			result = multierror.Append(result, err)
-		}
-	}()
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+
+			err := l.shutdown(ctx)
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				srv.logger.Warn(fmt.Sprintf("Timed out shutting down DNS server (%v), closed connections.", l.name))
+				return
+			}
+			if err != nil {
+				srv.logger.Error(fmt.Sprintf("Failed to shutdown DNS server (%v).", l.name), zap.Error(err))
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}(l)
+	}
 
 	wg.Wait()
 
@@ -172,24 +350,119 @@ func lockKey(zone string) string {
 	return "dns:" + strings.TrimSuffix(zone, ".")
 }
 
+// Lock acquisition is retried with backoff when it fails, e.g. on transient
+// storage errors under contention of concurrent ACME challenges (file storage
+// can fail reading a lockfile that's being written).
+const (
+	lockRetryDuration  = 30 * time.Second
+	lockInitialBackoff = 50 * time.Millisecond
+	lockMaxBackoff     = time.Second
+)
+
+// defaultQueryTimeout is the default time storage operations for answering a
+// query may take. It's much shorter than lockRetryDuration, as resolvers give
+// up on a query within a few seconds, and retry it.
+const defaultQueryTimeout = 2 * time.Second
+
+// lockZone obtains the storage lock of a zone, which must be held for reading
+// and writing its zonefile. It's retried for up to lockRetryDuration, or until
+// `ctx` is done. The returned function releases the lock.
+func (srv *Server) lockZone(ctx context.Context, zone string) (unlock func(), err error) {
+	key := lockKey(zone)
+	start := time.Now()
+	backoff := lockInitialBackoff
+
+	for {
+		err = srv.storage.Lock(ctx, key)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || time.Since(start) > lockRetryDuration {
+			return nil, fmt.Errorf("dns: failed to obtain lock: %w", err)
+		}
+
+		srv.logger.Debug("Failed to obtain lock, retrying.", zap.String("key", key), zap.Error(err))
+
+		// Jitter spreads out retries of concurrent callers.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("dns: failed to obtain lock: %w", ctx.Err())
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > lockMaxBackoff {
+			backoff = lockMaxBackoff
+		}
+	}
+
+	return func() {
+		if err := srv.storage.Unlock(key); err != nil {
+			srv.logger.Error("Failed to unlock key.", zap.String("key", key), zap.Error(err))
+		}
+	}, nil
+}
+
 func storageKey(zone string) string {
 	zoneKey := strings.TrimSuffix(dns.CanonicalName(zone), ".")
 	return path.Join("dns", zoneKey)
 }
 
+// zonefileVersion is the version of the format of stored zonefiles. It must be
+// incremented for changes that older versions can't read correctly, so they
+// fail instead of misparsing records.
+const zonefileVersion = 1
+
+// storedZonefile is the format of stored zonefiles. Zonefiles stored before
+// versioning are a bare JSON array of records, which are read as well, and
+// rewritten in the current format when the zone is next changed.
+type storedZonefile struct {
+	Version int             `json:"v"`
+	Records []libdns.Record `json:"records"`
+}
+
+func encodeZonefile(recs []libdns.Record) ([]byte, error) {
+	return json.Marshal(storedZonefile{
+		Version: zonefileVersion,
+		Records: recs,
+	})
+}
+
+func decodeZonefile(data []byte) ([]libdns.Record, error) {
+	var recs []libdns.Record
+
+	// Zonefiles without records were stored as `null` in the legacy format.
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &recs); err != nil {
+			return nil, err
+		}
+		return recs, nil
+	}
+
+	var zf storedZonefile
+	if err := json.Unmarshal(data, &zf); err != nil {
+		return nil, err
+	}
+	if zf.Version < 1 || zf.Version > zonefileVersion {
+		return nil, fmt.Errorf("unsupported zonefile version %v", zf.Version)
+	}
+
+	return zf.Records, nil
+}
+
 func (srv *Server) AppendRecords(ctx context.Context, zone string, newRecs []libdns.Record) ([]libdns.Record, error) {
 	var recs []libdns.Record
 	var createdRecords []libdns.Record
 
-	lockKey := lockKey(zone)
-	if err := srv.storage.Lock(ctx, lockKey); err != nil {
-		return nil, fmt.Errorf("dns: failed to obtain lock: %w", err)
+	unlock, err := srv.lockZone(ctx, zone)
+	if err != nil {
+		return nil, err
 	}
-	defer func() {
-		if err := srv.storage.Unlock(lockKey); err != nil {
-			log.Printf("Error: Failed to unlock key %q: %v", lockKey, err)
-		}
-	}()
+	defer unlock()
 
 	storageKey := storageKey(zone)
 
@@ -201,16 +474,20 @@ func (srv *Server) AppendRecords(ctx context.Context, zone string, newRecs []lib
 	}
 
 	if zonefile != nil {
-		err = json.Unmarshal(zonefile, &recs)
+		recs, err = decodeZonefile(zonefile)
 		if err != nil {
 			return nil, fmt.Errorf("dns: failed to decode zonefile JSON: %w", err)
 		}
 	}
 
+	// Records are matched by value too, so a name can have multiple records
+	// of a type, e.g. TXT records for the ACME DNS-01 challenges of both a
+	// domain and its wildcard (RFC 8555, section 8.4).
 Loop:
 	for _, newRec := range newRecs {
 		for _, rec := range recs {
-			if (newRec.ID != "" && newRec.ID == rec.ID) || (newRec.Name == rec.Name && newRec.Type == rec.Type) {
+			if (newRec.ID != "" && newRec.ID == rec.ID) ||
+				(newRec.Name == rec.Name && newRec.Type == rec.Type && newRec.Value == rec.Value) {
 				continue Loop
 			}
 		}
@@ -218,12 +495,13 @@ Loop:
 		createdRecords = append(createdRecords, newRec)
 	}
 
-	newZonefile, err := json.Marshal(recs)
+	newZonefile, err := encodeZonefile(recs)
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to encode zonefile JSON: %w", err)
 	}
 
 	err = srv.storage.Store(storageKey, newZonefile)
+	srv.invalidateRecordCache(storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to store zonefile (key: %q): %w", storageKey, err)
 	}
@@ -235,50 +513,51 @@ func (srv *Server) DeleteRecords(ctx context.Context, zone string, deleteRecs []
 	var recs []libdns.Record
 	var deletedRecs []libdns.Record
 
-	lockKey := lockKey(zone)
-	if err := srv.storage.Lock(ctx, lockKey); err != nil {
-		return nil, fmt.Errorf("dns: failed to obtain lock: %w", err)
+	unlock, err := srv.lockZone(ctx, zone)
+	if err != nil {
+		return nil, err
 	}
-	defer func() {
-		if err := srv.storage.Unlock(lockKey); err != nil {
-			log.Printf("Error: Failed to unlock key %q: %v", lockKey, err)
-		}
-	}()
+	defer unlock()
 
 	storageKey := storageKey(zone)
 
 	zonefile, err := srv.storage.Load(storageKey)
-	var errNotExist *certmagic.ErrNotExist
+	var errNotExist certmagic.ErrNotExist
 	// Absorb `certmagic.ErrNotExist`, but return all other errors.
-	if err != nil && !errors.As(err, errNotExist) {
+	if err != nil && !errors.As(err, &errNotExist) {
 		return nil, fmt.Errorf("dns: failed to load zonefile from storage: %w", err)
 	}
 
 	if zonefile != nil {
-		err = json.Unmarshal(zonefile, &recs)
+		recs, err = decodeZonefile(zonefile)
 		if err != nil {
 			return nil, fmt.Errorf("dns: failed to decode zonefile JSON: %w", err)
 		}
 	}
 
-	// Filter out existing records that need to be deleted.
-	filteredRecs := recs[:0]
-	for _, deleteRec := range deleteRecs {
-		for _, rec := range recs {
-			if (deleteRec.ID != "" && deleteRec.ID == rec.ID) || (deleteRec.Name == rec.Name && deleteRec.Type == rec.Type) {
-				deletedRecs = append(deletedRecs, deleteRec)
-			} else {
-				filteredRecs = append(filteredRecs, rec)
+	// Filter out existing records that need to be deleted. Without a value, all
+	// records with the given name and type are deleted.
+	var filteredRecs []libdns.Record
+Loop:
+	for _, rec := range recs {
+		for _, deleteRec := range deleteRecs {
+			if (deleteRec.ID != "" && deleteRec.ID == rec.ID) ||
+				(deleteRec.Name == rec.Name && deleteRec.Type == rec.Type &&
+					(deleteRec.Value == "" || deleteRec.Value == rec.Value)) {
+				deletedRecs = append(deletedRecs, rec)
+				continue Loop
 			}
 		}
+		filteredRecs = append(filteredRecs, rec)
 	}
 
-	newZonefile, err := json.Marshal(filteredRecs)
+	newZonefile, err := encodeZonefile(filteredRecs)
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to encode zonefile JSON: %w", err)
 	}
 
 	err = srv.storage.Store(storageKey, newZonefile)
+	srv.invalidateRecordCache(storageKey)
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to store zonefile (storage key: %q): %w", storageKey, err)
 	}
@@ -286,37 +565,100 @@ func (srv *Server) DeleteRecords(ctx context.Context, zone string, deleteRecs []
 	return deletedRecs, nil
 }
 
+// DeleteHostRecords deletes the zonefiles of a hostname and all of its
+// subdomains, e.g. when a host is deleted.
+func (srv *Server) DeleteHostRecords(ctx context.Context, hostname string) error {
+	keys, err := srv.storage.List("dns", false)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("dns: failed to list zonefiles: %w", err)
+	}
+
+	for _, key := range keys {
+		zone := strings.TrimPrefix(key, "dns/")
+		if !dns.IsSubDomain(dns.Fqdn(hostname), dns.Fqdn(zone)) {
+			continue
+		}
+		if err := srv.deleteZonefile(ctx, zone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (srv *Server) deleteZonefile(ctx context.Context, zone string) error {
+	unlock, err := srv.lockZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	storageKey := storageKey(zone)
+	err = srv.storage.Delete(storageKey)
+	srv.invalidateRecordCache(storageKey)
+	if err != nil {
+		return fmt.Errorf("dns: failed to delete zonefile (storage key: %q): %w", storageKey, err)
+	}
+
+	return nil
+}
+
 func (srv *Server) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
 	var recs []libdns.Record
 
-	lockKey := lockKey(zone)
-	if err := srv.storage.Lock(ctx, lockKey); err != nil {
-		return nil, fmt.Errorf("dns: failed to obtain lock: %w", err)
-	}
-	defer func() {
-		if err := srv.storage.Unlock(lockKey); err != nil {
-			log.Printf("Error: Failed to unlock key %q: %v", lockKey, err)
+	storageKey := storageKey(zone)
+	if srv.recordCache != nil {
+		if cached, ok := srv.recordCache.get(storageKey); ok {
+			return cached, nil
 		}
-	}()
+	}
+
+	unlock, err := srv.lockZone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	storageKey := storageKey(zone)
 	zonefile, err := srv.storage.Load(storageKey)
 	var errNotExist certmagic.ErrNotExist
 	if errors.As(err, &errNotExist) {
+		srv.cacheRecords(storageKey, recs)
 		return recs, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to get zonefile (storage key: %q): %w", storageKey, err)
 	}
 
-	err = json.Unmarshal(zonefile, &recs)
+	recs, err = decodeZonefile(zonefile)
 	if err != nil {
 		return nil, fmt.Errorf("dns: failed to decode zonefile JSON: %w", err)
 	}
+	srv.cacheRecords(storageKey, recs)
 
 	return recs, nil
 }
 
+// cacheRecords caches the records of a zone, if the record cache is enabled,
+// and its NS records in the delegation cache. The storage lock of the zone must
+// be held, so a concurrent write can't be overwritten with stale records.
+func (srv *Server) cacheRecords(storageKey string, recs []libdns.Record) {
+	if srv.recordCache != nil {
+		srv.recordCache.set(storageKey, recs)
+	}
+	srv.delegationCache.set(storageKey, nsRecords(recs))
+}
+
+// invalidateRecordCache removes the records of a zone from the record cache,
+// if enabled, and from the delegation cache. The storage lock of the zone must
+// be held. It's also called when writing to storage fails, as the write may
+// have partially succeeded.
+func (srv *Server) invalidateRecordCache(storageKey string) {
+	if srv.recordCache != nil {
+		srv.recordCache.invalidate(storageKey)
+	}
+	srv.delegationCache.invalidate(storageKey)
+}
+
 // MessageFromRecord parses a libdns.Record and returns a dns.Msg value, using
 // the `zone` argument.
 func MessageFromRecord(zone string, rec libdns.Record) (dns.RR, error) {
@@ -327,16 +669,75 @@ func MessageFromRecord(zone string, rec libdns.Record) (dns.RR, error) {
 		return nil, fmt.Errorf("dns: unknown record type %q", rec.Type)
 	}
 
+	hdr := dns.RR_Header{
+		Name:   libdns.AbsoluteName(rec.Name, zone),
+		Rrtype: rrType,
+		Class:  dns.ClassINET,
+		Ttl:    3600,
+	}
+	if rec.TTL > 0 {
+		hdr.Ttl = uint32(rec.TTL.Seconds())
+	}
+
 	switch rrType {
+	case dns.TypeNS:
+		rr = &dns.NS{
+			Hdr: hdr,
+			Ns:  dns.Fqdn(rec.Value),
+		}
+	case dns.TypeA:
+		ip := net.ParseIP(rec.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("dns: invalid IPv4 address %q", rec.Value)
+		}
+		rr = &dns.A{
+			Hdr: hdr,
+			A:   ip,
+		}
+	case dns.TypeAAAA:
+		ip := net.ParseIP(rec.Value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("dns: invalid IPv6 address %q", rec.Value)
+		}
+		rr = &dns.AAAA{
+			Hdr:  hdr,
+			AAAA: ip,
+		}
+	case dns.TypeCNAME:
+		rr = &dns.CNAME{
+			Hdr:    hdr,
+			Target: dns.Fqdn(rec.Value),
+		}
+	case dns.TypeMX:
+		if rec.Priority < 0 || rec.Priority > 65535 {
+			return nil, fmt.Errorf("dns: invalid MX priority %v", rec.Priority)
+		}
+		rr = &dns.MX{
+			Hdr:        hdr,
+			Preference: uint16(rec.Priority),
+			Mx:         dns.Fqdn(rec.Value),
+		}
 	case dns.TypeTXT:
 		rr = &dns.TXT{
-			Hdr: dns.RR_Header{
-				Name:   libdns.AbsoluteName(rec.Name, zone),
-				Rrtype: dns.TypeTXT,
-				Class:  dns.ClassINET,
-				Ttl:    3600,
-			},
-			Txt: []string{rec.Value},
+			Hdr: hdr,
+			Txt: splitTXT(rec.Value),
+		}
+	case dns.TypeSVCB, dns.TypeHTTPS:
+		if rec.Priority < 0 || rec.Priority > 65535 {
+			return nil, fmt.Errorf("dns: invalid %v priority %v", dns.TypeToString[rrType], rec.Priority)
+		}
+		// The value holds the target name and parameters in presentation
+		// format (RFC 9460), e.g. `. alpn=h2,h3 ipv4hint=192.0.2.1`, which
+		// is parsed along with the rest of the record.
+		if strings.TrimSpace(rec.Value) == "" {
+			return nil, fmt.Errorf("dns: empty %v record value", dns.TypeToString[rrType])
+		}
+		var err error
+		rr, err = dns.NewRR(fmt.Sprintf("%v %v IN %v %v %v",
+			hdr.Name, hdr.Ttl, dns.TypeToString[rrType], rec.Priority, rec.Value,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("dns: invalid %v record value %q: %w", dns.TypeToString[rrType], rec.Value, err)
 		}
 	default:
 		return nil, fmt.Errorf("dns: unsupported record type %q", dns.TypeToString[rrType])
@@ -344,3 +745,39 @@ func MessageFromRecord(zone string, rec libdns.Record) (dns.RR, error) {
 
 	return rr, nil
 }
+
+// maxTXTStringLength is the maximum length of a TXT character-string, see
+// RFC 1035, section 3.3.
+const maxTXTStringLength = 255
+
+// splitTXT splits a TXT record value into character-strings of at most 255
+// bytes each. Escape sequences (e.g. `\"` or `\065`) are never split, and
+// count as the single byte they represent. Resolvers reassemble the value by
+// concatenating the strings, e.g. for SPF and DKIM records. Records in
+// storage hold the whole value.
+func splitTXT(value string) []string {
+	var txt []string
+	var start, length int
+
+	for i := 0; i < len(value); {
+		n := 1
+		if value[i] == '\\' && i+1 < len(value) {
+			n = 2
+			if i+3 < len(value) && isDigit(value[i+1]) && isDigit(value[i+2]) && isDigit(value[i+3]) {
+				n = 4
+			}
+		}
+		if length == maxTXTStringLength {
+			txt = append(txt, value[start:i])
+			start, length = i, 0
+		}
+		i += n
+		length++
+	}
+
+	return append(txt, value[start:])
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
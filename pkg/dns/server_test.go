@@ -0,0 +1,406 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+func newTestServer(t testing.TB, opts ...ServerOption) *Server {
+	t.Helper()
+
+	opts = append([]ServerOption{
+		WithStorage(&certmagic.FileStorage{Path: t.TempDir()}),
+		WithSOAHostname("example.com"),
+	}, opts...)
+
+	return NewServer(opts...)
+}
+
+func TestDecodeZonefile(t *testing.T) {
+	recs := []libdns.Record{
+		{Type: "TXT", Name: "_acme-challenge", Value: "foo", TTL: 60 * time.Second},
+		{Type: "A", Name: "abc", Value: "127.0.0.1"},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		exp     []libdns.Record
+		wantErr bool
+	}{
+		{
+			name: "legacy array",
+			data: `[{"type":"TXT","name":"_acme-challenge","value":"foo","ttl":60000000000},{"type":"A","name":"abc","value":"127.0.0.1","ttl":0}]`,
+			exp:  recs,
+		},
+		{
+			name: "legacy null",
+			data: "null",
+		},
+		{
+			name: "empty",
+			data: "",
+		},
+		{
+			name: "version 1",
+			data: `{"v":1,"records":[{"type":"TXT","name":"_acme-challenge","value":"foo","ttl":60000000000},{"type":"A","name":"abc","value":"127.0.0.1","ttl":0}]}`,
+			exp:  recs,
+		},
+		{
+			name: "version 1 without records",
+			data: `{"v":1,"records":null}`,
+		},
+		{
+			name:    "unsupported version",
+			data:    `{"v":2,"records":[]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing version",
+			data:    `{"records":[]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeZonefile([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tt.exp) || (len(got) > 0 && !reflect.DeepEqual(got, tt.exp)) {
+				t.Errorf("expected records %+v, got %+v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestAppendRecordsMigratesLegacyZonefile(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	legacy := []libdns.Record{{Type: "TXT", Name: "abc", Value: "foo"}}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.storage.Store(storageKey("example.com."), data); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = srv.AppendRecords(ctx, "example.com.", []libdns.Record{{Type: "TXT", Name: "abc", Value: "bar"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err = srv.storage.Load(storageKey("example.com."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zf storedZonefile
+	if err := json.Unmarshal(data, &zf); err != nil {
+		t.Fatalf("expected versioned zonefile, got %s", data)
+	}
+	if zf.Version != zonefileVersion {
+		t.Errorf("expected version %v, got %v", zonefileVersion, zf.Version)
+	}
+	if len(zf.Records) != 2 {
+		t.Errorf("expected 2 records, got %+v", zf.Records)
+	}
+}
+
+func TestAppendRecordsConcurrently(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := srv.AppendRecords(ctx, "_acme-challenge.example.com.", []libdns.Record{
+				{Type: "TXT", Value: fmt.Sprintf("token-%v", i)},
+			})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	recs, err := srv.GetRecords(ctx, "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	values := make(map[string]bool)
+	for _, rec := range recs {
+		values[rec.Value] = true
+	}
+	for i := 0; i < n; i++ {
+		if exp := fmt.Sprintf("token-%v", i); !values[exp] {
+			t.Errorf("expected record %q, got %+v", exp, recs)
+		}
+	}
+}
+
+func TestAppendRecordsMultipleValues(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	// The ACME DNS-01 challenges for a domain and its wildcard use the same
+	// name, with different values.
+	zone := "_acme-challenge.abc.example.com."
+	for _, value := range []string{"token-abc", "token-wildcard"} {
+		created, err := srv.AppendRecords(ctx, zone, []libdns.Record{{Type: "TXT", Value: value}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(created) != 1 {
+			t.Errorf("expected 1 created record, got %+v", created)
+		}
+	}
+
+	// Appending an existing value is a no-op.
+	created, err := srv.AppendRecords(ctx, zone, []libdns.Record{{Type: "TXT", Value: "token-abc"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(created) != 0 {
+		t.Errorf("expected no created records, got %+v", created)
+	}
+
+	txtValues := func() []string {
+		msgs := query(srv, zone, dns.TypeTXT)
+		if len(msgs) != 1 {
+			t.Fatalf("expected 1 reply, got %v", len(msgs))
+		}
+		var values []string
+		for _, rr := range msgs[0].Answer {
+			txt, ok := rr.(*dns.TXT)
+			if !ok {
+				t.Fatalf("expected TXT record, got %v", rr)
+			}
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+		sort.Strings(values)
+		return values
+	}
+
+	if got, exp := txtValues(), []string{"token-abc", "token-wildcard"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected TXT values %v, got %v", exp, got)
+	}
+
+	// Deleting by value leaves other values in place.
+	deleted, err := srv.DeleteRecords(ctx, zone, []libdns.Record{{Type: "TXT", Value: "token-abc"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 1 {
+		t.Errorf("expected 1 deleted record, got %+v", deleted)
+	}
+	if got, exp := txtValues(), []string{"token-wildcard"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected TXT values %v, got %v", exp, got)
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	a := func(n int) string { return strings.Repeat("a", n) }
+
+	tests := []struct {
+		name  string
+		value string
+		exp   []string
+	}{
+		{
+			name:  "empty",
+			value: "",
+			exp:   []string{""},
+		},
+		{
+			name:  "short",
+			value: "v=spf1 -all",
+			exp:   []string{"v=spf1 -all"},
+		},
+		{
+			name:  "255 bytes",
+			value: a(255),
+			exp:   []string{a(255)},
+		},
+		{
+			name:  "256 bytes",
+			value: a(256),
+			exp:   []string{a(255), "a"},
+		},
+		{
+			name:  "600 bytes",
+			value: a(600),
+			exp:   []string{a(255), a(255), a(90)},
+		},
+		{
+			name:  "escaped quote at boundary",
+			value: a(254) + `\"b`,
+			exp:   []string{a(254) + `\"`, "b"},
+		},
+		{
+			name:  "escaped quote after boundary",
+			value: a(255) + `\"`,
+			exp:   []string{a(255), `\"`},
+		},
+		{
+			name:  "decimal escape at boundary",
+			value: a(254) + `\065b`,
+			exp:   []string{a(254) + `\065`, "b"},
+		},
+		{
+			name:  "decimal escape after boundary",
+			value: a(255) + `\065`,
+			exp:   []string{a(255), `\065`},
+		},
+		{
+			name:  "escaped backslash before digits",
+			value: a(254) + `\\065`,
+			exp:   []string{a(254) + `\\`, "065"},
+		},
+		{
+			name:  "trailing backslash",
+			value: a(255) + `\`,
+			exp:   []string{a(255), `\`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitTXT(tt.value); !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("expected %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestMessageFromRecordLongTXT(t *testing.T) {
+	value := strings.Repeat(`abc\"def\065`, 60)
+	rr, err := MessageFromRecord("example.com.", libdns.Record{Type: "TXT", Name: "abc", Value: value})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each character-string fits in 255 bytes on the wire, so the record can
+	// be packed, and resolvers get the whole value by concatenating them.
+	msg := &dns.Msg{}
+	msg.SetQuestion("abc.example.com.", dns.TypeTXT)
+	msg.Answer = append(msg.Answer, rr)
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("failed to pack message: %v", err)
+	}
+	if err := msg.Unpack(packed); err != nil {
+		t.Fatalf("failed to unpack message: %v", err)
+	}
+
+	txt := msg.Answer[0].(*dns.TXT)
+	if len(txt.Txt) < 2 {
+		t.Errorf("expected value to be split, got %v strings", len(txt.Txt))
+	}
+	// Decimal escapes are unpacked to the byte they represent.
+	exp := strings.ReplaceAll(value, `\065`, "A")
+	if got := strings.Join(txt.Txt, ""); got != exp {
+		t.Errorf("expected value %q, got %q", exp, got)
+	}
+}
+
+func TestMessageFromRecordSVCB(t *testing.T) {
+	tests := []struct {
+		name    string
+		rec     libdns.Record
+		exp     string
+		wantErr bool
+	}{
+		{
+			name: "HTTPS service mode",
+			rec:  libdns.Record{Type: "HTTPS", Value: ". alpn=h2,h3 ipv4hint=192.0.2.1", Priority: 1},
+			exp:  "abc.example.com.\t3600\tIN\tHTTPS\t1 . alpn=\"h2,h3\" ipv4hint=\"192.0.2.1\"",
+		},
+		{
+			name: "HTTPS alias mode",
+			rec:  libdns.Record{Type: "HTTPS", Value: "cdn.example.org."},
+			exp:  "abc.example.com.\t3600\tIN\tHTTPS\t0 cdn.example.org.",
+		},
+		{
+			name: "SVCB with port",
+			rec:  libdns.Record{Type: "SVCB", Value: "svc.example.org. port=8443", Priority: 2},
+			exp:  "abc.example.com.\t3600\tIN\tSVCB\t2 svc.example.org. port=\"8443\"",
+		},
+		{
+			name:    "empty value",
+			rec:     libdns.Record{Type: "HTTPS", Value: " ", Priority: 1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid priority",
+			rec:     libdns.Record{Type: "SVCB", Value: ".", Priority: 65536},
+			wantErr: true,
+		},
+		{
+			name:    "invalid parameter",
+			rec:     libdns.Record{Type: "HTTPS", Value: ". foo=bar", Priority: 1},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rec.Name = "abc"
+			rr, err := MessageFromRecord("example.com.", tt.rec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", rr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := rr.String(); got != tt.exp {
+				t.Errorf("expected %q, got %q", tt.exp, got)
+			}
+
+			// Wire format round trip.
+			buf := make([]byte, dns.Len(rr))
+			off, err := dns.PackRR(rr, buf, 0, nil, false)
+			if err != nil {
+				t.Fatalf("failed to pack record: %v", err)
+			}
+			unpacked, _, err := dns.UnpackRR(buf[:off], 0)
+			if err != nil {
+				t.Fatalf("failed to unpack record: %v", err)
+			}
+			if !dns.IsDuplicate(rr, unpacked) {
+				t.Errorf("expected unpacked record %v, got %v", rr, unpacked)
+			}
+		})
+	}
+}
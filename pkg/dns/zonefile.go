@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/miekg/dns"
+)
+
+// WriteZonefile writes the zone the server is authoritative for as an RFC 1035
+// master file, with the SOA and NS records followed by all stored records. The
+// records are the same as served for zone transfers.
+func (srv *Server) WriteZonefile(ctx context.Context, w io.Writer) error {
+	zone := dns.Fqdn(srv.soaHostname)
+
+	rrs, err := srv.zoneRecords(ctx, zone)
+	if err != nil {
+		return err
+	}
+	rrs = append([]dns.RR{srv.soaRecord(zone)}, rrs...)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$ORIGIN %v\n", zone)
+	for _, rr := range rrs {
+		fmt.Fprintln(bw, rr.String())
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("dns: failed to write zonefile: %w", err)
+	}
+
+	return nil
+}
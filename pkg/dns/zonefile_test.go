@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+func TestWriteZonefile(t *testing.T) {
+	ctx := context.Background()
+	srv := newTestServer(t)
+
+	records := map[string][]libdns.Record{
+		"abc.example.com.": {
+			{Type: "A", Value: "192.0.2.1"},
+			{Type: "AAAA", Value: "2001:db8::1"},
+			{Type: "TXT", Value: `v=spf1 -all \"quoted\"`},
+			{Type: "TXT", Value: strings.Repeat("a", 300)},
+		},
+		"www.abc.example.com.": {
+			{Type: "CNAME", Value: "abc.example.com."},
+		},
+		"_acme-challenge.def.example.com.": {
+			{Type: "TXT", Value: "token"},
+		},
+	}
+	for zone, recs := range records {
+		if _, err := srv.AppendRecords(ctx, zone, recs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := srv.WriteZonefile(ctx, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	zp := dns.NewZoneParser(strings.NewReader(buf.String()), "", "")
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("failed to parse zonefile: %v\n%s", err, buf.String())
+	}
+
+	if len(rrs) == 0 {
+		t.Fatal("expected records, got none")
+	}
+	if soa, ok := rrs[0].(*dns.SOA); !ok || soa.Hdr.Name != "example.com." {
+		t.Errorf("expected SOA record of zone first, got %v", rrs[0])
+	}
+
+	var hasNS bool
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok && ns.Hdr.Name == "example.com." {
+			hasNS = true
+		}
+	}
+	if !hasNS {
+		t.Error("expected NS record of zone")
+	}
+
+	// Every stored record round trips.
+	for zone, recs := range records {
+		for _, rec := range recs {
+			exp, err := MessageFromRecord(zone, rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found bool
+			for _, rr := range rrs {
+				if dns.IsDuplicate(exp, rr) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("expected record %v in zonefile, got:\n%s", exp, buf.String())
+			}
+		}
+	}
+}
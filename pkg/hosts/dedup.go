@@ -0,0 +1,79 @@
+package hosts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// dedupCache keeps track of recently stored HTTP log entries, so identical
+// requests (e.g. from burst scanners) received within a time window can be
+// counted as repeats instead of being stored again.
+type dedupCache struct {
+	mu       sync.Mutex
+	window   time.Duration
+	entries  map[string]dedupEntry
+	prunedAt time.Time
+}
+
+type dedupEntry struct {
+	id       ulid.ULID
+	storedAt time.Time
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: make(map[string]dedupEntry),
+	}
+}
+
+// lookup returns the ID of the HTTP log entry stored for `key`, if it was
+// stored within the window.
+func (c *dedupCache) lookup(key string, now time.Time) (ulid.ULID, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.Sub(entry.storedAt) > c.window {
+		return ulid.ULID{}, false
+	}
+
+	return entry.id, true
+}
+
+func (c *dedupCache) add(key string, id ulid.ULID, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = dedupEntry{id: id, storedAt: now}
+
+	// Prune expired entries at most once per window.
+	if now.Sub(c.prunedAt) < c.window {
+		return
+	}
+	for k, entry := range c.entries {
+		if now.Sub(entry.storedAt) > c.window {
+			delete(c.entries, k)
+		}
+	}
+	c.prunedAt = now
+}
+
+// httpDedupKey returns a key that identifies a request by host, remote IP,
+// method, request URI (path and query) and body.
+func httpDedupKey(hostID ulid.ULID, remoteAddr string, req *http.Request, body []byte) string {
+	remoteIP, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		remoteIP = remoteAddr
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	return hostID.String() + "|" + remoteIP + "|" + req.Method + "|" + req.URL.RequestURI() + "|" + hex.EncodeToString(bodyHash[:])
+}
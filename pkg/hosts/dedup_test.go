@@ -0,0 +1,131 @@
+package hosts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+func TestHTTPDedupKey(t *testing.T) {
+	hostID := ulid.MustNew(1, nil)
+	otherHostID := ulid.MustNew(2, nil)
+
+	key := func(hostID ulid.ULID, remoteAddr, method, target, body string) string {
+		req := httptest.NewRequest(method, target, nil)
+		return httpDedupKey(hostID, remoteAddr, req, []byte(body))
+	}
+	base := key(hostID, "192.0.2.1:1234", "GET", "/cb?data=foo", "")
+
+	tests := []struct {
+		name      string
+		key       string
+		expectDup bool
+	}{
+		{
+			name:      "identical request",
+			key:       key(hostID, "192.0.2.1:1234", "GET", "/cb?data=foo", ""),
+			expectDup: true,
+		},
+		{
+			name:      "other source port",
+			key:       key(hostID, "192.0.2.1:4321", "GET", "/cb?data=foo", ""),
+			expectDup: true,
+		},
+		{
+			name: "other query",
+			key:  key(hostID, "192.0.2.1:1234", "GET", "/cb?data=bar", ""),
+		},
+		{
+			name: "without query",
+			key:  key(hostID, "192.0.2.1:1234", "GET", "/cb", ""),
+		},
+		{
+			name: "other path",
+			key:  key(hostID, "192.0.2.1:1234", "GET", "/other?data=foo", ""),
+		},
+		{
+			name: "other method",
+			key:  key(hostID, "192.0.2.1:1234", "POST", "/cb?data=foo", ""),
+		},
+		{
+			name: "other body",
+			key:  key(hostID, "192.0.2.1:1234", "GET", "/cb?data=foo", "body"),
+		},
+		{
+			name: "other remote IP",
+			key:  key(hostID, "192.0.2.2:1234", "GET", "/cb?data=foo", ""),
+		},
+		{
+			name: "other host",
+			key:  key(otherHostID, "192.0.2.1:1234", "GET", "/cb?data=foo", ""),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key == base; got != tt.expectDup {
+				t.Errorf("expected duplicate to be %v, got %v (key: %q, base: %q)", tt.expectDup, got, tt.key, base)
+			}
+		})
+	}
+}
+
+func TestDedupCacheWindow(t *testing.T) {
+	c := newDedupCache(time.Minute)
+	id := ulid.MustNew(1, nil)
+	now := time.Now()
+
+	c.add("key", id, now)
+
+	if got, ok := c.lookup("key", now.Add(time.Minute)); !ok || got != id {
+		t.Errorf("expected entry %v within window, got %v (found: %v)", id, got, ok)
+	}
+	if _, ok := c.lookup("key", now.Add(time.Minute+time.Second)); ok {
+		t.Error("expected entry to be expired after window")
+	}
+	if _, ok := c.lookup("other", now); ok {
+		t.Error("expected unknown key not to be found")
+	}
+
+	// Adding after the window prunes expired entries.
+	c.add("other", id, now.Add(2*time.Minute))
+	if _, ok := c.entries["key"]; ok {
+		t.Error("expected expired entry to be pruned")
+	}
+}
+
+func TestStoreHTTPLogEntryDedup(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"), WithDedup(time.Minute))
+	host := newTestHost(t, svc)
+
+	store := func(target string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		req.Host = host.Hostname
+		req.RemoteAddr = "192.0.2.1:1234"
+		if _, err := svc.StoreHTTPLogEntry(ctx, StoreHTTPLogEntryParams{Request: req, Response: &http.Response{}}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	store("/cb?data=foo")
+	store("/cb?data=bar")
+	store("/cb?data=foo")
+
+	entries := db.storedHTTPLogEntries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 stored entries, got %v", len(entries))
+	}
+	if entries[0].RepeatCount != 1 {
+		t.Errorf("expected repeat count 1 for first entry, got %v", entries[0].RepeatCount)
+	}
+	if entries[1].RepeatCount != 0 {
+		t.Errorf("expected repeat count 0 for second entry, got %v", entries[1].RepeatCount)
+	}
+}
@@ -0,0 +1,155 @@
+package hosts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+)
+
+type DNSLogEntry struct {
+	ID           ulid.ULID
+	HostID       ulid.ULID
+	RemoteAddr   string
+	ClientSubnet string
+	Query        *dns.Msg
+	Response     *dns.Msg
+	RawQuery     []byte
+	RawResponse  []byte
+}
+
+type StoreDNSLogEntryParams struct {
+	Query        *dns.Msg
+	Response     *dns.Msg
+	RemoteAddr   string
+	ClientSubnet string
+}
+
+func (srv *service) StoreDNSLogEntry(ctx context.Context, params StoreDNSLogEntryParams) error {
+	if len(params.Query.Question) == 0 {
+		return errors.New("hosts: DNS query has no question")
+	}
+
+	name := params.Query.Question[0].Name
+	host, err := srv.findHostByDomainName(ctx, name)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to find host by domain name %q: %w", name, err)
+	}
+
+	now := time.Now().UTC()
+	id := ulid.MustNew(ulid.Timestamp(now), ulidEntropy)
+
+	entry := DNSLogEntry{
+		ID:           id,
+		HostID:       host.ID,
+		RemoteAddr:   params.RemoteAddr,
+		ClientSubnet: params.ClientSubnet,
+		Query:        params.Query,
+		Response:     params.Response,
+	}
+
+	err = srv.database.StoreDNSLogEntry(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to store DNS log entry: %w", err)
+	}
+
+	srv.logger.Info("Stored DNS log entry.",
+		zap.String("id", entry.ID.String()),
+		zap.String("hostId", entry.HostID.String()),
+		zap.String("name", name),
+		zap.String("type", dns.TypeToString[params.Query.Question[0].Qtype]),
+		zap.String("remoteAddr", params.RemoteAddr),
+		zap.String("clientSubnet", params.ClientSubnet),
+	)
+
+	return nil
+}
+
+// findHostByDomainName finds the host for a (fully qualified) domain name,
+// which is either the hostname of a host, or a subdomain of it.
+func (srv *service) findHostByDomainName(ctx context.Context, name string) (Host, error) {
+	baseHostname := strings.ToLower(strings.TrimSuffix(srv.baseHostname, "."))
+	labels := dns.SplitDomainName(NormalizeDomainName(name))
+
+	for i := range labels {
+		hostname := strings.Join(labels[i:], ".")
+		if hostname == baseHostname || !dns.IsSubDomain(baseHostname, hostname) {
+			break
+		}
+
+		host, err := srv.findHostByHostname(ctx, hostname)
+		if errors.Is(err, ErrHostNotFound) {
+			continue
+		}
+		if err != nil {
+			return Host{}, err
+		}
+
+		return host, nil
+	}
+
+	return Host{}, ErrHostNotFound
+}
+
+// NormalizeDomainName normalizes a domain name in presentation format, e.g. the
+// name of a DNS query, like NormalizeHostname. Escaped bytes are unescaped
+// first, so UTF-8 encoded labels are converted to punycode.
+func NormalizeDomainName(name string) string {
+	return NormalizeHostname(unescapeDomainName(name))
+}
+
+// unescapeDomainName replaces the `\DDD` and `\X` escape sequences of a domain
+// name in presentation format.
+func unescapeDomainName(name string) string {
+	if !strings.Contains(name, `\`) {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '\\' || i+1 >= len(name) {
+			b.WriteByte(c)
+			continue
+		}
+		if i+3 < len(name) && isDigit(name[i+1]) && isDigit(name[i+2]) && isDigit(name[i+3]) {
+			n := int(name[i+1]-'0')*100 + int(name[i+2]-'0')*10 + int(name[i+3]-'0')
+			if n <= 255 {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		// An escaped dot is part of a label, so it's kept escaped.
+		if name[i+1] == '.' {
+			b.WriteString(`\.`)
+		} else {
+			b.WriteByte(name[i+1])
+		}
+		i++
+	}
+
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type ListDNSLogEntriesParams struct {
+	HostIDs []ulid.ULID
+}
+
+func (srv *service) ListDNSLogEntries(ctx context.Context, params ListDNSLogEntriesParams) ([]DNSLogEntry, error) {
+	entries, err := srv.database.ListDNSLogEntries(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("hosts: failed to list DNS log entries: %w", err)
+	}
+
+	return entries, nil
+}
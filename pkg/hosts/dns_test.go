@@ -0,0 +1,60 @@
+package hosts
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/oklog/ulid"
+)
+
+func TestNormalizeDomainName(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  string
+	}{
+		{name: "abc.example.com.", exp: "abc.example.com"},
+		{name: "ABC.Example.COM.", exp: "abc.example.com"},
+		{name: "bücher.example.com.", exp: "xn--bcher-kva.example.com"},
+		{name: `b\195\188cher.example.com.`, exp: "xn--bcher-kva.example.com"},
+		{name: `B\195\156CHER.example.com.`, exp: "xn--bcher-kva.example.com"},
+		{name: `\097bc.example.com.`, exp: "abc.example.com"},
+		{name: `a\.b.example.com.`, exp: `a\.b.example.com`},
+		{name: "_acme-challenge.example.com.", exp: "_acme-challenge.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeDomainName(tt.name); got != tt.exp {
+				t.Errorf("expected %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestStoreDNSLogEntryUnicodeName(t *testing.T) {
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+	host := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "xn--bcher-kva.example.com"}
+	if err := db.StoreHosts(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+
+	// Names of DNS queries in presentation format have non-ASCII bytes
+	// escaped.
+	for _, name := range []string{`b\195\188cher.example.com.`, `www.B\195\156CHER.example.com.`} {
+		query := &dns.Msg{}
+		query.SetQuestion(name, dns.TypeA)
+		err := svc.StoreDNSLogEntry(context.Background(), StoreDNSLogEntryParams{Query: query})
+		if err != nil {
+			t.Errorf("expected host to be found for %q, got error: %v", name, err)
+		}
+	}
+
+	for _, entry := range db.dnsLogEntries {
+		if entry.HostID != host.ID {
+			t.Errorf("expected entry of host %v, got %v", host.ID, entry.HostID)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+)
+
+// Expired returns whether the host is expired at `now`.
+func (h Host) Expired(now time.Time) bool {
+	return !h.ExpiresAt.IsZero() && !now.Before(h.ExpiresAt)
+}
+
+// DeleteHost deletes a host and all of its interactions.
+func (srv *service) DeleteHost(ctx context.Context, hostID ulid.ULID) error {
+	err := srv.database.DeleteHost(ctx, hostID)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to delete host: %w", err)
+	}
+
+	srv.logger.Info("Deleted host.", zap.String("id", hostID.String()))
+
+	return nil
+}
+
+func (srv *service) DeleteExpiredHosts(ctx context.Context) ([]Host, error) {
+	hosts, err := srv.database.ListHosts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("hosts: failed to list hosts: %w", err)
+	}
+
+	now := time.Now()
+	var deleted []Host
+
+	for _, host := range hosts {
+		if !host.Expired(now) {
+			continue
+		}
+		if err := srv.database.DeleteHost(ctx, host.ID); err != nil {
+			return deleted, fmt.Errorf("hosts: failed to delete expired host: %w", err)
+		}
+
+		srv.logger.Info("Deleted expired host.",
+			zap.String("id", host.ID.String()),
+			zap.String("hostname", host.Hostname),
+			zap.Time("expiresAt", host.ExpiresAt),
+		)
+		deleted = append(deleted, host)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,145 @@
+package hosts
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+func TestHostExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		exp       bool
+	}{
+		{name: "no expiry", expiresAt: time.Time{}, exp: false},
+		{name: "expires later", expiresAt: now.Add(time.Second), exp: false},
+		{name: "expires now", expiresAt: now, exp: true},
+		{name: "expired", expiresAt: now.Add(-time.Second), exp: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Host{ExpiresAt: tt.expiresAt}).Expired(now); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestCreateHostsExpiresAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		hostTTL time.Duration
+		params  CreateHostsParams
+		expTTL  time.Duration
+	}{
+		{
+			name:   "no TTL",
+			params: CreateHostsParams{Amount: 1},
+		},
+		{
+			name:    "default TTL",
+			hostTTL: 24 * time.Hour,
+			params:  CreateHostsParams{Amount: 1},
+			expTTL:  24 * time.Hour,
+		},
+		{
+			name:    "TTL overriding default",
+			hostTTL: 24 * time.Hour,
+			params:  CreateHostsParams{Amount: 1, TTL: time.Hour},
+			expTTL:  time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(WithDatabase(newTestDatabase()), WithBaseHostname("example.com"), WithHostTTL(tt.hostTTL))
+
+			start := time.Now()
+			created, err := svc.CreateHosts(context.Background(), tt.params)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expiresAt := created[0].ExpiresAt
+
+			if tt.expTTL == 0 {
+				if !expiresAt.IsZero() {
+					t.Errorf("expected host not to expire, got %v", expiresAt)
+				}
+				return
+			}
+			if min, max := start.Add(tt.expTTL), time.Now().Add(tt.expTTL); expiresAt.Before(min) || expiresAt.After(max) {
+				t.Errorf("expected host to expire between %v and %v, got %v", min, max, expiresAt)
+			}
+		})
+	}
+}
+
+func TestExpiredHostNotFound(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+
+	host := Host{
+		ID:        ulid.MustNew(ulid.Now(), rand.Reader),
+		Hostname:  "abc.example.com",
+		ExpiresAt: time.Now().Add(-time.Second),
+	}
+	if err := db.StoreHosts(ctx, host); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired hosts aren't found, even before they're deleted.
+	if _, err := svc.FindHostByHostname(ctx, host.Hostname); !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected %v, got %v", ErrHostNotFound, err)
+	}
+
+	req := httptest.NewRequest("GET", "http://abc.example.com/", nil)
+	_, err := svc.StoreHTTPLogEntry(ctx, StoreHTTPLogEntryParams{Request: req, Response: &http.Response{}})
+	if !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected %v, got %v", ErrHostNotFound, err)
+	}
+	if entries := db.storedHTTPLogEntries(); len(entries) != 0 {
+		t.Errorf("expected no stored entries, got %v", len(entries))
+	}
+}
+
+func TestDeleteExpiredHosts(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+
+	now := time.Now()
+	expired := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "abc.example.com", ExpiresAt: now.Add(-time.Second)}
+	active := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "def.example.com", ExpiresAt: now.Add(time.Hour)}
+	permanent := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "ghi.example.com"}
+	if err := db.StoreHosts(ctx, expired, active, permanent); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := svc.DeleteExpiredHosts(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != expired.ID {
+		t.Errorf("expected expired host to be deleted, got %+v", deleted)
+	}
+
+	for _, host := range []Host{active, permanent} {
+		if _, err := db.FindHostByID(ctx, host.ID); err != nil {
+			t.Errorf("expected host %v to be kept, got %v", host.Hostname, err)
+		}
+	}
+	if _, err := db.FindHostByID(ctx, expired.ID); !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected expired host to be deleted, got %v", err)
+	}
+}
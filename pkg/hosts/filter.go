@@ -0,0 +1,76 @@
+package hosts
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+
+	"github.com/oklog/ulid"
+)
+
+// HeaderFilter matches requests with a header named Name, whose value
+// contains Value (case-insensitive).
+type HeaderFilter struct {
+	Name  string
+	Value string
+}
+
+// Match reports whether an HTTP log entry matches the filters of `params`. Host
+// IDs aren't checked. To keep walking large amounts of
+// entries cheap, only the request line and headers of the raw request are
+// parsed, and the query is matched against the raw bytes.
+func (params ListHTTPLogEntriesParams) Match(entry HTTPLogEntry) bool {
+	if params.After != (ulid.ULID{}) && entry.ID.Compare(params.After) <= 0 {
+		return false
+	}
+	if params.Label != "" && entry.Label != params.Label {
+		return false
+	}
+	if params.Query != "" && !bytes.Contains(entry.RawRequest, []byte(params.Query)) {
+		return false
+	}
+	if params.Method == "" && params.PathPrefix == "" && len(params.Headers) == 0 {
+		return true
+	}
+
+	head := entry.RawRequest
+	if i := bytes.Index(head, []byte("\r\n\r\n")); i >= 0 {
+		head = head[:i]
+	}
+	lines := strings.Split(string(head), "\r\n")
+
+	// Request line, e.g. `GET /foo?bar=baz HTTP/1.1`.
+	reqLine := strings.SplitN(lines[0], " ", 3)
+	if len(reqLine) < 2 {
+		return false
+	}
+	if params.Method != "" && !strings.EqualFold(reqLine[0], params.Method) {
+		return false
+	}
+	if params.PathPrefix != "" {
+		path := reqLine[1]
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		if !strings.HasPrefix(path, params.PathPrefix) {
+			return false
+		}
+	}
+
+Filters:
+	for _, filter := range params.Headers {
+		name := textproto.CanonicalMIMEHeaderKey(filter.Name)
+		for _, line := range lines[1:] {
+			i := strings.IndexByte(line, ':')
+			if i < 0 || textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(line[:i])) != name {
+				continue
+			}
+			if strings.Contains(strings.ToLower(line[i+1:]), strings.ToLower(filter.Value)) {
+				continue Filters
+			}
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,177 @@
+package hosts
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/oklog/ulid"
+)
+
+func TestListHTTPLogEntriesParamsMatch(t *testing.T) {
+	entry := HTTPLogEntry{
+		RawRequest: []byte("POST /api/callback?token=abc HTTP/1.1\r\n" +
+			"Host: abc.example.com\r\n" +
+			"User-Agent: curl/7.79.1\r\n" +
+			"X-Forwarded-For: 192.0.2.1\r\n" +
+			"\r\n" +
+			"secret=s3cr3t"),
+	}
+
+	tests := []struct {
+		name   string
+		params ListHTTPLogEntriesParams
+		exp    bool
+	}{
+		{
+			name:   "no filters",
+			params: ListHTTPLogEntriesParams{},
+			exp:    true,
+		},
+		{
+			name:   "query in body",
+			params: ListHTTPLogEntriesParams{Query: "s3cr3t"},
+			exp:    true,
+		},
+		{
+			name:   "query in request line",
+			params: ListHTTPLogEntriesParams{Query: "token=abc"},
+			exp:    true,
+		},
+		{
+			name:   "query is case-sensitive",
+			params: ListHTTPLogEntriesParams{Query: "S3CR3T"},
+			exp:    false,
+		},
+		{
+			name:   "query not found",
+			params: ListHTTPLogEntriesParams{Query: "foobar"},
+			exp:    false,
+		},
+		{
+			name:   "method",
+			params: ListHTTPLogEntriesParams{Method: "post"},
+			exp:    true,
+		},
+		{
+			name:   "other method",
+			params: ListHTTPLogEntriesParams{Method: "GET"},
+			exp:    false,
+		},
+		{
+			name:   "path prefix",
+			params: ListHTTPLogEntriesParams{PathPrefix: "/api/"},
+			exp:    true,
+		},
+		{
+			name:   "path prefix doesn't include query string",
+			params: ListHTTPLogEntriesParams{PathPrefix: "/api/callback?token"},
+			exp:    false,
+		},
+		{
+			name:   "other path prefix",
+			params: ListHTTPLogEntriesParams{PathPrefix: "/admin"},
+			exp:    false,
+		},
+		{
+			name:   "header",
+			params: ListHTTPLogEntriesParams{Headers: []HeaderFilter{{Name: "user-agent", Value: "CURL"}}},
+			exp:    true,
+		},
+		{
+			name: "multiple headers",
+			params: ListHTTPLogEntriesParams{Headers: []HeaderFilter{
+				{Name: "User-Agent", Value: "curl"},
+				{Name: "X-Forwarded-For", Value: "192.0.2."},
+			}},
+			exp: true,
+		},
+		{
+			name: "one of multiple headers not matching",
+			params: ListHTTPLogEntriesParams{Headers: []HeaderFilter{
+				{Name: "User-Agent", Value: "curl"},
+				{Name: "X-Forwarded-For", Value: "198.51.100.1"},
+			}},
+			exp: false,
+		},
+		{
+			name:   "missing header",
+			params: ListHTTPLogEntriesParams{Headers: []HeaderFilter{{Name: "Cookie", Value: ""}}},
+			exp:    false,
+		},
+		{
+			name:   "header value in body",
+			params: ListHTTPLogEntriesParams{Headers: []HeaderFilter{{Name: "Secret", Value: "s3cr3t"}}},
+			exp:    false,
+		},
+		{
+			name: "all filters",
+			params: ListHTTPLogEntriesParams{
+				Query:      "s3cr3t",
+				Method:     "POST",
+				PathPrefix: "/api",
+				Headers:    []HeaderFilter{{Name: "Host", Value: "example.com"}},
+			},
+			exp: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.params.Match(entry); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestListHTTPLogEntriesParamsMatchAfter(t *testing.T) {
+	now := ulid.Now()
+	before := HTTPLogEntry{ID: ulid.MustNew(now-1, rand.Reader)}
+	cursor := HTTPLogEntry{ID: ulid.MustNew(now, rand.Reader)}
+	after := HTTPLogEntry{ID: ulid.MustNew(now+1, rand.Reader)}
+
+	params := ListHTTPLogEntriesParams{After: cursor.ID}
+	for _, tt := range []struct {
+		name  string
+		entry HTTPLogEntry
+		exp   bool
+	}{
+		{name: "before cursor", entry: before, exp: false},
+		{name: "cursor", entry: cursor, exp: false},
+		{name: "after cursor", entry: after, exp: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := params.Match(tt.entry); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestListHTTPLogEntriesParamsMatchLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		params ListHTTPLogEntriesParams
+		exp    bool
+	}{
+		{name: "no filter", label: "prod-ssrf-test", exp: true},
+		{name: "no filter without label", label: "", exp: true},
+		{name: "label", label: "prod-ssrf-test", params: ListHTTPLogEntriesParams{Label: "prod-ssrf-test"}, exp: true},
+		{name: "other label", label: "staging", params: ListHTTPLogEntriesParams{Label: "prod-ssrf-test"}, exp: false},
+		{name: "without label", label: "", params: ListHTTPLogEntriesParams{Label: "prod-ssrf-test"}, exp: false},
+		{name: "label is case-sensitive", label: "Prod-SSRF-Test", params: ListHTTPLogEntriesParams{Label: "prod-ssrf-test"}, exp: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := HTTPLogEntry{
+				RawRequest: []byte("GET / HTTP/1.1\r\nHost: abc.example.com\r\n\r\n"),
+				Label:      tt.label,
+			}
+			if got := tt.params.Match(entry); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package hosts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	petname "github.com/dustinkirkland/golang-petname"
+	"golang.org/x/net/idna"
+)
+
+const (
+	// DefaultHostHashLength is the length in bytes of the random hash of
+	// generated hostnames, when not configured.
+	DefaultHostHashLength = 4
+	// MinHostHashLength is the minimum length in bytes of the random hash of
+	// generated hostnames, so they can't feasibly be guessed.
+	MinHostHashLength = 4
+	maxLabelLength    = 63
+	maxHostnameLength = 253
+)
+
+// hostnameEntropy is used for generating the random hash of hostnames, which
+// must be unpredictable, so hosts can't be enumerated.
+var hostnameEntropy io.Reader = rand.Reader
+
+// HostnamePattern configures how the subdomain label of a generated hostname
+// is composed, e.g. `proud-jaguar-8f1c2b3a` for the default pattern.
+type HostnamePattern struct {
+	// Words is the amount of petname words to use.
+	Words int
+	// Separator is placed between words, and before the hash.
+	Separator string
+	// Hash toggles appending a random hex encoded hash.
+	Hash bool
+	// HashLength is the amount of random bytes of the hash, which is twice
+	// as long when hex encoded. Defaults to DefaultHostHashLength if 0.
+	HashLength int
+}
+
+// DefaultHostnamePattern is used when no hostname pattern is configured.
+var DefaultHostnamePattern = HostnamePattern{
+	Words:     2,
+	Separator: "-",
+	Hash:      true,
+}
+
+// Validate validates the pattern for generating subdomain labels of
+// `baseHostname`, e.g. for checking configuration at startup rather than when
+// hosts are created.
+func (p HostnamePattern) Validate(baseHostname string) error {
+	if p.Words < 0 {
+		return errors.New("words cannot be negative")
+	}
+	if p.Words == 0 && !p.Hash {
+		return errors.New("at least one word or the hash is required")
+	}
+	for _, r := range p.Separator {
+		if !isLabelRune(r) {
+			return fmt.Errorf("separator %q contains characters not allowed in a DNS label", p.Separator)
+		}
+	}
+	if p.Hash {
+		hashLength := p.hashLength()
+		if hashLength < MinHostHashLength {
+			return fmt.Errorf("hash length must be at least %v bytes", MinHostHashLength)
+		}
+		// Petnames vary in length, so only the hash is checked upfront.
+		if 2*hashLength > maxLabelLength || 2*hashLength+1+len(baseHostname) > maxHostnameLength {
+			return fmt.Errorf("hash length of %v bytes exceeds DNS length limits", hashLength)
+		}
+	}
+	return nil
+}
+
+func (p HostnamePattern) hashLength() int {
+	if p.HashLength == 0 {
+		return DefaultHostHashLength
+	}
+	return p.HashLength
+}
+
+func (p HostnamePattern) generateLabel() (string, error) {
+	var parts []string
+
+	if p.Words > 0 {
+		parts = append(parts, petname.Generate(p.Words, p.Separator))
+	}
+
+	if p.Hash {
+		randBytes := make([]byte, p.hashLength())
+		_, err := io.ReadFull(hostnameEntropy, randBytes)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random bytes: %w", err)
+		}
+		parts = append(parts, hex.EncodeToString(randBytes))
+	}
+
+	return strings.Join(parts, p.Separator), nil
+}
+
+func isLabelRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-'
+}
+
+// NormalizeHostname returns the canonical form of a hostname, which is how
+// hostnames of hosts are stored: lowercase, without a trailing dot, and with
+// internationalized labels in their ASCII (punycode) form, e.g. `bücher.test`
+// becomes `xn--bcher-kva.test`. Hostnames that aren't valid internationalized
+// domain names (e.g. with underscores) are only lowercased.
+func NormalizeHostname(hostname string) string {
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	ascii, err := idna.Lookup.ToASCII(hostname)
+	if err != nil {
+		return strings.ToLower(hostname)
+	}
+
+	return ascii
+}
@@ -0,0 +1,315 @@
+package hosts
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setHostnameEntropy replaces the entropy of hostname hashes with `hashes`
+// until the test ends, so generated hostnames are predictable.
+func setHostnameEntropy(t *testing.T, hashes ...string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, hash := range hashes {
+		buf.WriteString(hash)
+	}
+
+	orig := hostnameEntropy
+	hostnameEntropy = &buf
+	t.Cleanup(func() { hostnameEntropy = orig })
+}
+
+func TestCreateHostsHostnameCollision(t *testing.T) {
+	pattern := HostnamePattern{Hash: true}
+
+	tests := []struct {
+		name     string
+		amount   int
+		taken    []string
+		hashes   []string
+		exp      []string
+		expError bool
+	}{
+		{
+			name:   "no collision",
+			amount: 1,
+			hashes: []string{"\x00\x00\x00\x01"},
+			exp:    []string{"00000001.example.com"},
+		},
+		{
+			name:   "collision with stored host",
+			amount: 1,
+			taken:  []string{"00000001.example.com"},
+			hashes: []string{"\x00\x00\x00\x01", "\x00\x00\x00\x02"},
+			exp:    []string{"00000002.example.com"},
+		},
+		{
+			name:   "collision within batch",
+			amount: 2,
+			hashes: []string{"\x00\x00\x00\x01", "\x00\x00\x00\x01", "\x00\x00\x00\x02"},
+			exp:    []string{"00000001.example.com", "00000002.example.com"},
+		},
+		{
+			name:     "no free hostname",
+			amount:   1,
+			taken:    []string{"00000001.example.com"},
+			hashes:   []string{strings.Repeat("\x00\x00\x00\x01", maxHostnameAttempts)},
+			expError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setHostnameEntropy(t, tt.hashes...)
+			db := newTestDatabase()
+			for _, hostname := range tt.taken {
+				db.takenHostnames[hostname] = true
+			}
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"), WithHostnamePattern(pattern))
+
+			created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: tt.amount})
+			if tt.expError {
+				if err == nil {
+					t.Fatalf("expected error, got hosts %+v", created)
+				}
+				if db.storeHostsCalls != 0 {
+					t.Errorf("expected no hosts to be stored, got %v calls", db.storeHostsCalls)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var hostnames []string
+			for _, host := range created {
+				hostnames = append(hostnames, host.Hostname)
+			}
+			if !reflect.DeepEqual(hostnames, tt.exp) {
+				t.Errorf("expected hostnames %v, got %v", tt.exp, hostnames)
+			}
+		})
+	}
+}
+
+func TestCreateHostsEntropyError(t *testing.T) {
+	// An exhausted entropy source fails creating hosts, instead of
+	// generating predictable hostnames.
+	setHostnameEntropy(t)
+	svc := NewService(WithDatabase(newTestDatabase()), WithBaseHostname("example.com"))
+
+	_, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 1})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestCreateHostsHashLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		hashLength int
+		expLength  int
+		expError   bool
+	}{
+		{name: "default", hashLength: 0, expLength: 2 * DefaultHostHashLength},
+		{name: "minimum", hashLength: MinHostHashLength, expLength: 2 * MinHostHashLength},
+		{name: "longer", hashLength: 8, expLength: 16},
+		{name: "longest", hashLength: 31, expLength: 62},
+		{name: "below minimum", hashLength: MinHostHashLength - 1, expError: true},
+		{name: "exceeding label length", hashLength: 32, expError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(
+				WithDatabase(db),
+				WithBaseHostname("example.com"),
+				WithHostnamePattern(HostnamePattern{Hash: true, HashLength: tt.hashLength}),
+			)
+
+			created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 1})
+			if tt.expError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				if len(db.hosts) != 0 {
+					t.Errorf("expected no stored hosts, got %v", len(db.hosts))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			hash := strings.TrimSuffix(created[0].Hostname, ".example.com")
+			if len(hash) != tt.expLength {
+				t.Errorf("expected hash of %v characters, got %q", tt.expLength, hash)
+			}
+			if _, err := hex.DecodeString(hash); err != nil {
+				t.Errorf("expected hex encoded hash, got %q", hash)
+			}
+		})
+	}
+}
+
+func TestHostnamePatternValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		pattern      HostnamePattern
+		baseHostname string
+		expError     bool
+	}{
+		{name: "default", pattern: DefaultHostnamePattern, baseHostname: "example.com"},
+		{name: "words only", pattern: HostnamePattern{Words: 3, Separator: "-"}, baseHostname: "example.com"},
+		{name: "hash only", pattern: HostnamePattern{Hash: true}, baseHostname: "example.com"},
+		{name: "empty separator", pattern: HostnamePattern{Words: 2, Hash: true}, baseHostname: "example.com"},
+		{name: "negative words", pattern: HostnamePattern{Words: -1, Hash: true}, baseHostname: "example.com", expError: true},
+		{name: "no words and no hash", pattern: HostnamePattern{Separator: "-"}, baseHostname: "example.com", expError: true},
+		{name: "separator with dot", pattern: HostnamePattern{Words: 2, Separator: ".", Hash: true}, baseHostname: "example.com", expError: true},
+		{name: "separator with underscore", pattern: HostnamePattern{Words: 2, Separator: "_"}, baseHostname: "example.com", expError: true},
+		{name: "uppercase separator", pattern: HostnamePattern{Words: 2, Separator: "X"}, baseHostname: "example.com", expError: true},
+		{name: "hash below minimum", pattern: HostnamePattern{Hash: true, HashLength: MinHostHashLength - 1}, baseHostname: "example.com", expError: true},
+		{name: "hash exceeding label length", pattern: HostnamePattern{Hash: true, HashLength: 32}, baseHostname: "example.com", expError: true},
+		{
+			name:         "hash exceeding hostname length",
+			pattern:      HostnamePattern{Hash: true, HashLength: 31},
+			baseHostname: strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63),
+			expError:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pattern.Validate(tt.baseHostname)
+			if tt.expError && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !tt.expError && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateHostsHostnamePattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  HostnamePattern
+		expParts int
+		expHash  bool
+	}{
+		{name: "default", pattern: DefaultHostnamePattern, expParts: 3, expHash: true},
+		{name: "one word", pattern: HostnamePattern{Words: 1, Separator: "-", Hash: true}, expParts: 2, expHash: true},
+		{name: "three words", pattern: HostnamePattern{Words: 3, Separator: "-", Hash: true}, expParts: 4, expHash: true},
+		{name: "words without hash", pattern: HostnamePattern{Words: 2, Separator: "-"}, expParts: 2},
+		{name: "multi character separator", pattern: HostnamePattern{Words: 3, Separator: "--", Hash: true}, expParts: 4, expHash: true},
+		{name: "digit separator", pattern: HostnamePattern{Words: 2, Separator: "0"}, expParts: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setHostnameEntropy(t, "abcd")
+
+			svc := NewService(
+				WithDatabase(newTestDatabase()),
+				WithBaseHostname("example.com"),
+				WithHostnamePattern(tt.pattern),
+			)
+
+			created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 1})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			label := strings.TrimSuffix(created[0].Hostname, ".example.com")
+			parts := strings.Split(label, tt.pattern.Separator)
+			if len(parts) != tt.expParts {
+				t.Fatalf("expected %v parts separated by %q, got %q", tt.expParts, tt.pattern.Separator, label)
+			}
+
+			last := parts[len(parts)-1]
+			expHash := hex.EncodeToString([]byte("abcd"))
+			if tt.expHash && last != expHash {
+				t.Errorf("expected hash %q, got %q", expHash, last)
+			}
+			if !tt.expHash && last == expHash {
+				t.Errorf("expected no hash, got %q", label)
+			}
+			for _, part := range parts {
+				if part == "" {
+					t.Errorf("expected no empty parts, got %q", label)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateHostsUnpredictable(t *testing.T) {
+	svc := NewService(
+		WithDatabase(newTestDatabase()),
+		WithBaseHostname("example.com"),
+		WithHostnamePattern(HostnamePattern{Hash: true}),
+	)
+
+	// Hosts created in one call share the same timestamp.
+	created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, b := created[0], created[1]
+
+	if a.ID.Time() != b.ID.Time() {
+		t.Fatalf("expected IDs with the same timestamp, got %v and %v", a.ID, b.ID)
+	}
+	if isSequential(a.ID.Entropy(), b.ID.Entropy()) {
+		t.Errorf("expected non-sequential IDs, got %v and %v", a.ID, b.ID)
+	}
+
+	hashA, err := hex.DecodeString(strings.TrimSuffix(a.Hostname, ".example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := hex.DecodeString(strings.TrimSuffix(b.Hostname, ".example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isSequential(hashA, hashB) {
+		t.Errorf("expected non-sequential hashes, got %v and %v", a.Hostname, b.Hostname)
+	}
+}
+
+// isSequential reports whether `b` is equal to `a` or directly follows it,
+// when read as big-endian integers.
+func isSequential(a, b []byte) bool {
+	diff := new(big.Int).Sub(new(big.Int).SetBytes(b), new(big.Int).SetBytes(a))
+	return diff.Sign() == 0 || diff.Cmp(big.NewInt(1)) == 0
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		hostname string
+		exp      string
+	}{
+		{hostname: "abc.example.com", exp: "abc.example.com"},
+		{hostname: "ABC.example.com.", exp: "abc.example.com"},
+		{hostname: "bücher.example.com", exp: "xn--bcher-kva.example.com"},
+		{hostname: "BÜCHER.example.com", exp: "xn--bcher-kva.example.com"},
+		{hostname: "xn--bcher-kva.example.com", exp: "xn--bcher-kva.example.com"},
+		{hostname: "_Acme-Challenge.example.com", exp: "_acme-challenge.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			if got := NormalizeHostname(tt.hostname); got != tt.exp {
+				t.Errorf("expected %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
@@ -1,26 +1,52 @@
 package hosts
 
 import (
+	"bytes"
 	"context"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"strconv"
 	"time"
 
-	petname "github.com/dustinkirkland/golang-petname"
 	"github.com/oklog/ulid"
 	"go.uber.org/zap"
 )
 
-const hostHashLength = 4
+const maxHostnameAttempts = 10
 
-var ErrHostNotFound = errors.New("host not found")
+var (
+	ErrHostNotFound         = errors.New("host not found")
+	ErrHTTPLogEntryNotFound = errors.New("HTTP log entry not found")
+	// ErrTooManyWrites is returned when the maximum amount of concurrent
+	// writes (see WithMaxConcurrentWrites) is reached.
+	ErrTooManyWrites = errors.New("too many concurrent writes")
+	// ErrMaxHostsReached is returned when creating hosts would exceed the
+	// maximum amount of hosts (see WithMaxHosts).
+	ErrMaxHostsReached = errors.New("maximum amount of hosts reached")
+)
 
 type Host struct {
 	ID       ulid.ULID
 	Hostname string
+	// InteractionCount is the amount of HTTP requests and DNS queries received
+	// for the host. It's maintained by the database.
+	InteractionCount int
+	// ResponseDelay is how long responses to captured HTTP requests are
+	// delayed, e.g. for timing-based tests.
+	ResponseDelay time.Duration
+	// ExpiresAt is the time after which the host is deleted, along with its
+	// interactions. Hosts without it never expire.
+	ExpiresAt time.Time
+	// Label tags the host for triaging, e.g. "prod-ssrf-test". It's copied
+	// onto HTTP log entries when they're captured.
+	Label string
+	// ACMEDNS marks hosts registered for answering ACME DNS-01 challenges
+	// (e.g. via an acme-dns compatible API). They never expire.
+	ACMEDNS bool
 }
 
 type HTTPLogEntry struct {
@@ -30,30 +56,155 @@ type HTTPLogEntry struct {
 	Response    *http.Response
 	RawRequest  []byte
 	RawResponse []byte
+	// RemoteAddr is the address of the client, which is resolved from proxy
+	// headers when the request was forwarded by a trusted proxy.
+	RemoteAddr    string
+	ACMEChallenge bool
+	// ClientCertificates holds the DER encoded certificates presented by the
+	// client during the TLS handshake, leaf first.
+	ClientCertificates [][]byte
+	// ServerName is the server name indication (SNI) of the TLS handshake,
+	// which can differ from the `Host` header, e.g. for domain fronting.
+	ServerName string
+	// RawWire is the request as it was read from the connection, if recorded.
+	// Unlike RawRequest, it has the original framing, e.g. of chunked bodies.
+	RawWire []byte
+	// RepeatCount is the amount of identical requests received after this
+	// one, within the deduplication window.
+	RepeatCount int
+	// BodyDropped is set when the request and response bodies were dropped
+	// from the raw request and response, because of the body retention.
+	BodyDropped bool
+	// ResponseBodyTruncated is set when the raw response holds only the first
+	// part of the response body, e.g. of a large upstream response.
+	ResponseBodyTruncated bool
+	// Label is the label of the host at the time the request was captured.
+	Label string
+}
+
+// CreateHostsParams holds the parameters for creating hosts.
+type CreateHostsParams struct {
+	Amount int
+	// TTL is the time after which the hosts expire. Defaults to the host TTL
+	// of the service, if set.
+	TTL time.Duration
+	// Label is set on all created hosts.
+	Label string
+	// ACMEDNS marks the hosts as registered for answering ACME DNS-01
+	// challenges, see Host. The TTL is ignored.
+	ACMEDNS bool
 }
 
-func (srv *service) CreateHosts(ctx context.Context, amount int) ([]Host, error) {
+func (srv *service) CreateHosts(ctx context.Context, params CreateHostsParams) ([]Host, error) {
+	amount := params.Amount
+
+	if srv.maxHosts > 0 {
+		srv.createMu.Lock()
+		defer srv.createMu.Unlock()
+
+		count, err := srv.database.CountHosts(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("hosts: failed to count hosts: %w", err)
+		}
+		if count+amount > srv.maxHosts {
+			return nil, fmt.Errorf("hosts: failed to create %v host(s), %v of %v exist: %w",
+				amount, count, srv.maxHosts, ErrMaxHostsReached)
+		}
+	}
+
 	hosts := make([]Host, amount)
+	hostnames := make(map[string]struct{}, amount)
+
+	now := time.Now()
+	var expiresAt time.Time
+	ttl := params.TTL
+	if ttl == 0 {
+		ttl = srv.hostTTL
+	}
+	if ttl > 0 && !params.ACMEDNS {
+		expiresAt = now.Add(ttl).UTC()
+	}
 
 	for i := 0; i < amount; i++ {
-		randBytes := make([]byte, hostHashLength)
-		_, err := rand.Read(randBytes)
+		hostname, err := srv.generateHostname(ctx, hostnames)
 		if err != nil {
-			return nil, fmt.Errorf("hosts: failed to generate random bytes: %w", err)
+			return nil, err
 		}
+		hostnames[hostname] = struct{}{}
 
 		hosts[i] = Host{
-			ID:       ulid.MustNew(ulid.Timestamp(time.Now()), ulidEntropy),
-			Hostname: fmt.Sprintf("%v-%v.%v", petname.Generate(2, "-"), hex.EncodeToString(randBytes), srv.baseHostname),
+			ID:        ulid.MustNew(ulid.Timestamp(now), ulidEntropy),
+			Hostname:  hostname,
+			ExpiresAt: expiresAt,
+			Label:     params.Label,
+			ACMEDNS:   params.ACMEDNS,
 		}
+	}
+
+	err := srv.database.StoreHosts(ctx, hosts...)
+	if err != nil {
+		return nil, fmt.Errorf("hosts: failed to store hosts: %w", err)
+	}
 
-		err = srv.database.StoreHosts(ctx, hosts...)
+	for _, host := range hosts {
+		srv.logger.Info("Created host.",
+			zap.String("id", host.ID.String()),
+			zap.String("hostname", host.Hostname),
+		)
+	}
+
+	return hosts, nil
+}
+
+// generateHostname returns a random hostname that isn't in use yet, either by
+// a stored host or by one of the `pending` hostnames. Because a collision would
+// overwrite the hostname index of an existing host, a new hostname is generated
+// on collision, up to `maxHostnameAttempts` times.
+func (srv *service) generateHostname(ctx context.Context, pending map[string]struct{}) (string, error) {
+	if err := srv.hostnamePattern.Validate(srv.baseHostname); err != nil {
+		return "", fmt.Errorf("hosts: invalid hostname pattern: %w", err)
+	}
+
+	tooLong := 0
+	for attempt := 0; attempt < maxHostnameAttempts; attempt++ {
+		label, err := srv.hostnamePattern.generateLabel()
+		if err != nil {
+			return "", fmt.Errorf("hosts: failed to generate label: %w", err)
+		}
+		// Long petnames can exceed DNS length limits, in which case we try
+		// again.
+		if len(label) > maxLabelLength {
+			tooLong++
+			continue
+		}
+
+		hostname := label + "." + srv.baseHostname
+		if len(hostname) > maxHostnameLength {
+			tooLong++
+			continue
+		}
+
+		if _, ok := pending[hostname]; ok {
+			continue
+		}
+
+		// Expired hosts that weren't deleted yet still hold their hostname.
+		_, err = srv.database.FindHostByHostname(ctx, hostname)
+		if errors.Is(err, ErrHostNotFound) {
+			return hostname, nil
+		}
 		if err != nil {
-			return nil, fmt.Errorf("hosts: failed to store hosts: %w", err)
+			return "", fmt.Errorf("hosts: failed to find host by hostname %q: %w", hostname, err)
 		}
+
+		srv.logger.Debug("Generated hostname is already in use, retrying.", zap.String("hostname", hostname))
 	}
 
-	return hosts, nil
+	if tooLong == maxHostnameAttempts {
+		return "", errors.New("hosts: generated hostnames exceed DNS length limits, use fewer words or a shorter hash")
+	}
+
+	return "", fmt.Errorf("hosts: failed to generate unused hostname after %v attempts", maxHostnameAttempts)
 }
 
 func (srv *service) FindHostByID(ctx context.Context, hostID ulid.ULID) (Host, error) {
@@ -65,32 +216,196 @@ func (srv *service) FindHostByID(ctx context.Context, hostID ulid.ULID) (Host, e
 	return host, nil
 }
 
+func (srv *service) ListHosts(ctx context.Context) ([]Host, error) {
+	hosts, err := srv.database.ListHosts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("hosts: failed to list hosts: %w", err)
+	}
+
+	return hosts, nil
+}
+
+func (srv *service) FindHostByHostname(ctx context.Context, hostname string) (Host, error) {
+	host, err := srv.findHostByHostname(ctx, hostname)
+	if err != nil {
+		return Host{}, fmt.Errorf("hosts: failed to find host by hostname: %w", err)
+	}
+
+	return host, nil
+}
+
+// UpdateHostResponseParams holds the response settings of a host to update.
+// Nil fields are left unchanged.
+type UpdateHostResponseParams struct {
+	Delay *time.Duration
+}
+
+func (srv *service) UpdateHostResponse(ctx context.Context, hostID ulid.ULID, params UpdateHostResponseParams) (Host, error) {
+	host, err := srv.database.FindHostByID(ctx, hostID)
+	if err != nil {
+		return Host{}, fmt.Errorf("hosts: failed to find host by ID: %w", err)
+	}
+
+	if params.Delay != nil {
+		host.ResponseDelay = *params.Delay
+	}
+
+	err = srv.database.UpdateHost(ctx, host)
+	if err != nil {
+		return Host{}, fmt.Errorf("hosts: failed to update host: %w", err)
+	}
+
+	return host, nil
+}
+
+// UpdateHostParams holds the settings of a host to update. Nil fields are left
+// unchanged.
+type UpdateHostParams struct {
+	// Label replaces the label of the host. An empty label removes it. Already
+	// captured HTTP log entries keep their label.
+	Label *string
+}
+
+func (srv *service) UpdateHost(ctx context.Context, hostID ulid.ULID, params UpdateHostParams) (Host, error) {
+	host, err := srv.database.FindHostByID(ctx, hostID)
+	if err != nil {
+		return Host{}, fmt.Errorf("hosts: failed to find host by ID: %w", err)
+	}
+
+	if params.Label != nil {
+		host.Label = *params.Label
+	}
+
+	err = srv.database.UpdateHost(ctx, host)
+	if err != nil {
+		return Host{}, fmt.Errorf("hosts: failed to update host: %w", err)
+	}
+
+	return host, nil
+}
+
 type StoreHTTPLogEntryParams struct {
 	Request  *http.Request
 	Response *http.Response
+	// RequestBody is the buffered body of `Request`. If nil, the body is read
+	// from `Request`.
+	RequestBody []byte
+	// RemoteAddr is the address of the client. Defaults to the `RemoteAddr`
+	// of the request.
+	RemoteAddr string
+	// ACMEChallenge marks requests for the ACME HTTP-01 challenge path, both
+	// those solved by the ACME manager and those that weren't.
+	ACMEChallenge bool
+	// RawWire is the request as it was read from the connection, if recorded.
+	RawWire []byte
+	// ResponseBodyTruncated marks responses of which the body was truncated
+	// before storing.
+	ResponseBodyTruncated bool
 }
 
-func (srv *service) StoreHTTPLogEntry(ctx context.Context, params StoreHTTPLogEntryParams) error {
-	hostname := params.Request.Host
-	host, err := srv.findHostByHostname(ctx, hostname)
+func (srv *service) StoreHTTPLogEntry(ctx context.Context, params StoreHTTPLogEntryParams) (ulid.ULID, error) {
+	release, ok := srv.acquireWrite()
+	if !ok {
+		return ulid.ULID{}, fmt.Errorf("hosts: failed to store HTTP log entry: %w", ErrTooManyWrites)
+	}
+	defer release()
+
+	host, err := srv.findHostByHostname(ctx, params.Request.Host)
 	if err != nil {
-		return fmt.Errorf("hosts: failed to find host by hostname %q: %w", hostname, err)
+		return ulid.ULID{}, fmt.Errorf("hosts: failed to find host by hostname %q: %w", params.Request.Host, err)
 	}
 
 	now := time.Now().UTC()
+
+	remoteAddr := params.RemoteAddr
+	if remoteAddr == "" {
+		remoteAddr = params.Request.RemoteAddr
+	}
+
+	body := params.RequestBody
+	if body == nil && params.Request.Body != nil {
+		body, err = ioutil.ReadAll(params.Request.Body)
+		if err != nil {
+			// Store what we have, rather than losing the interaction entirely.
+			srv.logger.Warn("Failed to read HTTP request body, storing partial body.", zap.Error(err))
+		}
+	}
+
+	var dedupKey string
+	if srv.dedup != nil {
+		dedupKey = httpDedupKey(host.ID, remoteAddr, params.Request, body)
+
+		if entryID, ok := srv.dedup.lookup(dedupKey, now); ok {
+			err = srv.database.IncrementHTTPLogEntryRepeatCount(ctx, entryID)
+			if err != nil {
+				return ulid.ULID{}, fmt.Errorf("hosts: failed to increment repeat count of HTTP log entry: %w", err)
+			}
+
+			srv.logger.Debug("Counted repeated HTTP request.",
+				zap.String("id", entryID.String()),
+				zap.String("hostId", host.ID.String()),
+			)
+
+			return entryID, nil
+		}
+	}
+
+	rawReq, err := dumpRequest(params.Request, body)
+	if err != nil {
+		srv.logger.Warn("Failed to dump HTTP request with body, storing headers only.", zap.Error(err))
+		rawReq, err = httputil.DumpRequest(params.Request, false)
+		if err != nil {
+			return ulid.ULID{}, fmt.Errorf("hosts: failed to dump HTTP request: %w", err)
+		}
+	}
+
+	rawRes, err := httputil.DumpResponse(params.Response, true)
+	if err != nil {
+		srv.logger.Warn("Failed to dump HTTP response with body, storing headers only.", zap.Error(err))
+		rawRes, err = httputil.DumpResponse(params.Response, false)
+		if err != nil {
+			return ulid.ULID{}, fmt.Errorf("hosts: failed to dump HTTP response: %w", err)
+		}
+	}
+
+	var clientCerts [][]byte
+	var serverName string
+	if params.Request.TLS != nil {
+		for _, cert := range params.Request.TLS.PeerCertificates {
+			clientCerts = append(clientCerts, cert.Raw)
+		}
+		serverName = params.Request.TLS.ServerName
+	}
+
 	id := ulid.MustNew(ulid.Timestamp(now), ulidEntropy)
 
 	entry := HTTPLogEntry{
-		ID:       id,
-		HostID:   host.ID,
-		Request:  params.Request,
-		Response: params.Response,
+		ID:                    id,
+		HostID:                host.ID,
+		Request:               params.Request,
+		Response:              params.Response,
+		RawRequest:            rawReq,
+		RawResponse:           rawRes,
+		RemoteAddr:            remoteAddr,
+		ACMEChallenge:         params.ACMEChallenge,
+		ClientCertificates:    clientCerts,
+		ServerName:            serverName,
+		RawWire:               params.RawWire,
+		ResponseBodyTruncated: params.ResponseBodyTruncated,
+		Label:                 host.Label,
 	}
 
 	err = srv.database.StoreHTTPLogEntry(ctx, entry)
 	if err != nil {
-		return fmt.Errorf("hosts: failed to store HTTP log entry: %w", err)
+		return ulid.ULID{}, fmt.Errorf("hosts: failed to store HTTP log entry: %w", err)
+	}
+
+	if srv.dedup != nil {
+		srv.dedup.add(dedupKey, entry.ID, now)
 	}
+	srv.httpLogNotifier.notify()
+
+	srv.dropHTTPLogEntryBodies(ctx, host.ID)
 
 	srv.logger.Info("Stored HTTP log entry.",
 		zap.String("id", entry.ID.String()),
@@ -98,22 +413,65 @@ func (srv *service) StoreHTTPLogEntry(ctx context.Context, params StoreHTTPLogEn
 		zap.String("host", params.Request.Host),
 		zap.String("url", params.Request.URL.String()),
 		zap.String("method", params.Request.Method),
+		zap.String("remoteAddr", entry.RemoteAddr),
+		zap.Bool("acmeChallenge", params.ACMEChallenge),
+		zap.Int("clientCertificates", len(clientCerts)),
 	)
 
-	return nil
+	return entry.ID, nil
 }
 
+// dumpRequest returns the HTTP/1.x wire representation of a request, using
+// the buffered `body`, so the request itself isn't consumed. Unless a transfer
+// encoding is used, the `Content-Length` header is set to the length of the
+// buffered body, so the dump can always be parsed. This covers HTTP/2 requests
+// (where the body is framed by the protocol) and partially read bodies.
+func dumpRequest(req *http.Request, body []byte) ([]byte, error) {
+	clone := req.Clone(req.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+
+	if len(clone.TransferEncoding) == 0 && (len(body) > 0 || clone.Header.Get("Content-Length") != "") {
+		clone.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return httputil.DumpRequest(clone, true)
+}
+
+// findHostByHostname finds the host for a hostname. Expired hosts aren't
+// found, even before they're deleted. The hostname may be the `Host` header of
+// a request, which can include a port (for CONNECT requests, it's the request
+// target, which always does).
 func (srv *service) findHostByHostname(ctx context.Context, hostname string) (Host, error) {
-	host, err := srv.database.FindHostByHostname(ctx, hostname)
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+
+	host, err := srv.database.FindHostByHostname(ctx, NormalizeHostname(hostname))
 	if err != nil {
 		return Host{}, err
 	}
+	if host.Expired(time.Now()) {
+		return Host{}, ErrHostNotFound
+	}
 
 	return host, nil
 }
 
 type ListHTTPLogEntriesParams struct {
 	HostIDs []ulid.ULID
+	// Query filters on requests that contain it, either in the request line,
+	// headers or body.
+	Query      string
+	Method     string
+	PathPrefix string
+	// Headers filters on requests that match all header filters.
+	Headers []HeaderFilter
+	// After is a cursor, for listing only entries with a greater ID, i.e. that
+	// were received later. Ignored if zero.
+	After ulid.ULID
+	// Label filters on entries captured while their host had the label.
+	Label string
 }
 
 func (srv *service) ListHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams) ([]HTTPLogEntry, error) {
@@ -124,3 +482,23 @@ func (srv *service) ListHTTPLogEntries(ctx context.Context, params ListHTTPLogEn
 
 	return hosts, nil
 }
+
+func (srv *service) FindHTTPLogEntryByID(ctx context.Context, id ulid.ULID) (HTTPLogEntry, error) {
+	entry, err := srv.database.FindHTTPLogEntryByID(ctx, id)
+	if err != nil {
+		return HTTPLogEntry{}, fmt.Errorf("hosts: failed to find HTTP log entry by ID: %w", err)
+	}
+
+	return entry, nil
+}
+
+// WalkHTTPLogEntries calls `fn` for each HTTP log entry matching `params`,
+// without loading all entries in memory first.
+func (srv *service) WalkHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams, fn func(HTTPLogEntry) error) error {
+	err := srv.database.WalkHTTPLogEntries(ctx, params, fn)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to walk HTTP log entries: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,618 @@
+package hosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testDatabase is an in-memory Database. Methods that aren't implemented
+// panic, via the nil embedded interface.
+type testDatabase struct {
+	Database
+
+	mu             sync.Mutex
+	hosts          map[ulid.ULID]Host
+	httpLogEntries []HTTPLogEntry
+	tlsLogEntries  []TLSLogEntry
+	dnsLogEntries  []DNSLogEntry
+	// storeHostsCalls counts calls of StoreHosts.
+	storeHostsCalls int
+	// takenHostnames are reported as in use by FindHostByHostname, without
+	// being stored.
+	takenHostnames map[string]bool
+}
+
+func newTestDatabase() *testDatabase {
+	return &testDatabase{
+		hosts:          make(map[ulid.ULID]Host),
+		takenHostnames: make(map[string]bool),
+	}
+}
+
+func (db *testDatabase) StoreHosts(_ context.Context, hosts ...Host) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.storeHostsCalls++
+	for _, host := range hosts {
+		db.hosts[host.ID] = host
+	}
+
+	return nil
+}
+
+func (db *testDatabase) UpdateHost(_ context.Context, host Host) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hosts[host.ID]; !ok {
+		return ErrHostNotFound
+	}
+	db.hosts[host.ID] = host
+
+	return nil
+}
+
+func (db *testDatabase) FindHostByID(_ context.Context, hostID ulid.ULID) (Host, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	host, ok := db.hosts[hostID]
+	if !ok {
+		return Host{}, ErrHostNotFound
+	}
+
+	return host, nil
+}
+
+func (db *testDatabase) FindHostByHostname(_ context.Context, hostname string) (Host, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.takenHostnames[hostname] {
+		return Host{Hostname: hostname}, nil
+	}
+	for _, host := range db.hosts {
+		if host.Hostname == hostname {
+			return host, nil
+		}
+	}
+
+	return Host{}, ErrHostNotFound
+}
+
+func (db *testDatabase) ListHosts(_ context.Context) ([]Host, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	hosts := make([]Host, 0, len(db.hosts))
+	for _, host := range db.hosts {
+		hosts = append(hosts, host)
+	}
+
+	return hosts, nil
+}
+
+func (db *testDatabase) DeleteHost(_ context.Context, hostID ulid.ULID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hosts[hostID]; !ok {
+		return ErrHostNotFound
+	}
+	delete(db.hosts, hostID)
+
+	return nil
+}
+
+func (db *testDatabase) CountHosts(_ context.Context) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.hosts), nil
+}
+
+func (db *testDatabase) StoreHTTPLogEntry(_ context.Context, entry HTTPLogEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.httpLogEntries = append(db.httpLogEntries, entry)
+
+	return nil
+}
+
+func (db *testDatabase) IncrementHTTPLogEntryRepeatCount(_ context.Context, id ulid.ULID) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for i := range db.httpLogEntries {
+		if db.httpLogEntries[i].ID == id {
+			db.httpLogEntries[i].RepeatCount++
+			return nil
+		}
+	}
+
+	return ErrHTTPLogEntryNotFound
+}
+
+func (db *testDatabase) FindHTTPLogEntryByID(_ context.Context, id ulid.ULID) (HTTPLogEntry, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, entry := range db.httpLogEntries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+
+	return HTTPLogEntry{}, ErrHTTPLogEntryNotFound
+}
+
+func (db *testDatabase) DropHTTPLogEntryBodies(_ context.Context, _ ulid.ULID, _ BodyRetention) error {
+	return nil
+}
+
+func (db *testDatabase) StoreTLSLogEntry(_ context.Context, entry TLSLogEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.tlsLogEntries = append(db.tlsLogEntries, entry)
+
+	return nil
+}
+
+func (db *testDatabase) StoreDNSLogEntry(_ context.Context, entry DNSLogEntry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.dnsLogEntries = append(db.dnsLogEntries, entry)
+
+	return nil
+}
+
+func (db *testDatabase) storedHTTPLogEntries() []HTTPLogEntry {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return append([]HTTPLogEntry(nil), db.httpLogEntries...)
+}
+
+// newTestHost creates a host with the service, and fails the test on error.
+func newTestHost(t *testing.T, svc Service) Host {
+	t.Helper()
+
+	created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 1})
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+
+	return created[0]
+}
+
+func TestCreateHostsStoresOnce(t *testing.T) {
+	for _, amount := range []int{1, 2, 50} {
+		db := newTestDatabase()
+		svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+
+		created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: amount})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(created) != amount {
+			t.Errorf("expected %v hosts, got %v", amount, len(created))
+		}
+		if db.storeHostsCalls != 1 {
+			t.Errorf("expected StoreHosts to be called once for %v hosts, got %v calls", amount, db.storeHostsCalls)
+		}
+		if len(db.hosts) != amount {
+			t.Errorf("expected %v stored hosts, got %v", amount, len(db.hosts))
+		}
+	}
+}
+
+func TestStoreHTTPLogEntryPartialBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		newBody func() io.Reader
+		expBody string
+	}{
+		{
+			name: "partially consumed body",
+			newBody: func() io.Reader {
+				body := strings.NewReader("foobar")
+				io.CopyN(ioutil.Discard, body, 3)
+				return body
+			},
+			expBody: "bar",
+		},
+		{
+			name: "body failing halfway",
+			newBody: func() io.Reader {
+				return io.MultiReader(strings.NewReader("foo"), iotest.ErrReader(io.ErrUnexpectedEOF))
+			},
+			expBody: "foo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+			host := newTestHost(t, svc)
+
+			req := httptest.NewRequest("POST", "http://"+host.Hostname+"/", tt.newBody())
+			req.ContentLength = 6
+			_, err := svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+				Request:  req,
+				Response: &http.Response{},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entries := db.storedHTTPLogEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+
+			// The raw request holds the body that could be read, and can be
+			// parsed.
+			stored, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(entries[0].RawRequest)))
+			if err != nil {
+				t.Fatalf("failed to parse raw request: %v", err)
+			}
+			body, err := ioutil.ReadAll(stored.Body)
+			if err != nil {
+				t.Fatalf("failed to read body of raw request: %v", err)
+			}
+			if string(body) != tt.expBody {
+				t.Errorf("expected body %q, got %q", tt.expBody, body)
+			}
+		})
+	}
+}
+
+func TestCreateHostsMaxHosts(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxHosts int
+		existing int
+		amount   int
+		expErr   error
+	}{
+		{
+			name:     "unlimited",
+			maxHosts: 0,
+			existing: 10,
+			amount:   50,
+		},
+		{
+			name:     "below max",
+			maxHosts: 3,
+			existing: 1,
+			amount:   1,
+		},
+		{
+			name:     "reaching max",
+			maxHosts: 3,
+			existing: 1,
+			amount:   2,
+		},
+		{
+			name:     "exceeding max",
+			maxHosts: 3,
+			existing: 1,
+			amount:   3,
+			expErr:   ErrMaxHostsReached,
+		},
+		{
+			name:     "max reached",
+			maxHosts: 3,
+			existing: 3,
+			amount:   1,
+			expErr:   ErrMaxHostsReached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"), WithMaxHosts(tt.maxHosts))
+			if tt.existing > 0 {
+				_, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: tt.existing})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: tt.amount})
+			if !errors.Is(err, tt.expErr) {
+				t.Fatalf("expected error %v, got %v", tt.expErr, err)
+			}
+			if err != nil {
+				if n := len(db.hosts); n != tt.existing {
+					t.Errorf("expected %v stored hosts, got %v", tt.existing, n)
+				}
+				return
+			}
+			if len(created) != tt.amount {
+				t.Errorf("expected %v hosts, got %v", tt.amount, len(created))
+			}
+		})
+	}
+}
+
+func TestStoreHTTPLogEntryUnicodeHost(t *testing.T) {
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+	host := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "xn--bcher-kva.example.com"}
+	if err := db.StoreHosts(context.Background(), host); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hostHeader := range []string{"bücher.example.com", "BÜCHER.example.com:8080", "xn--bcher-kva.example.com."} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Host = hostHeader
+		_, err := svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+			Request:  req,
+			Response: &http.Response{},
+		})
+		if err != nil {
+			t.Errorf("expected host to be found for %q, got error: %v", hostHeader, err)
+		}
+	}
+
+	for _, entry := range db.storedHTTPLogEntries() {
+		if entry.HostID != host.ID {
+			t.Errorf("expected entry of host %v, got %v", host.ID, entry.HostID)
+		}
+	}
+}
+
+func TestStoreHTTPLogEntryMethods(t *testing.T) {
+	// The hostname of the host is substituted for `{host}`.
+	tests := []struct {
+		name      string
+		raw       string
+		expPrefix string
+	}{
+		{
+			name:      "CONNECT",
+			raw:       "CONNECT {host}:443 HTTP/1.1\r\nHost: {host}:443\r\n\r\n",
+			expPrefix: "CONNECT {host}:443 HTTP/1.1\r\n",
+		},
+		{
+			name:      "TRACE",
+			raw:       "TRACE /foo HTTP/1.1\r\nHost: {host}\r\n\r\n",
+			expPrefix: "TRACE /foo HTTP/1.1\r\n",
+		},
+		{
+			name:      "custom method",
+			raw:       "FOOBAR /foo HTTP/1.1\r\nHost: {host}\r\n\r\n",
+			expPrefix: "FOOBAR /foo HTTP/1.1\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+			host := newTestHost(t, svc)
+
+			raw := strings.ReplaceAll(tt.raw, "{host}", host.Hostname)
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+				Request:  req,
+				Response: &http.Response{},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entries := db.storedHTTPLogEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			if entries[0].HostID != host.ID {
+				t.Errorf("expected entry of host %v, got %v", host.ID, entries[0].HostID)
+			}
+			expPrefix := strings.ReplaceAll(tt.expPrefix, "{host}", host.Hostname)
+			if !strings.HasPrefix(string(entries[0].RawRequest), expPrefix) {
+				t.Errorf("expected raw request to start with %q, got %q", expPrefix, entries[0].RawRequest)
+			}
+		})
+	}
+}
+
+func TestCreateHostsLogsHosts(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	svc := NewService(
+		WithDatabase(newTestDatabase()),
+		WithBaseHostname("example.com"),
+		WithLogger(zap.New(core)),
+	)
+
+	created, err := svc.CreateHosts(context.Background(), CreateHostsParams{Amount: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := logs.FilterMessage("Created host.").All()
+	if len(entries) != len(created) {
+		t.Fatalf("expected %v log entries, got %v", len(created), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Level != zap.InfoLevel {
+			t.Errorf("expected level %v, got %v", zap.InfoLevel, entry.Level)
+		}
+		fields := entry.ContextMap()
+		if fields["id"] != created[i].ID.String() {
+			t.Errorf("expected id %v, got %v", created[i].ID, fields["id"])
+		}
+		if fields["hostname"] != created[i].Hostname {
+			t.Errorf("expected hostname %v, got %v", created[i].Hostname, fields["hostname"])
+		}
+	}
+}
+
+func TestStoreHTTPLogEntryHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		expFound bool
+	}{
+		{name: "exact", host: "abc.example.com", expFound: true},
+		{name: "with port", host: "abc.example.com:8080", expFound: true},
+		{name: "uppercase", host: "ABC.Example.COM", expFound: true},
+		{name: "uppercase with port", host: "ABC.EXAMPLE.COM:443", expFound: true},
+		{name: "trailing dot", host: "abc.example.com.", expFound: true},
+		{name: "empty", host: ""},
+		{name: "other host", host: "def.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+			host := Host{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "abc.example.com"}
+			if err := db.StoreHosts(context.Background(), host); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.Host = tt.host
+			_, err := svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+				Request:  req,
+				Response: &http.Response{},
+			})
+			if !tt.expFound {
+				if !errors.Is(err, ErrHostNotFound) {
+					t.Errorf("expected error %v, got %v", ErrHostNotFound, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entries := db.storedHTTPLogEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			if entries[0].HostID != host.ID {
+				t.Errorf("expected entry of host %v, got %v", host.ID, entries[0].HostID)
+			}
+		})
+	}
+}
+
+func TestHostLabelCopiedOntoHTTPLogEntries(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+
+	created, err := svc.CreateHosts(ctx, CreateHostsParams{Amount: 1, Label: "prod-ssrf-test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	host := created[0]
+	if host.Label != "prod-ssrf-test" {
+		t.Errorf("expected label %q, got %q", "prod-ssrf-test", host.Label)
+	}
+
+	capture := func(t *testing.T) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "http://"+host.Hostname+"/", nil)
+		_, err := svc.StoreHTTPLogEntry(ctx, StoreHTTPLogEntryParams{
+			Request:  req,
+			Response: &http.Response{},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	capture(t)
+
+	label := "staging"
+	if _, err := svc.UpdateHost(ctx, host.ID, UpdateHostParams{Label: &label}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	capture(t)
+
+	label = ""
+	if _, err := svc.UpdateHost(ctx, host.ID, UpdateHostParams{Label: &label}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	capture(t)
+
+	// Entries keep the label the host had when they were captured.
+	exp := []string{"prod-ssrf-test", "staging", ""}
+	entries := db.storedHTTPLogEntries()
+	if len(entries) != len(exp) {
+		t.Fatalf("expected %v stored entries, got %v", len(exp), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Label != exp[i] {
+			t.Errorf("expected label %q of entry %v, got %q", exp[i], i, entry.Label)
+		}
+	}
+}
+
+func TestStoreHTTPLogEntryServerName(t *testing.T) {
+	tests := []struct {
+		name          string
+		tls           *tls.ConnectionState
+		expServerName string
+	}{
+		{name: "no TLS"},
+		{name: "TLS without SNI", tls: &tls.ConnectionState{}},
+		{name: "TLS with SNI", tls: &tls.ConnectionState{ServerName: "def.example.com"}, expServerName: "def.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+			host := newTestHost(t, svc)
+
+			req := httptest.NewRequest("GET", "http://"+host.Hostname+"/", nil)
+			req.TLS = tt.tls
+			_, err := svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+				Request:  req,
+				Response: &http.Response{},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			entries := db.storedHTTPLogEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			if entries[0].ServerName != tt.expServerName {
+				t.Errorf("expected server name %q, got %q", tt.expServerName, entries[0].ServerName)
+			}
+		})
+	}
+}
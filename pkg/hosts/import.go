@@ -0,0 +1,135 @@
+package hosts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrHostnameTaken is returned when importing a host whose hostname is
+	// used by another host.
+	ErrHostnameTaken = errors.New("hostname is used by another host")
+	// ErrHostnameOutsideBase is returned when importing a host whose hostname
+	// isn't a subdomain of the base hostname.
+	ErrHostnameOutsideBase = errors.New("hostname is not a subdomain of the base hostname")
+	// ErrHostIDTaken is returned when importing a host whose ID is used by a
+	// host with another hostname.
+	ErrHostIDTaken = errors.New("host ID is used by a host with another hostname")
+)
+
+type ImportHTTPLogEntryParams struct {
+	// Host is the host of the entry, which is created if it doesn't exist.
+	Host  Host
+	Entry HTTPLogEntry
+}
+
+// ImportHTTPLogEntry stores an HTTP log entry from an export, preserving the
+// IDs of the entry and its host. Entries that were imported before are
+// skipped, so importing is idempotent. It returns whether the entry was stored.
+func (srv *service) ImportHTTPLogEntry(ctx context.Context, params ImportHTTPLogEntryParams) (bool, error) {
+	if err := srv.importHost(ctx, params.Host); err != nil {
+		return false, err
+	}
+
+	_, err := srv.database.FindHTTPLogEntryByID(ctx, params.Entry.ID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrHTTPLogEntryNotFound) {
+		return false, fmt.Errorf("hosts: failed to find HTTP log entry by ID: %w", err)
+	}
+
+	entry := params.Entry
+	entry.HostID = params.Host.ID
+	if entry.RepeatCount < 0 {
+		entry.RepeatCount = 0
+	}
+
+	// The repeat count is stored with the entry, and its repeats are counted
+	// as interactions of the host as well.
+	err = srv.database.StoreHTTPLogEntry(ctx, entry)
+	if err != nil {
+		return false, fmt.Errorf("hosts: failed to store HTTP log entry: %w", err)
+	}
+	srv.httpLogNotifier.notify()
+
+	srv.logger.Debug("Imported HTTP log entry.",
+		zap.String("id", entry.ID.String()),
+		zap.String("hostId", entry.HostID.String()),
+	)
+
+	return true, nil
+}
+
+// importHost stores a host, unless a host with the same ID exists. Like
+// created hosts, imported hosts must be subdomains of the base hostname, count
+// towards the maximum amount of hosts, and expire after the host TTL.
+func (srv *service) importHost(ctx context.Context, host Host) error {
+	host.Hostname = NormalizeHostname(host.Hostname)
+
+	if !strings.HasSuffix(host.Hostname, "."+srv.baseHostname) {
+		return fmt.Errorf("hosts: failed to import host %q (ID: %v): %w",
+			host.Hostname, host.ID, ErrHostnameOutsideBase)
+	}
+
+	existing, err := srv.database.FindHostByID(ctx, host.ID)
+	if err == nil {
+		if existing.Hostname != host.Hostname {
+			return fmt.Errorf("hosts: failed to import host %q (ID: %v): %w (hostname: %q)",
+				host.Hostname, host.ID, ErrHostIDTaken, existing.Hostname)
+		}
+		if existing.Expired(time.Now()) {
+			return fmt.Errorf("hosts: failed to import host %q (ID: %v), it expired: %w",
+				host.Hostname, host.ID, ErrHostNotFound)
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrHostNotFound) {
+		return fmt.Errorf("hosts: failed to find host by ID: %w", err)
+	}
+
+	existing, err = srv.database.FindHostByHostname(ctx, host.Hostname)
+	if err == nil {
+		return fmt.Errorf("hosts: failed to import host %q (ID: %v): %w (ID: %v)",
+			host.Hostname, host.ID, ErrHostnameTaken, existing.ID)
+	}
+	if !errors.Is(err, ErrHostNotFound) {
+		return fmt.Errorf("hosts: failed to find host by hostname: %w", err)
+	}
+
+	if srv.maxHosts > 0 {
+		srv.createMu.Lock()
+		defer srv.createMu.Unlock()
+
+		count, err := srv.database.CountHosts(ctx)
+		if err != nil {
+			return fmt.Errorf("hosts: failed to count hosts: %w", err)
+		}
+		if count >= srv.maxHosts {
+			return fmt.Errorf("hosts: failed to import host %q (ID: %v), %v of %v exist: %w",
+				host.Hostname, host.ID, count, srv.maxHosts, ErrMaxHostsReached)
+		}
+	}
+
+	var expiresAt time.Time
+	if srv.hostTTL > 0 {
+		expiresAt = time.Now().Add(srv.hostTTL).UTC()
+	}
+
+	err = srv.database.StoreHosts(ctx, Host{ID: host.ID, Hostname: host.Hostname, ExpiresAt: expiresAt})
+	if err != nil {
+		return fmt.Errorf("hosts: failed to store host: %w", err)
+	}
+
+	srv.logger.Info("Imported host.",
+		zap.String("id", host.ID.String()),
+		zap.String("hostname", host.Hostname),
+	)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package hosts
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+)
+
+// noIncrementDatabase fails the test when repeat counts are incremented, which
+// imports must not do per repeat.
+type noIncrementDatabase struct {
+	*testDatabase
+	t *testing.T
+}
+
+func (db noIncrementDatabase) IncrementHTTPLogEntryRepeatCount(_ context.Context, _ ulid.ULID) error {
+	db.t.Error("expected repeat count to be stored with the entry, not incremented")
+	return nil
+}
+
+func TestImportHTTPLogEntry(t *testing.T) {
+	hostID := ulid.MustNew(ulid.Now(), rand.Reader)
+
+	tests := []struct {
+		name     string
+		existing []Host
+		maxHosts int
+		host     Host
+		expError error
+	}{
+		{
+			name: "new host",
+			host: Host{ID: hostID, Hostname: "abc.example.com"},
+		},
+		{
+			name: "new host with different case",
+			host: Host{ID: hostID, Hostname: "ABC.example.com"},
+		},
+		{
+			name:     "existing host",
+			existing: []Host{{ID: hostID, Hostname: "abc.example.com"}},
+			maxHosts: 1,
+			host:     Host{ID: hostID, Hostname: "abc.example.com"},
+		},
+		{
+			name:     "base hostname",
+			host:     Host{ID: hostID, Hostname: "example.com"},
+			expError: ErrHostnameOutsideBase,
+		},
+		{
+			name:     "outside of base hostname",
+			host:     Host{ID: hostID, Hostname: "abc.example.org"},
+			expError: ErrHostnameOutsideBase,
+		},
+		{
+			name:     "suffix of base hostname without dot",
+			host:     Host{ID: hostID, Hostname: "abcexample.com"},
+			expError: ErrHostnameOutsideBase,
+		},
+		{
+			name:     "ID of host with other hostname",
+			existing: []Host{{ID: hostID, Hostname: "def.example.com"}},
+			host:     Host{ID: hostID, Hostname: "abc.example.com"},
+			expError: ErrHostIDTaken,
+		},
+		{
+			name:     "hostname of other host",
+			existing: []Host{{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "abc.example.com"}},
+			host:     Host{ID: hostID, Hostname: "abc.example.com"},
+			expError: ErrHostnameTaken,
+		},
+		{
+			name:     "expired host",
+			existing: []Host{{ID: hostID, Hostname: "abc.example.com", ExpiresAt: time.Now().Add(-time.Minute)}},
+			host:     Host{ID: hostID, Hostname: "abc.example.com"},
+			expError: ErrHostNotFound,
+		},
+		{
+			name:     "max hosts reached",
+			existing: []Host{{ID: ulid.MustNew(ulid.Now(), rand.Reader), Hostname: "def.example.com"}},
+			maxHosts: 1,
+			host:     Host{ID: hostID, Hostname: "abc.example.com"},
+			expError: ErrMaxHostsReached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase()
+			for _, host := range tt.existing {
+				db.hosts[host.ID] = host
+			}
+			svc := NewService(
+				WithDatabase(noIncrementDatabase{testDatabase: db, t: t}),
+				WithBaseHostname("example.com"),
+				WithMaxHosts(tt.maxHosts),
+				WithHostTTL(time.Hour),
+			)
+
+			entry := HTTPLogEntry{ID: ulid.MustNew(ulid.Now(), rand.Reader), RepeatCount: 5}
+			imported, err := svc.ImportHTTPLogEntry(context.Background(), ImportHTTPLogEntryParams{
+				Host:  tt.host,
+				Entry: entry,
+			})
+			if tt.expError != nil {
+				if !errors.Is(err, tt.expError) {
+					t.Fatalf("expected error %v, got %v", tt.expError, err)
+				}
+				if entries := db.storedHTTPLogEntries(); len(entries) != 0 {
+					t.Errorf("expected no stored entries, got %v", len(entries))
+				}
+				if len(db.hosts) != len(tt.existing) {
+					t.Errorf("expected %v hosts, got %v", len(tt.existing), len(db.hosts))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !imported {
+				t.Error("expected entry to be imported")
+			}
+
+			entries := db.storedHTTPLogEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			if entries[0].RepeatCount != 5 {
+				t.Errorf("expected repeat count 5, got %v", entries[0].RepeatCount)
+			}
+			if entries[0].HostID != hostID {
+				t.Errorf("expected host ID %v, got %v", hostID, entries[0].HostID)
+			}
+
+			host, ok := db.hosts[hostID]
+			if !ok {
+				t.Fatal("expected host to be stored")
+			}
+			if host.Hostname != "abc.example.com" {
+				t.Errorf("expected hostname %q, got %q", "abc.example.com", host.Hostname)
+			}
+			if len(tt.existing) == 0 && host.ExpiresAt.IsZero() {
+				t.Error("expected imported host to expire after the host TTL")
+			}
+
+			// Importing again is skipped.
+			imported, err = svc.ImportHTTPLogEntry(context.Background(), ImportHTTPLogEntryParams{
+				Host:  tt.host,
+				Entry: entry,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if imported {
+				t.Error("expected entry to be skipped")
+			}
+		})
+	}
+}
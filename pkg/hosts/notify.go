@@ -0,0 +1,35 @@
+package hosts
+
+import "sync"
+
+// notifier notifies waiters of changes, e.g. stored HTTP log entries. Its zero
+// value is ready for use.
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel that is closed on the next call to notify.
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+func (srv *service) HTTPLogEntriesChanged() <-chan struct{} {
+	return srv.httpLogNotifier.wait()
+}
@@ -0,0 +1,59 @@
+package hosts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPLogEntriesChanged(t *testing.T) {
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+	host := newTestHost(t, svc)
+
+	changed := svc.HTTPLogEntriesChanged()
+	select {
+	case <-changed:
+		t.Fatal("expected channel not to be closed before an entry is stored")
+	default:
+	}
+
+	req := httptest.NewRequest("GET", "http://"+host.Hostname+"/", nil)
+	_, err := svc.StoreHTTPLogEntry(context.Background(), StoreHTTPLogEntryParams{
+		Request:  req,
+		Response: &http.Response{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected channel to be closed after an entry is stored")
+	}
+
+	select {
+	case <-svc.HTTPLogEntriesChanged():
+		t.Error("expected a new channel for the next change")
+	default:
+	}
+}
+
+func TestWithHTTPLogNotifications(t *testing.T) {
+	notifications := make(chan struct{})
+	defer close(notifications)
+
+	svc := NewService(WithDatabase(newTestDatabase()), WithHTTPLogNotifications(notifications))
+
+	changed := svc.HTTPLogEntriesChanged()
+	notifications <- struct{}{}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected channel to be closed after a notification")
+	}
+}
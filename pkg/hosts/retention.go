@@ -0,0 +1,72 @@
+package hosts
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+)
+
+// BodyRetention limits the HTTP log entries per host for which request and
+// response bodies are stored. Bodies of older entries are dropped, while their
+// headers and metadata are kept. A zero limit means no limit.
+type BodyRetention struct {
+	// Entries is the amount of most recent HTTP log entries with bodies.
+	Entries int
+	// Bytes is the total size of the bodies of the most recent HTTP log
+	// entries.
+	Bytes int64
+}
+
+// Enabled returns whether any limit is set.
+func (r BodyRetention) Enabled() bool {
+	return r.Entries > 0 || r.Bytes > 0
+}
+
+// Exceeded returns whether an HTTP log entry is beyond the limits, given the
+// amount of more recent entries (including itself) and their total body size.
+func (r BodyRetention) Exceeded(entries int, bytes int64) bool {
+	return (r.Entries > 0 && entries > r.Entries) || (r.Bytes > 0 && bytes > r.Bytes)
+}
+
+// StripHTTPBody returns the header section of a raw HTTP/1.x request or
+// response, including the empty line that ends it. If the raw message has no
+// header section terminator, it's returned as-is.
+func StripHTTPBody(raw []byte) []byte {
+	i := bytes.Index(raw, []byte("\r\n\r\n"))
+	if i == -1 {
+		return raw
+	}
+
+	return raw[:i+4]
+}
+
+// httpBodySize returns the size of the body of a raw HTTP/1.x request or
+// response.
+func httpBodySize(raw []byte) int64 {
+	return int64(len(raw) - len(StripHTTPBody(raw)))
+}
+
+// HTTPLogEntryBodySize returns the total size of the request and response
+// bodies of an HTTP log entry.
+func HTTPLogEntryBodySize(rawRequest, rawResponse []byte) int64 {
+	return httpBodySize(rawRequest) + httpBodySize(rawResponse)
+}
+
+// dropHTTPLogEntryBodies drops bodies of HTTP log entries of a host that are
+// beyond the body retention limits. Failing to do so isn't an error for
+// storing the interaction, so errors are only logged.
+func (srv *service) dropHTTPLogEntryBodies(ctx context.Context, hostID ulid.ULID) {
+	if !srv.bodyRetention.Enabled() {
+		return
+	}
+
+	err := srv.database.DropHTTPLogEntryBodies(ctx, hostID, srv.bodyRetention)
+	if err != nil {
+		srv.logger.Error("Failed to drop bodies of HTTP log entries.",
+			zap.String("hostId", hostID.String()),
+			zap.Error(err),
+		)
+	}
+}
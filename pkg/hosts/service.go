@@ -2,40 +2,100 @@ package hosts
 
 import (
 	"context"
-	"math/rand"
+	"crypto/rand"
+	"sync"
 	"time"
 
 	"github.com/oklog/ulid"
 	"go.uber.org/zap"
 )
 
-var ulidEntropy = rand.New(rand.NewSource(time.Now().UnixNano()))
+// ulidEntropy is used for generating ULIDs. Because ULIDs are used as public
+// identifiers, a cryptographically secure source is used, so IDs can't be
+// predicted. Unlike `math/rand`, it's also safe for concurrent use.
+var ulidEntropy = rand.Reader
 
 type Service interface {
-	CreateHosts(ctx context.Context, amount int) ([]Host, error)
+	CreateHosts(ctx context.Context, params CreateHostsParams) ([]Host, error)
 	FindHostByID(ctx context.Context, hostID ulid.ULID) (Host, error)
-	StoreHTTPLogEntry(ctx context.Context, params StoreHTTPLogEntryParams) error
+	FindHostByHostname(ctx context.Context, hostname string) (Host, error)
+	ListHosts(ctx context.Context) ([]Host, error)
+	UpdateHost(ctx context.Context, hostID ulid.ULID, params UpdateHostParams) (Host, error)
+	UpdateHostResponse(ctx context.Context, hostID ulid.ULID, params UpdateHostResponseParams) (Host, error)
+	DeleteHost(ctx context.Context, hostID ulid.ULID) error
+	// DeleteExpiredHosts deletes all expired hosts, and returns them.
+	DeleteExpiredHosts(ctx context.Context) ([]Host, error)
+	// StoreHTTPLogEntry stores an HTTP log entry and returns its ID. For
+	// repeated requests, the ID of the first entry is returned.
+	StoreHTTPLogEntry(ctx context.Context, params StoreHTTPLogEntryParams) (ulid.ULID, error)
 	ListHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams) ([]HTTPLogEntry, error)
+	FindHTTPLogEntryByID(ctx context.Context, id ulid.ULID) (HTTPLogEntry, error)
+	WalkHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams, fn func(HTTPLogEntry) error) error
+	// HTTPLogEntriesChanged returns a channel that is closed when the next
+	// HTTP log entry is stored, for any host. To not miss entries, it must be
+	// called before listing entries.
+	HTTPLogEntriesChanged() <-chan struct{}
+	ImportHTTPLogEntry(ctx context.Context, params ImportHTTPLogEntryParams) (bool, error)
+	StoreDNSLogEntry(ctx context.Context, params StoreDNSLogEntryParams) error
+	ListDNSLogEntries(ctx context.Context, params ListDNSLogEntriesParams) ([]DNSLogEntry, error)
+	StoreTLSLogEntry(ctx context.Context, params StoreTLSLogEntryParams) error
+	ListTLSLogEntries(ctx context.Context, params ListTLSLogEntriesParams) ([]TLSLogEntry, error)
+	// PendingWrites returns the amount of HTTP and TLS log entries being
+	// stored.
+	PendingWrites() int
+	Stats(ctx context.Context) (Stats, error)
 }
 
 type service struct {
-	baseHostname string
-	database     Database
-	logger       *zap.Logger
+	baseHostname    string
+	hostnamePattern HostnamePattern
+	dedup           *dedupCache
+	// maxHosts is the maximum amount of hosts, unlimited when 0. Creating
+	// hosts is serialized with createMu, so the limit can't be exceeded by
+	// concurrent requests.
+	maxHosts int
+	createMu sync.Mutex
+	// writes is a semaphore for limiting concurrent writes, if configured.
+	writes        chan struct{}
+	bodyRetention BodyRetention
+	// hostTTL is the default time after which hosts expire. Hosts don't
+	// expire if 0.
+	hostTTL         time.Duration
+	httpLogNotifier notifier
+	database        Database
+	logger          *zap.Logger
 }
 
 type serviceOption func(*service)
 
 type Database interface {
 	StoreHosts(ctx context.Context, hosts ...Host) error
+	UpdateHost(ctx context.Context, host Host) error
+	DeleteHost(ctx context.Context, hostID ulid.ULID) error
+	// StoreHTTPLogEntry stores an HTTP log entry including its repeat count,
+	// which is added to the interaction count of the host as well.
 	StoreHTTPLogEntry(ctx context.Context, entry HTTPLogEntry) error
+	IncrementHTTPLogEntryRepeatCount(ctx context.Context, id ulid.ULID) error
+	DropHTTPLogEntryBodies(ctx context.Context, hostID ulid.ULID, retention BodyRetention) error
 	FindHostByID(ctx context.Context, hostID ulid.ULID) (Host, error)
 	FindHostByHostname(ctx context.Context, hostname string) (Host, error)
+	ListHosts(ctx context.Context) ([]Host, error)
+	CountHosts(ctx context.Context) (int, error)
 	ListHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams) ([]HTTPLogEntry, error)
+	FindHTTPLogEntryByID(ctx context.Context, id ulid.ULID) (HTTPLogEntry, error)
+	WalkHTTPLogEntries(ctx context.Context, params ListHTTPLogEntriesParams, fn func(HTTPLogEntry) error) error
+	StoreDNSLogEntry(ctx context.Context, entry DNSLogEntry) error
+	ListDNSLogEntries(ctx context.Context, params ListDNSLogEntriesParams) ([]DNSLogEntry, error)
+	StoreTLSLogEntry(ctx context.Context, entry TLSLogEntry) error
+	ListTLSLogEntries(ctx context.Context, params ListTLSLogEntriesParams) ([]TLSLogEntry, error)
+	Stats(ctx context.Context) (Stats, error)
 }
 
 func NewService(opts ...serviceOption) Service {
-	srv := &service{}
+	srv := &service{
+		hostnamePattern: DefaultHostnamePattern,
+		logger:          zap.NewNop(),
+	}
 
 	for _, opt := range opts {
 		opt(srv)
@@ -47,7 +107,82 @@ func NewService(opts ...serviceOption) Service {
 // WithBaseHostname provides a base hostname, to use when generating hostnames.
 func WithBaseHostname(baseHostname string) serviceOption {
 	return func(srv *service) {
-		srv.baseHostname = baseHostname
+		srv.baseHostname = NormalizeHostname(baseHostname)
+	}
+}
+
+// WithHostnamePattern overrides the pattern used for generating the subdomain
+// label of hostnames. Defaults to `DefaultHostnamePattern`.
+func WithHostnamePattern(pattern HostnamePattern) serviceOption {
+	return func(srv *service) {
+		srv.hostnamePattern = pattern
+	}
+}
+
+// WithDedup enables deduplication of identical HTTP requests (same host,
+// remote IP, method, path and query, and body) received within `window` of the
+// first one. Instead of being stored, repeats increment a counter on the first
+// log entry. Deduplication is disabled by default.
+func WithDedup(window time.Duration) serviceOption {
+	return func(srv *service) {
+		if window > 0 {
+			srv.dedup = newDedupCache(window)
+		}
+	}
+}
+
+// WithMaxHosts limits the total amount of hosts to `n`, e.g. to prevent abuse
+// of public instances. When creating hosts would exceed it, CreateHosts returns
+// ErrMaxHostsReached. There is no limit by default.
+func WithMaxHosts(n int) serviceOption {
+	return func(srv *service) {
+		srv.maxHosts = n
+	}
+}
+
+// WithMaxConcurrentWrites limits the amount of HTTP and TLS log entries being
+// stored concurrently to `n`. When the limit is reached, StoreHTTPLogEntry and
+// StoreTLSLogEntry return ErrTooManyWrites instead of queueing, so memory use
+// is bounded under a flood of requests or handshakes. There is no limit by
+// default.
+func WithMaxConcurrentWrites(n int) serviceOption {
+	return func(srv *service) {
+		if n > 0 {
+			srv.writes = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithHostTTL sets the default time after which created hosts expire, and are
+// deleted along with their interactions. Hosts don't expire by default.
+func WithHostTTL(ttl time.Duration) serviceOption {
+	return func(srv *service) {
+		srv.hostTTL = ttl
+	}
+}
+
+// WithBodyRetention limits the HTTP log entries per host for which request
+// and response bodies are stored. After storing an HTTP log entry, bodies of
+// older entries of the host are dropped. Bodies are retained by default.
+func WithBodyRetention(retention BodyRetention) serviceOption {
+	return func(srv *service) {
+		srv.bodyRetention = retention
+	}
+}
+
+// WithHTTPLogNotifications makes HTTPLogEntriesChanged fire for every value
+// received on `ch` too, e.g. for HTTP log entries stored by other instances
+// sharing the database. A nil channel is ignored.
+func WithHTTPLogNotifications(ch <-chan struct{}) serviceOption {
+	return func(srv *service) {
+		if ch == nil {
+			return
+		}
+		go func() {
+			for range ch {
+				srv.httpLogNotifier.notify()
+			}
+		}()
 	}
 }
 
@@ -65,3 +200,23 @@ func WithLogger(logger *zap.Logger) serviceOption {
 		srv.logger = logger
 	}
 }
+
+func (srv *service) PendingWrites() int {
+	return len(srv.writes)
+}
+
+// acquireWrite acquires a slot of the concurrent writes semaphore, if
+// configured. It returns false if the limit is reached, and otherwise a func
+// for releasing the slot.
+func (srv *service) acquireWrite() (release func(), ok bool) {
+	if srv.writes == nil {
+		return func() {}, true
+	}
+
+	select {
+	case srv.writes <- struct{}{}:
+		return func() { <-srv.writes }, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,31 @@
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Stats describes the contents of the database, e.g. for monitoring its
+// growth.
+type Stats struct {
+	Hosts          int
+	HTTPLogEntries int
+	DNSLogEntries  int
+	TLSLogEntries  int
+	// OldestInteraction and NewestInteraction are the times of the oldest
+	// and newest log entries of any type, or zero if there are none.
+	OldestInteraction time.Time
+	NewestInteraction time.Time
+	// Size is the size of the database on disk, in bytes.
+	Size int64
+}
+
+func (srv *service) Stats(ctx context.Context) (Stats, error) {
+	stats, err := srv.database.Stats(ctx)
+	if err != nil {
+		return Stats{}, fmt.Errorf("hosts: failed to get database stats: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,87 @@
+package hosts
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+)
+
+// TLSLogEntry represents a TLS handshake (ClientHello) received for a host,
+// identified by its server name (SNI). Handshakes are logged even when no HTTP
+// request follows, e.g. for clients that only validate certificates.
+type TLSLogEntry struct {
+	ID              ulid.ULID
+	HostID          ulid.ULID
+	RemoteAddr      string
+	ServerName      string
+	SupportedProtos []string
+	// JA3 is the JA3 string of the client hello, if fingerprinting is enabled.
+	JA3 string
+	// JA4 is the JA4 fingerprint of the client hello, if fingerprinting is
+	// enabled.
+	JA4 string
+}
+
+type StoreTLSLogEntryParams struct {
+	ServerName      string
+	RemoteAddr      string
+	SupportedProtos []string
+	JA3             string
+	JA4             string
+}
+
+func (srv *service) StoreTLSLogEntry(ctx context.Context, params StoreTLSLogEntryParams) error {
+	release, ok := srv.acquireWrite()
+	if !ok {
+		return fmt.Errorf("hosts: failed to store TLS log entry: %w", ErrTooManyWrites)
+	}
+	defer release()
+
+	name := strings.TrimSuffix(params.ServerName, ".")
+	host, err := srv.findHostByDomainName(ctx, name)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to find host by server name %q: %w", name, err)
+	}
+
+	now := time.Now().UTC()
+	entry := TLSLogEntry{
+		ID:              ulid.MustNew(ulid.Timestamp(now), ulidEntropy),
+		HostID:          host.ID,
+		RemoteAddr:      params.RemoteAddr,
+		ServerName:      params.ServerName,
+		SupportedProtos: params.SupportedProtos,
+		JA3:             params.JA3,
+		JA4:             params.JA4,
+	}
+
+	err = srv.database.StoreTLSLogEntry(ctx, entry)
+	if err != nil {
+		return fmt.Errorf("hosts: failed to store TLS log entry: %w", err)
+	}
+
+	srv.logger.Info("Stored TLS log entry.",
+		zap.String("id", entry.ID.String()),
+		zap.String("hostId", entry.HostID.String()),
+		zap.String("serverName", entry.ServerName),
+		zap.String("remoteAddr", entry.RemoteAddr),
+	)
+
+	return nil
+}
+
+type ListTLSLogEntriesParams struct {
+	HostIDs []ulid.ULID
+}
+
+func (srv *service) ListTLSLogEntries(ctx context.Context, params ListTLSLogEntriesParams) ([]TLSLogEntry, error) {
+	entries, err := srv.database.ListTLSLogEntries(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("hosts: failed to list TLS log entries: %w", err)
+	}
+
+	return entries, nil
+}
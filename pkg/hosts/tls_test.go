@@ -0,0 +1,62 @@
+package hosts
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStoreTLSLogEntry(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"))
+	host := newTestHost(t, svc)
+
+	err := svc.StoreTLSLogEntry(ctx, StoreTLSLogEntryParams{
+		ServerName: "foo." + host.Hostname + ".",
+		RemoteAddr: "192.0.2.1:1234",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(db.tlsLogEntries) != 1 {
+		t.Fatalf("expected 1 stored entry, got %v", len(db.tlsLogEntries))
+	}
+	if got := db.tlsLogEntries[0].HostID; got != host.ID {
+		t.Errorf("expected host ID %v, got %v", host.ID, got)
+	}
+
+	err = svc.StoreTLSLogEntry(ctx, StoreTLSLogEntryParams{ServerName: "example.org"})
+	if !errors.Is(err, ErrHostNotFound) {
+		t.Errorf("expected ErrHostNotFound, got %v", err)
+	}
+}
+
+func TestStoreTLSLogEntryMaxConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase()
+	svc := NewService(WithDatabase(db), WithBaseHostname("example.com"), WithMaxConcurrentWrites(1))
+	host := newTestHost(t, svc)
+	params := StoreTLSLogEntryParams{ServerName: host.Hostname}
+
+	// A write in progress takes the only slot.
+	release, ok := svc.(*service).acquireWrite()
+	if !ok {
+		t.Fatal("expected to acquire write")
+	}
+	if n := svc.PendingWrites(); n != 1 {
+		t.Errorf("expected 1 pending write, got %v", n)
+	}
+
+	if err := svc.StoreTLSLogEntry(ctx, params); !errors.Is(err, ErrTooManyWrites) {
+		t.Errorf("expected ErrTooManyWrites, got %v", err)
+	}
+
+	release()
+	if err := svc.StoreTLSLogEntry(ctx, params); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := svc.PendingWrites(); n != 0 {
+		t.Errorf("expected no pending writes, got %v", n)
+	}
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+const (
+	acmeChallengePathPrefix = "/.well-known/acme-challenge/"
+	acmeTLSALPNProto        = "acme-tls/1"
+	// maxACMEChallengeBodySize caps the recorded response body of solved
+	// ACME HTTP-01 challenges, which is only a key authorization.
+	maxACMEChallengeBodySize = 4 << 10
+)
+
+// acmeChallengeCapturedKey is the context key of a flag that is set when an
+// ACME HTTP-01 challenge request reaches CaptureRequest, i.e. when it wasn't
+// solved by the ACME manager.
+type acmeChallengeCapturedKey struct{}
+
+func isACMEChallenge(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, acmeChallengePathPrefix)
+}
+
+// markACMEChallengeCaptured marks an ACME HTTP-01 challenge request as handled
+// by CaptureRequest, so ACMEChallengeLogMiddleware doesn't store it again.
+func markACMEChallengeCaptured(r *http.Request) {
+	if captured, ok := r.Context().Value(acmeChallengeCapturedKey{}).(*bool); ok {
+		*captured = true
+	}
+}
+
+// statusRecorder records the status code and (up to `bodyLimit` bytes of) the
+// body of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+	bodyLimit  int
+}
+
+func (rec *statusRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if remaining := rec.bodyLimit - rec.body.Len(); remaining > 0 {
+		if len(p) > remaining {
+			rec.body.Write(p[:remaining])
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// ACMEChallengeLogMiddleware logs inbound ACME HTTP-01 challenge requests, and
+// stores those solved by the ACME manager as HTTP log entries of the matching
+// host (marked as ACME challenge), like the ones handled by CaptureRequest. It
+// should be registered *before* the ACME HTTP challenge handler, so requests
+// solved by the ACME manager are logged too.
+func (srv *Server) ACMEChallengeLogMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isACMEChallenge(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		var captured bool
+		r = r.WithContext(context.WithValue(r.Context(), acmeChallengeCapturedKey{}, &captured))
+
+		rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK, bodyLimit: maxACMEChallengeBodySize}
+		h.ServeHTTP(rec, r)
+
+		srv.logger.Info("Received ACME HTTP-01 challenge request.",
+			zap.String("host", r.Host),
+			zap.String("path", r.URL.Path),
+			zap.String("remoteAddr", r.RemoteAddr),
+			zap.Int("statusCode", rec.statusCode),
+		)
+
+		if !captured {
+			srv.storeACMEChallenge(r, rec)
+		}
+	})
+}
+
+// storeACMEChallenge stores an ACME HTTP-01 challenge request that wasn't
+// handled by CaptureRequest, with the response recorded by `rec`.
+func (srv *Server) storeACMEChallenge(r *http.Request, rec *statusRecorder) {
+	res := &http.Response{
+		StatusCode:    rec.statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header().Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(rec.body.Bytes())),
+		ContentLength: int64(rec.body.Len()),
+	}
+
+	_, err := srv.hostsService.StoreHTTPLogEntry(r.Context(), hosts.StoreHTTPLogEntryParams{
+		Request:       r,
+		Response:      res,
+		RemoteAddr:    srv.remoteAddr(r),
+		ACMEChallenge: true,
+	})
+	if errors.Is(err, hosts.ErrHostNotFound) {
+		srv.logger.Debug("Skipped storing ACME HTTP-01 challenge request, host not found.", zap.String("host", r.Host))
+		return
+	}
+	if errors.Is(err, hosts.ErrTooManyWrites) {
+		srv.logger.Warn("Too many concurrent writes, dropping ACME HTTP-01 challenge request.", zap.Error(err))
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to store ACME HTTP-01 challenge request.", zap.Error(err))
+	}
+}
+
+// logACMETLSALPNChallenges wraps a TLS config's `GetCertificate` func so that
+// inbound ACME TLS-ALPN-01 challenge handshakes are logged. They are stored as
+// TLS log entries (with the `acme-tls/1` protocol) by captureTLSHandshakes.
+func (srv *Server) logACMETLSALPNChallenges(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil || tlsConfig.GetCertificate == nil {
+		return tlsConfig
+	}
+
+	cfg := tlsConfig.Clone()
+	getCertificate := tlsConfig.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		for _, proto := range hello.SupportedProtos {
+			if proto == acmeTLSALPNProto {
+				var remoteAddr string
+				if hello.Conn != nil {
+					remoteAddr = hello.Conn.RemoteAddr().String()
+				}
+				srv.logger.Info("Received ACME TLS-ALPN-01 challenge handshake.",
+					zap.String("serverName", hello.ServerName),
+					zap.String("remoteAddr", remoteAddr),
+				)
+				break
+			}
+		}
+
+		return getCertificate(hello)
+	}
+
+	return cfg
+}
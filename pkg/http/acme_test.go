@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// solveACMEChallenge answers ACME HTTP-01 challenge requests like the ACME
+// manager does, and passes other requests to `next`.
+func solveACMEChallenge(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isACMEChallenge(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("token.key-authorization"))
+	})
+}
+
+func TestACMEChallengeLogMiddleware(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		solved           bool
+		expEntries       int
+		expACMEChallenge bool
+		expBody          string
+	}{
+		{
+			name:             "solved challenge",
+			path:             "/.well-known/acme-challenge/token",
+			solved:           true,
+			expEntries:       1,
+			expACMEChallenge: true,
+			expBody:          "token.key-authorization",
+		},
+		{
+			name:             "unsolved challenge",
+			path:             "/.well-known/acme-challenge/token",
+			expEntries:       1,
+			expACMEChallenge: true,
+		},
+		{
+			name:       "solver without challenge",
+			path:       "/foo",
+			solved:     true,
+			expEntries: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(WithHostsService(svc))
+
+			var h http.Handler = http.HandlerFunc(srv.CaptureRequest)
+			if tt.solved {
+				h = solveACMEChallenge(h)
+			}
+			h = srv.ACMEChallengeLogMiddleware(h)
+
+			r := httptest.NewRequest("GET", "http://abc.example.com"+tt.path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			entries := svc.storedEntries()
+			if len(entries) != tt.expEntries {
+				t.Fatalf("expected %v stored entries, got %v", tt.expEntries, len(entries))
+			}
+			entry := entries[0]
+			if entry.ACMEChallenge != tt.expACMEChallenge {
+				t.Errorf("expected ACME challenge %v, got %v", tt.expACMEChallenge, entry.ACMEChallenge)
+			}
+			if entry.Request.URL.Path != tt.path {
+				t.Errorf("expected path %q, got %q", tt.path, entry.Request.URL.Path)
+			}
+			if tt.expBody == "" {
+				return
+			}
+
+			if entry.Response.StatusCode != http.StatusOK {
+				t.Errorf("expected status 200, got %v", entry.Response.StatusCode)
+			}
+			if got := entry.Response.Header.Get("Content-Type"); got != "text/plain" {
+				t.Errorf("expected content type %q, got %q", "text/plain", got)
+			}
+			body, err := ioutil.ReadAll(entry.Response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.expBody {
+				t.Errorf("expected body %q, got %q", tt.expBody, body)
+			}
+			if got := w.Body.String(); got != tt.expBody {
+				t.Errorf("expected response %q, got %q", tt.expBody, got)
+			}
+		})
+	}
+}
+
+// tlsLogHostsService sends the params of stored TLS log entries on `stored`.
+type tlsLogHostsService struct {
+	hosts.Service
+	stored chan hosts.StoreTLSLogEntryParams
+}
+
+func (svc *tlsLogHostsService) StoreTLSLogEntry(_ context.Context, params hosts.StoreTLSLogEntryParams) error {
+	svc.stored <- params
+	return nil
+}
+
+func TestACMETLSALPNChallengeStored(t *testing.T) {
+	svc := &tlsLogHostsService{stored: make(chan hosts.StoreTLSLogEntryParams, 1)}
+	srv := NewServer(WithHostsService(svc))
+
+	cfg := srv.logACMETLSALPNChallenges(srv.captureTLSHandshakes(&tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		},
+	}))
+
+	_, err := cfg.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:      "abc.example.com",
+		SupportedProtos: []string{acmeTLSALPNProto},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case params := <-svc.stored:
+		if params.ServerName != "abc.example.com" {
+			t.Errorf("expected server name %q, got %q", "abc.example.com", params.ServerName)
+		}
+		if exp := []string{acmeTLSALPNProto}; !reflect.DeepEqual(params.SupportedProtos, exp) {
+			t.Errorf("expected supported protocols %v, got %v", exp, params.SupportedProtos)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for TLS log entry to be stored")
+	}
+}
@@ -0,0 +1,257 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/libdns/libdns"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// acmeDNSMaxTXTRecords is the amount of TXT records kept per host.
+const acmeDNSMaxTXTRecords = 2
+
+// acmeDNSPasswordLen is the length of passwords, which acme-dns clients may
+// expect.
+const acmeDNSPasswordLen = 40
+
+type acmeDNSRegistration struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	FullDomain string   `json:"fulldomain"`
+	Subdomain  string   `json:"subdomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+type acmeDNSRegisterRequestBody struct {
+	AllowFrom []string `json:"allowfrom"`
+}
+
+type acmeDNSUpdateRequestBody struct {
+	Subdomain string `json:"subdomain"`
+	TXT       string `json:"txt"`
+}
+
+// registerACMEDNSRoutes registers an API compatible with acme-dns (see
+// https://github.com/joohoi/acme-dns), if enabled with WithACMEDNS. It lets
+// ACME clients with acme-dns support (e.g. lego, Certbot and acme.sh) answer
+// DNS-01 challenges with TXT records served by Edena. The API is served under
+// `/api/acme-dns`, which is the URL of the acme-dns server to configure:
+//
+//   - `POST /register` creates a host and returns credentials for it. The
+//     returned `fulldomain` is `_acme-challenge.<hostname>`, which the
+//     `_acme-challenge` record of the domain to obtain certificates for should
+//     be a CNAME of. The `subdomain` is the first label of the hostname.
+//     Restricting updates with `allowfrom` isn't supported.
+//   - `POST /update` sets the TXT record of `fulldomain`, with the username and
+//     password in the `X-Api-User` and `X-Api-Key` headers. Like acme-dns, the
+//     two most recent values are kept, for certificates with both a domain
+//     and its wildcard.
+//   - `GET /health` responds with status 200.
+//
+// Responses and errors have the format of acme-dns, not the API envelope. The
+// username is the host ID, and the password is derived from it with the
+// secret set with WithACMEDNS, so no credentials are stored. Registered hosts
+// never expire, and can only be changed or deleted via the generic API with
+// the admin token (see checkHostManaged). Deleting the host revokes the
+// credentials.
+func (srv *Server) registerACMEDNSRoutes(apiRouter *mux.Router) {
+	if len(srv.acmeDNSSecret) == 0 {
+		return
+	}
+	if _, ok := srv.recordManager.(libdns.RecordGetter); !ok {
+		return
+	}
+
+	apiRouter.Methods("POST").Path("/acme-dns/register").HandlerFunc(srv.RegisterACMEDNS)
+	apiRouter.Methods("POST").Path("/acme-dns/update").HandlerFunc(srv.UpdateACMEDNS)
+	apiRouter.Methods("GET").Path("/acme-dns/health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// RegisterACMEDNS creates a host, and returns acme-dns credentials for it.
+func (srv *Server) RegisterACMEDNS(w http.ResponseWriter, r *http.Request) {
+	var body acmeDNSRegisterRequestBody
+	if apiErr := decodeOptionalJSONBody(w, r, &body); apiErr != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	if len(body.AllowFrom) > 0 {
+		writeACMEDNSError(w, http.StatusBadRequest, "invalid_allowfrom_cidr")
+		return
+	}
+
+	hostList, err := srv.hostsService.CreateHosts(r.Context(), hosts.CreateHostsParams{
+		Amount:  1,
+		ACMEDNS: true,
+	})
+	if errors.Is(err, hosts.ErrMaxHostsReached) {
+		writeACMEDNSError(w, http.StatusForbidden, "max_hosts_reached")
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to create host for acme-dns registration.", zap.Error(err))
+		writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+		return
+	}
+	h := hostList[0]
+
+	srv.provisionHostRecords(r.Context(), hostList)
+
+	writeACMEDNSResponse(w, http.StatusCreated, acmeDNSRegistration{
+		Username:   h.ID.String(),
+		Password:   srv.acmeDNSPassword(h.ID),
+		FullDomain: acmeDNSFullDomain(h.Hostname),
+		Subdomain:  acmeDNSSubdomain(h.Hostname),
+		AllowFrom:  []string{},
+	})
+}
+
+// UpdateACMEDNS sets the TXT record of a host registered with RegisterACMEDNS.
+func (srv *Server) UpdateACMEDNS(w http.ResponseWriter, r *http.Request) {
+	h, ok := srv.authenticateACMEDNS(r)
+	if !ok {
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+
+	var body acmeDNSUpdateRequestBody
+	if apiErr := decodeJSONBody(w, r, &body); apiErr != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	if !strings.EqualFold(body.Subdomain, acmeDNSSubdomain(h.Hostname)) {
+		writeACMEDNSError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+	// Values are the base64url encoded SHA-256 digests of key authorizations.
+	if len(body.TXT) != base64.RawURLEncoding.EncodedLen(sha256.Size) {
+		writeACMEDNSError(w, http.StatusBadRequest, "bad_txt")
+		return
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(body.TXT); err != nil {
+		writeACMEDNSError(w, http.StatusBadRequest, "bad_txt")
+		return
+	}
+
+	if err := srv.setACMEDNSRecord(r.Context(), acmeDNSFullDomain(h.Hostname)+".", body.TXT); err != nil {
+		srv.logger.Error("Failed to update acme-dns TXT record.",
+			zap.String("hostname", h.Hostname),
+			zap.Error(err),
+		)
+		writeACMEDNSError(w, http.StatusInternalServerError, "db_error")
+		return
+	}
+
+	writeACMEDNSResponse(w, http.StatusOK, struct {
+		TXT string `json:"txt"`
+	}{body.TXT})
+}
+
+// setACMEDNSRecord appends a TXT record with `value`, and deletes the oldest
+// TXT records beyond acmeDNSMaxTXTRecords.
+func (srv *Server) setACMEDNSRecord(ctx context.Context, fqdn, value string) error {
+	if _, err := srv.recordManager.AppendRecords(ctx, fqdn, []libdns.Record{{Type: "TXT", Value: value}}); err != nil {
+		return err
+	}
+
+	recs, err := srv.recordManager.(libdns.RecordGetter).GetRecords(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+
+	// Records are kept in the order they were appended.
+	var txtRecs []libdns.Record
+	for _, rec := range recs {
+		if rec.Type == "TXT" {
+			txtRecs = append(txtRecs, rec)
+		}
+	}
+	if len(txtRecs) <= acmeDNSMaxTXTRecords {
+		return nil
+	}
+
+	_, err = srv.recordManager.DeleteRecords(ctx, fqdn, txtRecs[:len(txtRecs)-acmeDNSMaxTXTRecords])
+	return err
+}
+
+// authenticateACMEDNS returns the host of the credentials in the `X-Api-User`
+// and `X-Api-Key` headers.
+func (srv *Server) authenticateACMEDNS(r *http.Request) (hosts.Host, bool) {
+	hostID, err := ulid.Parse(r.Header.Get("X-Api-User"))
+	if err != nil {
+		return hosts.Host{}, false
+	}
+	key := r.Header.Get("X-Api-Key")
+	if subtle.ConstantTimeCompare([]byte(key), []byte(srv.acmeDNSPassword(hostID))) != 1 {
+		return hosts.Host{}, false
+	}
+
+	h, err := srv.hostsService.FindHostByID(r.Context(), hostID)
+	if err != nil {
+		if !errors.Is(err, hosts.ErrHostNotFound) {
+			srv.logger.Error("Failed to find host by ID.", zap.Error(err))
+		}
+		return hosts.Host{}, false
+	}
+
+	return h, true
+}
+
+// checkHostManaged writes an API error and returns false for hosts registered
+// via the acme-dns compatible API, unless the request has the admin token.
+// Their TXT records answer DNS-01 challenges, so whoever can change them (or
+// delete the host) can obtain or prevent certificates for the domains that
+// delegate to it.
+func (srv *Server) checkHostManaged(w http.ResponseWriter, r *http.Request, h hosts.Host) bool {
+	if !h.ACMEDNS || srv.hasAdminToken(r) {
+		return true
+	}
+
+	srv.writeAPIError(w, &APIError{
+		Message:    fmt.Sprintf("Host %q is registered via the acme-dns API, and can only be changed with the admin token.", h.ID),
+		Code:       ErrCodeHostManaged,
+		StatusCode: http.StatusForbidden,
+	})
+	return false
+}
+
+// acmeDNSPassword derives the acme-dns password of a host from its ID.
+func (srv *Server) acmeDNSPassword(hostID ulid.ULID) string {
+	mac := hmac.New(sha256.New, srv.acmeDNSSecret)
+	mac.Write([]byte("acme-dns:" + hostID.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:acmeDNSPasswordLen]
+}
+
+func acmeDNSFullDomain(hostname string) string {
+	return "_acme-challenge." + hostname
+}
+
+func acmeDNSSubdomain(hostname string) string {
+	return strings.SplitN(hostname, ".", 2)[0]
+}
+
+func writeACMEDNSResponse(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeACMEDNSError(w http.ResponseWriter, statusCode int, message string) {
+	writeACMEDNSResponse(w, statusCode, struct {
+		Error string `json:"error"`
+	}{message})
+}
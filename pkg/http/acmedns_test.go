@@ -0,0 +1,355 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// acmeDNSHostsService creates and finds hosts in memory. Methods that aren't
+// implemented panic, via the nil embedded interface.
+type acmeDNSHostsService struct {
+	hosts.Service
+
+	mu    sync.Mutex
+	hosts map[ulid.ULID]hosts.Host
+}
+
+func (svc *acmeDNSHostsService) CreateHosts(_ context.Context, params hosts.CreateHostsParams) ([]hosts.Host, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var created []hosts.Host
+	for i := 0; i < params.Amount; i++ {
+		h := hosts.Host{
+			ID:       ulid.MustNew(ulid.Now(), rand.Reader),
+			Hostname: fmt.Sprintf("host%v.example.com", len(svc.hosts)+1),
+			ACMEDNS:  params.ACMEDNS,
+		}
+		svc.hosts[h.ID] = h
+		created = append(created, h)
+	}
+
+	return created, nil
+}
+
+func (svc *acmeDNSHostsService) FindHostByID(_ context.Context, hostID ulid.ULID) (hosts.Host, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	h, ok := svc.hosts[hostID]
+	if !ok {
+		return hosts.Host{}, hosts.ErrHostNotFound
+	}
+	return h, nil
+}
+
+// testRecordManager keeps records in memory, in the order they were
+// appended.
+type testRecordManager struct {
+	mu   sync.Mutex
+	recs map[string][]libdns.Record
+}
+
+func (rm *testRecordManager) AppendRecords(_ context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.recs[zone] = append(rm.recs[zone], recs...)
+	return recs, nil
+}
+
+func (rm *testRecordManager) DeleteRecords(_ context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var kept, deleted []libdns.Record
+	for _, rec := range rm.recs[zone] {
+		match := false
+		for _, del := range recs {
+			if rec.Type == del.Type && (del.Value == "" || rec.Value == del.Value) {
+				match = true
+				break
+			}
+		}
+		if match {
+			deleted = append(deleted, rec)
+		} else {
+			kept = append(kept, rec)
+		}
+	}
+	rm.recs[zone] = kept
+
+	return deleted, nil
+}
+
+func (rm *testRecordManager) GetRecords(_ context.Context, zone string) ([]libdns.Record, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	return append([]libdns.Record(nil), rm.recs[zone]...), nil
+}
+
+func newACMEDNSTestServer(opts ...ServerOption) (*Server, *testRecordManager) {
+	rm := &testRecordManager{recs: make(map[string][]libdns.Record)}
+	opts = append([]ServerOption{
+		WithHostsService(&acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)}),
+		WithRecordManager(rm),
+		WithACMEDNS([]byte("secret")),
+	}, opts...)
+
+	return NewServer(opts...), rm
+}
+
+// registerACMEDNS registers with the acme-dns compatible API, and fails the
+// test on error.
+func registerACMEDNS(t *testing.T, srv *Server) acmeDNSRegistration {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	srv.RegisterACMEDNS(w, httptest.NewRequest("POST", "/api/acme-dns/register", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+	}
+
+	var reg acmeDNSRegistration
+	if err := json.NewDecoder(w.Body).Decode(&reg); err != nil {
+		t.Fatal(err)
+	}
+
+	return reg
+}
+
+func TestRegisterACMEDNS(t *testing.T) {
+	srv, _ := newACMEDNSTestServer()
+
+	reg := registerACMEDNS(t, srv)
+	if reg.FullDomain != "_acme-challenge.host1.example.com" {
+		t.Errorf("expected full domain `_acme-challenge.host1.example.com`, got %q", reg.FullDomain)
+	}
+	if reg.Subdomain != "host1" {
+		t.Errorf("expected subdomain `host1`, got %q", reg.Subdomain)
+	}
+	if len(reg.Password) != acmeDNSPasswordLen {
+		t.Errorf("expected password of %v characters, got %q", acmeDNSPasswordLen, reg.Password)
+	}
+	if reg.AllowFrom == nil {
+		t.Error("expected empty `allowfrom`, got null")
+	}
+
+	hostID, err := ulid.Parse(reg.Username)
+	if err != nil {
+		t.Fatalf("expected host ID as username, got %q", reg.Username)
+	}
+	h, err := srv.hostsService.FindHostByID(context.Background(), hostID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !h.ACMEDNS {
+		t.Error("expected host to be registered via the acme-dns API")
+	}
+
+	t.Run("allowfrom", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"allowfrom":["192.0.2.0/24"]}`)
+		srv.RegisterACMEDNS(w, httptest.NewRequest("POST", "/api/acme-dns/register", body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %v", w.Code)
+		}
+	})
+}
+
+func TestUpdateACMEDNS(t *testing.T) {
+	srv, rm := newACMEDNSTestServer()
+	reg := registerACMEDNS(t, srv)
+	fqdn := reg.FullDomain + "."
+
+	update := func(subdomain, txt string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(acmeDNSUpdateRequestBody{Subdomain: subdomain, TXT: txt})
+		r := httptest.NewRequest("POST", "/api/acme-dns/update", strings.NewReader(string(body)))
+		r.Header.Set("X-Api-User", reg.Username)
+		r.Header.Set("X-Api-Key", reg.Password)
+		w := httptest.NewRecorder()
+		srv.UpdateACMEDNS(w, r)
+		return w
+	}
+
+	values := []string{
+		strings.Repeat("a", 43),
+		strings.Repeat("b", 43),
+		strings.Repeat("c", 43),
+	}
+	for _, value := range values {
+		if w := update(reg.Subdomain, value); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body)
+		}
+	}
+
+	// The two most recent values are kept.
+	recs, _ := rm.GetRecords(context.Background(), fqdn)
+	if len(recs) != 2 || recs[0].Value != values[1] || recs[1].Value != values[2] {
+		t.Errorf("expected TXT records %v, got %+v", values[1:], recs)
+	}
+
+	tests := []struct {
+		name      string
+		subdomain string
+		txt       string
+		expStatus int
+	}{
+		{
+			name:      "other subdomain",
+			subdomain: "other",
+			txt:       values[0],
+			expStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "short TXT value",
+			subdomain: reg.Subdomain,
+			txt:       "foo",
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "invalid TXT value",
+			subdomain: reg.Subdomain,
+			txt:       strings.Repeat("!", 43),
+			expStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := update(tt.subdomain, tt.txt); w.Code != tt.expStatus {
+				t.Errorf("expected status %v, got %v", tt.expStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthenticateACMEDNS(t *testing.T) {
+	srv, _ := newACMEDNSTestServer()
+	reg := registerACMEDNS(t, srv)
+
+	otherSrv, _ := newACMEDNSTestServer(WithACMEDNS([]byte("other secret")))
+	unknownID := ulid.MustNew(ulid.Now(), rand.Reader)
+
+	tests := []struct {
+		name  string
+		user  string
+		key   string
+		expOK bool
+	}{
+		{
+			name:  "valid credentials",
+			user:  reg.Username,
+			key:   reg.Password,
+			expOK: true,
+		},
+		{
+			name: "wrong password",
+			user: reg.Username,
+			key:  strings.Repeat("x", acmeDNSPasswordLen),
+		},
+		{
+			name: "missing password",
+			user: reg.Username,
+		},
+		{
+			name: "malformed username",
+			user: "foo",
+			key:  reg.Password,
+		},
+		{
+			name: "password of other secret",
+			user: reg.Username,
+			key:  otherSrv.acmeDNSPassword(ulid.MustParse(reg.Username)),
+		},
+		{
+			name: "unknown host",
+			user: unknownID.String(),
+			key:  srv.acmeDNSPassword(unknownID),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/acme-dns/update", nil)
+			r.Header.Set("X-Api-User", tt.user)
+			r.Header.Set("X-Api-Key", tt.key)
+
+			h, ok := srv.authenticateACMEDNS(r)
+			if ok != tt.expOK {
+				t.Fatalf("expected %v, got %v", tt.expOK, ok)
+			}
+			if ok && h.ID.String() != reg.Username {
+				t.Errorf("expected host %v, got %v", reg.Username, h.ID)
+			}
+		})
+	}
+}
+
+func TestCheckHostManaged(t *testing.T) {
+	srv, _ := newACMEDNSTestServer(WithAdminToken("admin"))
+
+	tests := []struct {
+		name      string
+		acmeDNS   bool
+		token     string
+		expOK     bool
+		expStatus int
+	}{
+		{
+			name:  "generic host",
+			expOK: true,
+		},
+		{
+			name:      "acme-dns host",
+			acmeDNS:   true,
+			expStatus: http.StatusForbidden,
+		},
+		{
+			name:      "acme-dns host with wrong token",
+			acmeDNS:   true,
+			token:     "foo",
+			expStatus: http.StatusForbidden,
+		},
+		{
+			name:    "acme-dns host with admin token",
+			acmeDNS: true,
+			token:   "admin",
+			expOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/api/hosts/foo", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			ok := srv.checkHostManaged(w, r, hosts.Host{Hostname: "abc.example.com", ACMEDNS: tt.acmeDNS})
+			if ok != tt.expOK {
+				t.Fatalf("expected %v, got %v", tt.expOK, ok)
+			}
+			if !ok && w.Code != tt.expStatus {
+				t.Errorf("expected status %v, got %v", tt.expStatus, w.Code)
+			}
+			if !ok && !strings.Contains(w.Body.String(), ErrCodeHostManaged) {
+				t.Errorf("expected error code %q, got %s", ErrCodeHostManaged, w.Body)
+			}
+		})
+	}
+}
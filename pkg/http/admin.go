@@ -0,0 +1,32 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// RequireAdminToken only calls `h` for requests with the admin token set
+// with WithAdminToken as bearer token.
+func (srv *Server) RequireAdminToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.hasAdminToken(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="edena"`)
+			srv.writeAPIError(w, &APIError{
+				Message:    "A valid admin token is required.",
+				Code:       ErrCodeUnauthorized,
+				StatusCode: http.StatusUnauthorized,
+			})
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// hasAdminToken reports whether the request has the admin token set with
+// WithAdminToken as bearer token.
+func (srv *Server) hasAdminToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return srv.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(srv.adminToken)) == 1
+}
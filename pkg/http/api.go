@@ -11,8 +11,65 @@ type APIResponse struct {
 	StatusCode int         `json:"-"`
 }
 
+// Error codes of API errors, so clients can handle errors without parsing
+// messages. Codes are stable; messages may change.
+const (
+	// ErrCodeInternal is used for unexpected server errors.
+	ErrCodeInternal = "internal_error"
+	// ErrCodeInvalidRequest is used when a request body, path parameter or
+	// query parameter can't be parsed.
+	ErrCodeInvalidRequest = "invalid_request"
+	// ErrCodeValidation is used when a request is well-formed, but has
+	// invalid values.
+	ErrCodeValidation = "validation_error"
+	// ErrCodeMaxHostsReached is used when creating hosts would exceed the
+	// maximum amount of hosts.
+	ErrCodeMaxHostsReached = "max_hosts_reached"
+	// ErrCodeHostNotFound is used when a host doesn't exist.
+	ErrCodeHostNotFound = "host_not_found"
+	// ErrCodeHostnameTaken is used when importing a host whose hostname is
+	// used by another host.
+	ErrCodeHostnameTaken = "hostname_taken"
+	// ErrCodeHostIDTaken is used when importing a host whose ID is used by a
+	// host with another hostname.
+	ErrCodeHostIDTaken = "host_id_taken"
+	// ErrCodeHTTPLogEntryNotFound is used when an HTTP log entry doesn't
+	// exist.
+	ErrCodeHTTPLogEntryNotFound = "http_log_entry_not_found"
+	// ErrCodeRecordExists is used when creating a DNS record that already
+	// exists.
+	ErrCodeRecordExists = "record_exists"
+	// ErrCodeRecordNotFound is used when no DNS records match.
+	ErrCodeRecordNotFound = "record_not_found"
+	// ErrCodeReplayTargetNotAllowed is used when replaying a request to a
+	// target outside of the allowed networks.
+	ErrCodeReplayTargetNotAllowed = "replay_target_not_allowed"
+	// ErrCodeReplayTimeout is used when replaying a request times out.
+	ErrCodeReplayTimeout = "replay_timeout"
+	// ErrCodeReplayFailed is used when replaying a request fails, e.g.
+	// because the target can't be reached.
+	ErrCodeReplayFailed = "replay_failed"
+	// ErrCodeBodyDropped is used when requesting a body of an HTTP log entry
+	// that was dropped, see hosts.BodyRetention.
+	ErrCodeBodyDropped = "body_dropped"
+	// ErrCodeUnauthorized is used when an admin endpoint is requested without
+	// a valid admin token.
+	ErrCodeUnauthorized = "unauthorized"
+	// ErrCodeHostManaged is used when changing or deleting a host registered
+	// via the acme-dns compatible API without a valid admin token.
+	ErrCodeHostManaged = "host_managed"
+	// ErrCodeIdempotencyKeyInUse is used when a request with the same
+	// idempotency key is still being handled.
+	ErrCodeIdempotencyKeyInUse = "idempotency_key_in_use"
+	// ErrCodeIdempotencyKeyReused is used when an idempotency key was used
+	// for a request with different parameters.
+	ErrCodeIdempotencyKeyReused = "idempotency_key_reused"
+)
+
 type APIError struct {
-	Message    string `json:"message"`
+	Message string `json:"message"`
+	// Code is a machine-readable error code, see the ErrCode constants.
+	Code       string `json:"code"`
 	StatusCode int    `json:"-"`
 	Err        error  `json:"-"`
 }
@@ -25,22 +82,37 @@ func (e *APIError) Unwrap() error {
 	return e.Err
 }
 
-func writeAPIError(w http.ResponseWriter, err *APIError) {
-	writeAPIResponse(w, APIResponse{
+func (srv *Server) writeAPIError(w http.ResponseWriter, err *APIError) {
+	srv.writeAPIResponse(w, APIResponse{
 		Error:      err,
 		StatusCode: err.StatusCode,
 	})
 }
 
-func writeAPIResponse(w http.ResponseWriter, res APIResponse) {
+func (srv *Server) writeAPIResponse(w http.ResponseWriter, res APIResponse) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(res.StatusCode)
-	_ = json.NewEncoder(w).Encode(res)
+
+	// Without envelope, the data or error is written at the top level, and
+	// errors are distinguished by their status code.
+	var v interface{} = res
+	if srv.apiEnvelopeDisabled {
+		v = res.Data
+		if res.Error != nil {
+			v = res.Error
+		}
+		if v == nil {
+			return
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(v)
 }
 
 func (srv *Server) handleInternalError(w http.ResponseWriter) {
-	writeAPIError(w, &APIError{
+	srv.writeAPIError(w, &APIError{
 		Message:    "Internal server error. Please try again.",
+		Code:       ErrCodeInternal,
 		StatusCode: http.StatusInternalServerError,
 	})
 }
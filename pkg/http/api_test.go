@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestAPIEnvelope(t *testing.T) {
+	svc := &acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)}
+	created, err := svc.CreateHosts(context.Background(), hosts.CreateHostsParams{Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := created[0]
+
+	tests := []struct {
+		name      string
+		envelope  bool
+		hostID    ulid.ULID
+		expStatus int
+		expCode   string
+	}{
+		{
+			name:      "data with envelope",
+			envelope:  true,
+			hostID:    h.ID,
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "data without envelope",
+			hostID:    h.ID,
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "error with envelope",
+			envelope:  true,
+			hostID:    ulid.MustNew(ulid.Now(), rand.Reader),
+			expStatus: http.StatusNotFound,
+			expCode:   ErrCodeHostNotFound,
+		},
+		{
+			name:      "error without envelope",
+			hostID:    ulid.MustNew(ulid.Now(), rand.Reader),
+			expStatus: http.StatusNotFound,
+			expCode:   ErrCodeHostNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(WithHostsService(svc), WithAPIEnvelope(tt.envelope))
+
+			r := httptest.NewRequest("GET", "/api/hosts/"+tt.hostID.String(), nil)
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			if w.Code != tt.expStatus {
+				t.Fatalf("expected status %v, got %v: %s", tt.expStatus, w.Code, w.Body)
+			}
+
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			// Without envelope, the data or error is the top level object.
+			raw := w.Body.Bytes()
+			if tt.envelope {
+				key := "data"
+				if tt.expCode != "" {
+					key = "error"
+				}
+				if len(fields) != 1 || fields[key] == nil {
+					t.Fatalf("expected envelope with only %q, got %s", key, w.Body)
+				}
+				raw = fields[key]
+			} else if fields["data"] != nil || fields["error"] != nil {
+				t.Fatalf("expected response without envelope, got %s", w.Body)
+			}
+
+			if tt.expCode != "" {
+				var apiErr APIError
+				if err := json.Unmarshal(raw, &apiErr); err != nil {
+					t.Fatal(err)
+				}
+				if apiErr.Code != tt.expCode {
+					t.Errorf("expected error code %q, got %q", tt.expCode, apiErr.Code)
+				}
+				return
+			}
+
+			var got host
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != h.ID {
+				t.Errorf("expected host ID %v, got %v", h.ID, got.ID)
+			}
+			if got.Hostname != h.Hostname {
+				t.Errorf("expected hostname %q, got %q", h.Hostname, got.Hostname)
+			}
+		})
+	}
+}
+
+func TestWriteAPIResponseWithoutData(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope bool
+		expBody  string
+	}{
+		{name: "with envelope", envelope: true, expBody: "{}\n"},
+		{name: "without envelope", expBody: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(WithAPIEnvelope(tt.envelope))
+
+			w := httptest.NewRecorder()
+			srv.writeAPIResponse(w, APIResponse{StatusCode: http.StatusOK})
+
+			if w.Code != http.StatusOK {
+				t.Errorf("expected status %v, got %v", http.StatusOK, w.Code)
+			}
+			if got := w.Body.String(); got != tt.expBody {
+				t.Errorf("expected body %q, got %q", tt.expBody, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Backuper creates database backups, e.g. badger.Database.
+type Backuper interface {
+	Backup(w io.Writer) error
+}
+
+// BackupDatabase streams a backup of the database as a downloadable file.
+//
+// A backup can take longer than the write timeout of the server, so on HTTP/1.x
+// connections the write deadline is extended on every write instead. Streams
+// of HTTP/2 connections have a fixed write timeout, so large backups should be
+// downloaded over HTTP/1.1 (like `edena db backup` does).
+func (srv *Server) BackupDatabase(w http.ResponseWriter, r *http.Request) {
+	filename := fmt.Sprintf("edena-%v.bak", time.Now().UTC().Format("20060102T150405Z"))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, filename))
+
+	var bw io.Writer = w
+	if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok && r.ProtoMajor == 1 && srv.timeouts.Write > 0 {
+		bw = &deadlineWriter{w: w, conn: conn, timeout: srv.timeouts.Write}
+	}
+
+	err := srv.backuper.Backup(bw)
+	if err != nil {
+		// Headers (and possibly part of the body) are already written, so the
+		// error can only be logged.
+		srv.logger.Error("Failed to backup database.", zap.Error(err))
+		return
+	}
+
+	srv.logger.Info("Created database backup.")
+}
+
+// deadlineWriter extends the write deadline of a connection before every
+// write, so the write timeout applies to each write instead of the response as
+// a whole.
+type deadlineWriter struct {
+	w       io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if err := dw.conn.SetWriteDeadline(time.Now().Add(dw.timeout)); err != nil {
+		return 0, err
+	}
+	return dw.w.Write(p)
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowBackuper writes `chunks` chunks of `size` bytes, waiting `delay` before
+// each chunk.
+type slowBackuper struct {
+	chunks int
+	size   int
+	delay  time.Duration
+}
+
+func (b slowBackuper) Backup(w io.Writer) error {
+	chunk := bytes.Repeat([]byte("x"), b.size)
+	for i := 0; i < b.chunks; i++ {
+		time.Sleep(b.delay)
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serveBackupTest(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{
+		Handler:      srv.APIHandler(),
+		WriteTimeout: srv.timeouts.Write,
+		ConnContext:  connContext,
+	}
+	go httpServer.Serve(ln)
+	t.Cleanup(func() { httpServer.Close() })
+
+	return "http://" + ln.Addr().String() + "/api/db/backup"
+}
+
+func TestBackupDatabaseRequiresAdminToken(t *testing.T) {
+	backuper := slowBackuper{chunks: 1, size: 10}
+
+	t.Run("without admin token configured", func(t *testing.T) {
+		url := serveBackupTest(t, NewServer(WithBackuper(backuper)))
+
+		res, err := http.Post(url, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %v", res.StatusCode)
+		}
+	})
+
+	t.Run("with invalid admin token", func(t *testing.T) {
+		url := serveBackupTest(t, NewServer(WithBackuper(backuper), WithAdminToken("secret")))
+
+		req, _ := http.NewRequest(http.MethodPost, url, nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %v", res.StatusCode)
+		}
+	})
+}
+
+func TestBackupDatabaseExceedsWriteTimeout(t *testing.T) {
+	// The backup takes about 5 times the write timeout, but every write is
+	// within it.
+	backuper := slowBackuper{chunks: 10, size: 64 << 10, delay: 50 * time.Millisecond}
+	srv := NewServer(
+		WithBackuper(backuper),
+		WithAdminToken("secret"),
+		WithTimeouts(Timeouts{Write: 100 * time.Millisecond}),
+	)
+	url := serveBackupTest(t, srv)
+
+	req, _ := http.NewRequest(http.MethodPost, url, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading backup: %v", err)
+	}
+	if exp := backuper.chunks * backuper.size; len(body) != exp {
+		t.Errorf("expected backup of %v bytes, got %v", exp, len(body))
+	}
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMultipartMemory is the max amount of bytes of a multipart body that are
+// kept in memory while parsing. Remaining file parts are ignored.
+const maxMultipartMemory = 32 << 20
+
+// parseBody returns a structured representation of a request body, for form
+// and JSON content types. For other content types, nil values are returned.
+// File parts of multipart forms are omitted.
+func parseBody(contentType string, body []byte) (url.Values, interface{}, error) {
+	if contentType == "" || len(body) == 0 {
+		return nil, nil, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid content type: %w", err)
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid form: %w", err)
+		}
+		return form, nil, nil
+	case mediaType == "multipart/form-data":
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, nil, errors.New("invalid multipart form: missing boundary")
+		}
+		form, err := multipart.NewReader(bytes.NewReader(body), boundary).ReadForm(maxMultipartMemory)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		defer form.RemoveAll()
+		return url.Values(form.Value), nil, nil
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return nil, v, nil
+	}
+
+	return nil, nil, nil
+}
+
+// isTextBody reports whether a body can be rendered as text, so clients know
+// when to fall back to a hex view. The content type is sniffed (ignoring the
+// declared `Content-Type`, which can't be trusted for captured traffic), and
+// the whole body must be valid UTF-8 without control characters other than
+// whitespace. An empty body is considered text.
+func isTextBody(body []byte) bool {
+	if len(body) == 0 {
+		return true
+	}
+	if !strings.HasPrefix(http.DetectContentType(body), "text/") {
+		return false
+	}
+	if !utf8.Valid(body) {
+		return false
+	}
+
+	for _, r := range string(body) {
+		switch {
+		case r == '\t', r == '\n', r == '\r', r == '\f':
+		case r < 0x20, r == 0x7f:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestIsTextBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		exp  bool
+	}{
+		{name: "empty", body: nil, exp: true},
+		{name: "plain text", body: []byte("foo=bar\r\n\tbaz"), exp: true},
+		{name: "JSON", body: []byte(`{"foo":"bar"}`), exp: true},
+		{name: "HTML", body: []byte("<!DOCTYPE html><html></html>"), exp: true},
+		{name: "UTF-8", body: []byte("bücher ✓"), exp: true},
+		{name: "control character", body: []byte("foo\x1bbar"), exp: false},
+		{name: "NUL byte", body: []byte("foo\x00bar"), exp: false},
+		{name: "invalid UTF-8", body: []byte("foo\xffbar"), exp: false},
+		{name: "PNG", body: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), exp: false},
+		{name: "gzip", body: []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), exp: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTextBody(tt.body); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestParseHTTPLogEntryBodyIsText(t *testing.T) {
+	entry := hosts.HTTPLogEntry{
+		RawRequest: []byte("POST / HTTP/1.1\r\nHost: abc.example.com\r\nContent-Length: 4\r\n\r\n" +
+			"\x00\x01\x02\x03"),
+		RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"),
+	}
+
+	parsed, err := parseHTTPLogEntry(entry, maxInlineBodySize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Request.BodyIsText {
+		t.Error("expected binary request body not to be text")
+	}
+	if !parsed.Response.BodyIsText {
+		t.Error("expected response body to be text")
+	}
+}
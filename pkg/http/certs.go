@@ -0,0 +1,201 @@
+package http
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// CertificateMonitor reports the status of certificates managed by certmagic,
+// for the TLS status API endpoint. Errors are tracked via the certmagic
+// logger, see WrapCore, and successful renewals via OnEvent.
+type CertificateMonitor struct {
+	config  *certmagic.Config
+	domains []string
+
+	mu           sync.Mutex
+	lastError    *certificateError
+	lastObtained time.Time
+}
+
+type certificateError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type tlsStatus struct {
+	Domains        []domainStatus    `json:"domains"`
+	LastError      *certificateError `json:"lastError"`
+	LastObtainedAt *time.Time        `json:"lastObtainedAt"`
+}
+
+type domainStatus struct {
+	Name        string             `json:"name"`
+	Certificate *certificateStatus `json:"certificate"`
+}
+
+type certificateStatus struct {
+	Names     []string  `json:"names"`
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Expired   bool      `json:"expired"`
+}
+
+// NewCertificateMonitor returns a CertificateMonitor for `domains`, managed by
+// `cfg`.
+func NewCertificateMonitor(cfg *certmagic.Config, domains []string) *CertificateMonitor {
+	return &CertificateMonitor{
+		config:  cfg,
+		domains: domains,
+	}
+}
+
+// WrapCore wraps the core of a certmagic logger, so that logged errors are
+// reported as last error. Use it with `zap.WrapCore`.
+func (m *CertificateMonitor) WrapCore(core zapcore.Core) zapcore.Core {
+	return &certificateErrorCore{Core: core, monitor: m}
+}
+
+// OnEvent handles certmagic events, see certmagic.Config.OnEvent. Obtained and
+// renewed certificates clear the last error.
+func (m *CertificateMonitor) OnEvent(event string, data interface{}) {
+	if event != "cert_obtained" && event != "cert_renewed" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastError = nil
+	m.lastObtained = time.Now().UTC()
+}
+
+func (m *CertificateMonitor) setError(msg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastError = &certificateError{
+		Message: msg,
+		Time:    time.Now().UTC(),
+	}
+}
+
+func (m *CertificateMonitor) status() (tlsStatus, error) {
+	status := tlsStatus{
+		Domains: make([]domainStatus, len(m.domains)),
+	}
+
+	for i, domain := range m.domains {
+		cert, err := m.loadCertificate(domain)
+		if err != nil {
+			return tlsStatus{}, err
+		}
+		status.Domains[i] = domainStatus{
+			Name:        domain,
+			Certificate: cert,
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status.LastError = m.lastError
+	if !m.lastObtained.IsZero() {
+		lastObtained := m.lastObtained
+		status.LastObtainedAt = &lastObtained
+	}
+
+	return status, nil
+}
+
+// loadCertificate loads the certificate for `domain` of the first issuer that
+// has one in storage. If none of the issuers have one, nil is returned.
+func (m *CertificateMonitor) loadCertificate(domain string) (*certificateStatus, error) {
+	for _, issuer := range m.config.Issuers {
+		certPEM, err := m.config.Storage.Load(certmagic.StorageKeys.SiteCert(issuer.IssuerKey(), domain))
+		var errNotExist certmagic.ErrNotExist
+		if errors.As(err, &errNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate from storage: %w", err)
+		}
+
+		block, _ := pem.Decode(certPEM)
+		if block == nil {
+			return nil, fmt.Errorf("failed to decode certificate PEM for %q", domain)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+
+		return &certificateStatus{
+			Names:     cert.DNSNames,
+			Issuer:    cert.Issuer.String(),
+			NotBefore: cert.NotBefore.UTC(),
+			NotAfter:  cert.NotAfter.UTC(),
+			Expired:   time.Now().After(cert.NotAfter),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// certificateErrorCore is a zapcore.Core that reports error level entries to a
+// CertificateMonitor.
+type certificateErrorCore struct {
+	zapcore.Core
+	monitor *CertificateMonitor
+	fields  []zapcore.Field
+}
+
+func (c *certificateErrorCore) With(fields []zapcore.Field) zapcore.Core {
+	return &certificateErrorCore{
+		Core:    c.Core.With(fields),
+		monitor: c.monitor,
+		fields:  append(append([]zapcore.Field(nil), c.fields...), fields...),
+	}
+}
+
+func (c *certificateErrorCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level >= zapcore.ErrorLevel {
+		ce = ce.AddCore(entry, c)
+	}
+	return c.Core.Check(entry, ce)
+}
+
+// Write only reports entries to the monitor; they're written by the wrapped
+// core, which adds itself to the checked entry.
+func (c *certificateErrorCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	msg := entry.Message
+	for _, field := range append(c.fields, fields...) {
+		if err, ok := field.Interface.(error); ok && field.Type == zapcore.ErrorType {
+			msg = fmt.Sprintf("%v: %v", msg, err)
+		}
+	}
+	c.monitor.setError(msg)
+
+	return nil
+}
+
+// TLSStatus writes the status of managed certificates.
+func (srv *Server) TLSStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := srv.certMonitor.status()
+	if err != nil {
+		srv.logger.Error("Failed to get TLS status.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       status,
+	})
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+)
+
+type clientCertificate struct {
+	Subject        string    `json:"subject"`
+	Issuer         string    `json:"issuer"`
+	SerialNumber   string    `json:"serialNumber"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	DNSNames       []string  `json:"dnsNames"`
+	EmailAddresses []string  `json:"emailAddresses"`
+	IPAddresses    []string  `json:"ipAddresses"`
+	URIs           []string  `json:"uris"`
+	// SHA256 is the hex encoded SHA-256 fingerprint of the certificate.
+	SHA256     string `json:"sha256"`
+	ParseError string `json:"parseError,omitempty"`
+	Raw        []byte `json:"raw"`
+}
+
+// requestClientCertificates returns a copy of `tlsConfig` that requests (but
+// doesn't verify) client certificates during TLS handshakes, if enabled.
+func (srv *Server) requestClientCertificates(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil || !srv.clientCerts {
+		return tlsConfig
+	}
+
+	cfg := tlsConfig.Clone()
+	cfg.ClientAuth = tls.RequestClientCert
+
+	return cfg
+}
+
+// parseClientCertificate parses a DER encoded certificate. A certificate that
+// can't be parsed (e.g. because it's malformed) is returned with its
+// fingerprint and parse error, rather than failing the log entry as a whole.
+func parseClientCertificate(raw []byte) clientCertificate {
+	sum := sha256.Sum256(raw)
+	c := clientCertificate{
+		SHA256: hex.EncodeToString(sum[:]),
+		Raw:    raw,
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		c.ParseError = err.Error()
+		return c
+	}
+
+	c.Subject = cert.Subject.String()
+	c.Issuer = cert.Issuer.String()
+	c.SerialNumber = cert.SerialNumber.String()
+	c.NotBefore = cert.NotBefore.UTC()
+	c.NotAfter = cert.NotAfter.UTC()
+	c.DNSNames = cert.DNSNames
+	c.EmailAddresses = cert.EmailAddresses
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		c.URIs = append(c.URIs, uri.String())
+	}
+
+	return c
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	const logsURL = "/api/http-logs?hostId=01ARZ3NDEKTSV4RRFFQ69G5FAV"
+
+	tests := []struct {
+		name      string
+		origins   []string
+		method    string
+		origin    string
+		preflight bool
+		expStatus int
+		expOrigin string
+	}{
+		{
+			name:      "preflight from allowed origin",
+			origins:   []string{"https://dashboard.example.com"},
+			method:    "OPTIONS",
+			origin:    "https://dashboard.example.com",
+			preflight: true,
+			expStatus: http.StatusNoContent,
+			expOrigin: "https://dashboard.example.com",
+		},
+		{
+			name:      "preflight from any origin",
+			origins:   []string{"*"},
+			method:    "OPTIONS",
+			origin:    "https://dashboard.example.com",
+			preflight: true,
+			expStatus: http.StatusNoContent,
+			expOrigin: "https://dashboard.example.com",
+		},
+		{
+			name:      "preflight from other origin",
+			origins:   []string{"https://dashboard.example.com"},
+			method:    "OPTIONS",
+			origin:    "https://evil.example.org",
+			expStatus: http.StatusNoContent,
+		},
+		{
+			name:      "request from allowed origin",
+			origins:   []string{"https://dashboard.example.com"},
+			method:    "GET",
+			origin:    "https://DASHBOARD.example.com",
+			expStatus: http.StatusOK,
+			expOrigin: "https://DASHBOARD.example.com",
+		},
+		{
+			name:      "request from other origin",
+			origins:   []string{"https://dashboard.example.com"},
+			method:    "GET",
+			origin:    "https://evil.example.org",
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "request without origin",
+			origins:   []string{"https://dashboard.example.com"},
+			method:    "GET",
+			expStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(WithHostsService(&logsHostsService{}), WithCORS(tt.origins))
+
+			r := httptest.NewRequest(tt.method, logsURL, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == "OPTIONS" {
+				r.Header.Set("Access-Control-Request-Method", "GET")
+				r.Header.Set("Access-Control-Request-Headers", "authorization")
+			}
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			if w.Code != tt.expStatus {
+				t.Errorf("expected status %v, got %v", tt.expStatus, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.expOrigin {
+				t.Errorf("expected allowed origin %q, got %q", tt.expOrigin, got)
+			}
+
+			allowHeaders := w.Header().Get("Access-Control-Allow-Headers")
+			if !tt.preflight {
+				if allowHeaders != "" {
+					t.Errorf("expected no allowed headers, got %q", allowHeaders)
+				}
+				return
+			}
+			if !strings.Contains(allowHeaders, "Authorization") {
+				t.Errorf("expected Authorization to be an allowed header, got %q", allowHeaders)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "PATCH") {
+				t.Errorf("expected PATCH to be an allowed method, got %q", got)
+			}
+		})
+	}
+}
+
+func TestCORSCaptureRoutes(t *testing.T) {
+	srv := NewServer(WithHostsService(&testHostsService{}), WithCORS([]string{"*"}))
+
+	r := httptest.NewRequest("GET", "http://abc.example.com/api/http-logs", nil)
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, r)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no CORS headers on captured requests, got allowed origin %q", got)
+	}
+}
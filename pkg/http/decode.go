@@ -0,0 +1,63 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxJSONBodySize is the max size of JSON request bodies of the API.
+const maxJSONBodySize = 64 << 10
+
+// decodeJSONBody strictly decodes a JSON request body into `v`: bodies larger
+// than maxJSONBodySize, unknown fields and data after the JSON value are
+// rejected, so typos and garbage don't go unnoticed.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) *APIError {
+	return decodeJSON(w, r, v, false)
+}
+
+// decodeOptionalJSONBody is like decodeJSONBody, but an empty body is allowed,
+// in which case `v` is left unchanged.
+func decodeOptionalJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) *APIError {
+	return decodeJSON(w, r, v, true)
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) *APIError {
+	invalid := func(msg string, err error) *APIError {
+		return &APIError{
+			Message:    msg,
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	if err != nil && len(body) >= maxJSONBodySize {
+		return invalid(fmt.Sprintf("Request body cannot be larger than %v bytes.", maxJSONBodySize), err)
+	}
+	if err != nil {
+		return invalid("Failed to read request body.", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if optional {
+			return nil
+		}
+		return invalid("Request body cannot be empty.", io.EOF)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return invalid(fmt.Sprintf("Failed to parse request body: %v", err), err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return invalid("Request body must contain a single JSON value.", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestCreateHostsInvalidBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		expStatus int
+	}{
+		{
+			name:      "valid",
+			body:      `{"amount":1}`,
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "valid with trailing whitespace",
+			body:      "{\"amount\":1}\n",
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "empty",
+			body:      "",
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "oversized",
+			body:      `{"amount":1,"label":"` + strings.Repeat("a", maxJSONBodySize) + `"}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "unknown field",
+			body:      `{"amount":1,"amonut":2}`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "trailing garbage",
+			body:      `{"amount":1}garbage`,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "multiple values",
+			body:      `{"amount":1}{"amount":2}`,
+			expStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)}
+			srv := NewServer(WithHostsService(svc))
+
+			r := httptest.NewRequest("POST", "/api/hosts", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			if w.Code != tt.expStatus {
+				t.Fatalf("expected status %v, got %v: %s", tt.expStatus, w.Code, w.Body)
+			}
+			if tt.expStatus == http.StatusCreated {
+				return
+			}
+
+			var res struct {
+				Error APIError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error.Code != ErrCodeInvalidRequest {
+				t.Errorf("expected error code %q, got %q", ErrCodeInvalidRequest, res.Error.Code)
+			}
+			if len(svc.hosts) != 0 {
+				t.Errorf("expected no hosts to be created, got %v", len(svc.hosts))
+			}
+		})
+	}
+}
+
+func TestDecodeJSONBodyEndpoints(t *testing.T) {
+	srv, _ := newACMEDNSTestServer(WithReplayAllow([]net.IPNet{{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Mask: net.CIDRMask(32, 32),
+	}}))
+
+	created, err := srv.hostsService.CreateHosts(context.Background(), hosts.CreateHostsParams{Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostID := created[0].ID
+	logEntryID := ulid.MustNew(ulid.Now(), rand.Reader)
+
+	endpoints := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{
+			name:   "update host response",
+			method: "PATCH",
+			path:   "/api/hosts/" + hostID.String() + "/response",
+			body:   `{"delayMs":1`,
+		},
+		{
+			name:   "create record",
+			method: "POST",
+			path:   "/api/hosts/" + hostID.String() + "/records",
+			body:   `{"type":"TXT","value":"foo"`,
+		},
+		{
+			name:   "replay HTTP log entry",
+			method: "POST",
+			path:   "/api/http-logs/" + logEntryID.String() + "/replay",
+			body:   `{"url":"http://127.0.0.1/"`,
+		},
+	}
+
+	bodies := []struct {
+		name   string
+		suffix string
+	}{
+		{name: "oversized", suffix: `,"foo":"` + strings.Repeat("a", maxJSONBodySize) + `"}`},
+		{name: "unknown field", suffix: `,"foo":1}`},
+		{name: "trailing garbage", suffix: `}garbage`},
+		{name: "multiple values", suffix: `}{}`},
+	}
+
+	for _, ep := range endpoints {
+		for _, body := range bodies {
+			t.Run(ep.name+"/"+body.name, func(t *testing.T) {
+				r := httptest.NewRequest(ep.method, ep.path, strings.NewReader(ep.body+body.suffix))
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				srv.APIHandler().ServeHTTP(w, r)
+
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("expected status %v, got %v: %s", http.StatusBadRequest, w.Code, w.Body)
+				}
+
+				var res struct {
+					Error APIError `json:"error"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Fatal(err)
+				}
+				if res.Error.Code != ErrCodeInvalidRequest {
+					t.Errorf("expected error code %q, got %q", ErrCodeInvalidRequest, res.Error.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeJSONBodyACMEDNS(t *testing.T) {
+	srv, _ := newACMEDNSTestServer()
+
+	t.Run("register without body", func(t *testing.T) {
+		registerACMEDNS(t, srv)
+	})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{
+			name: "register with unknown field",
+			path: "/api/acme-dns/register",
+			body: `{"allowfrom":[],"foo":1}`,
+		},
+		{
+			name: "update with unknown field",
+			path: "/api/acme-dns/update",
+			body: `{"subdomain":"foo","txt":"bar","foo":1}`,
+		},
+		{
+			name: "update with trailing garbage",
+			path: "/api/acme-dns/update",
+			body: `{"subdomain":"foo","txt":"bar"}garbage`,
+		},
+		{
+			name: "update without body",
+			path: "/api/acme-dns/update",
+		},
+	}
+
+	reg := registerACMEDNS(t, srv)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			r.Header.Set("X-Api-User", reg.Username)
+			r.Header.Set("X-Api-Key", reg.Password)
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %v, got %v: %s", http.StatusBadRequest, w.Code, w.Body)
+			}
+
+			var res struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error != "malformed_json_payload" {
+				t.Errorf("expected error %q, got %q", "malformed_json_payload", res.Error)
+			}
+		})
+	}
+}
+
+func TestDecodeOptionalJSONBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		expURL string
+		expErr bool
+	}{
+		{name: "empty", body: "", expURL: "http://example.com/"},
+		{name: "whitespace", body: " \n", expURL: "http://example.com/"},
+		{name: "value", body: `{"url":"http://example.org/"}`, expURL: "http://example.org/"},
+		{name: "unknown field", body: `{"foo":1}`, expURL: "http://example.com/", expErr: true},
+		{name: "garbage", body: "garbage", expURL: "http://example.com/", expErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := replayRequestBody{URL: "http://example.com/"}
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			apiErr := decodeOptionalJSONBody(w, r, &body)
+			if (apiErr != nil) != tt.expErr {
+				t.Fatalf("expected error %v, got %v", tt.expErr, apiErr)
+			}
+			if body.URL != tt.expURL {
+				t.Errorf("expected URL %q, got %q", tt.expURL, body.URL)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+type dnsLogEntry struct {
+	ID           ulid.ULID   `json:"id"`
+	HostID       ulid.ULID   `json:"hostId"`
+	RemoteAddr   string      `json:"remoteAddr"`
+	ClientSubnet string      `json:"clientSubnet"`
+	Query        dnsQuery    `json:"query"`
+	Response     dnsResponse `json:"response"`
+	CreatedAt    time.Time   `json:"createdAt"`
+}
+
+type dnsQuery struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Raw  []byte `json:"raw"`
+}
+
+type dnsResponse struct {
+	Rcode   string   `json:"rcode"`
+	Answers []string `json:"answers"`
+	Raw     []byte   `json:"raw"`
+}
+
+func (srv *Server) ListDNSLogEntries(w http.ResponseWriter, r *http.Request) {
+	hostIDs, apiErr := parseHostIDs(r.URL.Query()["hostId"])
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	params := hosts.ListDNSLogEntriesParams{
+		HostIDs: hostIDs,
+	}
+
+	logEntries, err := srv.hostsService.ListDNSLogEntries(r.Context(), params)
+	if err != nil {
+		srv.logger.Error("Failed to list DNS logs.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	data := make([]dnsLogEntry, len(logEntries))
+	for i, logEntry := range logEntries {
+		l, err := parseDNSLogEntry(logEntry)
+		if err != nil {
+			srv.logger.Error("Failed to parse DNS log entry.", zap.Error(err))
+			srv.handleInternalError(w)
+			return
+		}
+		data[i] = l
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       data,
+	})
+}
+
+func parseDNSLogEntry(log hosts.DNSLogEntry) (dnsLogEntry, error) {
+	query := &dns.Msg{}
+	if err := query.Unpack(log.RawQuery); err != nil {
+		return dnsLogEntry{}, fmt.Errorf("failed to unpack query: %w", err)
+	}
+	if len(query.Question) == 0 {
+		return dnsLogEntry{}, fmt.Errorf("query has no question")
+	}
+
+	res := &dns.Msg{}
+	if len(log.RawResponse) > 0 {
+		if err := res.Unpack(log.RawResponse); err != nil {
+			return dnsLogEntry{}, fmt.Errorf("failed to unpack response: %w", err)
+		}
+	}
+
+	answers := make([]string, len(res.Answer))
+	for i, rr := range res.Answer {
+		answers[i] = rr.String()
+	}
+
+	return dnsLogEntry{
+		ID:           log.ID,
+		HostID:       log.HostID,
+		RemoteAddr:   log.RemoteAddr,
+		ClientSubnet: log.ClientSubnet,
+		Query: dnsQuery{
+			Name: query.Question[0].Name,
+			Type: dns.TypeToString[query.Question[0].Qtype],
+			Raw:  log.RawQuery,
+		},
+		Response: dnsResponse{
+			Rcode:   dns.RcodeToString[res.Rcode],
+			Answers: answers,
+			Raw:     log.RawResponse,
+		},
+		CreatedAt: ulid.Time(log.ID.Time()).UTC(),
+	}, nil
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecodedBodySize is the max size of decoded bodies. Bodies are controlled
+// by whoever sent them, so a small compressed body (e.g. a "zip bomb") could
+// otherwise decode to gigabytes.
+const maxDecodedBodySize = 10 << 20
+
+// errDecodedBodyTooLarge is returned when a decoded body exceeds
+// maxDecodedBodySize.
+var errDecodedBodyTooLarge = errors.New("decoded body too large")
+
+// decodeBody decodes a body using the codings listed in a `Content-Encoding`
+// header value. Codings are undone in the reverse order they were applied. If
+// decoding fails, an unknown coding is encountered or the decoded body is
+// larger than maxDecodedBodySize, the raw body is returned unchanged.
+func decodeBody(body []byte, contentEncoding string) []byte {
+	if len(body) == 0 || contentEncoding == "" {
+		return body
+	}
+
+	codings := strings.Split(contentEncoding, ",")
+	decoded := body
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		if coding == "" || coding == "identity" {
+			continue
+		}
+
+		var err error
+		decoded, err = decodeCoding(decoded, coding)
+		if err != nil {
+			return body
+		}
+	}
+
+	return decoded
+}
+
+func decodeCoding(body []byte, coding string) ([]byte, error) {
+	var r io.Reader
+
+	switch coding {
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		// Despite its name, "deflate" is zlib wrapped (RFC 1950), but some
+		// clients send raw deflate streams. Try zlib first.
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			r = fr
+			break
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported content coding %q", coding)
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > maxDecodedBodySize {
+		return nil, errDecodedBodyTooLarge
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encode compresses `body` with the writer returned by `newWriter`.
+func encode(t *testing.T, body []byte, newWriter func(w io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func zlibWriter(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+
+func flateWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+	return fw
+}
+
+func brotliWriter(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }
+
+func zstdWriter(w io.Writer) io.WriteCloser {
+	zw, _ := zstd.NewWriter(w)
+	return zw
+}
+
+func TestDecodeBody(t *testing.T) {
+	body := []byte(`{"foo":"bar"}`)
+
+	tests := []struct {
+		name            string
+		body            []byte
+		contentEncoding string
+		exp             []byte
+	}{
+		{
+			name:            "gzip",
+			body:            encode(t, body, gzipWriter),
+			contentEncoding: "gzip",
+			exp:             body,
+		},
+		{
+			name:            "x-gzip",
+			body:            encode(t, body, gzipWriter),
+			contentEncoding: "x-gzip",
+			exp:             body,
+		},
+		{
+			name:            "deflate",
+			body:            encode(t, body, zlibWriter),
+			contentEncoding: "deflate",
+			exp:             body,
+		},
+		{
+			name:            "raw deflate",
+			body:            encode(t, body, flateWriter),
+			contentEncoding: "deflate",
+			exp:             body,
+		},
+		{
+			name:            "brotli",
+			body:            encode(t, body, brotliWriter),
+			contentEncoding: "br",
+			exp:             body,
+		},
+		{
+			name:            "zstd",
+			body:            encode(t, body, zstdWriter),
+			contentEncoding: "zstd",
+			exp:             body,
+		},
+		{
+			name:            "multiple codings",
+			body:            encode(t, encode(t, body, gzipWriter), brotliWriter),
+			contentEncoding: "gzip, br",
+			exp:             body,
+		},
+		{
+			name:            "uppercase coding",
+			body:            encode(t, body, gzipWriter),
+			contentEncoding: "GZIP",
+			exp:             body,
+		},
+		{
+			name:            "identity",
+			body:            body,
+			contentEncoding: "identity",
+			exp:             body,
+		},
+		{
+			name:            "no coding",
+			body:            body,
+			contentEncoding: "",
+			exp:             body,
+		},
+		{
+			name:            "unknown coding",
+			body:            body,
+			contentEncoding: "compress",
+			exp:             body,
+		},
+		{
+			name:            "unknown coding after known coding",
+			body:            encode(t, body, gzipWriter),
+			contentEncoding: "gzip, compress",
+			exp:             encode(t, body, gzipWriter),
+		},
+		{
+			name:            "corrupt body",
+			body:            []byte("not gzip"),
+			contentEncoding: "gzip",
+			exp:             []byte("not gzip"),
+		},
+		{
+			name:            "truncated body",
+			body:            encode(t, body, zstdWriter)[:8],
+			contentEncoding: "zstd",
+			exp:             encode(t, body, zstdWriter)[:8],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeBody(tt.body, tt.contentEncoding); !bytes.Equal(got, tt.exp) {
+				t.Errorf("expected %q, got %q", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyMaxSize(t *testing.T) {
+	atMax := bytes.Repeat([]byte("a"), maxDecodedBodySize)
+	bomb := bytes.Repeat([]byte("a"), 4*maxDecodedBodySize)
+
+	tests := []struct {
+		name      string
+		newWriter func(w io.Writer) io.WriteCloser
+		coding    string
+	}{
+		{name: "gzip", newWriter: gzipWriter, coding: "gzip"},
+		{name: "deflate", newWriter: zlibWriter, coding: "deflate"},
+		{name: "brotli", newWriter: brotliWriter, coding: "br"},
+		{name: "zstd", newWriter: zstdWriter, coding: "zstd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeBody(encode(t, atMax, tt.newWriter), tt.coding); len(got) != len(atMax) {
+				t.Errorf("expected body of max size to be decoded, got %v bytes", len(got))
+			}
+
+			// A highly compressible payload decoding beyond the max size is
+			// returned raw.
+			encoded := encode(t, bomb, tt.newWriter)
+			if len(encoded) > maxInlineBodySize {
+				t.Fatalf("expected encoded payload to be at most %v bytes, got %v", maxInlineBodySize, len(encoded))
+			}
+			if got := decodeBody(encoded, tt.coding); !bytes.Equal(got, encoded) {
+				t.Errorf("expected raw body of %v bytes, got %v bytes", len(encoded), len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatJSON   = "json"
+)
+
+// ExportHTTPLogEntries writes all HTTP log entries of one or more hosts as a
+// downloadable file. Entries are streamed from the database one by one, so
+// large exports don't need to fit in memory.
+func (srv *Server) ExportHTTPLogEntries(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatNDJSON
+	}
+
+	var contentType string
+	switch format {
+	case exportFormatNDJSON:
+		contentType = "application/x-ndjson"
+	case exportFormatJSON:
+		contentType = "application/json"
+	default:
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Invalid format %q, must be one of: ndjson, json.", format),
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	params, apiErr := parseHTTPLogEntriesParams(r.URL.Query())
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="http-logs.%v"`, format))
+
+	enc := json.NewEncoder(w)
+	n := 0
+
+	if format == exportFormatJSON {
+		fmt.Fprint(w, "[")
+	}
+
+	err := srv.hostsService.WalkHTTPLogEntries(r.Context(), params, func(logEntry hosts.HTTPLogEntry) error {
+		l, err := parseHTTPLogEntry(logEntry, 0)
+		if err != nil {
+			return fmt.Errorf("failed to parse HTTP log entry: %w", err)
+		}
+		if format == exportFormatJSON && n > 0 {
+			fmt.Fprint(w, ",")
+		}
+		n++
+		return enc.Encode(l)
+	})
+	if err != nil {
+		// Headers (and possibly part of the body) are already written, so the
+		// error can only be logged.
+		srv.logger.Error("Failed to export HTTP logs.", zap.Error(err))
+		return
+	}
+
+	if format == exportFormatJSON {
+		fmt.Fprint(w, "]")
+	}
+}
@@ -0,0 +1,365 @@
+package http
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxClientHelloSize is the maximum amount of bytes read from a connection that
+// are recorded for parsing the TLS client hello.
+const maxClientHelloSize = 1 << 16
+
+// TLS extension types used for fingerprinting.
+const (
+	extServerName          uint16 = 0x0000
+	extSupportedGroups     uint16 = 0x000a
+	extECPointFormats      uint16 = 0x000b
+	extSignatureAlgorithms uint16 = 0x000d
+	extALPN                uint16 = 0x0010
+	extSupportedVersions   uint16 = 0x002b
+)
+
+var errInvalidClientHello = errors.New("invalid client hello")
+
+// serveTLS serves HTTPS on `ln`. When TLS fingerprinting is enabled, the first
+// bytes read from connections are recorded, so the raw client hello is
+// available for computing fingerprints.
+func (srv *Server) serveTLS(tlsServer *http.Server, ln net.Listener) error {
+	if srv.tlsFingerprints {
+		ln = &clientHelloListener{Listener: ln}
+	}
+
+	return tlsServer.ServeTLS(ln, "", "")
+}
+
+// clientHelloListener is a net.Listener that returns connections which record
+// the client hello.
+type clientHelloListener struct {
+	net.Listener
+}
+
+func (l *clientHelloListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientHelloConn{Conn: conn}, nil
+}
+
+// clientHelloConn records bytes read from the connection, until the client
+// hello is consumed or maxClientHelloSize is exceeded.
+type clientHelloConn struct {
+	net.Conn
+	buf  []byte
+	done bool
+}
+
+func (c *clientHelloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.done && n > 0 {
+		c.buf = append(c.buf, p[:n]...)
+		if len(c.buf) > maxClientHelloSize {
+			c.buf = nil
+			c.done = true
+		}
+	}
+
+	return n, err
+}
+
+// clientHello returns the recorded bytes and stops recording.
+func (c *clientHelloConn) clientHello() []byte {
+	buf := c.buf
+	c.buf = nil
+	c.done = true
+
+	return buf
+}
+
+// clientHello holds the fields of a TLS client hello used for fingerprinting.
+type clientHello struct {
+	version           uint16
+	cipherSuites      []uint16
+	extensions        []uint16
+	supportedGroups   []uint16
+	ecPointFormats    []uint8
+	signatureAlgs     []uint16
+	alpnProtocols     []string
+	supportedVersions []uint16
+}
+
+// parseClientHello parses a client hello from raw TLS records, as sent by a
+// client at the start of a handshake.
+func parseClientHello(records []byte) (clientHello, error) {
+	// Concatenate handshake record fragments until the message is complete.
+	var msg []byte
+	for {
+		if len(records) < 5 || records[0] != 0x16 {
+			return clientHello{}, errInvalidClientHello
+		}
+		length := int(binary.BigEndian.Uint16(records[3:5]))
+		if len(records) < 5+length {
+			return clientHello{}, errInvalidClientHello
+		}
+		msg = append(msg, records[5:5+length]...)
+		records = records[5+length:]
+
+		if len(msg) >= 4 && len(msg) >= 4+handshakeLength(msg) {
+			break
+		}
+	}
+	if msg[0] != 0x01 {
+		return clientHello{}, errInvalidClientHello
+	}
+
+	r := byteReader(msg[4 : 4+handshakeLength(msg)])
+	ch := clientHello{}
+
+	ch.version = r.uint16()
+	r.skip(32) // Random.
+	r.skip(int(r.uint8()))
+
+	cipherSuites := r.bytes(int(r.uint16()))
+	for len(cipherSuites) >= 2 {
+		ch.cipherSuites = append(ch.cipherSuites, cipherSuites.uint16())
+	}
+	r.skip(int(r.uint8())) // Compression methods.
+
+	if len(r) == 0 {
+		return ch, nil
+	}
+
+	exts := r.bytes(int(r.uint16()))
+	for len(exts) >= 4 {
+		extType := exts.uint16()
+		data := exts.bytes(int(exts.uint16()))
+		ch.extensions = append(ch.extensions, extType)
+
+		switch extType {
+		case extSupportedGroups:
+			groups := data.bytes(int(data.uint16()))
+			for len(groups) >= 2 {
+				ch.supportedGroups = append(ch.supportedGroups, groups.uint16())
+			}
+		case extECPointFormats:
+			ch.ecPointFormats = append(ch.ecPointFormats, data.bytes(int(data.uint8()))...)
+		case extSignatureAlgorithms:
+			algs := data.bytes(int(data.uint16()))
+			for len(algs) >= 2 {
+				ch.signatureAlgs = append(ch.signatureAlgs, algs.uint16())
+			}
+		case extALPN:
+			protos := data.bytes(int(data.uint16()))
+			for len(protos) > 0 {
+				ch.alpnProtocols = append(ch.alpnProtocols, string(protos.bytes(int(protos.uint8()))))
+			}
+		case extSupportedVersions:
+			versions := data.bytes(int(data.uint8()))
+			for len(versions) >= 2 {
+				ch.supportedVersions = append(ch.supportedVersions, versions.uint16())
+			}
+		}
+	}
+
+	return ch, nil
+}
+
+// handshakeLength returns the length of a handshake message's body, which is
+// encoded as 24 bit integer after the message type.
+func handshakeLength(msg []byte) int {
+	return int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+}
+
+// byteReader reads big endian values. Reading past the end yields zero values.
+type byteReader []byte
+
+func (r *byteReader) bytes(n int) byteReader {
+	if n > len(*r) {
+		n = len(*r)
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b
+}
+
+func (r *byteReader) skip(n int) {
+	r.bytes(n)
+}
+
+func (r *byteReader) uint8() uint8 {
+	b := r.bytes(1)
+	if len(b) < 1 {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *byteReader) uint16() uint16 {
+	b := r.bytes(2)
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// isGREASE reports whether `v` is a GREASE value (RFC 8701), which are ignored
+// for fingerprints.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ja3 returns the JA3 string of the client hello. See:
+// https://github.com/salesforce/ja3
+func (ch clientHello) ja3() string {
+	join := func(values []uint16) string {
+		s := make([]string, 0, len(values))
+		for _, v := range values {
+			if !isGREASE(v) {
+				s = append(s, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(s, "-")
+	}
+
+	points := make([]string, len(ch.ecPointFormats))
+	for i, p := range ch.ecPointFormats {
+		points[i] = strconv.Itoa(int(p))
+	}
+
+	return fmt.Sprintf("%d,%s,%s,%s,%s",
+		ch.version,
+		join(ch.cipherSuites),
+		join(ch.extensions),
+		join(ch.supportedGroups),
+		strings.Join(points, "-"),
+	)
+}
+
+// ja3Hash returns the MD5 hash of a JA3 string, which is how JA3 fingerprints
+// are commonly shared.
+func ja3Hash(ja3 string) string {
+	sum := md5.Sum([]byte(ja3))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint of the client hello, for TLS over TCP. See:
+// https://github.com/FoxIO-LLC/ja4
+func (ch clientHello) ja4(serverName bool) string {
+	version := ch.version
+	for _, v := range ch.supportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if serverName {
+		sni = "d"
+	}
+
+	var cipherSuites, extensions []string
+	for _, v := range ch.cipherSuites {
+		if !isGREASE(v) {
+			cipherSuites = append(cipherSuites, fmt.Sprintf("%04x", v))
+		}
+	}
+	extCount := 0
+	for _, v := range ch.extensions {
+		if isGREASE(v) {
+			continue
+		}
+		extCount++
+		if v != extServerName && v != extALPN {
+			extensions = append(extensions, fmt.Sprintf("%04x", v))
+		}
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		ja4Version(version),
+		sni,
+		min99(len(cipherSuites)),
+		min99(extCount),
+		ja4ALPN(ch.alpnProtocols),
+	)
+
+	sort.Strings(cipherSuites)
+	b := ja4Hash(strings.Join(cipherSuites, ","), len(cipherSuites) == 0)
+
+	sort.Strings(extensions)
+	c := strings.Join(extensions, ",")
+	if len(ch.signatureAlgs) > 0 {
+		algs := make([]string, 0, len(ch.signatureAlgs))
+		for _, v := range ch.signatureAlgs {
+			if !isGREASE(v) {
+				algs = append(algs, fmt.Sprintf("%04x", v))
+			}
+		}
+		c += "_" + strings.Join(algs, ",")
+	}
+
+	return a + "_" + b + "_" + ja4Hash(c, len(extensions) == 0)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	default:
+		return "00"
+	}
+}
+
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+
+	proto := protos[0]
+	first, last := proto[0], proto[len(proto)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString([]byte(proto))
+		return h[:1] + h[len(h)-1:]
+	}
+
+	return string([]byte{first, last})
+}
+
+func ja4Hash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// logsHostsService lists a fixed set of HTTP log entries.
+type logsHostsService struct {
+	hosts.Service
+	entries []hosts.HTTPLogEntry
+}
+
+func (svc *logsHostsService) ListHTTPLogEntries(context.Context, hosts.ListHTTPLogEntriesParams) ([]hosts.HTTPLogEntry, error) {
+	return svc.entries, nil
+}
+
+func (svc *logsHostsService) HTTPLogEntriesChanged() <-chan struct{} {
+	return nil
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		exp            bool
+	}{
+		{acceptEncoding: "", exp: false},
+		{acceptEncoding: "gzip", exp: true},
+		{acceptEncoding: "deflate, GZIP", exp: true},
+		{acceptEncoding: "gzip;q=0.5", exp: true},
+		{acceptEncoding: "*", exp: true},
+		{acceptEncoding: "br", exp: false},
+		{acceptEncoding: "gzip;q=0", exp: false},
+		{acceptEncoding: "gzip; q=0.000", exp: false},
+		{acceptEncoding: "gzipx", exp: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := acceptsGzip(tt.acceptEncoding); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	entry := hosts.HTTPLogEntry{
+		RawRequest: []byte("POST / HTTP/1.1\r\nHost: abc.example.com\r\nContent-Length: 4096\r\n\r\n" +
+			strings.Repeat("a", 4096)),
+		RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+	}
+	entries := make([]hosts.HTTPLogEntry, 100)
+	for i := range entries {
+		entries[i] = entry
+	}
+	srv := NewServer(WithHostsService(&logsHostsService{entries: entries}))
+
+	t.Run("large log listing", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/http-logs?hostId=01ARZ3NDEKTSV4RRFFQ69G5FAV", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("expected gzip content encoding, got %q", got)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("expected `Vary: Accept-Encoding`, got %q", got)
+		}
+
+		compressedSize := w.Body.Len()
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(gr)
+		if err != nil {
+			t.Fatalf("failed to decompress body: %v", err)
+		}
+		if compressedSize >= len(body) {
+			t.Errorf("expected compressed body (%v bytes) to be smaller than %v bytes", compressedSize, len(body))
+		}
+		var res struct {
+			Data []json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		if len(res.Data) != len(entries) {
+			t.Errorf("expected %v entries, got %v", len(entries), len(res.Data))
+		}
+	})
+
+	t.Run("gzip not accepted", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/http-logs?hostId=01ARZ3NDEKTSV4RRFFQ69G5FAV", nil)
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected no content encoding, got %q", got)
+		}
+		if !json.Valid(w.Body.Bytes()) {
+			t.Errorf("expected uncompressed JSON body, got %q", w.Body.Bytes()[:16])
+		}
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		h := srv.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: foo\n\n"))
+		}))
+		r := httptest.NewRequest("GET", "/api/events", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected no content encoding, got %q", got)
+		}
+		if got := w.Body.String(); got != "data: foo\n\n" {
+			t.Errorf("expected uncompressed body, got %q", got)
+		}
+	})
+
+	t.Run("captured request", func(t *testing.T) {
+		srv := NewServer(WithHostsService(&testHostsService{}))
+		r := httptest.NewRequest("GET", "http://abc.example.com/api/http-logs", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("expected no content encoding, got %q", got)
+		}
+	})
+}
@@ -3,14 +3,13 @@ package http
 import (
 	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"strings"
 	"time"
 
@@ -22,28 +21,141 @@ import (
 )
 
 func (srv *Server) Handler() http.Handler {
-	r := mux.NewRouter()
+	// Paths aren't cleaned, because the router would otherwise redirect
+	// requests instead of capturing them, e.g. for CONNECT requests (which
+	// have no path), `*` targets of methods other than OPTIONS, and paths
+	// like `/a/../b` used in path traversal tests.
+	r := mux.NewRouter().SkipClean(true)
 	r.Use(srv.RecoveryMiddleware)
+	r.Use(srv.ACMEChallengeLogMiddleware)
 
 	if srv.acmeManager != nil {
 		// Register ACME HTTP-01 challenge middleware.
 		r.Use(srv.acmeManager.HTTPChallengeHandler)
 	}
 
-	apiRouter := r.MatcherFunc(func(req *http.Request, match *mux.RouteMatch) bool {
-		hostname, _ := os.Hostname()
-		host, _, _ := net.SplitHostPort(req.Host)
-		return strings.EqualFold(host, hostname) || (req.Host == srv.hostname || req.Host == "localhost:8080")
-	}).PathPrefix("/api").Subrouter().StrictSlash(true)
-	apiRouter.Methods("POST").Path("/hosts").HandlerFunc(srv.CreateHosts)
-	apiRouter.Methods("GET").Path("/hosts/{id:\\w{26}}").HandlerFunc(srv.GetHostByID)
-	apiRouter.Methods("GET").Path("/http-logs").HandlerFunc(srv.ListHTTPLogEntries)
+	// Routes are matched in order, so for API hosts (see matchAPIHost), paths
+	// prefixed with `/api` are always served by the API, and other paths by
+	// the web UI if enabled. Only requests for other hosts, or for API hosts
+	// without web UI, are captured. When the API has a dedicated listener, all
+	// requests on this handler are captured.
+	if srv.apiAddr == "" {
+		apiRouter := r.MatcherFunc(srv.matchAPIHost).PathPrefix("/api").Subrouter().StrictSlash(true)
+		srv.registerAPIRoutes(apiRouter)
+
+		if srv.webUI != nil {
+			r.MatcherFunc(srv.matchAPIHost).Handler(http.FileServer(http.FS(srv.webUI)))
+		}
+	}
 
 	r.PathPrefix("").HandlerFunc(srv.CaptureRequest)
 
 	return r
 }
 
+// APIHandler returns a handler that only serves the API, used for the
+// dedicated listener configured with WithAPIAddr.
+func (srv *Server) APIHandler() http.Handler {
+	r := mux.NewRouter()
+	r.Use(srv.RecoveryMiddleware)
+
+	apiRouter := r.PathPrefix("/api").Subrouter().StrictSlash(true)
+	srv.registerAPIRoutes(apiRouter)
+
+	if srv.webUI != nil {
+		r.PathPrefix("/").Handler(http.FileServer(http.FS(srv.webUI)))
+	}
+
+	return r
+}
+
+func (srv *Server) matchAPIHost(req *http.Request, match *mux.RouteMatch) bool {
+	host := stripPort(req.Host)
+
+	if len(srv.apiHosts) > 0 {
+		for _, apiHost := range srv.apiHosts {
+			if strings.EqualFold(host, apiHost) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !srv.apiOnHostnameDisabled && srv.hostname != "" && strings.EqualFold(host, srv.hostname) {
+		return true
+	}
+
+	// Loopback hosts only match for loopback clients, so a crafted `Host`
+	// header (e.g. `localhost:8080`) of a remote client is captured.
+	return isLoopbackHost(host) && isLoopbackAddr(req.RemoteAddr)
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isLoopbackAddr(addr string) bool {
+	ip := net.ParseIP(stripPort(addr))
+	return ip != nil && ip.IsLoopback()
+}
+
+func (srv *Server) registerAPIRoutes(apiRouter *mux.Router) {
+	apiRouter.Use(srv.GzipMiddleware)
+	if len(srv.corsOrigins) > 0 {
+		apiRouter.Use(srv.CORSMiddleware)
+		// Preflight requests are answered by the CORS middleware, which only
+		// runs for matched routes.
+		apiRouter.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	apiRouter.Methods("POST").Path("/hosts").HandlerFunc(srv.CreateHosts)
+	apiRouter.Methods("GET").Path("/hosts").HandlerFunc(srv.ListHosts)
+	apiRouter.Methods("GET").Path("/hosts/{id:\\w{26}}").HandlerFunc(srv.GetHostByID)
+	apiRouter.Methods("DELETE").Path("/hosts/{id:\\w{26}}").HandlerFunc(srv.DeleteHost)
+	apiRouter.Methods("PATCH").Path("/hosts/{id:\\w{26}}").HandlerFunc(srv.UpdateHost)
+	apiRouter.Methods("PATCH").Path("/hosts/{id:\\w{26}}/response").HandlerFunc(srv.UpdateHostResponse)
+	if srv.recordManager != nil {
+		apiRouter.Methods("POST").Path("/hosts/{id:\\w{26}}/records").HandlerFunc(srv.CreateRecord)
+		apiRouter.Methods("DELETE").Path("/hosts/{id:\\w{26}}/records").HandlerFunc(srv.DeleteRecords)
+	}
+	if _, ok := srv.recordManager.(ZonefileWriter); ok {
+		apiRouter.Methods("GET").Path("/dns/zone").HandlerFunc(srv.ExportZonefile)
+	}
+	if _, ok := srv.recordManager.(ZoneApexer); ok && srv.adminToken != "" {
+		apiRouter.Methods("POST").Path("/dns/records").HandlerFunc(srv.RequireAdminToken(srv.CreateApexRecord))
+		apiRouter.Methods("DELETE").Path("/dns/records").HandlerFunc(srv.RequireAdminToken(srv.DeleteApexRecords))
+	}
+	srv.registerACMEDNSRoutes(apiRouter)
+	apiRouter.Methods("GET").Path("/http-logs").HandlerFunc(srv.ListHTTPLogEntries)
+	apiRouter.Methods("GET").Path("/http-logs/export").HandlerFunc(srv.ExportHTTPLogEntries)
+	// Imports create hosts with IDs and hostnames of the client's choice, so
+	// they require the admin token.
+	if srv.adminToken != "" {
+		apiRouter.Methods("POST").Path("/http-logs/import").HandlerFunc(srv.RequireAdminToken(srv.ImportHTTPLogEntries))
+	}
+	apiRouter.Methods("GET").Path("/http-logs/{id:\\w{26}}/{part:request|response}-body").HandlerFunc(srv.GetHTTPLogEntryBody)
+	if len(srv.replayAllow) > 0 {
+		apiRouter.Methods("POST").Path("/http-logs/{id:\\w{26}}/replay").HandlerFunc(srv.ReplayHTTPLogEntry)
+	}
+	apiRouter.Methods("GET").Path("/dns-logs").HandlerFunc(srv.ListDNSLogEntries)
+	apiRouter.Methods("GET").Path("/tls-logs").HandlerFunc(srv.ListTLSLogEntries)
+	apiRouter.Methods("GET").Path("/stats").HandlerFunc(srv.GetStats)
+	// Backups can include TLS private keys (when certificates are stored in
+	// the database), so they require the admin token.
+	if srv.backuper != nil && srv.adminToken != "" {
+		apiRouter.Methods("POST").Path("/db/backup").HandlerFunc(srv.RequireAdminToken(srv.BackupDatabase))
+	}
+	if srv.certMonitor != nil {
+		apiRouter.Methods("GET").Path("/tls/status").HandlerFunc(srv.TLSStatus)
+	}
+}
+
 func (srv *Server) RecoveryMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -57,11 +169,49 @@ func (srv *Server) RecoveryMiddleware(h http.Handler) http.Handler {
 }
 
 func (srv *Server) CaptureRequest(w http.ResponseWriter, r *http.Request) {
+	markACMEChallengeCaptured(r)
+
+	// Requests without host (e.g. HTTP/1.0 requests of scanners) can't belong
+	// to a host.
+	if stripPort(r.Host) == "" {
+		srv.logger.Debug("Host is empty, ignoring incoming request.", zap.String("remoteAddr", r.RemoteAddr))
+		http.Error(w, "Bad Request: missing required Host header", http.StatusBadRequest)
+		return
+	}
+
+	if !srv.isCaptureHost(r.Host) {
+		srv.logger.Debug("Host is outside of the capture domain, ignoring incoming request.", zap.String("host", r.Host))
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	if srv.isIgnoredPath(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if srv.upstream != nil {
+		srv.proxyRequest(w, r)
+		return
+	}
+
 	ctx := r.Context()
 
-	err := srv.hostsService.StoreHTTPLogEntry(ctx, hosts.StoreHTTPLogEntryParams{
-		Request:  r,
-		Response: &http.Response{},
+	// Buffer the request body once, so it can be stored even when reading it
+	// fails halfway, e.g. for a client that disconnects mid-stream.
+	body, err := bufferBody(r)
+	if err != nil {
+		srv.logger.Warn("Failed to read request body, storing partial body.", zap.Error(err))
+	}
+
+	entryID, err := srv.hostsService.StoreHTTPLogEntry(ctx, hosts.StoreHTTPLogEntryParams{
+		Request:       r,
+		Response:      &http.Response{},
+		RequestBody:   body,
+		RemoteAddr:    srv.remoteAddr(r),
+		ACMEChallenge: isACMEChallenge(r),
+		RawWire:       rawWire(r),
 	})
 	if errors.Is(err, hosts.ErrHostNotFound) {
 		srv.logger.Info("Host not found, ignorning incoming request.", zap.Error(err))
@@ -69,81 +219,299 @@ func (srv *Server) CaptureRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(code), code)
 		return
 	}
+	if errors.Is(err, hosts.ErrTooManyWrites) {
+		srv.logger.Warn("Too many concurrent writes, dropping incoming request.", zap.Error(err))
+		w.Header().Set("Retry-After", "1")
+		code := http.StatusServiceUnavailable
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
 	if err != nil {
 		srv.logger.Error("Failed to store HTTP log entry.", zap.Error(err))
 		srv.handleInternalError(w)
 		return
 	}
 
-	fmt.Fprint(w, "OK")
+	if !srv.delayResponse(r) {
+		return
+	}
+
+	srv.writeDefaultResponse(w, r, entryID)
+}
+
+// writeDefaultResponse writes the response for captured requests, using the
+// default response template if set, or "OK" otherwise.
+func (srv *Server) writeDefaultResponse(w http.ResponseWriter, r *http.Request, entryID ulid.ULID) {
+	if srv.defaultResponse == nil {
+		fmt.Fprint(w, "OK")
+		return
+	}
+
+	body, err := srv.defaultResponse.execute(ResponseTemplateData{
+		Host:       stripPort(r.Host),
+		RequestID:  entryID.String(),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: srv.remoteAddr(r),
+	})
+	if err != nil {
+		srv.logger.Error("Failed to execute default response template.", zap.Error(err))
+		fmt.Fprint(w, "OK")
+		return
+	}
+
+	if srv.defaultResponse.contentType != "" {
+		w.Header().Set("Content-Type", srv.defaultResponse.contentType)
+	}
+	w.Write(body)
+}
+
+// delayResponse waits for the response delay of the request's host, capped at
+// the maximum response delay. It returns false if the request was canceled
+// while waiting.
+func (srv *Server) delayResponse(r *http.Request) bool {
+	ctx := r.Context()
+
+	h, err := srv.hostsService.FindHostByHostname(ctx, r.Host)
+	if err != nil {
+		srv.logger.Error("Failed to find host for response delay.", zap.Error(err))
+		return true
+	}
+
+	delay := h.ResponseDelay
+	if delay > srv.maxResponseDelay {
+		delay = srv.maxResponseDelay
+	}
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isIgnoredPath reports whether the request path is one of the ignored paths,
+// which are answered without capturing the request. ACME HTTP-01 challenge
+// paths are never ignored.
+func (srv *Server) isIgnoredPath(r *http.Request) bool {
+	if isACMEChallenge(r) {
+		return false
+	}
+	for _, path := range srv.ignorePaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// isCaptureHost reports whether requests for a `Host` header value may be
+// captured, see WithCaptureHostSuffix.
+func (srv *Server) isCaptureHost(host string) bool {
+	if srv.captureHostSuffix == "" {
+		return true
+	}
+	host = hosts.NormalizeHostname(stripPort(host))
+
+	return host == srv.captureHostSuffix || strings.HasSuffix(host, "."+srv.captureHostSuffix)
+}
+
+// bufferBody reads the body of a request and replaces it with a buffered copy,
+// so it can be read again by other handlers. On error, the partially read body
+// is returned (and buffered) as well.
+func bufferBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, err
 }
 
 type createHostRequestBody struct {
 	Amount int `json:"amount"`
+	// TTLSeconds is the time after which the hosts expire. Defaults to the
+	// host TTL of the server.
+	TTLSeconds int64 `json:"ttlSeconds"`
+	// Label is set on the hosts, and copied onto their HTTP log entries.
+	Label string `json:"label"`
 }
 
-func (body *createHostRequestBody) validate() *APIError {
-	if body.Amount < 1 || body.Amount > 50 {
+func (body *createHostRequestBody) validate(max int) *APIError {
+	if body.Amount < 1 || body.Amount > max {
 		return &APIError{
-			Message:    `Property "amount" must be min 1, max 50.`,
+			Message:    fmt.Sprintf(`Property "amount" must be min 1, max %v.`, max),
+			Code:       ErrCodeValidation,
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	return nil
+	if body.TTLSeconds < 0 {
+		return &APIError{
+			Message:    `Property "ttlSeconds" cannot be negative.`,
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return validateHostLabel(body.Label)
 }
 
-func (srv *Server) CreateHosts(w http.ResponseWriter, r *http.Request) {
-	var body createHostRequestBody
+// maxHostLabelLength is the maximum length of host labels.
+const maxHostLabelLength = 64
 
-	err := json.NewDecoder(r.Body).Decode(&body)
-	if err == io.EOF {
-		apiErr := &APIError{
-			Message:    "Request body cannot be empty.",
-			StatusCode: http.StatusBadRequest,
-			Err:        err,
+// validateHostLabel checks that a host label (if not empty) only consists of
+// letters, digits, `-`, `_`, `.` and `:`, so it can be used as query parameter
+// without escaping.
+func validateHostLabel(label string) *APIError {
+	valid := len(label) <= maxHostLabelLength
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') &&
+			r != '-' && r != '_' && r != '.' && r != ':' {
+			valid = false
+			break
 		}
-		writeAPIError(w, apiErr)
-		return
 	}
-	if err != nil {
-		apiErr := &APIError{
-			Message:    fmt.Sprintf("Failed to parse request body: %v", err),
+	if !valid {
+		return &APIError{
+			Message:    fmt.Sprintf(`Property "label" must be max %v characters, consisting of letters, digits, "-", "_", "." and ":".`, maxHostLabelLength),
+			Code:       ErrCodeValidation,
 			StatusCode: http.StatusBadRequest,
-			Err:        err,
 		}
-		writeAPIError(w, apiErr)
+	}
+	return nil
+}
+
+func (srv *Server) CreateHosts(w http.ResponseWriter, r *http.Request) {
+	var body createHostRequestBody
+	if apiErr := decodeJSONBody(w, r, &body); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	if err := body.validate(srv.maxHostsPerRequest); err != nil {
+		srv.writeAPIError(w, err)
 		return
 	}
 
-	if err := body.validate(); err != nil {
-		writeAPIError(w, err)
+	// Retried requests (e.g. after a network error) with the same
+	// idempotency key are answered with the hosts created originally.
+	idempotencyKey, ok := srv.beginIdempotentRequest(w, r, fmt.Sprintf("%+v", body))
+	if !ok {
 		return
 	}
 
-	hosts, err := srv.hostsService.CreateHosts(r.Context(), body.Amount)
+	hostList, err := srv.hostsService.CreateHosts(r.Context(), hosts.CreateHostsParams{
+		Amount: body.Amount,
+		TTL:    time.Duration(body.TTLSeconds) * time.Second,
+		Label:  body.Label,
+	})
+	if err != nil {
+		srv.endIdempotentRequest(idempotencyKey, nil)
+	}
+	if errors.Is(err, hosts.ErrMaxHostsReached) {
+		srv.writeAPIError(w, &APIError{
+			Message:    "Maximum amount of hosts reached.",
+			Code:       ErrCodeMaxHostsReached,
+			StatusCode: http.StatusForbidden,
+			Err:        err,
+		})
+		return
+	}
 	if err != nil {
 		srv.logger.Error("Failed to create hosts.", zap.Error(err))
 		srv.handleInternalError(w)
 		return
 	}
 
-	writeAPIResponse(w, APIResponse{
+	srv.provisionHostRecords(r.Context(), hostList)
+
+	data := make([]host, len(hostList))
+	for i, h := range hostList {
+		data[i] = parseHost(h)
+		data[i].Setup = srv.hostSetup(h)
+	}
+
+	res := APIResponse{
 		StatusCode: http.StatusCreated,
-		Data:       hosts,
-	})
+		Data:       data,
+	}
+	srv.endIdempotentRequest(idempotencyKey, &res)
+
+	srv.writeAPIResponse(w, res)
 }
 
 type host struct {
-	ID        ulid.ULID `json:"id"`
-	Hostname  string    `json:"hostname"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID               ulid.ULID    `json:"id"`
+	Hostname         string       `json:"hostname"`
+	InteractionCount int          `json:"interactionCount"`
+	CreatedAt        time.Time    `json:"createdAt"`
+	Response         hostResponse `json:"response"`
+	ExpiresAt        *time.Time   `json:"expiresAt,omitempty"`
+	Label            string       `json:"label,omitempty"`
+	// ACMEDNS is set for hosts registered via the acme-dns compatible API.
+	ACMEDNS bool `json:"acmeDns,omitempty"`
+	// Setup is only set for newly created hosts.
+	Setup *hostSetup `json:"setup,omitempty"`
+}
+
+// hostResponse holds the settings of responses to captured HTTP requests.
+type hostResponse struct {
+	DelayMs int64 `json:"delayMs"`
+}
+
+func parseHost(h hosts.Host) host {
+	parsed := host{
+		ID:               h.ID,
+		Hostname:         h.Hostname,
+		InteractionCount: h.InteractionCount,
+		CreatedAt:        ulid.Time(h.ID.Time()).UTC(),
+		Response: hostResponse{
+			DelayMs: h.ResponseDelay.Milliseconds(),
+		},
+		Label:   h.Label,
+		ACMEDNS: h.ACMEDNS,
+	}
+	if !h.ExpiresAt.IsZero() {
+		expiresAt := h.ExpiresAt.UTC()
+		parsed.ExpiresAt = &expiresAt
+	}
+
+	return parsed
+}
+
+func (srv *Server) ListHosts(w http.ResponseWriter, r *http.Request) {
+	hostList, err := srv.hostsService.ListHosts(r.Context())
+	if err != nil {
+		srv.logger.Error("Failed to list hosts.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	data := make([]host, len(hostList))
+	for i, h := range hostList {
+		data[i] = parseHost(h)
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       data,
+	})
 }
 
 func (srv *Server) GetHostByID(w http.ResponseWriter, r *http.Request) {
 	hostID, err := ulid.Parse(mux.Vars(r)["id"])
 	if err != nil {
-		writeAPIError(w, &APIError{
+		srv.writeAPIError(w, &APIError{
 			Message:    fmt.Sprintf("Failed to parse host ID: %v", err),
+			Code:       ErrCodeInvalidRequest,
 			StatusCode: http.StatusBadRequest,
 			Err:        err,
 		})
@@ -153,8 +521,9 @@ func (srv *Server) GetHostByID(w http.ResponseWriter, r *http.Request) {
 	h, err := srv.hostsService.FindHostByID(r.Context(), hostID)
 	switch {
 	case errors.Is(err, hosts.ErrHostNotFound):
-		writeAPIError(w, &APIError{
+		srv.writeAPIError(w, &APIError{
 			Message:    fmt.Sprintf("Host %q not found.", hostID),
+			Code:       ErrCodeHostNotFound,
 			StatusCode: http.StatusNotFound,
 			Err:        err,
 		})
@@ -162,27 +531,185 @@ func (srv *Server) GetHostByID(w http.ResponseWriter, r *http.Request) {
 		srv.logger.Error("Failed to find host by ID.", zap.Error(err))
 		srv.handleInternalError(w)
 	default:
-		writeAPIResponse(w, APIResponse{
+		srv.writeAPIResponse(w, APIResponse{
 			StatusCode: http.StatusOK,
-			Data: host{
-				ID:        h.ID,
-				Hostname:  h.Hostname,
-				CreatedAt: ulid.Time(h.ID.Time()).UTC(),
-			},
+			Data:       parseHost(h),
 		})
 	}
 }
 
+// DeleteHost deletes a host, including its interactions and DNS records.
+func (srv *Server) DeleteHost(w http.ResponseWriter, r *http.Request) {
+	hostID, err := ulid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to parse host ID: %v", err),
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		})
+		return
+	}
+
+	h, err := srv.hostsService.FindHostByID(r.Context(), hostID)
+	if err == nil && !srv.checkHostManaged(w, r, h) {
+		return
+	}
+	if err == nil {
+		err = srv.hostsService.DeleteHost(r.Context(), hostID)
+	}
+	switch {
+	case errors.Is(err, hosts.ErrHostNotFound):
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Host %q not found.", hostID),
+			Code:       ErrCodeHostNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+		return
+	case err != nil:
+		srv.logger.Error("Failed to delete host.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	if deleter, ok := srv.recordManager.(HostRecordsDeleter); ok {
+		if err := deleter.DeleteHostRecords(r.Context(), h.Hostname); err != nil {
+			srv.logger.Error("Failed to delete DNS records of deleted host.", zap.Error(err))
+		}
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       parseHost(h),
+	})
+}
+
+type updateHostRequestBody struct {
+	Label *string `json:"label"`
+}
+
+// UpdateHost updates the settings of a host, e.g. its label. Omitted
+// properties are left unchanged.
+func (srv *Server) UpdateHost(w http.ResponseWriter, r *http.Request) {
+	current, ok := srv.findHostForRequest(w, r)
+	if !ok || !srv.checkHostManaged(w, r, current) {
+		return
+	}
+	hostID := current.ID
+
+	var body updateHostRequestBody
+	if apiErr := decodeJSONBody(w, r, &body); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	if body.Label != nil {
+		if apiErr := validateHostLabel(*body.Label); apiErr != nil {
+			srv.writeAPIError(w, apiErr)
+			return
+		}
+	}
+
+	h, err := srv.hostsService.UpdateHost(r.Context(), hostID, hosts.UpdateHostParams{
+		Label: body.Label,
+	})
+	switch {
+	case errors.Is(err, hosts.ErrHostNotFound):
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Host %q not found.", hostID),
+			Code:       ErrCodeHostNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+	case err != nil:
+		srv.logger.Error("Failed to update host.", zap.Error(err))
+		srv.handleInternalError(w)
+	default:
+		srv.writeAPIResponse(w, APIResponse{
+			StatusCode: http.StatusOK,
+			Data:       parseHost(h),
+		})
+	}
+}
+
+type updateHostResponseRequestBody struct {
+	DelayMs *int64 `json:"delayMs"`
+}
+
+func (body *updateHostResponseRequestBody) validate(maxDelay time.Duration) *APIError {
+	if body.DelayMs != nil && (*body.DelayMs < 0 || *body.DelayMs > maxDelay.Milliseconds()) {
+		return &APIError{
+			Message:    fmt.Sprintf(`Property "delayMs" must be min 0, max %v.`, maxDelay.Milliseconds()),
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// UpdateHostResponse updates the settings of responses to captured HTTP
+// requests for a host. Omitted properties are left unchanged.
+func (srv *Server) UpdateHostResponse(w http.ResponseWriter, r *http.Request) {
+	current, ok := srv.findHostForRequest(w, r)
+	if !ok || !srv.checkHostManaged(w, r, current) {
+		return
+	}
+	hostID := current.ID
+
+	var body updateHostResponseRequestBody
+	if apiErr := decodeJSONBody(w, r, &body); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	if err := body.validate(srv.maxResponseDelay); err != nil {
+		srv.writeAPIError(w, err)
+		return
+	}
+
+	var params hosts.UpdateHostResponseParams
+	if body.DelayMs != nil {
+		delay := time.Duration(*body.DelayMs) * time.Millisecond
+		params.Delay = &delay
+	}
+
+	h, err := srv.hostsService.UpdateHostResponse(r.Context(), hostID, params)
+	switch {
+	case errors.Is(err, hosts.ErrHostNotFound):
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Host %q not found.", hostID),
+			Code:       ErrCodeHostNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+	case err != nil:
+		srv.logger.Error("Failed to update host response.", zap.Error(err))
+		srv.handleInternalError(w)
+	default:
+		srv.writeAPIResponse(w, APIResponse{
+			StatusCode: http.StatusOK,
+			Data:       parseHost(h),
+		})
+	}
+}
+
+// maxHostIDs is the maximum amount of `hostId` query parameters used for
+// filtering log entries.
+const maxHostIDs = 20
+
 func parseHostIDs(rawIDs []string) ([]ulid.ULID, *APIError) {
 	if len(rawIDs) == 0 {
 		return nil, &APIError{
 			Message:    "At least one `hostId` query parameter is required.",
+			Code:       ErrCodeValidation,
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	if len(rawIDs) > 20 {
+	if len(rawIDs) > maxHostIDs {
 		return nil, &APIError{
-			Message:    "Cannot filter by more than 10 host IDs.",
+			Message:    fmt.Sprintf("Cannot filter by more than %v host IDs.", maxHostIDs),
+			Code:       ErrCodeValidation,
 			StatusCode: http.StatusBadRequest,
 		}
 	}
@@ -194,6 +721,7 @@ func parseHostIDs(rawIDs []string) ([]ulid.ULID, *APIError) {
 		if err != nil {
 			return nil, &APIError{
 				Message:    fmt.Sprintf("Failed to parse host ID: %v", err),
+				Code:       ErrCodeInvalidRequest,
 				StatusCode: http.StatusBadRequest,
 				Err:        err,
 			}
@@ -210,20 +738,45 @@ func parseHostIDs(rawIDs []string) ([]ulid.ULID, *APIError) {
 }
 
 type httpLogEntry struct {
-	ID        ulid.ULID    `json:"id"`
-	HostID    ulid.ULID    `json:"hostId"`
-	Request   httpRequest  `json:"request"`
-	Response  httpResponse `json:"response"`
-	CreatedAt time.Time    `json:"createdAt"`
+	ID            ulid.ULID    `json:"id"`
+	HostID        ulid.ULID    `json:"hostId"`
+	Request       httpRequest  `json:"request"`
+	Response      httpResponse `json:"response"`
+	ACMEChallenge bool         `json:"acmeChallenge"`
+	RepeatCount   int          `json:"repeatCount"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	// ClientCertificates are presented by clients during TLS handshakes,
+	// leaf first.
+	ClientCertificates []clientCertificate `json:"clientCertificates,omitempty"`
+	// BodyDropped is set when the request and response bodies weren't
+	// retained, and only headers are available.
+	BodyDropped bool `json:"bodyDropped"`
+	// HostMismatch is set when the `Host` header of a request over TLS differs
+	// from the server name indication (SNI), e.g. for domain fronting.
+	HostMismatch bool `json:"hostMismatch"`
+	// Label is the label of the host when the request was captured.
+	Label string `json:"label,omitempty"`
 }
 
 type httpRequest struct {
-	Host    string      `json:"host"`
-	URL     string      `json:"url"`
-	Method  string      `json:"method"`
-	Headers http.Header `json:"headers"`
-	Body    []byte      `json:"body"`
-	Raw     []byte      `json:"raw"`
+	Host       string      `json:"host"`
+	URL        string      `json:"url"`
+	Method     string      `json:"method"`
+	Headers    http.Header `json:"headers"`
+	Body       []byte      `json:"body"`
+	BodyIsText bool        `json:"bodyIsText"`
+	BodySize   int64       `json:"bodySize"`
+	BodyURL    string      `json:"bodyUrl,omitempty"`
+	ParsedForm url.Values  `json:"parsedForm"`
+	ParsedJSON interface{} `json:"parsedJson"`
+	ParseError string      `json:"parseError,omitempty"`
+	RemoteAddr string      `json:"remoteAddr"`
+	// ServerName is the server name indication (SNI) of requests over TLS.
+	ServerName string `json:"serverName,omitempty"`
+	Raw        []byte `json:"raw"`
+	// RawWire is the request as it was read from the connection, which has
+	// the original framing of the body, e.g. chunk sizes and trailers.
+	RawWire []byte `json:"rawWire,omitempty"`
 }
 
 type httpResponse struct {
@@ -231,30 +784,134 @@ type httpResponse struct {
 	Status     string      `json:"status"`
 	Headers    http.Header `json:"headers"`
 	Body       []byte      `json:"body"`
+	BodyIsText bool        `json:"bodyIsText"`
+	BodySize   int64       `json:"bodySize"`
+	BodyURL    string      `json:"bodyUrl,omitempty"`
 	Raw        []byte      `json:"raw"`
+	// BodyTruncated is set when only the first part of the body was stored,
+	// e.g. of a large upstream response.
+	BodyTruncated bool `json:"bodyTruncated,omitempty"`
 }
 
-func (srv *Server) ListHTTPLogEntries(w http.ResponseWriter, r *http.Request) {
-	hostIDs, apiErr := parseHostIDs(r.URL.Query()["hostId"])
+// parseHTTPLogEntriesParams parses the query parameters used for listing HTTP
+// log entries: `hostId` (required, repeatable), `q` (substring of the raw
+// request), `method`, `pathPrefix`, `header` (repeatable, formatted as
+// `{name}:{value}`, where the value is a case-insensitive substring), `label`
+// (the label of the host when entries were captured) and `after` (the ID of an
+// entry, for listing only entries received later).
+func parseHTTPLogEntriesParams(query url.Values) (hosts.ListHTTPLogEntriesParams, *APIError) {
+	hostIDs, apiErr := parseHostIDs(query["hostId"])
 	if apiErr != nil {
-		writeAPIError(w, apiErr)
-		return
+		return hosts.ListHTTPLogEntriesParams{}, apiErr
 	}
 
 	params := hosts.ListHTTPLogEntriesParams{
-		HostIDs: hostIDs,
+		HostIDs:    hostIDs,
+		Query:      query.Get("q"),
+		Method:     query.Get("method"),
+		PathPrefix: query.Get("pathPrefix"),
+		Label:      query.Get("label"),
 	}
 
-	logEntries, err := srv.hostsService.ListHTTPLogEntries(r.Context(), params)
-	if err != nil {
-		srv.logger.Error("Failed to list HTTP logs.", zap.Error(err))
-		srv.handleInternalError(w)
+	for _, rawHeader := range query["header"] {
+		name, value, ok := cut(rawHeader, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return hosts.ListHTTPLogEntriesParams{}, &APIError{
+				Message:    fmt.Sprintf("Invalid `header` query parameter %q, expected format `{name}:{value}`.", rawHeader),
+				Code:       ErrCodeValidation,
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		params.Headers = append(params.Headers, hosts.HeaderFilter{
+			Name:  name,
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	if after := query.Get("after"); after != "" {
+		id, err := ulid.Parse(after)
+		if err != nil {
+			return hosts.ListHTTPLogEntriesParams{}, &APIError{
+				Message:    fmt.Sprintf("Invalid `after` query parameter: %v", err),
+				Code:       ErrCodeValidation,
+				StatusCode: http.StatusBadRequest,
+				Err:        err,
+			}
+		}
+		params.After = id
+	}
+
+	return params, nil
+}
+
+// maxLongPollWait is the maximum time that listing entries waits for new
+// entries. It's below the default write timeout of the HTTP(S) servers.
+const maxLongPollWait = 30 * time.Second
+
+// parseLongPollWait parses the `wait` query parameter, e.g. `30s`, which is
+// the maximum time to wait for entries when there are none yet.
+func parseLongPollWait(query url.Values) (time.Duration, *APIError) {
+	raw := query.Get("wait")
+	if raw == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 || wait > maxLongPollWait {
+		return 0, &APIError{
+			Message:    fmt.Sprintf("Query parameter `wait` must be a duration (e.g. `30s`), max %v.", maxLongPollWait),
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+
+	return wait, nil
+}
+
+func (srv *Server) ListHTTPLogEntries(w http.ResponseWriter, r *http.Request) {
+	params, apiErr := parseHTTPLogEntriesParams(r.URL.Query())
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+	wait, apiErr := parseLongPollWait(r.URL.Query())
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
 		return
 	}
 
+	// With `wait`, the request is a long poll: without matching entries, the
+	// response is delayed until an entry is stored (the list is empty if the
+	// time is up), so polling clients don't need to poll as often.
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	var logEntries []hosts.HTTPLogEntry
+	for {
+		changed := srv.hostsService.HTTPLogEntriesChanged()
+
+		var err error
+		logEntries, err = srv.hostsService.ListHTTPLogEntries(r.Context(), params)
+		if err != nil {
+			srv.logger.Error("Failed to list HTTP logs.", zap.Error(err))
+			srv.handleInternalError(w)
+			return
+		}
+		if len(logEntries) > 0 || ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+		}
+	}
+
 	data := make([]httpLogEntry, len(logEntries))
 	for i, logEntry := range logEntries {
-		l, err := parseHTTPLogEntry(logEntry)
+		l, err := parseHTTPLogEntry(logEntry, maxInlineBodySize)
 		if err != nil {
 			srv.logger.Error("Failed to parse HTTP log entry.", zap.Error(err))
 			srv.handleInternalError(w)
@@ -263,13 +920,27 @@ func (srv *Server) ListHTTPLogEntries(w http.ResponseWriter, r *http.Request) {
 		data[i] = l
 	}
 
-	writeAPIResponse(w, APIResponse{
+	srv.writeAPIResponse(w, APIResponse{
 		StatusCode: http.StatusOK,
 		Data:       data,
 	})
 }
 
-func parseHTTPLogEntry(log hosts.HTTPLogEntry) (httpLogEntry, error) {
+// hostMismatch returns whether the `Host` header of a request differs from the
+// server name indication (SNI) of its TLS connection. Requests without SNI,
+// e.g. for IP addresses or over plain HTTP, never mismatch.
+func hostMismatch(host, serverName string) bool {
+	if serverName == "" {
+		return false
+	}
+
+	return hosts.NormalizeHostname(stripPort(host)) != hosts.NormalizeHostname(serverName)
+}
+
+// parseHTTPLogEntry parses the raw request and response of an HTTP log entry.
+// Bodies larger than `maxBodySize` (unless 0) are omitted, as are the bodies of
+// the raw request and response, and can be downloaded separately instead.
+func parseHTTPLogEntry(log hosts.HTTPLogEntry, maxBodySize int64) (httpLogEntry, error) {
 	reqReader := bufio.NewReader(bytes.NewReader(log.RawRequest))
 	req, err := http.ReadRequest(reqReader)
 	if err != nil {
@@ -282,34 +953,91 @@ func parseHTTPLogEntry(log hosts.HTTPLogEntry) (httpLogEntry, error) {
 		return httpLogEntry{}, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	reqBody, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return httpLogEntry{}, fmt.Errorf("failed to read request body: %w", err)
+	// Without bodies, the framing headers (e.g. `Content-Length`) no longer
+	// match the raw request and response.
+	var reqBody, resBody []byte
+	var reqBodySize, resBodySize int64
+	var reqBodyOmitted, resBodyOmitted bool
+	if !log.BodyDropped {
+		reqBody, reqBodySize, reqBodyOmitted, err = readLogEntryBody(req.Body, maxBodySize)
+		if err != nil {
+			return httpLogEntry{}, fmt.Errorf("failed to read request body: %w", err)
+		}
+
+		resBody, resBodySize, resBodyOmitted, err = readLogEntryBody(res.Body, maxBodySize)
+		if err != nil {
+			return httpLogEntry{}, fmt.Errorf("failed to read response body: %w", err)
+		}
+	}
+
+	reqBody = decodeBody(reqBody, req.Header.Get("Content-Encoding"))
+	resBody = decodeBody(resBody, res.Header.Get("Content-Encoding"))
+
+	reqEntry := httpRequest{
+		Host:       req.Host,
+		URL:        req.URL.String(),
+		Method:     req.Method,
+		Headers:    req.Header,
+		Body:       reqBody,
+		BodyIsText: isTextBody(reqBody),
+		BodySize:   reqBodySize,
+		RemoteAddr: log.RemoteAddr,
+		ServerName: log.ServerName,
+		Raw:        log.RawRequest,
+		RawWire:    log.RawWire,
+	}
+	// The target of CONNECT requests is an authority (`host:port`), which
+	// would otherwise be formatted as `//host:port`.
+	if req.Method == http.MethodConnect {
+		reqEntry.URL = req.RequestURI
+	}
+	if reqBodyOmitted {
+		reqEntry.BodyIsText = false
+		reqEntry.BodyURL = fmt.Sprintf("/api/http-logs/%v/request-body", log.ID)
+		reqEntry.Raw = hosts.StripHTTPBody(log.RawRequest)
+		reqEntry.RawWire = hosts.StripHTTPBody(log.RawWire)
+	}
+
+	resEntry := httpResponse{
+		StatusCode:    res.StatusCode,
+		Status:        res.Status,
+		Headers:       res.Header,
+		Body:          resBody,
+		BodyIsText:    isTextBody(resBody),
+		BodySize:      resBodySize,
+		Raw:           log.RawResponse,
+		BodyTruncated: log.ResponseBodyTruncated,
+	}
+	if resBodyOmitted {
+		resEntry.BodyIsText = false
+		resEntry.BodyURL = fmt.Sprintf("/api/http-logs/%v/response-body", log.ID)
+		resEntry.Raw = hosts.StripHTTPBody(log.RawResponse)
 	}
 
-	resBody, err := ioutil.ReadAll(res.Body)
+	// A malformed body isn't an error for the log entry as a whole.
+	parsedForm, parsedJSON, err := parseBody(req.Header.Get("Content-Type"), reqBody)
 	if err != nil {
-		return httpLogEntry{}, fmt.Errorf("failed to read response body: %w", err)
+		reqEntry.ParseError = err.Error()
+	}
+	reqEntry.ParsedForm = parsedForm
+	reqEntry.ParsedJSON = parsedJSON
+
+	var clientCerts []clientCertificate
+	for _, raw := range log.ClientCertificates {
+		clientCerts = append(clientCerts, parseClientCertificate(raw))
 	}
 
 	return httpLogEntry{
-		ID:     log.ID,
-		HostID: log.HostID,
-		Request: httpRequest{
-			Host:    req.Host,
-			URL:     req.URL.String(),
-			Method:  req.Method,
-			Headers: req.Header,
-			Body:    reqBody,
-			Raw:     log.RawRequest,
-		},
-		Response: httpResponse{
-			StatusCode: res.StatusCode,
-			Status:     res.Status,
-			Headers:    res.Header,
-			Body:       resBody,
-			Raw:        log.RawResponse,
-		},
-		CreatedAt: ulid.Time(log.ID.Time()).UTC(),
+		ID:                 log.ID,
+		HostID:             log.HostID,
+		Request:            reqEntry,
+		Response:           resEntry,
+		ACMEChallenge:      log.ACMEChallenge,
+		RepeatCount:        log.RepeatCount,
+		CreatedAt:          ulid.Time(log.ID.Time()).UTC(),
+		ClientCertificates: clientCerts,
+		BodyDropped:        log.BodyDropped,
+		HostMismatch:       hostMismatch(req.Host, log.ServerName),
+		Label:              log.Label,
 	}, nil
 }
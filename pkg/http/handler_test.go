@@ -0,0 +1,883 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestIsCaptureHost(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		host   string
+		exp    bool
+	}{
+		{
+			name:   "no suffix",
+			suffix: "",
+			host:   "example.org",
+			exp:    true,
+		},
+		{
+			name:   "suffix",
+			suffix: "example.com",
+			host:   "example.com",
+			exp:    true,
+		},
+		{
+			name:   "subdomain",
+			suffix: "example.com",
+			host:   "abc.example.com",
+			exp:    true,
+		},
+		{
+			name:   "subdomain with port",
+			suffix: "example.com",
+			host:   "abc.example.com:8080",
+			exp:    true,
+		},
+		{
+			name:   "subdomain with trailing dot",
+			suffix: "example.com",
+			host:   "abc.example.com.",
+			exp:    true,
+		},
+		{
+			name:   "uppercase",
+			suffix: "Example.com",
+			host:   "ABC.EXAMPLE.COM",
+			exp:    true,
+		},
+		{
+			name:   "internationalized subdomain",
+			suffix: "example.com",
+			host:   "bücher.example.com",
+			exp:    true,
+		},
+		{
+			name:   "other domain",
+			suffix: "example.com",
+			host:   "example.org",
+			exp:    false,
+		},
+		{
+			name:   "other domain with suffix",
+			suffix: "example.com",
+			host:   "badexample.com",
+			exp:    false,
+		},
+		{
+			name:   "suffix as subdomain",
+			suffix: "example.com",
+			host:   "example.com.evil.test",
+			exp:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(WithCaptureHostSuffix(tt.suffix))
+			if got := srv.isCaptureHost(tt.host); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestCaptureRequestOutsideCaptureDomain(t *testing.T) {
+	svc := &testHostsService{}
+	srv := NewServer(WithHostsService(svc), WithCaptureHostSuffix("example.com"))
+
+	r := httptest.NewRequest("GET", "http://badexample.com/", nil)
+	w := httptest.NewRecorder()
+	srv.CaptureRequest(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %v", w.Code)
+	}
+	if entries := svc.storedEntries(); len(entries) != 0 {
+		t.Errorf("expected no stored entries, got %v", len(entries))
+	}
+}
+
+func TestCaptureRequestPartialBody(t *testing.T) {
+	svc := &testHostsService{}
+	srv := NewServer(WithHostsService(svc))
+
+	body := io.MultiReader(strings.NewReader("foo"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	r := httptest.NewRequest("POST", "http://abc.example.com/", body)
+	w := httptest.NewRecorder()
+	srv.CaptureRequest(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %v", w.Code)
+	}
+	entries := svc.storedEntries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 stored entry, got %v", len(entries))
+	}
+	if got := string(entries[0].RequestBody); got != "foo" {
+		t.Errorf("expected partial body %q, got %q", "foo", got)
+	}
+}
+
+func TestHandlerAPIHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []ServerOption
+		host       string
+		remoteAddr string
+		expAPI     bool
+	}{
+		{
+			name:       "localhost from remote client",
+			host:       "localhost:8080",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     false,
+		},
+		{
+			name:       "loopback IP from remote client",
+			host:       "127.0.0.1:8080",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     false,
+		},
+		{
+			name:       "localhost from loopback client",
+			host:       "localhost:8080",
+			remoteAddr: "127.0.0.1:1234",
+			expAPI:     true,
+		},
+		{
+			name:       "server hostname",
+			opts:       []ServerOption{WithHostname("edena.example.com")},
+			host:       "EDENA.example.com",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     true,
+		},
+		{
+			name:       "server hostname with API on hostname disabled",
+			opts:       []ServerOption{WithHostname("edena.example.com"), WithAPIOnHostname(false)},
+			host:       "edena.example.com",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     false,
+		},
+		{
+			name:       "configured API host",
+			opts:       []ServerOption{WithAPIHosts([]string{"api.example.com"})},
+			host:       "api.example.com:443",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     true,
+		},
+		{
+			name:       "localhost from loopback client with API hosts configured",
+			opts:       []ServerOption{WithAPIHosts([]string{"api.example.com"})},
+			host:       "localhost:8080",
+			remoteAddr: "127.0.0.1:1234",
+			expAPI:     false,
+		},
+		{
+			name:       "capture host",
+			host:       "abc.example.com",
+			remoteAddr: "192.0.2.1:1234",
+			expAPI:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(append([]ServerOption{WithHostsService(svc)}, tt.opts...)...)
+
+			r := httptest.NewRequest("GET", "http://"+tt.host+"/api/hosts", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if got := srv.matchAPIHost(r, nil); got != tt.expAPI {
+				t.Errorf("expected API host match %v, got %v", tt.expAPI, got)
+			}
+
+			// Requests that don't reach the API are captured.
+			w := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(w, r)
+			captured := len(svc.storedEntries()) == 1
+			if captured == tt.expAPI {
+				t.Errorf("expected request to be captured: %v, got %v", !tt.expAPI, captured)
+			}
+		})
+	}
+}
+
+// maxHostsService creates hosts in memory, up to `max` hosts in total.
+type maxHostsService struct {
+	*acmeDNSHostsService
+	max int
+}
+
+func (svc *maxHostsService) CreateHosts(ctx context.Context, params hosts.CreateHostsParams) ([]hosts.Host, error) {
+	svc.mu.Lock()
+	count := len(svc.hosts)
+	svc.mu.Unlock()
+	if count+params.Amount > svc.max {
+		return nil, fmt.Errorf("failed to create hosts: %w", hosts.ErrMaxHostsReached)
+	}
+
+	return svc.acmeDNSHostsService.CreateHosts(ctx, params)
+}
+
+func TestCreateHostsLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []ServerOption
+		maxHosts  int
+		amount    int
+		expStatus int
+	}{
+		{
+			name:      "zero amount",
+			amount:    0,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "min amount",
+			amount:    1,
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "default max per request",
+			amount:    DefaultMaxHostsPerRequest,
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "above default max per request",
+			amount:    DefaultMaxHostsPerRequest + 1,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "configured max per request",
+			opts:      []ServerOption{WithMaxHostsPerRequest(5)},
+			amount:    5,
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "above configured max per request",
+			opts:      []ServerOption{WithMaxHostsPerRequest(5)},
+			amount:    6,
+			expStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "max hosts",
+			maxHosts:  3,
+			amount:    3,
+			expStatus: http.StatusCreated,
+		},
+		{
+			name:      "above max hosts",
+			maxHosts:  3,
+			amount:    4,
+			expStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &maxHostsService{
+				acmeDNSHostsService: &acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)},
+				max:                 tt.maxHosts,
+			}
+			if svc.max == 0 {
+				svc.max = 1000
+			}
+			srv := NewServer(append([]ServerOption{WithHostsService(svc)}, tt.opts...)...)
+
+			body := fmt.Sprintf(`{"amount":%v}`, tt.amount)
+			r := httptest.NewRequest("POST", "/api/hosts", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			if w.Code != tt.expStatus {
+				t.Fatalf("expected status %v, got %v: %s", tt.expStatus, w.Code, w.Body)
+			}
+			if tt.expStatus != http.StatusCreated {
+				return
+			}
+			var res struct {
+				Data []host `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Data) != tt.amount {
+				t.Errorf("expected %v hosts, got %v", tt.amount, len(res.Data))
+			}
+		})
+	}
+}
+
+func TestParseHostIDs(t *testing.T) {
+	newIDs := func(n int) []string {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = ulid.MustNew(ulid.Now(), rand.Reader).String()
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name       string
+		rawIDs     []string
+		expCount   int
+		expMessage string
+	}{
+		{
+			name:       "none",
+			rawIDs:     nil,
+			expMessage: "At least one `hostId` query parameter is required.",
+		},
+		{
+			name:     "one",
+			rawIDs:   newIDs(1),
+			expCount: 1,
+		},
+		{
+			name:     "max",
+			rawIDs:   newIDs(maxHostIDs),
+			expCount: maxHostIDs,
+		},
+		{
+			name:       "above max",
+			rawIDs:     newIDs(maxHostIDs + 1),
+			expMessage: "Cannot filter by more than 20 host IDs.",
+		},
+		{
+			name:     "duplicates",
+			rawIDs:   []string{"01ARZ3NDEKTSV4RRFFQ69G5FAV", "01ARZ3NDEKTSV4RRFFQ69G5FAV"},
+			expCount: 1,
+		},
+		{
+			name:       "invalid",
+			rawIDs:     []string{"foobar"},
+			expMessage: "Failed to parse host ID: ulid: bad data size when unmarshaling",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostIDs, apiErr := parseHostIDs(tt.rawIDs)
+			if tt.expMessage != "" {
+				if apiErr == nil {
+					t.Fatalf("expected error, got %v host IDs", len(hostIDs))
+				}
+				if apiErr.Message != tt.expMessage {
+					t.Errorf("expected message %q, got %q", tt.expMessage, apiErr.Message)
+				}
+				if apiErr.StatusCode != http.StatusBadRequest {
+					t.Errorf("expected status 400, got %v", apiErr.StatusCode)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("unexpected error: %v", apiErr.Message)
+			}
+			if len(hostIDs) != tt.expCount {
+				t.Errorf("expected %v host IDs, got %v", tt.expCount, len(hostIDs))
+			}
+		})
+	}
+}
+
+func TestParseHTTPLogEntriesParams(t *testing.T) {
+	const hostID = "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+
+	t.Run("filters", func(t *testing.T) {
+		query, err := url.ParseQuery("hostId=" + hostID +
+			"&q=s3cr3t&method=POST&pathPrefix=%2Fapi&header=User-Agent%3A+curl&header=X-Foo%3Abar%3Abaz" +
+			"&label=prod-ssrf-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params, apiErr := parseHTTPLogEntriesParams(query)
+		if apiErr != nil {
+			t.Fatalf("unexpected error: %v", apiErr.Message)
+		}
+		exp := hosts.ListHTTPLogEntriesParams{
+			HostIDs:    []ulid.ULID{ulid.MustParse(hostID)},
+			Query:      "s3cr3t",
+			Method:     "POST",
+			PathPrefix: "/api",
+			Headers: []hosts.HeaderFilter{
+				{Name: "User-Agent", Value: "curl"},
+				{Name: "X-Foo", Value: "bar:baz"},
+			},
+			Label: "prod-ssrf-test",
+		}
+		if !reflect.DeepEqual(params, exp) {
+			t.Errorf("expected params %+v, got %+v", exp, params)
+		}
+	})
+
+	for _, rawHeader := range []string{"User-Agent", ":curl", " : curl"} {
+		t.Run("invalid header "+rawHeader, func(t *testing.T) {
+			query := url.Values{"hostId": {hostID}, "header": {rawHeader}}
+			if _, apiErr := parseHTTPLogEntriesParams(query); apiErr == nil || apiErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected bad request error, got %v", apiErr)
+			}
+		})
+	}
+}
+
+func TestCaptureRequestIgnoredPaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []ServerOption
+		path        string
+		expCaptured bool
+	}{
+		{
+			name:        "robots.txt",
+			path:        "/robots.txt",
+			expCaptured: false,
+		},
+		{
+			name:        "favicon",
+			path:        "/favicon.ico",
+			expCaptured: false,
+		},
+		{
+			name:        "other path",
+			path:        "/robots.txt.bak",
+			expCaptured: true,
+		},
+		{
+			name:        "custom ignored path",
+			opts:        []ServerOption{WithIgnorePaths([]string{"/health"})},
+			path:        "/health",
+			expCaptured: false,
+		},
+		{
+			name:        "default path with custom ignored paths",
+			opts:        []ServerOption{WithIgnorePaths([]string{"/health"})},
+			path:        "/robots.txt",
+			expCaptured: true,
+		},
+		{
+			name:        "no ignored paths",
+			opts:        []ServerOption{WithIgnorePaths(nil)},
+			path:        "/favicon.ico",
+			expCaptured: true,
+		},
+		{
+			name:        "ACME challenge",
+			opts:        []ServerOption{WithIgnorePaths([]string{"/.well-known/acme-challenge/token"})},
+			path:        "/.well-known/acme-challenge/token",
+			expCaptured: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(append([]ServerOption{WithHostsService(svc)}, tt.opts...)...)
+
+			r := httptest.NewRequest("GET", "http://abc.example.com"+tt.path, nil)
+			w := httptest.NewRecorder()
+			srv.CaptureRequest(w, r)
+
+			captured := len(svc.storedEntries()) == 1
+			if captured != tt.expCaptured {
+				t.Errorf("expected request to be captured: %v, got %v", tt.expCaptured, captured)
+			}
+			if !tt.expCaptured {
+				if w.Code != http.StatusNoContent {
+					t.Errorf("expected status 204, got %v", w.Code)
+				}
+				if w.Body.Len() != 0 {
+					t.Errorf("expected empty body, got %q", w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureRequestUnusualMethods(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		expMethod string
+		expTarget string
+	}{
+		{
+			name:      "CONNECT",
+			raw:       "CONNECT abc.example.com:443 HTTP/1.1\r\nHost: abc.example.com:443\r\n\r\n",
+			expMethod: "CONNECT",
+			expTarget: "abc.example.com:443",
+		},
+		{
+			name:      "TRACE",
+			raw:       "TRACE /foo HTTP/1.1\r\nHost: abc.example.com\r\n\r\n",
+			expMethod: "TRACE",
+			expTarget: "/foo",
+		},
+		{
+			name:      "PATCH",
+			raw:       "PATCH /foo HTTP/1.1\r\nHost: abc.example.com\r\nContent-Length: 3\r\n\r\nbar",
+			expMethod: "PATCH",
+			expTarget: "/foo",
+		},
+		{
+			name:      "custom method",
+			raw:       "FOOBAR /foo HTTP/1.1\r\nHost: abc.example.com\r\n\r\n",
+			expMethod: "FOOBAR",
+			expTarget: "/foo",
+		},
+		{
+			name:      "asterisk target",
+			raw:       "GET * HTTP/1.1\r\nHost: abc.example.com\r\n\r\n",
+			expMethod: "GET",
+			expTarget: "*",
+		},
+		{
+			name:      "unclean path",
+			raw:       "GET /a/../etc/passwd HTTP/1.1\r\nHost: abc.example.com\r\n\r\n",
+			expMethod: "GET",
+			expTarget: "/a/../etc/passwd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(WithHostsService(svc))
+			addr := serveHTTPTest(t, srv)
+
+			res := sendRaw(t, addr, []byte(tt.raw))
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status 200, got %v", res.StatusCode)
+			}
+
+			entries := svc.storedEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			req := entries[0].Request
+			if req.Method != tt.expMethod {
+				t.Errorf("expected method %q, got %q", tt.expMethod, req.Method)
+			}
+			if req.RequestURI != tt.expTarget {
+				t.Errorf("expected request target %q, got %q", tt.expTarget, req.RequestURI)
+			}
+		})
+	}
+}
+
+func TestParseHTTPLogEntryConnect(t *testing.T) {
+	entry := hosts.HTTPLogEntry{
+		RawRequest:  []byte("CONNECT abc.example.com:443 HTTP/1.1\r\nHost: abc.example.com:443\r\n\r\n"),
+		RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+	}
+
+	parsed, err := parseHTTPLogEntry(entry, maxInlineBodySize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Request.Method != "CONNECT" {
+		t.Errorf("expected method %q, got %q", "CONNECT", parsed.Request.Method)
+	}
+	if exp := "abc.example.com:443"; parsed.Request.URL != exp {
+		t.Errorf("expected URL %q, got %q", exp, parsed.Request.URL)
+	}
+}
+
+func TestCaptureRequestHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		host      string
+		expStatus int
+	}{
+		{name: "empty", host: "", expStatus: http.StatusBadRequest},
+		{name: "only port", host: ":8080", expStatus: http.StatusBadRequest},
+		{name: "lowercase", host: "abc.example.com", expStatus: http.StatusOK},
+		{name: "with port", host: "abc.example.com:8080", expStatus: http.StatusOK},
+		{name: "uppercase", host: "ABC.Example.COM", expStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(WithHostsService(svc))
+
+			r := httptest.NewRequest("GET", "http://example.com/", nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			srv.CaptureRequest(w, r)
+
+			if w.Code != tt.expStatus {
+				t.Fatalf("expected status %v, got %v", tt.expStatus, w.Code)
+			}
+			expEntries := 1
+			if tt.expStatus != http.StatusOK {
+				expEntries = 0
+			}
+			if n := len(svc.storedEntries()); n != expEntries {
+				t.Errorf("expected %v stored entries, got %v", expEntries, n)
+			}
+		})
+	}
+}
+
+// pollHostsService lists HTTP log entries after a cursor, and notifies
+// waiters when entries are added.
+type pollHostsService struct {
+	hosts.Service
+
+	mu      sync.Mutex
+	entries []hosts.HTTPLogEntry
+	changed chan struct{}
+}
+
+func newPollHostsService() *pollHostsService {
+	return &pollHostsService{changed: make(chan struct{})}
+}
+
+func (svc *pollHostsService) ListHTTPLogEntries(_ context.Context, params hosts.ListHTTPLogEntriesParams) ([]hosts.HTTPLogEntry, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	var entries []hosts.HTTPLogEntry
+	for _, entry := range svc.entries {
+		if entry.ID.Compare(params.After) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (svc *pollHostsService) HTTPLogEntriesChanged() <-chan struct{} {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.changed
+}
+
+func (svc *pollHostsService) add(entry hosts.HTTPLogEntry) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	svc.entries = append(svc.entries, entry)
+	close(svc.changed)
+	svc.changed = make(chan struct{})
+}
+
+// newTestHTTPLogEntry returns an HTTP log entry with an ID from `ms`
+// milliseconds after now, so IDs of entries are ordered.
+func newTestHTTPLogEntry(ms uint64) hosts.HTTPLogEntry {
+	return hosts.HTTPLogEntry{
+		ID:          ulid.MustNew(ulid.Now()+ms, rand.Reader),
+		RawRequest:  []byte("GET / HTTP/1.1\r\nHost: abc.example.com\r\n\r\n"),
+		RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+	}
+}
+
+func TestListHTTPLogEntriesLongPoll(t *testing.T) {
+	const hostID = "01ARZ3NDEKTSV4RRFFQ69G5FAV"
+
+	list := func(t *testing.T, srv *Server, query string) ([]httpLogEntry, time.Duration) {
+		t.Helper()
+
+		start := time.Now()
+		r := httptest.NewRequest("GET", "/api/http-logs?hostId="+hostID+"&"+query, nil)
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+		elapsed := time.Since(start)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body)
+		}
+		var res struct {
+			Data []httpLogEntry `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Data, elapsed
+	}
+
+	t.Run("entries exist", func(t *testing.T) {
+		svc := newPollHostsService()
+		first, second := newTestHTTPLogEntry(0), newTestHTTPLogEntry(1)
+		svc.add(first)
+		svc.add(second)
+		srv := NewServer(WithHostsService(svc))
+
+		entries, elapsed := list(t, srv, "after="+first.ID.String()+"&wait=10s")
+		if len(entries) != 1 || entries[0].ID != second.ID {
+			t.Errorf("expected entry %v, got %+v", second.ID, entries)
+		}
+		if elapsed > 5*time.Second {
+			t.Errorf("expected immediate return, took %v", elapsed)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		svc := newPollHostsService()
+		entry := newTestHTTPLogEntry(0)
+		svc.add(entry)
+		srv := NewServer(WithHostsService(svc))
+
+		entries, elapsed := list(t, srv, "after="+entry.ID.String()+"&wait=100ms")
+		if len(entries) != 0 {
+			t.Errorf("expected no entries, got %+v", entries)
+		}
+		if elapsed < 100*time.Millisecond {
+			t.Errorf("expected to wait at least 100ms, took %v", elapsed)
+		}
+	})
+
+	t.Run("entry stored while waiting", func(t *testing.T) {
+		svc := newPollHostsService()
+		srv := NewServer(WithHostsService(svc))
+		entry := newTestHTTPLogEntry(0)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			svc.add(entry)
+		}()
+
+		entries, elapsed := list(t, srv, "wait=10s")
+		if len(entries) != 1 || entries[0].ID != entry.ID {
+			t.Errorf("expected entry %v, got %+v", entry.ID, entries)
+		}
+		if elapsed > 5*time.Second {
+			t.Errorf("expected return when entry is stored, took %v", elapsed)
+		}
+	})
+
+	for _, query := range []string{"wait=foo", "wait=-1s", "wait=1h", "after=foo"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			srv := NewServer(WithHostsService(newPollHostsService()))
+			r := httptest.NewRequest("GET", "/api/http-logs?hostId="+hostID+"&"+query, nil)
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestValidateHostLabel(t *testing.T) {
+	tests := []struct {
+		label    string
+		expValid bool
+	}{
+		{label: "", expValid: true},
+		{label: "prod-ssrf-test", expValid: true},
+		{label: "Team_A.v2:ssrf", expValid: true},
+		{label: strings.Repeat("a", maxHostLabelLength), expValid: true},
+		{label: strings.Repeat("a", maxHostLabelLength+1), expValid: false},
+		{label: "with space", expValid: false},
+		{label: "a&b=c", expValid: false},
+		{label: "bücher", expValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			apiErr := validateHostLabel(tt.label)
+			if valid := apiErr == nil; valid != tt.expValid {
+				t.Errorf("expected valid %v, got %v", tt.expValid, valid)
+			}
+		})
+	}
+}
+
+func TestHostMismatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		host       string
+		serverName string
+		exp        bool
+	}{
+		{name: "matching", host: "abc.example.com", serverName: "abc.example.com"},
+		{name: "host with port", host: "abc.example.com:8443", serverName: "abc.example.com"},
+		{name: "different case", host: "ABC.Example.com", serverName: "abc.example.COM"},
+		{name: "trailing dot", host: "abc.example.com.", serverName: "abc.example.com"},
+		{name: "unicode host", host: "bücher.example.com", serverName: "xn--bcher-kva.example.com"},
+		{name: "no TLS", host: "abc.example.com", serverName: ""},
+		{name: "mismatching", host: "abc.example.com", serverName: "def.example.com", exp: true},
+		{name: "mismatching with port", host: "abc.example.com:443", serverName: "def.example.com", exp: true},
+		{name: "subdomain", host: "foo.abc.example.com", serverName: "abc.example.com", exp: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostMismatch(tt.host, tt.serverName); got != tt.exp {
+				t.Errorf("expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestParseHTTPLogEntryHostMismatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		serverName  string
+		expMismatch bool
+	}{
+		{name: "matching", host: "abc.example.com", serverName: "abc.example.com"},
+		{name: "host with port and different case", host: "ABC.example.com:8443", serverName: "abc.example.com"},
+		{name: "no TLS", host: "abc.example.com"},
+		{name: "mismatching", host: "abc.example.com", serverName: "def.example.com", expMismatch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := newTestHTTPLogEntry(0)
+			entry.RawRequest = []byte("GET / HTTP/1.1\r\nHost: " + tt.host + "\r\n\r\n")
+			entry.ServerName = tt.serverName
+
+			l, err := parseHTTPLogEntry(entry, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			b, err := json.Marshal(l)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				HostMismatch *bool `json:"hostMismatch"`
+				Request      struct {
+					ServerName string `json:"serverName"`
+				} `json:"request"`
+			}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got.HostMismatch == nil {
+				t.Fatalf("expected hostMismatch field, got %s", b)
+			}
+			if *got.HostMismatch != tt.expMismatch {
+				t.Errorf("expected hostMismatch %v, got %v", tt.expMismatch, *got.HostMismatch)
+			}
+			if got.Request.ServerName != tt.serverName {
+				t.Errorf("expected server name %q, got %q", tt.serverName, got.Request.ServerName)
+			}
+		})
+	}
+}
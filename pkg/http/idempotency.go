@@ -0,0 +1,173 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyTTL is the time idempotency keys of API requests are
+// remembered, when not configured with WithIdempotencyTTL.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// idempotencyMaxKeys is the max amount of idempotency keys remembered. It
+// bounds memory use when clients send a key with every request.
+const idempotencyMaxKeys = 10000
+
+// idempotencyStore remembers the responses of API requests by the value of
+// their `Idempotency-Key` header, so retried requests are answered with the
+// original response instead of being handled again. Keys are kept in memory,
+// so they are forgotten on restart.
+type idempotencyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	// fingerprint identifies the request, so a key reused for a different
+	// request can be rejected.
+	fingerprint string
+	// res is nil while the request is being handled.
+	res       *APIResponse
+	expiresAt time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// begin returns a copy of the entry of a key, if it exists. Otherwise, a
+// pending entry is added for the request and `stored` is true; the entry must
+// then be completed with complete or removed with release. If the store is
+// full, nothing is added and the request isn't protected against duplicates.
+func (s *idempotencyStore) begin(key, fingerprint string) (existing *idempotencyEntry, stored bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if entry, ok := s.entries[key]; ok {
+		if now.Before(entry.expiresAt) {
+			copied := *entry
+			return &copied, false
+		}
+		delete(s.entries, key)
+	}
+
+	if len(s.entries) >= idempotencyMaxKeys {
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= idempotencyMaxKeys {
+			return nil, false
+		}
+	}
+
+	s.entries[key] = &idempotencyEntry{
+		fingerprint: fingerprint,
+		expiresAt:   now.Add(s.ttl),
+	}
+
+	return nil, true
+}
+
+// complete stores the response of a pending request.
+func (s *idempotencyStore) complete(key string, res APIResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.res = &res
+		entry.expiresAt = time.Now().Add(s.ttl)
+	}
+}
+
+// release removes a pending request, e.g. when it failed, so it can be
+// retried with the same key.
+func (s *idempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// maxIdempotencyKeyLength is the max length of `Idempotency-Key` headers.
+const maxIdempotencyKeyLength = 255
+
+// beginIdempotentRequest checks the `Idempotency-Key` header of a request. If
+// the key was used before, the original response (or an error) is written to
+// `w`, and false is returned. Otherwise, the returned key must be passed to
+// endIdempotentRequest once the request is handled. The key is empty if the
+// request has no key, or if idempotency keys are disabled.
+func (srv *Server) beginIdempotentRequest(w http.ResponseWriter, r *http.Request, fingerprint string) (string, bool) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || srv.idempotency == nil {
+		return "", true
+	}
+
+	if len(key) > maxIdempotencyKeyLength || !isPrintableASCII(key) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Header \"Idempotency-Key\" must be printable ASCII, max %v characters.", maxIdempotencyKeyLength),
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+		})
+		return "", false
+	}
+
+	existing, stored := srv.idempotency.begin(key, fingerprint)
+	switch {
+	case existing != nil && existing.fingerprint != fingerprint:
+		srv.writeAPIError(w, &APIError{
+			Message:    "Idempotency key was already used for a request with different parameters.",
+			Code:       ErrCodeIdempotencyKeyReused,
+			StatusCode: http.StatusUnprocessableEntity,
+		})
+		return "", false
+	case existing != nil && existing.res == nil:
+		srv.writeAPIError(w, &APIError{
+			Message:    "A request with this idempotency key is still being handled.",
+			Code:       ErrCodeIdempotencyKeyInUse,
+			StatusCode: http.StatusConflict,
+		})
+		return "", false
+	case existing != nil:
+		w.Header().Set("Idempotent-Replayed", "true")
+		srv.writeAPIResponse(w, *existing.res)
+		return "", false
+	case !stored:
+		srv.logger.Warn("Too many idempotency keys, handling request without one.")
+		return "", true
+	}
+
+	return key, true
+}
+
+// endIdempotentRequest stores the response of a request for its idempotency
+// key. If `res` is nil (e.g. the request failed), the key is released, so the
+// request can be retried with it.
+func (srv *Server) endIdempotentRequest(key string, res *APIResponse) {
+	if key == "" {
+		return
+	}
+	if res == nil {
+		srv.idempotency.release(key)
+		return
+	}
+	srv.idempotency.complete(key, *res)
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestCreateHostsIdempotencyKey(t *testing.T) {
+	svc := &acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)}
+	srv := NewServer(WithHostsService(svc))
+
+	createHosts := func(t *testing.T, key, body string) ([]string, *httptest.ResponseRecorder) {
+		t.Helper()
+
+		r := httptest.NewRequest("POST", "/api/hosts", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+
+		if w.Code != http.StatusCreated {
+			return nil, w
+		}
+		var res struct {
+			Data []host `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(res.Data))
+		for i, h := range res.Data {
+			ids[i] = h.ID.String()
+		}
+		return ids, w
+	}
+
+	first, w := createHosts(t, "key-a", `{"amount":2}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+	}
+	if len(first) != 2 {
+		t.Fatalf("expected 2 hosts, got %v", len(first))
+	}
+
+	t.Run("same key", func(t *testing.T) {
+		replayed, w := createHosts(t, "key-a", `{"amount":2}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+		}
+		if !reflect.DeepEqual(replayed, first) {
+			t.Errorf("expected hosts %v, got %v", first, replayed)
+		}
+		if got := w.Header().Get("Idempotent-Replayed"); got != "true" {
+			t.Errorf("expected Idempotent-Replayed header %q, got %q", "true", got)
+		}
+		if n := len(svc.hosts); n != 2 {
+			t.Errorf("expected 2 stored hosts, got %v", n)
+		}
+	})
+
+	t.Run("same key with different parameters", func(t *testing.T) {
+		_, w := createHosts(t, "key-a", `{"amount":3}`)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status 422, got %v: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("different key", func(t *testing.T) {
+		other, w := createHosts(t, "key-b", `{"amount":2}`)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+		}
+		if reflect.DeepEqual(other, first) {
+			t.Errorf("expected new hosts, got %v", other)
+		}
+		if got := w.Header().Get("Idempotent-Replayed"); got != "" {
+			t.Errorf("expected no Idempotent-Replayed header, got %q", got)
+		}
+		if n := len(svc.hosts); n != 4 {
+			t.Errorf("expected 4 stored hosts, got %v", n)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, w := createHosts(t, strings.Repeat("a", maxIdempotencyKeyLength+1), `{"amount":1}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %v: %s", w.Code, w.Body)
+		}
+	})
+}
+
+func TestCreateHostsIdempotencyKeyReleasedOnError(t *testing.T) {
+	svc := &maxHostsService{
+		acmeDNSHostsService: &acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)},
+		max:                 0,
+	}
+	srv := NewServer(WithHostsService(svc))
+
+	do := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/hosts", strings.NewReader(`{"amount":1}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Idempotency-Key", "key-a")
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do(); w.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %v: %s", w.Code, w.Body)
+	}
+
+	// A failed request can be retried with the same key.
+	svc.max = 1
+	if w := do(); w.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %v: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+type importResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// ImportHTTPLogEntries stores HTTP log entries from an NDJSON export (see
+// ExportHTTPLogEntries), e.g. of another instance. IDs of entries and hosts
+// are preserved, and hosts that don't exist are created. Entries that exist
+// are skipped, so an import can safely be retried. As it creates hosts with
+// chosen IDs and hostnames, it requires the admin token.
+func (srv *Server) ImportHTTPLogEntries(w http.ResponseWriter, r *http.Request) {
+	var result importResult
+
+	dec := json.NewDecoder(r.Body)
+
+	for line := 1; ; line++ {
+		var l httpLogEntry
+		err := dec.Decode(&l)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Failed to parse entry %v: %v. Preceding entries were imported.", line, err),
+				Code:       ErrCodeInvalidRequest,
+				StatusCode: http.StatusBadRequest,
+				Err:        err,
+			})
+			return
+		}
+
+		params, err := parseImportEntry(l)
+		if err != nil {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Invalid entry %v: %v. Preceding entries were imported.", line, err),
+				Code:       ErrCodeValidation,
+				StatusCode: http.StatusBadRequest,
+				Err:        err,
+			})
+			return
+		}
+
+		imported, err := srv.hostsService.ImportHTTPLogEntry(r.Context(), params)
+		if errors.Is(err, hosts.ErrHostnameTaken) {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Failed to import entry %v: hostname %q is used by another host. Preceding entries were imported.", line, params.Host.Hostname),
+				Code:       ErrCodeHostnameTaken,
+				StatusCode: http.StatusConflict,
+				Err:        err,
+			})
+			return
+		}
+		if errors.Is(err, hosts.ErrHostIDTaken) {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Failed to import entry %v: host ID %v is used by a host with another hostname. Preceding entries were imported.", line, params.Host.ID),
+				Code:       ErrCodeHostIDTaken,
+				StatusCode: http.StatusConflict,
+				Err:        err,
+			})
+			return
+		}
+		if errors.Is(err, hosts.ErrHostnameOutsideBase) {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Invalid entry %v: hostname %q is not a subdomain of the base hostname. Preceding entries were imported.", line, params.Host.Hostname),
+				Code:       ErrCodeValidation,
+				StatusCode: http.StatusBadRequest,
+				Err:        err,
+			})
+			return
+		}
+		if errors.Is(err, hosts.ErrHostNotFound) {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Failed to import entry %v: host %q expired. Preceding entries were imported.", line, params.Host.Hostname),
+				Code:       ErrCodeHostNotFound,
+				StatusCode: http.StatusNotFound,
+				Err:        err,
+			})
+			return
+		}
+		if errors.Is(err, hosts.ErrMaxHostsReached) {
+			srv.writeAPIError(w, &APIError{
+				Message:    fmt.Sprintf("Failed to import entry %v: maximum amount of hosts reached. Preceding entries were imported.", line),
+				Code:       ErrCodeMaxHostsReached,
+				StatusCode: http.StatusForbidden,
+				Err:        err,
+			})
+			return
+		}
+		if err != nil {
+			srv.logger.Error("Failed to import HTTP log entry.", zap.Error(err))
+			srv.handleInternalError(w)
+			return
+		}
+
+		if imported {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	srv.logger.Info("Imported HTTP log entries.",
+		zap.Int("imported", result.Imported),
+		zap.Int("skipped", result.Skipped),
+	)
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       result,
+	})
+}
+
+// parseImportEntry converts an exported HTTP log entry. The raw request and
+// response are parsed, so entries that can't be listed afterwards are
+// rejected.
+func parseImportEntry(l httpLogEntry) (hosts.ImportHTTPLogEntryParams, error) {
+	var zero ulid.ULID
+	if l.ID == zero {
+		return hosts.ImportHTTPLogEntryParams{}, errors.New(`property "id" is required`)
+	}
+	if l.HostID == zero {
+		return hosts.ImportHTTPLogEntryParams{}, errors.New(`property "hostId" is required`)
+	}
+	hostname := stripPort(l.Request.Host)
+	if hostname == "" {
+		return hosts.ImportHTTPLogEntryParams{}, errors.New(`property "request.host" is required`)
+	}
+	if l.RepeatCount < 0 {
+		return hosts.ImportHTTPLogEntryParams{}, errors.New(`property "repeatCount" cannot be negative`)
+	}
+
+	clientCerts := make([][]byte, len(l.ClientCertificates))
+	for i, cert := range l.ClientCertificates {
+		clientCerts[i] = cert.Raw
+	}
+
+	entry := hosts.HTTPLogEntry{
+		ID:                    l.ID,
+		HostID:                l.HostID,
+		RawRequest:            l.Request.Raw,
+		RawResponse:           l.Response.Raw,
+		RemoteAddr:            l.Request.RemoteAddr,
+		ACMEChallenge:         l.ACMEChallenge,
+		ClientCertificates:    clientCerts,
+		RepeatCount:           l.RepeatCount,
+		BodyDropped:           l.BodyDropped,
+		ServerName:            l.Request.ServerName,
+		RawWire:               l.Request.RawWire,
+		ResponseBodyTruncated: l.Response.BodyTruncated,
+		Label:                 l.Label,
+	}
+	if _, err := parseHTTPLogEntry(entry, 0); err != nil {
+		return hosts.ImportHTTPLogEntryParams{}, err
+	}
+
+	return hosts.ImportHTTPLogEntryParams{
+		Host: hosts.Host{
+			ID:       l.HostID,
+			Hostname: hostname,
+		},
+		Entry: entry,
+	}, nil
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// importHostsService records imported HTTP log entries, and fails imports
+// with `err` if set.
+type importHostsService struct {
+	hosts.Service
+
+	mu       sync.Mutex
+	imported []hosts.ImportHTTPLogEntryParams
+	err      error
+}
+
+func (svc *importHostsService) ImportHTTPLogEntry(_ context.Context, params hosts.ImportHTTPLogEntryParams) (bool, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if svc.err != nil {
+		return false, svc.err
+	}
+	svc.imported = append(svc.imported, params)
+	return true, nil
+}
+
+// exportLine returns an exported HTTP log entry with `repeatCount`, as a line
+// of an NDJSON export.
+func exportLine(t *testing.T, repeatCount int) string {
+	t.Helper()
+
+	entry := newTestHTTPLogEntry(0)
+	entry.HostID = ulid.MustNew(ulid.Now(), rand.Reader)
+
+	l, err := parseHTTPLogEntry(entry, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.RepeatCount = repeatCount
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b) + "\n"
+}
+
+func importRequest(body, token string) *http.Request {
+	r := httptest.NewRequest("POST", "/api/http-logs/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-ndjson")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestImportHTTPLogEntriesAdminToken(t *testing.T) {
+	t.Run("without admin token configured", func(t *testing.T) {
+		svc := &importHostsService{}
+		srv := NewServer(WithHostsService(svc))
+
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, importRequest(exportLine(t, 0), ""))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %v", w.Code)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		token     string
+		expStatus int
+		expCount  int
+	}{
+		{name: "without token", expStatus: http.StatusUnauthorized},
+		{name: "with invalid token", token: "wrong", expStatus: http.StatusUnauthorized},
+		{name: "with admin token", token: "secret", expStatus: http.StatusOK, expCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &importHostsService{}
+			srv := NewServer(WithHostsService(svc), WithAdminToken("secret"))
+
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, importRequest(exportLine(t, 0), tt.token))
+			if w.Code != tt.expStatus {
+				t.Errorf("expected status %v, got %v: %s", tt.expStatus, w.Code, w.Body)
+			}
+			if len(svc.imported) != tt.expCount {
+				t.Errorf("expected %v imported entries, got %v", tt.expCount, len(svc.imported))
+			}
+		})
+	}
+}
+
+func TestImportHTTPLogEntriesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		repeatCount int
+		err         error
+		expStatus   int
+		expCode     string
+	}{
+		{
+			name:        "negative repeat count",
+			repeatCount: -1,
+			expStatus:   http.StatusBadRequest,
+			expCode:     ErrCodeValidation,
+		},
+		{
+			name:      "hostname outside of base hostname",
+			err:       fmt.Errorf("hosts: failed: %w", hosts.ErrHostnameOutsideBase),
+			expStatus: http.StatusBadRequest,
+			expCode:   ErrCodeValidation,
+		},
+		{
+			name:      "hostname taken",
+			err:       fmt.Errorf("hosts: failed: %w", hosts.ErrHostnameTaken),
+			expStatus: http.StatusConflict,
+			expCode:   ErrCodeHostnameTaken,
+		},
+		{
+			name:      "host ID taken",
+			err:       fmt.Errorf("hosts: failed: %w", hosts.ErrHostIDTaken),
+			expStatus: http.StatusConflict,
+			expCode:   ErrCodeHostIDTaken,
+		},
+		{
+			name:      "host expired",
+			err:       fmt.Errorf("hosts: failed: %w", hosts.ErrHostNotFound),
+			expStatus: http.StatusNotFound,
+			expCode:   ErrCodeHostNotFound,
+		},
+		{
+			name:      "max hosts reached",
+			err:       fmt.Errorf("hosts: failed: %w", hosts.ErrMaxHostsReached),
+			expStatus: http.StatusForbidden,
+			expCode:   ErrCodeMaxHostsReached,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &importHostsService{err: tt.err}
+			srv := NewServer(WithHostsService(svc), WithAdminToken("secret"))
+
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, importRequest(exportLine(t, tt.repeatCount), "secret"))
+			if w.Code != tt.expStatus {
+				t.Errorf("expected status %v, got %v: %s", tt.expStatus, w.Code, w.Body)
+			}
+
+			var res APIResponse
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error == nil || res.Error.Code != tt.expCode {
+				t.Errorf("expected error code %q, got %+v", tt.expCode, res.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// maxInlineBodySize is the max size of request and response bodies included
+// when listing HTTP log entries. Larger bodies are omitted, and can be
+// downloaded with GetHTTPLogEntryBody.
+const maxInlineBodySize = 1 << 20
+
+// readLogEntryBody reads a body, and returns it with its size. If `max` is not
+// 0 and the body is larger, it's omitted: only its size is returned.
+func readLogEntryBody(r io.Reader, max int64) (body []byte, size int64, omitted bool, err error) {
+	if max == 0 {
+		body, err = ioutil.ReadAll(r)
+		return body, int64(len(body)), false, err
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if int64(len(body)) <= max {
+		return body, int64(len(body)), false, nil
+	}
+
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return nil, int64(len(body)) + n, true, nil
+}
+
+// GetHTTPLogEntryBody streams the request or response body of an HTTP log
+// entry, as received (e.g. still compressed, with the `Content-Encoding` header
+// set accordingly). Because bodies are controlled by whoever sent them, they
+// are served as attachments in a sandbox, so they can't run scripts on the
+// origin of the API.
+func (srv *Server) GetHTTPLogEntryBody(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	id, err := ulid.Parse(vars["id"])
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to parse HTTP log entry ID: %v", err),
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		})
+		return
+	}
+
+	logEntry, err := srv.hostsService.FindHTTPLogEntryByID(r.Context(), id)
+	if errors.Is(err, hosts.ErrHTTPLogEntryNotFound) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("HTTP log entry %q not found.", id),
+			Code:       ErrCodeHTTPLogEntryNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to find HTTP log entry.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+	if logEntry.BodyDropped {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Bodies of HTTP log entry %q were dropped.", id),
+			Code:       ErrCodeBodyDropped,
+			StatusCode: http.StatusGone,
+		})
+		return
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(logEntry.RawRequest)))
+	if err != nil {
+		srv.logger.Error("Failed to read request of HTTP log entry.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	header, body := req.Header, req.Body
+	if vars["part"] == "response" {
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(logEntry.RawResponse)), req)
+		if err != nil {
+			srv.logger.Error("Failed to read response of HTTP log entry.", zap.Error(err))
+			srv.handleInternalError(w)
+			return
+		}
+		header, body = res.Header, res.Body
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if contentEncoding := header.Get("Content-Encoding"); contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v-%v-body"`, id, vars["part"]))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, body); err != nil {
+		// Headers (and possibly part of the body) are already written, so the
+		// error can only be logged.
+		srv.logger.Debug("Failed to write HTTP log entry body.", zap.Error(err))
+	}
+}
@@ -0,0 +1,237 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// bodyHostsService finds HTTP log entries by ID.
+type bodyHostsService struct {
+	hosts.Service
+	entries map[ulid.ULID]hosts.HTTPLogEntry
+}
+
+func (svc *bodyHostsService) FindHTTPLogEntryByID(_ context.Context, id ulid.ULID) (hosts.HTTPLogEntry, error) {
+	entry, ok := svc.entries[id]
+	if !ok {
+		return hosts.HTTPLogEntry{}, hosts.ErrHTTPLogEntryNotFound
+	}
+	return entry, nil
+}
+
+func TestReadLogEntryBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		max        int64
+		expBody    string
+		expSize    int64
+		expOmitted bool
+	}{
+		{
+			name:    "unlimited",
+			body:    "foobar",
+			max:     0,
+			expBody: "foobar",
+			expSize: 6,
+		},
+		{
+			name:    "below max",
+			body:    "foo",
+			max:     6,
+			expBody: "foo",
+			expSize: 3,
+		},
+		{
+			name:    "at max",
+			body:    "foobar",
+			max:     6,
+			expBody: "foobar",
+			expSize: 6,
+		},
+		{
+			name:       "above max",
+			body:       "foobarbaz",
+			max:        6,
+			expSize:    9,
+			expOmitted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, size, omitted, err := readLogEntryBody(strings.NewReader(tt.body), tt.max)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(body) != tt.expBody {
+				t.Errorf("expected body %q, got %q", tt.expBody, body)
+			}
+			if size != tt.expSize {
+				t.Errorf("expected size %v, got %v", tt.expSize, size)
+			}
+			if omitted != tt.expOmitted {
+				t.Errorf("expected omitted %v, got %v", tt.expOmitted, omitted)
+			}
+		})
+	}
+}
+
+func TestParseHTTPLogEntryOmitsLargeBodies(t *testing.T) {
+	tests := []struct {
+		name       string
+		bodySize   int
+		expOmitted bool
+	}{
+		{name: "at threshold", bodySize: maxInlineBodySize},
+		{name: "above threshold", bodySize: maxInlineBodySize + 1, expOmitted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := ulid.MustNew(ulid.Now(), rand.Reader)
+			body := strings.Repeat("a", tt.bodySize)
+			entry := hosts.HTTPLogEntry{
+				ID: id,
+				RawRequest: []byte(fmt.Sprintf("POST / HTTP/1.1\r\nHost: abc.example.com\r\nContent-Length: %v\r\n\r\n%v",
+					tt.bodySize, body)),
+				RawResponse: []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %v\r\n\r\n%v", tt.bodySize, body)),
+			}
+
+			parsed, err := parseHTTPLogEntry(entry, maxInlineBodySize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if parsed.Request.BodySize != int64(tt.bodySize) {
+				t.Errorf("expected request body size %v, got %v", tt.bodySize, parsed.Request.BodySize)
+			}
+			if parsed.Response.BodySize != int64(tt.bodySize) {
+				t.Errorf("expected response body size %v, got %v", tt.bodySize, parsed.Response.BodySize)
+			}
+
+			if !tt.expOmitted {
+				if len(parsed.Request.Body) != tt.bodySize || len(parsed.Response.Body) != tt.bodySize {
+					t.Errorf("expected bodies of %v bytes, got %v and %v", tt.bodySize, len(parsed.Request.Body), len(parsed.Response.Body))
+				}
+				if parsed.Request.BodyURL != "" || parsed.Response.BodyURL != "" {
+					t.Errorf("expected no body URLs, got %q and %q", parsed.Request.BodyURL, parsed.Response.BodyURL)
+				}
+				return
+			}
+
+			if parsed.Request.Body != nil || parsed.Response.Body != nil {
+				t.Error("expected bodies to be omitted")
+			}
+			if exp := fmt.Sprintf("/api/http-logs/%v/request-body", id); parsed.Request.BodyURL != exp {
+				t.Errorf("expected request body URL %q, got %q", exp, parsed.Request.BodyURL)
+			}
+			if exp := fmt.Sprintf("/api/http-logs/%v/response-body", id); parsed.Response.BodyURL != exp {
+				t.Errorf("expected response body URL %q, got %q", exp, parsed.Response.BodyURL)
+			}
+			if strings.Contains(string(parsed.Request.Raw), body) || strings.Contains(string(parsed.Response.Raw), body) {
+				t.Error("expected bodies to be stripped from raw request and response")
+			}
+		})
+	}
+}
+
+func TestGetHTTPLogEntryBody(t *testing.T) {
+	id := ulid.MustNew(ulid.Now(), rand.Reader)
+	droppedID := ulid.MustNew(ulid.Now(), rand.Reader)
+	svc := &bodyHostsService{
+		entries: map[ulid.ULID]hosts.HTTPLogEntry{
+			id: {
+				ID: id,
+				RawRequest: []byte("POST / HTTP/1.1\r\nHost: abc.example.com\r\n" +
+					"Content-Type: application/json\r\nContent-Length: 13\r\n\r\n{\"foo\":\"bar\"}"),
+				RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: 4\r\n\r\n\x1f\x8b\x08\x00"),
+			},
+			droppedID: {
+				ID:          droppedID,
+				RawRequest:  []byte("GET / HTTP/1.1\r\nHost: abc.example.com\r\n\r\n"),
+				RawResponse: []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+				BodyDropped: true,
+			},
+		},
+	}
+	srv := NewServer(WithHostsService(svc))
+
+	tests := []struct {
+		name               string
+		path               string
+		expStatus          int
+		expBody            string
+		expContentType     string
+		expContentEncoding string
+	}{
+		{
+			name:           "request body",
+			path:           fmt.Sprintf("/api/http-logs/%v/request-body", id),
+			expStatus:      http.StatusOK,
+			expBody:        `{"foo":"bar"}`,
+			expContentType: "application/json",
+		},
+		{
+			name:               "response body",
+			path:               fmt.Sprintf("/api/http-logs/%v/response-body", id),
+			expStatus:          http.StatusOK,
+			expBody:            "\x1f\x8b\x08\x00",
+			expContentType:     "application/octet-stream",
+			expContentEncoding: "gzip",
+		},
+		{
+			name:      "not found",
+			path:      fmt.Sprintf("/api/http-logs/%v/request-body", ulid.MustNew(ulid.Now(), rand.Reader)),
+			expStatus: http.StatusNotFound,
+		},
+		{
+			name:      "body dropped",
+			path:      fmt.Sprintf("/api/http-logs/%v/request-body", droppedID),
+			expStatus: http.StatusGone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			srv.APIHandler().ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != tt.expStatus {
+				t.Fatalf("expected status %v, got %v: %s", tt.expStatus, res.StatusCode, w.Body)
+			}
+			if tt.expStatus != http.StatusOK {
+				return
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.expBody {
+				t.Errorf("expected body %q, got %q", tt.expBody, body)
+			}
+			if got := res.Header.Get("Content-Type"); got != tt.expContentType {
+				t.Errorf("expected content type %q, got %q", tt.expContentType, got)
+			}
+			if got := res.Header.Get("Content-Encoding"); got != tt.expContentEncoding {
+				t.Errorf("expected content encoding %q, got %q", tt.expContentEncoding, got)
+			}
+			if got := res.Header.Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+				t.Errorf("expected attachment content disposition, got %q", got)
+			}
+		})
+	}
+}
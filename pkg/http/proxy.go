@@ -0,0 +1,132 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// proxyRequest forwards a captured request to the configured upstream, writes
+// the upstream response to the client and stores both as an HTTP log entry.
+func (srv *Server) proxyRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, err := srv.hostsService.FindHostByHostname(ctx, r.Host)
+	if errors.Is(err, hosts.ErrHostNotFound) {
+		srv.logger.Info("Host not found, ignorning incoming request.", zap.Error(err))
+		code := http.StatusNotFound
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to find host.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	// The request body is read by both the reverse proxy and the hosts
+	// service, so it's buffered once and replayed.
+	reqBody, err := bufferBody(r)
+	if err != nil {
+		srv.logger.Error("Failed to read request body.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	res := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(srv.upstream)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = srv.upstream.Host
+	}
+	// The upstream response body is streamed to the client, and recorded up
+	// to the body limit while doing so.
+	var resBody *recordedBody
+	proxy.ModifyResponse = func(upstreamRes *http.Response) error {
+		resBody = &recordedBody{ReadCloser: upstreamRes.Body, limit: srv.upstreamBodyLimit}
+		upstreamRes.Body = resBody
+
+		captured := *upstreamRes
+		captured.Header = upstreamRes.Header.Clone()
+		res = &captured
+
+		return nil
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		srv.logger.Error("Failed to proxy request to upstream.",
+			zap.String("upstream", srv.upstream.String()),
+			zap.Error(err),
+		)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	proxy.ServeHTTP(w, r)
+
+	var resBodyTruncated bool
+	if resBody != nil {
+		res.Body = ioutil.NopCloser(bytes.NewReader(resBody.buf.Bytes()))
+		// The length of the recorded body is used, so the stored response
+		// can be parsed when it's truncated, or incomplete because the client
+		// went away.
+		size := int64(resBody.buf.Len())
+		if resBody.truncated || (res.ContentLength >= 0 && res.ContentLength != size) {
+			res.ContentLength = size
+			res.TransferEncoding = nil
+		}
+		resBodyTruncated = resBody.truncated
+	}
+
+	_, err = srv.hostsService.StoreHTTPLogEntry(ctx, hosts.StoreHTTPLogEntryParams{
+		Request:               r,
+		Response:              res,
+		RequestBody:           reqBody,
+		RemoteAddr:            srv.remoteAddr(r),
+		ACMEChallenge:         isACMEChallenge(r),
+		ResponseBodyTruncated: resBodyTruncated,
+	})
+	if errors.Is(err, hosts.ErrTooManyWrites) {
+		srv.logger.Warn("Too many concurrent writes, dropping proxied request.", zap.Error(err))
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to store HTTP log entry.", zap.Error(err))
+	}
+}
+
+// recordedBody records the bytes read from a body, up to `limit` bytes, or
+// without limit if `limit` is zero or less.
+type recordedBody struct {
+	io.ReadCloser
+	limit     int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		data := p[:n]
+		if b.limit > 0 {
+			if remaining := b.limit - int64(b.buf.Len()); int64(n) > remaining {
+				data = p[:remaining]
+				b.truncated = true
+			}
+		}
+		b.buf.Write(data)
+	}
+
+	return n, err
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	badgerdb "github.com/dgraph-io/badger/v3"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/database/badger"
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+func TestProxyRequestStoresResponse(t *testing.T) {
+	var upstreamHost, upstreamBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		upstreamHost, upstreamBody = r.Host, string(body)
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello from upstream"))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := badger.OpenDatabase(badgerdb.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	svc := hosts.NewService(hosts.WithDatabase(db), hosts.WithBaseHostname("example.com"))
+	created, err := svc.CreateHosts(context.Background(), hosts.CreateHostsParams{Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := created[0]
+
+	srv := NewServer(WithHostsService(svc), WithUpstream(upstreamURL))
+	r := httptest.NewRequest("POST", "http://"+host.Hostname+"/cb", strings.NewReader("foo=bar"))
+	w := httptest.NewRecorder()
+	srv.CaptureRequest(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %v", w.Code)
+	}
+	if got := w.Body.String(); got != "hello from upstream" {
+		t.Errorf("expected body %q, got %q", "hello from upstream", got)
+	}
+	if upstreamHost != upstreamURL.Host {
+		t.Errorf("expected upstream host %q, got %q", upstreamURL.Host, upstreamHost)
+	}
+	if upstreamBody != "foo=bar" {
+		t.Errorf("expected upstream request body %q, got %q", "foo=bar", upstreamBody)
+	}
+
+	entries, err := svc.ListHTTPLogEntries(context.Background(), hosts.ListHTTPLogEntriesParams{HostIDs: []ulid.ULID{host.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 stored entry, got %v", len(entries))
+	}
+	if !bytes.HasSuffix(entries[0].RawRequest, []byte("foo=bar")) {
+		t.Errorf("expected raw request with body, got %q", entries[0].RawRequest)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(entries[0].RawResponse)), nil)
+	if err != nil {
+		t.Fatalf("failed to parse raw response: %v", err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("expected stored status 201, got %v", res.StatusCode)
+	}
+	if got := res.Header.Get("X-Upstream"); got != "yes" {
+		t.Errorf("expected stored header %q, got %q", "yes", got)
+	}
+	if string(body) != "hello from upstream" {
+		t.Errorf("expected stored body %q, got %q", "hello from upstream", body)
+	}
+}
+
+func TestProxyRequestUpstreamBodyLimit(t *testing.T) {
+	body := strings.Repeat("a", 64<<10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		limit        int64
+		expStored    int
+		expTruncated bool
+	}{
+		{
+			name:      "below limit",
+			limit:     int64(len(body)),
+			expStored: len(body),
+		},
+		{
+			name:         "above limit",
+			limit:        1024,
+			expStored:    1024,
+			expTruncated: true,
+		},
+		{
+			name:      "without limit",
+			limit:     0,
+			expStored: len(body),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &testHostsService{}
+			srv := NewServer(
+				WithHostsService(svc),
+				WithUpstream(upstreamURL),
+				WithUpstreamBodyLimit(tt.limit),
+			)
+
+			r := httptest.NewRequest("GET", "http://abc.example.com/", nil)
+			w := httptest.NewRecorder()
+			srv.CaptureRequest(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %v", w.Code)
+			}
+			if w.Body.Len() != len(body) {
+				t.Errorf("expected client to receive %v bytes, got %v", len(body), w.Body.Len())
+			}
+
+			entries := svc.storedEntries()
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 stored entry, got %v", len(entries))
+			}
+			stored, err := ioutil.ReadAll(entries[0].Response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != tt.expStored {
+				t.Errorf("expected %v stored bytes, got %v", tt.expStored, len(stored))
+			}
+			// Truncated responses are stored with the length of the stored
+			// body, so they can be parsed.
+			if tt.expTruncated && entries[0].Response.ContentLength != int64(len(stored)) {
+				t.Errorf("expected stored content length %v, got %v", len(stored), entries[0].Response.ContentLength)
+			}
+			if entries[0].ResponseBodyTruncated != tt.expTruncated {
+				t.Errorf("expected truncated %v, got %v", tt.expTruncated, entries[0].ResponseBodyTruncated)
+			}
+		})
+	}
+}
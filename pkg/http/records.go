@@ -0,0 +1,386 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/libdns/libdns"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/dns"
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// RecordManager manages DNS records, e.g. dns.Server.
+type RecordManager interface {
+	libdns.RecordAppender
+	libdns.RecordDeleter
+}
+
+// HostRecordsDeleter is optionally implemented by a RecordManager, for deleting
+// all DNS records of a host when the host is deleted.
+type HostRecordsDeleter interface {
+	DeleteHostRecords(ctx context.Context, hostname string) error
+}
+
+// ZoneApexer is optionally implemented by a RecordManager, for managing DNS
+// records of the zone apex (e.g. a TXT record for domain verification) via
+// the API.
+type ZoneApexer interface {
+	Apex() string
+}
+
+// ZonefileWriter is optionally implemented by a RecordManager, for exporting
+// the DNS zone as a master file via the API.
+type ZonefileWriter interface {
+	WriteZonefile(ctx context.Context, w io.Writer) error
+}
+
+// supportedRecordTypes are the DNS record types that can be managed via the
+// API.
+var supportedRecordTypes = map[string]bool{
+	"A":     true,
+	"AAAA":  true,
+	"CNAME": true,
+	"MX":    true,
+	"NS":    true,
+	"TXT":   true,
+	"SVCB":  true,
+	"HTTPS": true,
+}
+
+type recordRequestBody struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
+}
+
+type record struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	FQDN     string `json:"fqdn"`
+	Value    string `json:"value"`
+	TTL      int    `json:"ttl,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+func parseRecord(hostname, fqdn string, rec libdns.Record) record {
+	fqdn = libdns.AbsoluteName(rec.Name, fqdn)
+	name := libdns.RelativeName(fqdn, hostname+".")
+	if name == "" {
+		name = "@"
+	}
+
+	return record{
+		Type:     rec.Type,
+		Name:     name,
+		FQDN:     fqdn,
+		Value:    rec.Value,
+		TTL:      int(rec.TTL.Seconds()),
+		Priority: rec.Priority,
+	}
+}
+
+// validate checks the request body and returns the fully qualified domain name
+// of the record, which is `name` relative to the host's hostname.
+func (body *recordRequestBody) validate(hostname string, requireValue bool) (string, *APIError) {
+	body.Type = strings.ToUpper(body.Type)
+	if !supportedRecordTypes[body.Type] {
+		return "", &APIError{
+			Message:    `Property "type" must be one of: A, AAAA, CNAME, MX, NS, TXT, SVCB, HTTPS.`,
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	name := strings.ToLower(body.Name)
+	if name == "@" {
+		name = ""
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if !isRecordLabel(label) {
+				return "", &APIError{
+					Message:    `Property "name" must be a relative domain name, e.g. "www" or "@" for the host itself.`,
+					Code:       ErrCodeValidation,
+					StatusCode: http.StatusBadRequest,
+				}
+			}
+		}
+	}
+
+	if requireValue && body.Value == "" {
+		return "", &APIError{
+			Message:    `Property "value" cannot be empty.`,
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	if body.TTL < 0 {
+		return "", &APIError{
+			Message:    `Property "ttl" cannot be negative.`,
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	return libdns.AbsoluteName(name, hostname+"."), nil
+}
+
+// provisionHostRecords creates an A record for each of the given (new) hosts,
+// if enabled with WithAutoProvisionA. Failures are logged, as the hosts have
+// been created already; records can still be created via the API.
+func (srv *Server) provisionHostRecords(ctx context.Context, hostList []hosts.Host) {
+	if srv.autoProvisionA == nil || srv.recordManager == nil {
+		return
+	}
+
+	for _, h := range hostList {
+		_, err := srv.recordManager.AppendRecords(ctx, h.Hostname+".", []libdns.Record{{
+			Type:  "A",
+			Value: srv.autoProvisionA.String(),
+		}})
+		if err != nil {
+			srv.logger.Error("Failed to create A record of new host.",
+				zap.String("hostname", h.Hostname),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func isRecordLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// findHostForRequest parses the host ID in the request path and returns the
+// host. On error, an API error is written to `w`.
+func (srv *Server) findHostForRequest(w http.ResponseWriter, r *http.Request) (hosts.Host, bool) {
+	hostID, err := ulid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to parse host ID: %v", err),
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		})
+		return hosts.Host{}, false
+	}
+
+	h, err := srv.hostsService.FindHostByID(r.Context(), hostID)
+	if errors.Is(err, hosts.ErrHostNotFound) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Host %q not found.", hostID),
+			Code:       ErrCodeHostNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+		return hosts.Host{}, false
+	}
+	if err != nil {
+		srv.logger.Error("Failed to find host by ID.", zap.Error(err))
+		srv.handleInternalError(w)
+		return hosts.Host{}, false
+	}
+
+	return h, true
+}
+
+func (srv *Server) decodeRecordRequestBody(w http.ResponseWriter, r *http.Request) (recordRequestBody, bool) {
+	var body recordRequestBody
+	if apiErr := decodeJSONBody(w, r, &body); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return body, false
+	}
+
+	return body, true
+}
+
+// CreateRecord adds a DNS record for a host.
+func (srv *Server) CreateRecord(w http.ResponseWriter, r *http.Request) {
+	h, ok := srv.findHostForRequest(w, r)
+	if !ok || !srv.checkHostManaged(w, r, h) {
+		return
+	}
+
+	srv.createRecord(w, r, h.Hostname)
+}
+
+// CreateApexRecord adds a DNS record with a name relative to the zone apex,
+// which doesn't belong to a host.
+func (srv *Server) CreateApexRecord(w http.ResponseWriter, r *http.Request) {
+	srv.createRecord(w, r, srv.zoneApex())
+}
+
+// createRecord adds a DNS record with a name relative to `hostname`.
+func (srv *Server) createRecord(w http.ResponseWriter, r *http.Request, hostname string) {
+	body, ok := srv.decodeRecordRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	fqdn, apiErr := body.validate(hostname, true)
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+	if apiErr := srv.validateApexRecord(fqdn, body.Type); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	// Records are stored with the fully qualified domain name as zone, which
+	// is how the DNS server looks them up.
+	rec := libdns.Record{
+		Type:     body.Type,
+		Value:    body.Value,
+		TTL:      time.Duration(body.TTL) * time.Second,
+		Priority: body.Priority,
+	}
+	if _, err := dns.MessageFromRecord(fqdn, rec); err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Invalid record: %v", strings.TrimPrefix(err.Error(), "dns: ")),
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		})
+		return
+	}
+
+	created, err := srv.recordManager.AppendRecords(r.Context(), fqdn, []libdns.Record{rec})
+	if err != nil {
+		srv.logger.Error("Failed to append DNS records.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+	if len(created) == 0 {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("A %v record for %q with value %q already exists.", rec.Type, fqdn, rec.Value),
+			Code:       ErrCodeRecordExists,
+			StatusCode: http.StatusConflict,
+		})
+		return
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusCreated,
+		Data:       parseRecord(hostname, fqdn, created[0]),
+	})
+}
+
+// zoneApex returns the zone apex of the record manager, without trailing dot.
+func (srv *Server) zoneApex() string {
+	return strings.TrimSuffix(srv.recordManager.(ZoneApexer).Apex(), ".")
+}
+
+// validateApexRecord rejects records that conflict with the SOA and NS
+// records the DNS server answers for the zone apex.
+func (srv *Server) validateApexRecord(fqdn, recType string) *APIError {
+	apexer, ok := srv.recordManager.(ZoneApexer)
+	if !ok || !strings.EqualFold(fqdn, apexer.Apex()) {
+		return nil
+	}
+	if recType == "NS" || recType == "CNAME" {
+		return &APIError{
+			Message:    fmt.Sprintf("A %v record can't be created for the zone apex.", recType),
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+
+	return nil
+}
+
+// ExportZonefile writes the DNS zone as an RFC 1035 master file.
+func (srv *Server) ExportZonefile(w http.ResponseWriter, r *http.Request) {
+	zw := srv.recordManager.(ZonefileWriter)
+
+	// The zonefile is buffered, so errors can still be reported as such.
+	var buf bytes.Buffer
+	if err := zw.WriteZonefile(r.Context(), &buf); err != nil {
+		srv.logger.Error("Failed to write zonefile.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/dns")
+	if _, err := buf.WriteTo(w); err != nil {
+		srv.logger.Debug("Failed to write zonefile response.", zap.Error(err))
+	}
+}
+
+// DeleteRecords deletes the DNS records of a host with the given name and
+// type, and value (if not empty).
+func (srv *Server) DeleteRecords(w http.ResponseWriter, r *http.Request) {
+	h, ok := srv.findHostForRequest(w, r)
+	if !ok || !srv.checkHostManaged(w, r, h) {
+		return
+	}
+
+	srv.deleteRecords(w, r, h.Hostname)
+}
+
+// DeleteApexRecords deletes DNS records with a name relative to the zone
+// apex, with the given name and type, and value (if not empty).
+func (srv *Server) DeleteApexRecords(w http.ResponseWriter, r *http.Request) {
+	srv.deleteRecords(w, r, srv.zoneApex())
+}
+
+func (srv *Server) deleteRecords(w http.ResponseWriter, r *http.Request, hostname string) {
+	body, ok := srv.decodeRecordRequestBody(w, r)
+	if !ok {
+		return
+	}
+
+	fqdn, apiErr := body.validate(hostname, false)
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	deleted, err := srv.recordManager.DeleteRecords(r.Context(), fqdn, []libdns.Record{{
+		Type:  body.Type,
+		Value: body.Value,
+	}})
+	if err != nil {
+		srv.logger.Error("Failed to delete DNS records.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+	if len(deleted) == 0 {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("No %v records for %q found.", body.Type, fqdn),
+			Code:       ErrCodeRecordNotFound,
+			StatusCode: http.StatusNotFound,
+		})
+		return
+	}
+
+	data := make([]record, len(deleted))
+	for i, rec := range deleted {
+		data[i] = parseRecord(hostname, fqdn, rec)
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       data,
+	})
+}
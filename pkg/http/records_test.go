@@ -0,0 +1,177 @@
+package http
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/certmagic"
+	miekgdns "github.com/miekg/dns"
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/dns"
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// testDNSResponseWriter records written DNS replies.
+type testDNSResponseWriter struct {
+	miekgdns.ResponseWriter
+	msgs []*miekgdns.Msg
+}
+
+func (w *testDNSResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *testDNSResponseWriter) WriteMsg(msg *miekgdns.Msg) error {
+	w.msgs = append(w.msgs, msg)
+	return nil
+}
+
+// queryDNS queries the DNS server, and returns its reply.
+func queryDNS(t *testing.T, srv *dns.Server, name string, qtype uint16) *miekgdns.Msg {
+	t.Helper()
+
+	w := &testDNSResponseWriter{}
+	r := &miekgdns.Msg{}
+	r.SetQuestion(name, qtype)
+	srv.ServeDNS(w, r)
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected 1 reply, got %v", len(w.msgs))
+	}
+
+	return w.msgs[0]
+}
+
+func TestApexRecords(t *testing.T) {
+	dnsServer := dns.NewServer(
+		dns.WithStorage(&certmagic.FileStorage{Path: t.TempDir()}),
+		dns.WithSOAHostname("example.com"),
+	)
+
+	do := func(t *testing.T, srv *Server, method, body, token string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, "/api/dns/records", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.APIHandler().ServeHTTP(w, r)
+
+		return w
+	}
+
+	queryTXT := func(t *testing.T) []string {
+		t.Helper()
+
+		var values []string
+		for _, rr := range queryDNS(t, dnsServer, "example.com.", miekgdns.TypeTXT).Answer {
+			if txt, ok := rr.(*miekgdns.TXT); ok {
+				values = append(values, strings.Join(txt.Txt, ""))
+			}
+		}
+		return values
+	}
+
+	const verification = `{"type":"TXT","name":"@","value":"verification=abc123"}`
+
+	t.Run("without admin token configured", func(t *testing.T) {
+		srv := NewServer(WithRecordManager(dnsServer))
+		if w := do(t, srv, "POST", verification, ""); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %v", w.Code)
+		}
+	})
+
+	srv := NewServer(WithRecordManager(dnsServer), WithAdminToken("secret"))
+
+	t.Run("without admin token", func(t *testing.T) {
+		if w := do(t, srv, "POST", verification, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %v", w.Code)
+		}
+		if w := do(t, srv, "POST", verification, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %v", w.Code)
+		}
+		if values := queryTXT(t); len(values) != 0 {
+			t.Errorf("expected no TXT records, got %v", values)
+		}
+	})
+
+	t.Run("invalid records", func(t *testing.T) {
+		for _, body := range []string{
+			`{"type":"NS","name":"@","value":"ns.example.org."}`,
+			`{"type":"CNAME","name":"@","value":"example.org."}`,
+			`{"type":"TXT","name":"@","value":""}`,
+			`{"type":"SOA","name":"@","value":"foo"}`,
+		} {
+			if w := do(t, srv, "POST", body, "secret"); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %v, got %v: %s", body, w.Code, w.Body)
+			}
+		}
+	})
+
+	t.Run("create and delete", func(t *testing.T) {
+		if w := do(t, srv, "POST", verification, "secret"); w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+		}
+		values := queryTXT(t)
+		if len(values) != 1 || values[0] != "verification=abc123" {
+			t.Errorf("expected TXT record %q for zone apex, got %v", "verification=abc123", values)
+		}
+
+		if w := do(t, srv, "DELETE", verification, "secret"); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %v: %s", w.Code, w.Body)
+		}
+		if values := queryTXT(t); len(values) != 0 {
+			t.Errorf("expected no TXT records, got %v", values)
+		}
+	})
+}
+
+func TestAutoProvisionA(t *testing.T) {
+	dnsServer := dns.NewServer(
+		dns.WithStorage(&certmagic.FileStorage{Path: t.TempDir()}),
+		dns.WithSOAHostname("example.com"),
+	)
+	ip := net.ParseIP("192.0.2.1")
+	srv := NewServer(
+		WithHostsService(&acmeDNSHostsService{hosts: make(map[ulid.ULID]hosts.Host)}),
+		WithRecordManager(dnsServer),
+		WithAutoProvisionA(ip),
+	)
+
+	r := httptest.NewRequest("POST", "/api/hosts", strings.NewReader(`{"amount":2}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	srv.APIHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %v: %s", w.Code, w.Body)
+	}
+	var res struct {
+		Data []host `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data) != 2 {
+		t.Fatalf("expected 2 hosts, got %v", len(res.Data))
+	}
+
+	for _, h := range res.Data {
+		reply := queryDNS(t, dnsServer, h.Hostname+".", miekgdns.TypeA)
+		if len(reply.Answer) != 1 {
+			t.Fatalf("expected 1 answer for %v, got %v", h.Hostname, reply.Answer)
+		}
+		a, ok := reply.Answer[0].(*miekgdns.A)
+		if !ok {
+			t.Fatalf("expected A record for %v, got %v", h.Hostname, reply.Answer[0])
+		}
+		if !a.A.Equal(ip) {
+			t.Errorf("expected %v to resolve to %v, got %v", h.Hostname, ip, a.A)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// remoteAddr returns the address of the client that made a request. When the
+// direct peer is a trusted proxy, the client address is resolved from the
+// `Forwarded` or `X-Forwarded-For` header, by taking the right-most address
+// that isn't a trusted proxy itself.
+func (srv *Server) remoteAddr(r *http.Request) string {
+	peerIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peerIP = r.RemoteAddr
+	}
+	if !srv.isTrustedProxy(net.ParseIP(peerIP)) {
+		return r.RemoteAddr
+	}
+
+	forwarded := forwardedFor(r.Header)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			// Don't trust anything left of a malformed address.
+			break
+		}
+		if !srv.isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+
+	return r.RemoteAddr
+}
+
+func (srv *Server) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range srv.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the client addresses listed in the `Forwarded` header
+// (RFC 7239) or, if absent, the `X-Forwarded-For` header, ordered from client
+// to closest proxy. Ports are stripped.
+func forwardedFor(header http.Header) []string {
+	var addrs []string
+
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, ok := cut(strings.TrimSpace(pair), "=")
+					if !ok || !strings.EqualFold(key, "for") {
+						continue
+					}
+					addrs = append(addrs, stripPort(strings.Trim(val, `"`)))
+				}
+			}
+		}
+		return addrs
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, stripPort(strings.TrimSpace(addr)))
+		}
+	}
+
+	return addrs
+}
+
+// stripPort removes an optional port from an address, including IPv6
+// addresses in brackets, e.g. `[2001:db8::1]:4711`.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// DefaultReplayTimeout is the default timeout for replaying requests,
+// including reading the response body.
+const DefaultReplayTimeout = 10 * time.Second
+
+// maxReplayResponseSize is the maximum size of a replayed request's response
+// body.
+const maxReplayResponseSize = 10 << 20
+
+var errReplayTargetNotAllowed = errors.New("replay target not allowed")
+
+type replayRequestBody struct {
+	// URL overrides the target of the replayed request. If it has no path,
+	// the path and query of the captured request are used.
+	URL string `json:"url"`
+}
+
+type replayResponse struct {
+	URL      string       `json:"url"`
+	Response httpResponse `json:"response"`
+}
+
+// ReplayHTTPLogEntry sends a captured request again, to its original host or
+// an overridden target URL, and returns the response. Only targets with an IP
+// address in an allowed network (see WithReplayAllow) can be reached.
+func (srv *Server) ReplayHTTPLogEntry(w http.ResponseWriter, r *http.Request) {
+	id, err := ulid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to parse HTTP log entry ID: %v", err),
+			Code:       ErrCodeInvalidRequest,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		})
+		return
+	}
+
+	// The body is optional, for replaying to the original host.
+	var body replayRequestBody
+	if apiErr := decodeOptionalJSONBody(w, r, &body); apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	logEntry, err := srv.hostsService.FindHTTPLogEntryByID(r.Context(), id)
+	if errors.Is(err, hosts.ErrHTTPLogEntryNotFound) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("HTTP log entry %q not found.", id),
+			Code:       ErrCodeHTTPLogEntryNotFound,
+			StatusCode: http.StatusNotFound,
+			Err:        err,
+		})
+		return
+	}
+	if err != nil {
+		srv.logger.Error("Failed to find HTTP log entry.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(logEntry.RawRequest)))
+	if err != nil {
+		srv.logger.Error("Failed to read captured request.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	// A request without its retained body is replayed without one.
+	if logEntry.BodyDropped {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+		req.TransferEncoding = nil
+		req.Header.Del("Content-Length")
+	}
+
+	target, apiErr := replayURL(req, body.URL)
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), srv.replayTimeout)
+	defer cancel()
+
+	req = req.WithContext(ctx)
+	req.RequestURI = ""
+	req.URL = target
+	if body.URL != "" {
+		req.Host = target.Host
+	}
+
+	res, err := srv.replayClient().Do(req)
+	if errors.Is(err, errReplayTargetNotAllowed) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Target %q resolves to an IP address that isn't allowed for replaying requests.", target.Host),
+			Code:       ErrCodeReplayTargetNotAllowed,
+			StatusCode: http.StatusForbidden,
+			Err:        err,
+		})
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Timed out replaying request after %v.", srv.replayTimeout),
+			Code:       ErrCodeReplayTimeout,
+			StatusCode: http.StatusGatewayTimeout,
+			Err:        err,
+		})
+		return
+	}
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to replay request: %v", err),
+			Code:       ErrCodeReplayFailed,
+			StatusCode: http.StatusBadGateway,
+			Err:        err,
+		})
+		return
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(io.LimitReader(res.Body, maxReplayResponseSize+1))
+	if err != nil {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Failed to read response body: %v", err),
+			Code:       ErrCodeReplayFailed,
+			StatusCode: http.StatusBadGateway,
+			Err:        err,
+		})
+		return
+	}
+	if len(resBody) > maxReplayResponseSize {
+		srv.writeAPIError(w, &APIError{
+			Message:    fmt.Sprintf("Response body exceeds the maximum size of %v bytes.", maxReplayResponseSize),
+			Code:       ErrCodeReplayFailed,
+			StatusCode: http.StatusBadGateway,
+		})
+		return
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+	rawRes, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		srv.logger.Error("Failed to dump replayed response.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	srv.logger.Info("Replayed HTTP log entry.",
+		zap.String("id", id.String()),
+		zap.String("url", target.String()),
+		zap.Int("statusCode", res.StatusCode),
+	)
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data: replayResponse{
+			URL: target.String(),
+			Response: httpResponse{
+				StatusCode: res.StatusCode,
+				Status:     res.Status,
+				Headers:    res.Header,
+				Body:       decodeBody(resBody, res.Header.Get("Content-Encoding")),
+				Raw:        rawRes,
+			},
+		},
+	})
+}
+
+// replayURL returns the URL to send a captured request to. Captured requests
+// are sent over HTTP to their original host, unless `override` is set.
+func replayURL(req *http.Request, override string) (*url.URL, *APIError) {
+	target := &url.URL{
+		Scheme:   "http",
+		Host:     req.Host,
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: req.URL.RawQuery,
+	}
+	if override == "" {
+		return target, nil
+	}
+
+	u, err := url.Parse(override)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &APIError{
+			Message:    `Property "url" must be an absolute URL with scheme "http" or "https".`,
+			Code:       ErrCodeValidation,
+			StatusCode: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	if u.Path == "" {
+		u.Path, u.RawPath, u.RawQuery = target.Path, target.RawPath, target.RawQuery
+	}
+
+	return u, nil
+}
+
+// replayClient returns an HTTP client that only connects to IP addresses in
+// the networks allowed for replaying requests, checked after name resolution.
+// Redirects aren't followed, and proxies from the environment aren't used.
+func (srv *Server) replayClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: srv.replayTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			for _, ipNet := range srv.replayAllow {
+				if ip != nil && ipNet.Contains(ip) {
+					return nil
+				}
+			}
+			return errReplayTargetNotAllowed
+		},
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: srv.replayTimeout,
+			DisableKeepAlives:   true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ResponseTemplate is a template for the response body of captured requests.
+type ResponseTemplate struct {
+	tmpl interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+	contentType string
+}
+
+// ResponseTemplateData is the data a ResponseTemplate is executed with, e.g.
+// `{{.Host}}` or `{{.RequestID}}`.
+type ResponseTemplateData struct {
+	// Host is the hostname of the request.
+	Host string
+	// RequestID is the ID of the HTTP log entry of the request.
+	RequestID string
+	Method    string
+	Path      string
+	// RemoteAddr is the address of the client.
+	RemoteAddr string
+}
+
+// ParseResponseTemplateFile parses the file at `path` as a ResponseTemplate.
+// Files with an `.html` or `.htm` extension are parsed with `html/template`,
+// so values (which are controlled by clients) are escaped. The content type
+// of responses is derived from the extension, if known.
+func ParseResponseTemplateFile(path string) (*ResponseTemplate, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("http: failed to read response template: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	name := filepath.Base(path)
+	t := &ResponseTemplate{contentType: mime.TypeByExtension(ext)}
+
+	switch ext {
+	case ".html", ".htm":
+		t.tmpl, err = htmltemplate.New(name).Parse(string(raw))
+	default:
+		t.tmpl, err = template.New(name).Parse(string(raw))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("http: failed to parse response template: %w", err)
+	}
+
+	return t, nil
+}
+
+// execute returns the response body for `data`.
+func (t *ResponseTemplate) execute(data ResponseTemplateData) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("http: failed to execute response template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
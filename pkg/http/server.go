@@ -4,43 +4,171 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/certmagic"
 	"github.com/dstotijn/edena/pkg/hosts"
 	"github.com/hashicorp/go-multierror"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Server represents a server for HTTP and TLS.
 type Server struct {
-	hostsService hosts.Service
-	hostname     string
-	acmeManager  *certmagic.ACMEManager
-	httpAddr     string
-	tlsAddr      string
-	tlsDisabled  bool
-	tlsConfig    *tls.Config
+	hostsService  hosts.Service
+	recordManager RecordManager
+	backuper      Backuper
+	hostname      string
+	apiHosts      []string
+	corsOrigins   []string
+	apiAddr       string
+	apiSocketMode os.FileMode
+	acmeManager   *certmagic.ACMEManager
+	httpAddrs     []string
+	tlsAddrs      []string
+	tlsDisabled   bool
+	tlsConfig     *tls.Config
+	certMonitor   *CertificateMonitor
+	h2c           bool
+	upstream      *url.URL
+	webUI         fs.FS
+	// trustedProxies holds the networks of proxies whose forwarding headers
+	// are used for resolving the client address.
+	trustedProxies []net.IPNet
+	ignorePaths    []string
+	timeouts       Timeouts
+	// captureHostSuffix is the domain that requests must be for (or for a
+	// subdomain of) to be captured. All requests are captured if empty.
+	captureHostSuffix string
+	// replayAllow holds the networks that captured requests may be replayed
+	// to. Replaying is disabled if empty.
+	replayAllow   []net.IPNet
+	replayTimeout time.Duration
+	// adminToken is the bearer token required by admin endpoints, e.g. for
+	// managing DNS records of the zone apex. Admin endpoints are disabled if
+	// empty.
+	adminToken string
+	// acmeDNSSecret is the secret for deriving passwords of the acme-dns
+	// compatible API. The API is disabled if empty.
+	acmeDNSSecret []byte
+	// maxHostsPerRequest is the maximum amount of hosts created per API
+	// request.
+	maxHostsPerRequest int
+	// maxResponseDelay is the maximum response delay of hosts.
+	maxResponseDelay time.Duration
+	// apiOnHostnameDisabled stops serving the API and web UI on the hostname,
+	// so requests for it are captured.
+	apiOnHostnameDisabled bool
+	// apiEnvelopeDisabled writes API responses without the `data` and `error`
+	// envelope.
+	apiEnvelopeDisabled bool
+	// idempotencyTTL is the time idempotency keys of requests for creating
+	// hosts are remembered. Keys are ignored if zero.
+	idempotencyTTL time.Duration
+	idempotency    *idempotencyStore
+	// defaultResponse is the template for responses to captured requests.
+	defaultResponse *ResponseTemplate
+	// tlsFingerprints enables computing JA3 and JA4 fingerprints of TLS
+	// client hellos.
+	tlsFingerprints bool
+	// clientCerts enables requesting client certificates during TLS
+	// handshakes.
+	clientCerts bool
+	// wireCapture enables recording requests on the HTTP server as they were
+	// read from the connection.
+	wireCapture bool
+	// autoProvisionA is the IPv4 address of A records created for new hosts,
+	// if set.
+	autoProvisionA net.IP
+	// upstreamBodyLimit is the maximum size of upstream response bodies that
+	// is stored, unlimited if zero or less.
+	upstreamBodyLimit int64
+
+	// delegation is used to tell users how to delegate the DNS zone.
+	delegation Delegation
+
+	// mu guards the servers, which are set by Run and read by Shutdown.
+	mu           sync.Mutex
 	httpServer   *http.Server
 	tlsServer    *http.Server
+	apiServer    *http.Server
+	shuttingDown bool
 	logger       *zap.Logger
 }
 
 type ServerOption func(*Server)
 
+// Timeouts configures the timeouts of the HTTP and HTTPS servers. A zero
+// value means no timeout. See http.Server for details.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// DefaultTimeouts are used when no timeouts are configured. They protect
+// against slow clients (e.g. Slowloris) holding on to connections.
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       30 * time.Second,
+	Write:      60 * time.Second,
+	Idle:       120 * time.Second,
+}
+
+// DefaultIgnorePaths are the paths that are answered without capturing the
+// request, when not configured with WithIgnorePaths. Browsers and crawlers
+// request them for any host.
+var DefaultIgnorePaths = []string{"/robots.txt", "/favicon.ico"}
+
+// DefaultMaxResponseDelay is the maximum response delay of hosts, when not
+// configured with WithMaxResponseDelay.
+const DefaultMaxResponseDelay = 30 * time.Second
+
+// DefaultMaxHostsPerRequest is the maximum amount of hosts created per API
+// request, when not configured with WithMaxHostsPerRequest.
+const DefaultMaxHostsPerRequest = 50
+
+// DefaultUpstreamBodyLimit is the maximum size of upstream response bodies
+// that is stored, when not configured with WithUpstreamBodyLimit.
+const DefaultUpstreamBodyLimit = 10 << 20
+
+// DefaultAPISocketMode is the file mode of the API's Unix domain socket, when
+// not configured with WithAPISocketMode.
+const DefaultAPISocketMode os.FileMode = 0o660
+
 func NewServer(opts ...ServerOption) *Server {
 	srv := &Server{
-		httpAddr: ":80",
-		tlsAddr:  ":443",
-		logger:   zap.NewNop(),
+		httpAddrs:          []string{":80"},
+		tlsAddrs:           []string{":443"},
+		timeouts:           DefaultTimeouts,
+		ignorePaths:        DefaultIgnorePaths,
+		replayTimeout:      DefaultReplayTimeout,
+		maxHostsPerRequest: DefaultMaxHostsPerRequest,
+		maxResponseDelay:   DefaultMaxResponseDelay,
+		apiSocketMode:      DefaultAPISocketMode,
+		idempotencyTTL:     DefaultIdempotencyTTL,
+		upstreamBodyLimit:  DefaultUpstreamBodyLimit,
+		logger:             zap.NewNop(),
 	}
 
 	for _, opt := range opts {
 		opt(srv)
 	}
 
+	if srv.idempotencyTTL > 0 {
+		srv.idempotency = newIdempotencyStore(srv.idempotencyTTL)
+	}
+
 	return srv
 }
 
@@ -52,6 +180,59 @@ func WithHostsService(svc hosts.Service) ServerOption {
 	}
 }
 
+// WithRecordManager sets the RecordManager used for managing DNS records of
+// hosts via the API. Without it, the DNS records endpoints aren't served.
+func WithRecordManager(rm RecordManager) ServerOption {
+	return func(srv *Server) {
+		srv.recordManager = rm
+	}
+}
+
+// WithAutoProvisionA creates an A record with the given IPv4 address for every
+// new host, so its hostname resolves without relying on a catch-all. Like
+// records created via the API, it can be deleted individually, and is deleted
+// with the host. Requires WithRecordManager.
+func WithAutoProvisionA(ip net.IP) ServerOption {
+	return func(srv *Server) {
+		srv.autoProvisionA = ip.To4()
+	}
+}
+
+// WithBackuper enables the API endpoint for creating database backups. The
+// endpoint requires the admin token, so it's only served with WithAdminToken.
+func WithBackuper(b Backuper) ServerOption {
+	return func(srv *Server) {
+		srv.backuper = b
+	}
+}
+
+// WithMaxHostsPerRequest overrides the maximum amount of hosts that can be
+// created per API request. Defaults to DefaultMaxHostsPerRequest.
+func WithMaxHostsPerRequest(n int) ServerOption {
+	return func(srv *Server) {
+		if n > 0 {
+			srv.maxHostsPerRequest = n
+		}
+	}
+}
+
+// WithMaxResponseDelay overrides the maximum response delay that can be set
+// for hosts. Delayed requests hold on to a connection, so keep this well
+// below the write timeout of the HTTP and HTTPS servers.
+func WithMaxResponseDelay(d time.Duration) ServerOption {
+	return func(srv *Server) {
+		srv.maxResponseDelay = d
+	}
+}
+
+// WithDefaultResponse sets the template for the response body of captured
+// requests, e.g. for serving a canary page. Without it, "OK" is served.
+func WithDefaultResponse(t *ResponseTemplate) ServerOption {
+	return func(srv *Server) {
+		srv.defaultResponse = t
+	}
+}
+
 // WithHostname sets the hostname used to serve the API.
 func WithHostname(hostname string) ServerOption {
 	return func(srv *Server) {
@@ -59,17 +240,72 @@ func WithHostname(hostname string) ServerOption {
 	}
 }
 
-// WithHTTPAddr overrides the default TCP address for the HTTP server to listen on.
-func WithHTTPAddr(addr string) ServerOption {
+// WithDelegation sets how the DNS zone of the server is delegated, so that
+// created hosts come with the DNS records users must set at their registrar.
+func WithDelegation(d Delegation) ServerOption {
+	return func(srv *Server) {
+		srv.delegation = d
+	}
+}
+
+// WithAPIHosts sets the hostnames that the API is served on, on the HTTP and
+// HTTPS servers. Requests for other hostnames are captured. By default, the
+// API is served on the hostname set with WithHostname, and on loopback hosts
+// (e.g. `localhost`) for clients connecting via loopback.
+//
+// Because the `Host` header is controlled by clients, every hostname listed
+// here makes the API reachable for anyone who can connect to the server. Use
+// WithAPIAddr to serve the API on a listener that isn't publicly reachable.
+func WithAPIHosts(hosts []string) ServerOption {
 	return func(srv *Server) {
-		srv.httpAddr = addr
+		srv.apiHosts = hosts
 	}
 }
 
-// WithTLSAddr overrides the default TCP address for the HTTPS server to listen on.
-func WithTLSAddr(addr string) ServerOption {
+// WithAPIOnHostname sets whether the API and web UI are served on the hostname
+// set with WithHostname (default), when no API hosts are set. If disabled, the
+// API is only served on loopback hosts for local clients, and requests for the
+// hostname are handled like requests for any other host, e.g. for recon
+// against the server's own domain.
+func WithAPIOnHostname(enabled bool) ServerOption {
 	return func(srv *Server) {
-		srv.tlsAddr = addr
+		srv.apiOnHostnameDisabled = !enabled
+	}
+}
+
+// WithAPIAddr serves the API on a dedicated address, instead of on the HTTP
+// and HTTPS servers used for capturing requests. The address is either a TCP
+// address, or a Unix domain socket path prefixed with `unix://`, e.g.
+// `unix:///run/edena/api.sock`.
+func WithAPIAddr(addr string) ServerOption {
+	return func(srv *Server) {
+		srv.apiAddr = addr
+	}
+}
+
+// WithAPISocketMode sets the file permissions of the Unix domain socket the API
+// listens on, if WithAPIAddr is set with a `unix://` address. Defaults to
+// DefaultAPISocketMode.
+func WithAPISocketMode(mode os.FileMode) ServerOption {
+	return func(srv *Server) {
+		srv.apiSocketMode = mode
+	}
+}
+
+// WithHTTPAddr overrides the default TCP address for the HTTP server to listen
+// on. When multiple addresses are given, e.g. an IPv4 and IPv6 address, the
+// server listens on all of them.
+func WithHTTPAddr(addrs ...string) ServerOption {
+	return func(srv *Server) {
+		srv.httpAddrs = addrs
+	}
+}
+
+// WithTLSAddr overrides the default TCP address for the HTTPS server to listen
+// on. When multiple addresses are given, the server listens on all of them.
+func WithTLSAddr(addrs ...string) ServerOption {
+	return func(srv *Server) {
+		srv.tlsAddrs = addrs
 	}
 }
 
@@ -88,6 +324,14 @@ func WithTLSConfig(tlsConfig *tls.Config) ServerOption {
 	}
 }
 
+// WithCertificateMonitor enables the TLS status API endpoint, which reports the
+// status of certificates managed by certmagic.
+func WithCertificateMonitor(m *CertificateMonitor) ServerOption {
+	return func(srv *Server) {
+		srv.certMonitor = m
+	}
+}
+
 // WithoutTLS disables binding on a port for serving TLS. This will implicitly
 // disable the TLS-ALPN challenge of the ACME protocol.
 func WithoutTLS() ServerOption {
@@ -96,6 +340,170 @@ func WithoutTLS() ServerOption {
 	}
 }
 
+// WithReplayAllow enables replaying captured requests via the API, to targets
+// with an IP address in one of the given networks. Because requests are sent
+// from the server, the networks should be chosen with care, to prevent server
+// side request forgery (SSRF) against internal services.
+func WithReplayAllow(nets []net.IPNet) ServerOption {
+	return func(srv *Server) {
+		srv.replayAllow = nets
+	}
+}
+
+// WithAPIEnvelope sets whether API responses are wrapped in an envelope, with
+// the data in a `data` property and errors in an `error` property (default).
+// Without envelope, the data or error is the response itself, and errors are
+// distinguished by their status code (4xx or 5xx).
+func WithAPIEnvelope(enabled bool) ServerOption {
+	return func(srv *Server) {
+		srv.apiEnvelopeDisabled = !enabled
+	}
+}
+
+// WithIdempotencyTTL overrides the default time (DefaultIdempotencyTTL) that
+// idempotency keys of requests for creating hosts are remembered. A zero TTL
+// disables idempotency keys.
+func WithIdempotencyTTL(ttl time.Duration) ServerOption {
+	return func(srv *Server) {
+		srv.idempotencyTTL = ttl
+	}
+}
+
+// WithAdminToken enables admin endpoints of the API, which require the token
+// in an `Authorization: Bearer <token>` header.
+func WithAdminToken(token string) ServerOption {
+	return func(srv *Server) {
+		srv.adminToken = token
+	}
+}
+
+// WithACMEDNS enables the acme-dns compatible API for answering ACME DNS-01
+// challenges, with passwords derived from `secret`. The secret must be kept
+// across restarts, for registered clients to remain valid. Requires
+// WithRecordManager.
+func WithACMEDNS(secret []byte) ServerOption {
+	return func(srv *Server) {
+		srv.acmeDNSSecret = secret
+	}
+}
+
+// WithReplayTimeout overrides the default timeout (DefaultReplayTimeout) for
+// replaying captured requests.
+func WithReplayTimeout(timeout time.Duration) ServerOption {
+	return func(srv *Server) {
+		srv.replayTimeout = timeout
+	}
+}
+
+// WithCORS allows browsers to call the API from the given origins, e.g.
+// `https://dashboard.example.com`, or from any origin with `*`. Capture routes
+// are unaffected.
+func WithCORS(origins []string) ServerOption {
+	return func(srv *Server) {
+		srv.corsOrigins = origins
+	}
+}
+
+// WithTLSFingerprints enables computing JA3 and JA4 fingerprints of the client
+// hellos of TLS handshakes, which are stored with TLS log entries. This
+// requires recording the first bytes read from each TLS connection.
+func WithTLSFingerprints() ServerOption {
+	return func(srv *Server) {
+		srv.tlsFingerprints = true
+	}
+}
+
+// WithClientCertificates requests client certificates during TLS handshakes,
+// which are stored with HTTP log entries. Certificates aren't verified, so
+// handshakes of clients that don't present one still succeed.
+func WithClientCertificates() ServerOption {
+	return func(srv *Server) {
+		srv.clientCerts = true
+	}
+}
+
+// WithWireCapture enables recording requests on the HTTP server exactly as
+// they were read from the connection, e.g. including the framing of chunked
+// request bodies and trailers, which are stored with HTTP log entries. Only
+// HTTP/1.x requests are recorded; requests over TLS aren't.
+func WithWireCapture() ServerOption {
+	return func(srv *Server) {
+		srv.wireCapture = true
+	}
+}
+
+// WithH2C enables HTTP/2 over cleartext (h2c) on the HTTP server, for clients
+// with prior knowledge and clients using the `Upgrade: h2c` header.
+func WithH2C() ServerOption {
+	return func(srv *Server) {
+		srv.h2c = true
+	}
+}
+
+// WithWebUI serves the web UI in `fsys` on the API hostnames, for all
+// requests outside of `/api`. Requests for capture hostnames are unaffected.
+func WithWebUI(fsys fs.FS) ServerOption {
+	return func(srv *Server) {
+		srv.webUI = fsys
+	}
+}
+
+// WithUpstream configures an upstream server that captured requests are
+// proxied to. The upstream response is returned to the client and stored
+// alongside the request. The upstream applies to all hosts; it can't be set
+// per host via the API, as that would let any API client make the server send
+// requests to arbitrary (e.g. internal) addresses.
+func WithUpstream(upstream *url.URL) ServerOption {
+	return func(srv *Server) {
+		srv.upstream = upstream
+	}
+}
+
+// WithUpstreamBodyLimit overrides the default maximum size
+// (DefaultUpstreamBodyLimit) of upstream response bodies that is stored. The
+// body is still streamed to the client in full, but the stored response is
+// truncated, which is recorded with the HTTP log entry. A limit of zero or
+// less stores bodies of any size.
+func WithUpstreamBodyLimit(n int64) ServerOption {
+	return func(srv *Server) {
+		srv.upstreamBodyLimit = n
+	}
+}
+
+// WithTrustedProxies configures the networks of proxies (e.g. load balancers)
+// in front of the server. For requests from these proxies, the client address
+// is resolved from the `Forwarded` or `X-Forwarded-For` header.
+func WithTrustedProxies(nets []net.IPNet) ServerOption {
+	return func(srv *Server) {
+		srv.trustedProxies = nets
+	}
+}
+
+// WithIgnorePaths overrides the paths (e.g. `/robots.txt`) that are answered
+// with an empty response, without capturing the request. Paths must match
+// exactly. Pass no paths to capture all requests.
+func WithIgnorePaths(paths []string) ServerOption {
+	return func(srv *Server) {
+		srv.ignorePaths = paths
+	}
+}
+
+// WithCaptureHostSuffix only captures requests with a `Host` header for the
+// given domain or its subdomains, e.g. the base domain of hosts. Requests for
+// unrelated domains get a 404 response, without looking up hosts.
+func WithCaptureHostSuffix(suffix string) ServerOption {
+	return func(srv *Server) {
+		srv.captureHostSuffix = hosts.NormalizeHostname(suffix)
+	}
+}
+
+// WithTimeouts overrides the default timeouts of the HTTP and HTTPS servers.
+func WithTimeouts(timeouts Timeouts) ServerOption {
+	return func(srv *Server) {
+		srv.timeouts = timeouts
+	}
+}
+
 // WithLogger provides a logger, which is used for HTTP related logs.
 func WithLogger(logger *zap.Logger) ServerOption {
 	return func(srv *Server) {
@@ -103,9 +511,10 @@ func WithLogger(logger *zap.Logger) ServerOption {
 	}
 }
 
-// Run starts the HTTP and (if enabled) HTTPS server.
+// Run starts the HTTP and (if enabled) HTTPS and API servers.
 func (srv *Server) Run(ctx context.Context) error {
 	var result *multierror.Error
+	var mu sync.Mutex
 	var wg sync.WaitGroup
 	handler := srv.Handler()
 
@@ -113,10 +522,13 @@ func (srv *Server) Run(ctx context.Context) error {
 	go func() {
 		defer wg.Done()
 
-		// Configure HTTPS server.
+		// Configure HTTP server.
 		httpServer := &http.Server{
-			Addr:    srv.httpAddr,
-			Handler: handler,
+			Handler:           srv.httpHandler(handler),
+			ReadHeaderTimeout: srv.timeouts.ReadHeader,
+			ReadTimeout:       srv.timeouts.Read,
+			WriteTimeout:      srv.timeouts.Write,
+			IdleTimeout:       srv.timeouts.Idle,
 		}
 		if srv.logger != nil {
 			logger, err := zap.NewStdLogAt(srv.logger, zapcore.DebugLevel)
@@ -126,14 +538,24 @@ func (srv *Server) Run(ctx context.Context) error {
 				httpServer.ErrorLog = logger
 			}
 		}
-		srv.httpServer = httpServer
+		httpServer.ConnContext = connContext
+		if !srv.setServer(&srv.httpServer, httpServer) {
+			return
+		}
 
 		// Start HTTP server.
-		srv.logger.Info(fmt.Sprintf("HTTP server listening on %v ...", srv.httpAddr))
-		err := httpServer.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
+		err := listenAndServe(srv.httpAddrs, func(ln net.Listener) error {
+			srv.logger.Info(fmt.Sprintf("HTTP server listening on %v ...", ln.Addr()))
+			if srv.wireCapture {
+				ln = &wireListener{Listener: ln}
+			}
+			return httpServer.Serve(ln)
+		})
+		if err != nil {
 			srv.logger.Error("HTTP server failed.", zap.Error(err))
+			mu.Lock()
 			result = multierror.Append(result, err)
+			mu.Unlock()
 		}
 	}()
 
@@ -144,9 +566,13 @@ func (srv *Server) Run(ctx context.Context) error {
 
 			// Configure HTTPS server.
 			tlsServer := &http.Server{
-				Addr:      srv.tlsAddr,
-				Handler:   handler,
-				TLSConfig: srv.tlsConfig,
+				Handler:           handler,
+				TLSConfig:         srv.logACMETLSALPNChallenges(srv.captureTLSHandshakes(srv.requestClientCertificates(srv.tlsConfig))),
+				ReadHeaderTimeout: srv.timeouts.ReadHeader,
+				ReadTimeout:       srv.timeouts.Read,
+				WriteTimeout:      srv.timeouts.Write,
+				IdleTimeout:       srv.timeouts.Idle,
+				ConnContext:       connContext,
 			}
 			if srv.logger != nil {
 				logger, err := zap.NewStdLogAt(srv.logger, zapcore.DebugLevel)
@@ -156,14 +582,73 @@ func (srv *Server) Run(ctx context.Context) error {
 					tlsServer.ErrorLog = logger
 				}
 			}
-			srv.tlsServer = tlsServer
+			// Configure HTTP/2 explicitly, rather than relying on `net/http`
+			// to do so implicitly.
+			err := http2.ConfigureServer(tlsServer, &http2.Server{})
+			if err != nil {
+				srv.logger.Error("Failed to configure HTTP/2 for HTTPS server.", zap.Error(err))
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+				return
+			}
+			if !srv.setServer(&srv.tlsServer, tlsServer) {
+				return
+			}
 
 			// Start HTTPS server.
-			srv.logger.Info(fmt.Sprintf("HTTPS server listening on %v ...", srv.tlsAddr))
-			err := srv.tlsServer.ListenAndServeTLS("", "")
-			if err != nil && err != http.ErrServerClosed {
+			err = listenAndServe(srv.tlsAddrs, func(ln net.Listener) error {
+				srv.logger.Info(fmt.Sprintf("HTTPS server listening on %v ...", ln.Addr()))
+				return srv.serveTLS(tlsServer, ln)
+			})
+			if err != nil {
 				srv.logger.Error("HTTPS server failed.", zap.Error(err))
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	if srv.apiAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Configure API server.
+			apiServer := &http.Server{
+				Handler:           srv.APIHandler(),
+				ReadHeaderTimeout: srv.timeouts.ReadHeader,
+				ReadTimeout:       srv.timeouts.Read,
+				WriteTimeout:      srv.timeouts.Write,
+				IdleTimeout:       srv.timeouts.Idle,
+				ConnContext:       connContext,
+			}
+			if srv.logger != nil {
+				logger, err := zap.NewStdLogAt(srv.logger, zapcore.DebugLevel)
+				if err != nil {
+					srv.logger.Error("Failed to create API logger.", zap.Error(err))
+				} else {
+					apiServer.ErrorLog = logger
+				}
+			}
+			if !srv.setServer(&srv.apiServer, apiServer) {
+				return
+			}
+
+			// Start API server.
+			ln, err := srv.listenAPI()
+			if err == nil {
+				srv.logger.Info(fmt.Sprintf("API server listening on %v ...", srv.apiAddr))
+				// For Unix domain sockets, closing the listener (on shutdown)
+				// also removes the socket file.
+				err = apiServer.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				srv.logger.Error("API server failed.", zap.Error(err))
+				mu.Lock()
 				result = multierror.Append(result, err)
+				mu.Unlock()
 			}
 		}()
 	}
@@ -177,33 +662,171 @@ func (srv *Server) Run(ctx context.Context) error {
 	return nil
 }
 
+// httpHandler wraps the handler of the HTTP server with the features that are
+// only supported on it, i.e. wire capture and h2c.
+func (srv *Server) httpHandler(handler http.Handler) http.Handler {
+	if srv.wireCapture {
+		handler = srv.WireCaptureMiddleware(handler)
+	}
+	if srv.h2c {
+		handler = h2c.NewHandler(handler, &http2.Server{
+			IdleTimeout: srv.timeouts.Idle,
+		})
+	}
+
+	return handler
+}
+
+// setServer sets a server field, unless Shutdown was called, in which case
+// false is returned and the server shouldn't be started.
+func (srv *Server) setServer(field **http.Server, server *http.Server) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.shuttingDown {
+		return false
+	}
+	*field = server
+
+	return true
+}
+
+// listenAPI listens on the API address, which is either a TCP address or a
+// Unix domain socket path prefixed with `unix://`.
+func (srv *Server) listenAPI() (net.Listener, error) {
+	path, ok := unixSocketPath(srv.apiAddr)
+	if !ok {
+		return net.Listen("tcp", srv.apiAddr)
+	}
+
+	// A socket file left behind by a previous process that didn't shut down
+	// cleanly would cause listening to fail. Other file types are left alone.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket file: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, srv.apiSocketMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket file permissions: %w", err)
+	}
+
+	return ln, nil
+}
+
+// unixSocketPath returns the path of a `unix://` address.
+func unixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, "unix://") {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, "unix://"), true
+}
+
+type connContextKey struct{}
+
+// connContext is used as `ConnContext` of HTTP servers, so handlers can access
+// the connection of a request, e.g. for extending its write deadline.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	ctx = context.WithValue(ctx, connContextKey{}, c)
+	return wireConnContext(ctx, c)
+}
+
+// listenAndServe listens on all TCP addresses, and then calls `serve` for each
+// listener concurrently, until they all return. If listening on any of the
+// addresses fails, no listener is served. A returned http.ErrServerClosed is
+// not treated as error.
+func listenAndServe(addrs []string, serve func(ln net.Listener) error) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	var result *multierror.Error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, ln := range listeners {
+		wg.Add(1)
+		go func(ln net.Listener) {
+			defer wg.Done()
+
+			err := serve(ln)
+			if err != nil && err != http.ErrServerClosed {
+				mu.Lock()
+				result = multierror.Append(result, err)
+				mu.Unlock()
+			}
+		}(ln)
+	}
+
+	wg.Wait()
+
+	return result.ErrorOrNil()
+}
+
 func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.shuttingDown = true
+	httpServer, tlsServer, apiServer := srv.httpServer, srv.tlsServer, srv.apiServer
+	srv.mu.Unlock()
+
 	// We don't use the `errgroup` package, because we want to await *all*
 	// errors before returning.
 	var result *multierror.Error
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(3)
 
 	go func() {
 		defer wg.Done()
-		if srv.httpServer == nil {
+		if httpServer == nil {
 			return
 		}
-		srv.httpServer.SetKeepAlivesEnabled(false)
-		if err := srv.httpServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+		httpServer.SetKeepAlivesEnabled(false)
+		if err := httpServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
 			srv.logger.Error("Failed to shutdown HTTP server.", zap.Error(err))
+			mu.Lock()
 			result = multierror.Append(result, err)
+			mu.Unlock()
 		}
 	}()
 	go func() {
 		defer wg.Done()
-		if srv.tlsServer == nil {
+		if tlsServer == nil {
 			return
 		}
-		srv.tlsServer.SetKeepAlivesEnabled(false)
-		if err := srv.tlsServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+		tlsServer.SetKeepAlivesEnabled(false)
+		if err := tlsServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
 			srv.logger.Error("Failed to shutdown HTTPS server.", zap.Error(err))
+			mu.Lock()
+			result = multierror.Append(result, err)
+			mu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if apiServer == nil {
+			return
+		}
+		apiServer.SetKeepAlivesEnabled(false)
+		if err := apiServer.Shutdown(ctx); err != nil && err != context.DeadlineExceeded {
+			srv.logger.Error("Failed to shutdown API server.", zap.Error(err))
+			mu.Lock()
 			result = multierror.Append(result, err)
+			mu.Unlock()
 		}
 	}()
 
@@ -0,0 +1,363 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// testHostsService records stored HTTP log entries. Methods that aren't
+// implemented panic, via the nil embedded interface.
+type testHostsService struct {
+	hosts.Service
+
+	mu      sync.Mutex
+	entries []hosts.StoreHTTPLogEntryParams
+}
+
+func (svc *testHostsService) StoreHTTPLogEntry(_ context.Context, params hosts.StoreHTTPLogEntryParams) (ulid.ULID, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.entries = append(svc.entries, params)
+	return ulid.ULID{}, nil
+}
+
+func (svc *testHostsService) FindHostByHostname(_ context.Context, hostname string) (hosts.Host, error) {
+	return hosts.Host{Hostname: stripPort(hostname)}, nil
+}
+
+func (svc *testHostsService) storedEntries() []hosts.StoreHTTPLogEntryParams {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	return append([]hosts.StoreHTTPLogEntryParams(nil), svc.entries...)
+}
+
+// serveHTTPTest serves the handler of the HTTP server like Run does, and
+// returns the address it listens on.
+func serveHTTPTest(t *testing.T, srv *Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.wireCapture {
+		ln = &wireListener{Listener: ln}
+	}
+	httpServer := &http.Server{
+		Handler:     srv.httpHandler(srv.Handler()),
+		ConnContext: connContext,
+	}
+	go httpServer.Serve(ln)
+	t.Cleanup(func() { httpServer.Close() })
+
+	return ln.Addr().String()
+}
+
+// sendRaw writes `raw` to a new connection to `addr` and reads the response.
+func sendRaw(t *testing.T, addr string, raw []byte) *http.Response {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res
+}
+
+func TestWireCaptureWithH2C(t *testing.T) {
+	svc := &testHostsService{}
+	srv := NewServer(WithHostsService(svc), WithWireCapture(), WithH2C())
+	addr := serveHTTPTest(t, srv)
+
+	raw := []byte("POST /cb HTTP/1.1\r\n" +
+		"Host: abc.example.com\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"4\r\nfoo=\r\n3;ext=1\r\nbar\r\n0\r\nX-Trailer: baz\r\n\r\n")
+
+	res := sendRaw(t, addr, raw)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", res.StatusCode)
+	}
+
+	entries := svc.storedEntries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 stored entry, got %v", len(entries))
+	}
+	if got := entries[0].RawWire; !bytes.Equal(got, raw) {
+		t.Errorf("expected raw wire %q, got %q", raw, got)
+	}
+}
+
+// runHTTPTest runs a server with only HTTP enabled on a free local port, and
+// returns the address it listens on once it accepts connections.
+func runHTTPTest(t *testing.T, opts ...ServerOption) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	srv := NewServer(append([]ServerOption{WithHTTPAddr(addr), WithoutTLS()}, opts...)...)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(context.Background()) }()
+	t.Cleanup(func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Errorf("failed to shutdown: %v", err)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected error from Run: %v", err)
+		}
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server didn't start listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSlowHeaderClientDisconnected(t *testing.T) {
+	timeouts := DefaultTimeouts
+	timeouts.ReadHeader = 100 * time.Millisecond
+	addr := runHTTPTest(t, WithTimeouts(timeouts))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The request header is never completed.
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: abc.example.com\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("expected connection to be closed after the read header timeout")
+	}
+}
+
+func TestNewServerDefaultTimeouts(t *testing.T) {
+	srv := NewServer()
+	if srv.timeouts != DefaultTimeouts {
+		t.Errorf("expected timeouts %+v, got %+v", DefaultTimeouts, srv.timeouts)
+	}
+	if srv.timeouts.ReadHeader == 0 || srv.timeouts.Read == 0 || srv.timeouts.Write == 0 || srv.timeouts.Idle == 0 {
+		t.Errorf("expected all default timeouts to be set, got %+v", srv.timeouts)
+	}
+}
+
+func TestRunShutdownConcurrently(t *testing.T) {
+	tlsConfig := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return nil, errors.New("no certificate")
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		srv := NewServer(
+			WithHTTPAddr("127.0.0.1:0"),
+			WithTLSAddr("127.0.0.1:0"),
+			WithTLSConfig(tlsConfig),
+			WithAPIAddr("127.0.0.1:0"),
+		)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := srv.Run(context.Background()); err != nil {
+				t.Errorf("unexpected error running server: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				t.Errorf("unexpected error shutting down server: %v", err)
+			}
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected Run to return after Shutdown")
+		}
+	}
+}
+
+func TestAPIUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix domain socket file permissions aren't supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	// A socket file left behind by a previous process is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := NewServer(
+		WithHostsService(&logsHostsService{}),
+		WithHTTPAddr("127.0.0.1:0"),
+		WithoutTLS(),
+		WithAPIAddr("unix://"+path),
+		WithAPISocketMode(0o600),
+	)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(context.Background()) }()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	var res *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err = client.Get("http://edena/api/http-logs?hostId=01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to connect to API socket: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %v", res.StatusCode)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o600 {
+		t.Errorf("expected socket file mode %v, got %v", os.FileMode(0o600), mode)
+	}
+
+	client.CloseIdleConnections()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shutdown: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected error from Run: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket file to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestRunMultipleHTTPAddrs(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, ln.Addr().String())
+		ln.Close()
+	}
+
+	svc := &testHostsService{}
+	srv := NewServer(WithHostsService(svc), WithHTTPAddr(addrs...), WithoutTLS())
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(context.Background()) }()
+	defer func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Errorf("failed to shutdown: %v", err)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected error from Run: %v", err)
+		}
+	}()
+
+	hostnames := []string{"abc.example.com", "def.example.com"}
+	for i, addr := range addrs {
+		var conn net.Conn
+		var err error
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			conn, err = net.Dial("tcp", addr)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("server didn't start listening on %v: %v", addr, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		conn.Close()
+
+		_, port, _ := net.SplitHostPort(addr)
+		res := sendRaw(t, addr, []byte("GET / HTTP/1.1\r\nHost: "+hostnames[i]+":"+port+"\r\nConnection: close\r\n\r\n"))
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("expected status 200 on %v, got %v", addr, res.StatusCode)
+		}
+	}
+
+	entries := svc.storedEntries()
+	if len(entries) != len(addrs) {
+		t.Fatalf("expected %v stored entries, got %v", len(addrs), len(entries))
+	}
+	for i, entry := range entries {
+		if got := stripPort(entry.Request.Host); got != hostnames[i] {
+			t.Errorf("expected entry of host %v, got %v", hostnames[i], got)
+		}
+	}
+}
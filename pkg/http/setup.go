@@ -0,0 +1,112 @@
+package http
+
+import (
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// Delegation describes how the DNS zone of the server is delegated to its
+// nameservers. It's used to tell users which DNS records to set at their
+// registrar.
+type Delegation struct {
+	// Zone is the DNS zone served by the nameservers, e.g. `example.com`.
+	Zone string
+	// Nameservers are the hostnames of the nameservers of the zone.
+	Nameservers []string
+	// GlueIPs are the IP addresses of the nameservers. They're only used for
+	// nameservers within the zone, which require glue records.
+	GlueIPs []net.IP
+}
+
+// setupTTL is the TTL of the suggested DNS records.
+const setupTTL = 3600
+
+type hostSetup struct {
+	Records []setupRecord `json:"records"`
+	// Zonefile holds the records in zone file format, ready to be copied.
+	Zonefile string `json:"zonefile"`
+}
+
+type setupRecord struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	TTL         int    `json:"ttl"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// hostSetup returns the DNS records a user must set to delegate the zone of
+// the server, plus a suggested CNAME record for answering ACME DNS-01
+// challenges with TXT records of the host. It returns nil if no delegation is
+// configured.
+func (srv *Server) hostSetup(h hosts.Host) *hostSetup {
+	if srv.delegation.Zone == "" {
+		return nil
+	}
+
+	zone := dns.Fqdn(srv.delegation.Zone)
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{
+			Name:   name,
+			Rrtype: rrtype,
+			Class:  dns.ClassINET,
+			Ttl:    setupTTL,
+		}
+	}
+
+	var rrs []dns.RR
+	var descriptions []string
+
+	for _, ns := range srv.delegation.Nameservers {
+		rrs = append(rrs, &dns.NS{Hdr: hdr(zone, dns.TypeNS), Ns: dns.Fqdn(ns)})
+		descriptions = append(descriptions, "Delegates the zone to the nameserver. Set it at the registrar of the parent zone.")
+	}
+	for _, ns := range srv.delegation.Nameservers {
+		ns = dns.Fqdn(ns)
+		if !dns.IsSubDomain(zone, ns) {
+			continue
+		}
+		for _, ip := range srv.delegation.GlueIPs {
+			if ipv4 := ip.To4(); ipv4 != nil {
+				rrs = append(rrs, &dns.A{Hdr: hdr(ns, dns.TypeA), A: ipv4})
+			} else {
+				rrs = append(rrs, &dns.AAAA{Hdr: hdr(ns, dns.TypeAAAA), AAAA: ip})
+			}
+			descriptions = append(descriptions, "Glue record for the nameserver, which is within the zone. Set it at the registrar of the parent zone.")
+		}
+	}
+
+	// The name is relative to the domain to obtain certificates for. TXT
+	// records for DNS-01 challenges can then be set via the records API of
+	// the host.
+	rrs = append(rrs, &dns.CNAME{
+		Hdr:    hdr("_acme-challenge", dns.TypeCNAME),
+		Target: dns.Fqdn("_acme-challenge." + h.Hostname),
+	})
+	descriptions = append(descriptions, "Optional. Set it on a domain to obtain certificates for, to answer ACME DNS-01 challenges with TXT records of this host.")
+
+	setup := &hostSetup{
+		Records: make([]setupRecord, len(rrs)),
+	}
+	var zonefile strings.Builder
+
+	for i, rr := range rrs {
+		rrHdr := rr.Header()
+		setup.Records[i] = setupRecord{
+			Name:        rrHdr.Name,
+			Type:        dns.TypeToString[rrHdr.Rrtype],
+			TTL:         int(rrHdr.Ttl),
+			Value:       strings.TrimPrefix(rr.String(), rrHdr.String()),
+			Description: descriptions[i],
+		}
+		zonefile.WriteString(rr.String())
+		zonefile.WriteString("\n")
+	}
+	setup.Zonefile = zonefile.String()
+
+	return setup
+}
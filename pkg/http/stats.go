@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type stats struct {
+	Hosts               int        `json:"hosts"`
+	HTTPLogEntries      int        `json:"httpLogEntries"`
+	DNSLogEntries       int        `json:"dnsLogEntries"`
+	TLSLogEntries       int        `json:"tlsLogEntries"`
+	PendingWrites       int        `json:"pendingWrites"`
+	OldestInteractionAt *time.Time `json:"oldestInteractionAt,omitempty"`
+	NewestInteractionAt *time.Time `json:"newestInteractionAt,omitempty"`
+	SizeBytes           int64      `json:"sizeBytes"`
+}
+
+// GetStats reports entry counts and the size of the database.
+func (srv *Server) GetStats(w http.ResponseWriter, r *http.Request) {
+	dbStats, err := srv.hostsService.Stats(r.Context())
+	if err != nil {
+		srv.logger.Error("Failed to get stats.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	resp := stats{
+		Hosts:          dbStats.Hosts,
+		HTTPLogEntries: dbStats.HTTPLogEntries,
+		DNSLogEntries:  dbStats.DNSLogEntries,
+		TLSLogEntries:  dbStats.TLSLogEntries,
+		PendingWrites:  srv.hostsService.PendingWrites(),
+		SizeBytes:      dbStats.Size,
+	}
+	if !dbStats.OldestInteraction.IsZero() {
+		resp.OldestInteractionAt = &dbStats.OldestInteraction
+	}
+	if !dbStats.NewestInteraction.IsZero() {
+		resp.NewestInteractionAt = &dbStats.NewestInteraction
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       resp,
+	})
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/oklog/ulid"
+	"go.uber.org/zap"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+type tlsLogEntry struct {
+	ID              ulid.ULID `json:"id"`
+	HostID          ulid.ULID `json:"hostId"`
+	RemoteAddr      string    `json:"remoteAddr"`
+	ServerName      string    `json:"serverName"`
+	SupportedProtos []string  `json:"supportedProtos"`
+	JA3             string    `json:"ja3,omitempty"`
+	JA3String       string    `json:"ja3String,omitempty"`
+	JA4             string    `json:"ja4,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+func (srv *Server) ListTLSLogEntries(w http.ResponseWriter, r *http.Request) {
+	hostIDs, apiErr := parseHostIDs(r.URL.Query()["hostId"])
+	if apiErr != nil {
+		srv.writeAPIError(w, apiErr)
+		return
+	}
+
+	params := hosts.ListTLSLogEntriesParams{
+		HostIDs: hostIDs,
+	}
+
+	logEntries, err := srv.hostsService.ListTLSLogEntries(r.Context(), params)
+	if err != nil {
+		srv.logger.Error("Failed to list TLS logs.", zap.Error(err))
+		srv.handleInternalError(w)
+		return
+	}
+
+	data := make([]tlsLogEntry, len(logEntries))
+	for i, logEntry := range logEntries {
+		data[i] = parseTLSLogEntry(logEntry)
+	}
+
+	srv.writeAPIResponse(w, APIResponse{
+		StatusCode: http.StatusOK,
+		Data:       data,
+	})
+}
+
+func parseTLSLogEntry(log hosts.TLSLogEntry) tlsLogEntry {
+	supportedProtos := log.SupportedProtos
+	if supportedProtos == nil {
+		supportedProtos = []string{}
+	}
+
+	entry := tlsLogEntry{
+		ID:              log.ID,
+		HostID:          log.HostID,
+		RemoteAddr:      log.RemoteAddr,
+		ServerName:      log.ServerName,
+		SupportedProtos: supportedProtos,
+		JA3String:       log.JA3,
+		JA4:             log.JA4,
+		CreatedAt:       ulid.Time(log.ID.Time()).UTC(),
+	}
+	if log.JA3 != "" {
+		entry.JA3 = ja3Hash(log.JA3)
+	}
+
+	return entry
+}
+
+// storeTLSLogEntryTimeout is the maximum duration of storing a TLS log entry.
+const storeTLSLogEntryTimeout = 10 * time.Second
+
+// captureTLSHandshakes wraps a TLS config's `GetCertificate` func so that
+// handshakes with a server name (SNI) of a host are stored, regardless of
+// whether an HTTP request follows.
+func (srv *Server) captureTLSHandshakes(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil || tlsConfig.GetCertificate == nil {
+		return tlsConfig
+	}
+
+	cfg := tlsConfig.Clone()
+	getCertificate := tlsConfig.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		srv.storeTLSLogEntry(hello)
+		return getCertificate(hello)
+	}
+
+	return cfg
+}
+
+func (srv *Server) storeTLSLogEntry(hello *tls.ClientHelloInfo) {
+	if hello.ServerName == "" {
+		return
+	}
+
+	params := hosts.StoreTLSLogEntryParams{
+		ServerName:      hello.ServerName,
+		SupportedProtos: hello.SupportedProtos,
+	}
+	if hello.Conn != nil {
+		params.RemoteAddr = hello.Conn.RemoteAddr().String()
+	}
+	if conn, ok := hello.Conn.(*clientHelloConn); ok {
+		ch, err := parseClientHello(conn.clientHello())
+		if err != nil {
+			srv.logger.Debug("Failed to parse TLS client hello.", zap.Error(err))
+		} else {
+			params.JA3 = ch.ja3()
+			params.JA4 = ch.ja4(true)
+		}
+	}
+
+	// The entry is stored in the background, so the handshake isn't blocked
+	// by the database. Writes are bounded by the hosts service (see
+	// hosts.WithMaxConcurrentWrites).
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTLSLogEntryTimeout)
+		defer cancel()
+
+		err := srv.hostsService.StoreTLSLogEntry(ctx, params)
+		if errors.Is(err, hosts.ErrHostNotFound) {
+			srv.logger.Debug("Skipped storing TLS log entry, host not found.", zap.String("serverName", params.ServerName))
+			return
+		}
+		if errors.Is(err, hosts.ErrTooManyWrites) {
+			srv.logger.Warn("Too many concurrent writes, dropping TLS handshake.", zap.Error(err))
+			return
+		}
+		if err != nil {
+			srv.logger.Error("Failed to store TLS log entry.", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/edena/pkg/hosts"
+)
+
+// blockingTLSHostsService blocks storing TLS log entries until `release` is
+// closed, and sends the context of each call on `stored` once it returns.
+type blockingTLSHostsService struct {
+	hosts.Service
+	release chan struct{}
+	stored  chan context.Context
+}
+
+func (svc *blockingTLSHostsService) StoreTLSLogEntry(ctx context.Context, _ hosts.StoreTLSLogEntryParams) error {
+	<-svc.release
+	svc.stored <- ctx
+	return nil
+}
+
+func TestCaptureTLSHandshakesStoresInBackground(t *testing.T) {
+	svc := &blockingTLSHostsService{
+		release: make(chan struct{}),
+		stored:  make(chan context.Context, 1),
+	}
+	srv := NewServer(WithHostsService(svc))
+
+	cert := &tls.Certificate{}
+	cfg := srv.captureTLSHandshakes(&tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert, nil
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		got, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "abc.example.com"})
+		if err != nil || got != cert {
+			t.Errorf("expected certificate, got %v (error: %v)", got, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected handshake not to wait for storing the TLS log entry")
+	}
+
+	close(svc.release)
+	select {
+	case ctx := <-svc.stored:
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected context with deadline")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for TLS log entry to be stored")
+	}
+}
@@ -0,0 +1,240 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxWireCaptureSize is the maximum amount of bytes of a connection that are
+// buffered for wire capture, before capturing stops for the connection.
+const maxWireCaptureSize = 10 << 20
+
+type wireConnContextKey struct{}
+
+type wireRequestContextKey struct{}
+
+// wireListener is a net.Listener that returns connections which record the
+// bytes read from them, so requests can be stored exactly as they were sent,
+// e.g. including chunked transfer encoding framing.
+type wireListener struct {
+	net.Listener
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &wireConn{Conn: conn}, nil
+}
+
+// wireConn records bytes read from a connection, until they are consumed per
+// request with next. Recording stops when request boundaries can't be found
+// (e.g. for HTTP/2) or maxWireCaptureSize is exceeded.
+type wireConn struct {
+	net.Conn
+	mu   sync.Mutex
+	buf  []byte
+	done bool
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		if !c.done {
+			c.buf = append(c.buf, p[:n]...)
+			if len(c.buf) > maxWireCaptureSize {
+				c.stop()
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return n, err
+}
+
+// next removes the bytes of the first request from the recorded bytes, and
+// returns them. Bytes of subsequent requests (e.g. pipelined requests) that
+// were read ahead are kept.
+func (c *wireConn) next() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done {
+		return nil
+	}
+
+	n, ok := wireRequestLength(c.buf)
+	if !ok {
+		c.stop()
+		return nil
+	}
+
+	req := c.buf[:n:n]
+	c.buf = append([]byte(nil), c.buf[n:]...)
+
+	return req
+}
+
+// stop stops recording, because the start of the next request can no longer
+// be found.
+func (c *wireConn) stop() {
+	c.buf = nil
+	c.done = true
+}
+
+// wireConnContext is used as `ConnContext` of HTTP servers, so handlers can
+// find the wireConn of a request.
+func wireConnContext(ctx context.Context, c net.Conn) context.Context {
+	if wc, ok := c.(*wireConn); ok {
+		return context.WithValue(ctx, wireConnContextKey{}, wc)
+	}
+	return ctx
+}
+
+// wireRequest holds the recorded bytes of a request, which are taken from its
+// connection once.
+type wireRequest struct {
+	conn *wireConn
+	once sync.Once
+	raw  []byte
+}
+
+func (wr *wireRequest) take() []byte {
+	wr.once.Do(func() {
+		wr.raw = wr.conn.next()
+	})
+	return wr.raw
+}
+
+// WireCaptureMiddleware consumes the recorded bytes of every request, so the
+// bytes of the next request on the same connection can be found. Handlers get
+// the bytes of a request with rawWire.
+func (srv *Server) WireCaptureMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wc, ok := r.Context().Value(wireConnContextKey{}).(*wireConn)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		wr := &wireRequest{conn: wc}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), wireRequestContextKey{}, wr)))
+		wr.take()
+	})
+}
+
+// rawWire returns the bytes of a request as read from the connection. The
+// request body must be read before calling rawWire. Nil is returned if wire
+// capture isn't enabled or failed.
+func rawWire(r *http.Request) []byte {
+	wr, ok := r.Context().Value(wireRequestContextKey{}).(*wireRequest)
+	if !ok {
+		return nil
+	}
+	return wr.take()
+}
+
+// wireRequestLength returns the length of the first HTTP/1.x request in
+// `buf`, and false if `buf` doesn't hold a complete request.
+func wireRequestLength(buf []byte) (int, bool) {
+	var (
+		n             int
+		contentLength int64
+		chunked       bool
+		first         = true
+	)
+
+	// Header section, which ends with an empty line.
+	for {
+		line, ok := wireLine(buf[n:])
+		if !ok {
+			return 0, false
+		}
+		n += len(line)
+
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		if first {
+			first = false
+			continue
+		}
+
+		name, value, found := cut(string(line), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.EqualFold(strings.TrimSpace(name), "Transfer-Encoding"):
+			// Chunked must be the last transfer coding.
+			codings := strings.Split(value, ",")
+			chunked = strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+		case strings.EqualFold(strings.TrimSpace(name), "Content-Length"):
+			cl, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || cl < 0 {
+				return 0, false
+			}
+			contentLength = cl
+		}
+	}
+
+	if !chunked {
+		if int64(len(buf)-n) < contentLength {
+			return 0, false
+		}
+		return n + int(contentLength), true
+	}
+
+	// Chunked body: chunks, each with a size line and data followed by CRLF,
+	// ending with a zero size chunk, trailers and an empty line.
+	for {
+		line, ok := wireLine(buf[n:])
+		if !ok {
+			return 0, false
+		}
+		n += len(line)
+
+		sizeStr, _, _ := cut(string(bytes.TrimRight(line, "\r\n")), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			return 0, false
+		}
+		if size == 0 {
+			break
+		}
+		if int64(len(buf)-n) < size+2 {
+			return 0, false
+		}
+		n += int(size) + 2
+	}
+
+	for {
+		line, ok := wireLine(buf[n:])
+		if !ok {
+			return 0, false
+		}
+		n += len(line)
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return n, true
+		}
+	}
+}
+
+// wireLine returns the first line of `buf`, including its line ending.
+func wireLine(buf []byte) ([]byte, bool) {
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		return nil, false
+	}
+	return buf[:i+1], true
+}
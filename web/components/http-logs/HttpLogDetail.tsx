@@ -22,11 +22,52 @@ export function HttpLogDetail({ httpLogEntry }: Props): JSX.Element {
 
       <h2 className="text-2xl font-bold mb-4">Request</h2>
       <pre className="text-sm text-indigo-200 bg-primary rounded-xl p-4 mb-4">{atob(httpLogEntry.request.raw)}</pre>
+      {httpLogEntry.request.bodyUrl && <OmittedBody size={httpLogEntry.request.bodySize} url={httpLogEntry.request.bodyUrl} />}
+
+      {httpLogEntry.request.remoteAddr && <p className="mb-4">Remote address: {httpLogEntry.request.remoteAddr}</p>}
+
+      {httpLogEntry.request.parsedForm && (
+        <>
+          <h2 className="text-2xl font-bold mb-4">Form body</h2>
+          <pre className="text-sm text-indigo-200 bg-primary rounded-xl p-4 mb-4">
+            {JSON.stringify(httpLogEntry.request.parsedForm, null, 2)}
+          </pre>
+        </>
+      )}
+
+      {httpLogEntry.request.parsedJson !== undefined && httpLogEntry.request.parsedJson !== null && (
+        <>
+          <h2 className="text-2xl font-bold mb-4">JSON body</h2>
+          <pre className="text-sm text-indigo-200 bg-primary rounded-xl p-4 mb-4">
+            {JSON.stringify(httpLogEntry.request.parsedJson, null, 2)}
+          </pre>
+        </>
+      )}
+
+      {httpLogEntry.request.parseError && (
+        <p className="mb-4">
+          Failed to parse request body: <em>{httpLogEntry.request.parseError}</em>
+        </p>
+      )}
 
       <h2 className="text-2xl font-bold mb-4">Response</h2>
       <pre className="text-sm text-indigo-200 bg-primary rounded-xl p-4 mb-4">{atob(httpLogEntry.response.raw)}</pre>
+      {httpLogEntry.response.bodyUrl && (
+        <OmittedBody size={httpLogEntry.response.bodySize} url={httpLogEntry.response.bodyUrl} />
+      )}
     </div>
   );
 }
 
+function OmittedBody({ size, url }: { size: number; url: string }): JSX.Element {
+  return (
+    <p className="mb-4">
+      Body omitted ({size} bytes).{" "}
+      <a href={url} className="underline">
+        Download
+      </a>
+    </p>
+  );
+}
+
 export default HttpLogDetail;
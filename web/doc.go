@@ -0,0 +1,3 @@
+// Package web contains the web UI. Its static assets are only embedded in
+// binaries built with the `webui` build tag, see Assets.
+package web
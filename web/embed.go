@@ -0,0 +1,24 @@
+//go:build webui
+// +build webui
+
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Run `yarn build` before building with the `webui` tag, so the static export
+// in `out` exists.
+//
+//go:embed out
+var assets embed.FS
+
+// Assets returns the static export of the web UI.
+func Assets() fs.FS {
+	fsys, err := fs.Sub(assets, "out")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
@@ -1,12 +1,7 @@
 import useSWR from "swr";
 
 import { fetcher } from "../lib/fetcher";
-
-type Host = {
-  id: string;
-  hostname: string;
-  createdAt: string;
-};
+import { Host } from "../types/Host";
 
 type Response = {
   data?: Host;
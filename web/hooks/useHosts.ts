@@ -0,0 +1,24 @@
+import useSWR from "swr";
+
+import { fetcher } from "../lib/fetcher";
+import { Host } from "../types/Host";
+
+type Response = {
+  data?: Host[];
+  error?: {
+    message: string;
+  };
+};
+
+export function useHosts(): { hosts?: Host[]; error: any; mutate: () => Promise<any> } {
+  const { data, error, mutate } = useSWR<Response, any>("/api/hosts", fetcher, {
+    // Poll for updated interaction counts.
+    refreshInterval: 2000,
+  });
+
+  if (error) {
+    return { error, mutate };
+  }
+
+  return { hosts: data?.data, error: data?.error, mutate };
+}
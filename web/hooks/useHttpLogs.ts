@@ -18,7 +18,9 @@ export function useHttpLogs(hostId?: string): { httpLogs?: HttpLogEntry[]; error
         return;
       }
       return fetcher(`${url}?${new URLSearchParams({ hostId })}`);
-    }
+    },
+    // Poll for new interactions.
+    { refreshInterval: 2000 }
   );
 
   if (error) {
@@ -1,4 +1,40 @@
-export async function fetcher(...args: Parameters<typeof fetch>): Promise<any> {
+export type ApiResponse<T = any> = {
+  data?: T;
+  error?: {
+    message: string;
+  };
+};
+
+export async function fetcher(...args: Parameters<typeof fetch>): Promise<ApiResponse> {
   const res = await fetch(...args);
-  return res.json();
+  return parseResponse(res);
+}
+
+// parseResponse returns the data or error of an API response. Responses are
+// either wrapped in an envelope with `data` and `error` properties, or, if the
+// server runs with `--api-envelope=false`, have the data or error at the top
+// level, in which case errors are told apart by their status code.
+export async function parseResponse<T = any>(res: Response): Promise<ApiResponse<T>> {
+  const text = await res.text();
+  const body = text ? JSON.parse(text) : undefined;
+
+  if (!res.ok) {
+    return { error: body?.error ?? body ?? { message: res.statusText } };
+  }
+  if (isEnvelope(body)) {
+    return body;
+  }
+  return { data: body };
+}
+
+// isEnvelope returns whether a successful response is wrapped in an envelope,
+// i.e. it's an object without properties other than `data`. None of the
+// objects returned by the API look like that.
+function isEnvelope(body: any): body is ApiResponse {
+  return (
+    typeof body === "object" &&
+    body !== null &&
+    !Array.isArray(body) &&
+    Object.keys(body).every((key) => key === "data")
+  );
 }
@@ -5,6 +5,9 @@
  **/
 const nextConfig = {
   reactStrictMode: true,
+  // Export pages as `{page}/index.html`, so they can be served by Go's
+  // `http.FileServer` when embedded (see `embed.go`).
+  trailingSlash: true,
   async rewrites() {
     return [
       {
@@ -0,0 +1,11 @@
+//go:build !webui
+// +build !webui
+
+package web
+
+import "io/fs"
+
+// Assets returns nil, because the web UI isn't embedded in this build.
+func Assets() fs.FS {
+	return nil
+}
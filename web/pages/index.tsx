@@ -1,17 +1,85 @@
+import { DateTime } from "luxon";
 import type { NextPage } from "next";
+import Link from "next/link";
+import { useRouter } from "next/router";
+import React, { useState } from "react";
 
 import MenuItem from "../components/MenuItem";
 import { NavBar } from "../components/NavBar";
+import { useHosts } from "../hooks/useHosts";
+import { parseResponse } from "../lib/fetcher";
+import { Host } from "../types/Host";
 
 const Home: NextPage = () => {
+  const router = useRouter();
+  const { hosts, error, mutate } = useHosts();
+  const [createError, setCreateError] = useState<string>();
+  const [creating, setCreating] = useState(false);
+
+  async function createHost() {
+    setCreating(true);
+    setCreateError(undefined);
+    try {
+      const res = await fetch("/api/hosts", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ amount: 1 }),
+      });
+      const body = await parseResponse<Host[]>(res);
+      if (body.error) {
+        setCreateError(body.error.message);
+        return;
+      }
+      await mutate();
+      if (body.data?.length) {
+        router.push({ pathname: "/http-logs", query: { hostId: body.data[0].id } });
+      }
+    } catch (err) {
+      setCreateError((err as Error).message);
+    } finally {
+      setCreating(false);
+    }
+  }
+
   return (
     <>
       <NavBar>
-        <MenuItem text="Hosts" href="/hosts/" />
+        <MenuItem text="Hosts" href="/" />
       </NavBar>
-      <div className="p-6 clear-both">
-        Welcome to <strong>Edena</strong>…
-      </div>
+      <main className="p-6 clear-both">
+        <p className="mb-4">
+          <button className="bg-primary text-white font-bold rounded px-4 py-2" disabled={creating} onClick={createHost}>
+            Create host
+          </button>
+        </p>
+        {createError && (
+          <p className="mb-4">
+            Failed to create host: <em>{createError}</em>
+          </p>
+        )}
+        {error && (
+          <p>
+            Failed to load hosts: <em>{error.message}</em>
+          </p>
+        )}
+        {!(hosts || error) && <p>Loading ...</p>}
+        {hosts && hosts.length === 0 && <p>No hosts yet.</p>}
+        {hosts && hosts.length > 0 && (
+          <ul className="divide-y">
+            {hosts.map((host) => (
+              <li key={host.id} className="py-4">
+                <Link href={{ pathname: "/http-logs", query: { hostId: host.id } }}>
+                  <a className="text-link font-bold">{host.hostname}</a>
+                </Link>
+                <p className="text-gray-400">
+                  {host.interactionCount} {host.interactionCount === 1 ? "interaction" : "interactions"}, created{" "}
+                  {DateTime.fromISO(host.createdAt).toRelative()}
+                </p>
+              </li>
+            ))}
+          </ul>
+        )}
+      </main>
     </>
   );
 };
@@ -0,0 +1,8 @@
+export type Host = {
+  id: string;
+  hostname: string;
+  interactionCount: number;
+  createdAt: string;
+  label?: string;
+  acmeDns?: boolean;
+};
@@ -9,14 +9,25 @@ export type HttpLogEntry = {
     method: string;
     headers: HttpHeaders;
     body: string;
+    bodySize: number;
+    bodyUrl?: string;
+    parsedForm?: Record<string, string[]>;
+    parsedJson?: unknown;
+    parseError?: string;
+    remoteAddr: string;
     raw: string;
+    rawWire?: string;
   };
   response: {
     statusCode: number;
     status: string;
     headers: HttpHeaders;
     body: string;
+    bodySize: number;
+    bodyUrl?: string;
+    bodyTruncated?: boolean;
     raw: string;
   };
   createdAt: string;
+  label?: string;
 };